@@ -12,7 +12,9 @@ import (
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -119,6 +121,46 @@ const (
 	TCPFlagCwr = 0x80
 )
 
+// PortStats contains basic statistics of Ethernet port which are
+// counted by NIC since port start.
+type PortStats struct {
+	RxPackets uint64 // Successfully received packets
+	TxPackets uint64 // Successfully transmitted packets
+	RxBytes   uint64 // Successfully received bytes
+	TxBytes   uint64 // Successfully transmitted bytes
+	RxMissed  uint64 // Packets dropped by NIC because there was no room in receive queue
+	RxErrors  uint64 // Erroneous received packets
+	TxErrors  uint64 // Failed transmitted packets
+	RxNoMbuf  uint64 // Receive mbuf allocation failures
+}
+
+// DropReason is a code of reason why packet was dropped by flow graph.
+// Codes are registered by flow.RegisterDropReason, zero code means that
+// reason is unknown.
+type DropReason uint8
+
+// UnknownDropReason is a reason of packets which weren't tagged.
+const UnknownDropReason DropReason = 0
+
+// LinkStatus contains state of link of Ethernet port.
+type LinkStatus struct {
+	Up         bool
+	Speed      uint32 // Mbit/s, zero if unknown
+	FullDuplex bool
+	Autoneg    bool
+}
+
+func (s LinkStatus) String() string {
+	if !s.Up {
+		return "down"
+	}
+	duplex := "half-duplex"
+	if s.FullDuplex {
+		duplex = "full-duplex"
+	}
+	return fmt.Sprintf("up, %d Mbit/s, %s", s.Speed, duplex)
+}
+
 // ErrorCode type for codes of errors
 type ErrorCode int
 
@@ -155,6 +197,8 @@ const (
 	FailToInitDPDK
 	FailToCreateKNI
 	FailToReleaseKNI
+	NotInSimulation
+	FailToGetPortStats
 )
 
 // NFError is error type returned by nff-go functions
@@ -342,6 +386,28 @@ func GetDefaultCPUs(cpuNumber int) []int {
 	return cpus
 }
 
+// Directory where Linux describes CPUs and their NUMA nodes
+var sysCPUPath = "/sys/devices/system/cpu"
+
+// GetNUMANodes returns NUMA node of each core from cpus list. Node is
+// read from /sys. It is -1 if it is unknown, for example if kernel was
+// built without NUMA support.
+func GetNUMANodes(cpus []int) []int {
+	nodes := make([]int, len(cpus))
+	for i, cpu := range cpus {
+		nodes[i] = -1
+		// Each CPU directory has link to directory of its node
+		links, _ := filepath.Glob(filepath.Join(sysCPUPath, "cpu"+strconv.Itoa(cpu), "node*"))
+		for _, link := range links {
+			if node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(link), "node")); err == nil {
+				nodes[i] = node
+				break
+			}
+		}
+	}
+	return nodes
+}
+
 // HandleCPUs parses cpu list string into array of valid core numbers.
 // Removes duplicates
 func HandleCPUList(s string, maxcpu int) ([]int, error) {
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"testing"
@@ -104,6 +107,29 @@ var ErrorCauseTests = []struct {
 	{nil, nil, nil, -1},
 }
 
+func TestGetNUMANodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for cpu, node := range map[int]string{0: "node0", 1: "node1", 2: ""} {
+		path := filepath.Join(dir, "cpu"+strconv.Itoa(cpu), node)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := sysCPUPath
+	sysCPUPath = dir
+	defer func() { sysCPUPath = old }()
+
+	actual := GetNUMANodes([]int{1, 0, 2, 5})
+	expected := []int{1, 0, -1, -1}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("GetNUMANodes: got %v, want %v", actual, expected)
+	}
+}
+
 // TestErrorCause checks GetNFError, GetNFErrorCode, and Cause methods.
 func TestErrorCause(t *testing.T) {
 	for _, tt := range ErrorCauseTests {
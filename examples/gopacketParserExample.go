@@ -8,9 +8,9 @@ import (
 	"flag"
 	"fmt"
 
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/gopacketadapter"
 	"github.com/intel-go/nff-go/packet"
 )
 
@@ -37,8 +37,8 @@ func main() {
 	firstFlow, err := flow.SetReceiver(uint16(*inport))
 	flow.CheckFatal(err)
 
-	var ctx gopacketContext
-	flow.CheckFatal(flow.SetHandler(firstFlow, gopacketHandleFunc, ctx))
+	// Each handler will use its own copy of parser.
+	flow.CheckFatal(flow.SetHandler(firstFlow, gopacketHandleFunc, gopacketadapter.NewParser()))
 
 	// Split for two senders and send
 	secondFlow, err := flow.SetPartitioner(firstFlow, 150, 150)
@@ -50,63 +50,38 @@ func main() {
 	flow.SystemStart()
 }
 
-// Parser and pre-allocated layers to be parsed.
-type gopacketContext struct {
-	eth layers.Ethernet
-	ip4 layers.IPv4
-	ip6 layers.IPv6
-	tcp layers.TCP
-	udp layers.UDP
-
-	parser *gopacket.DecodingLayerParser
-}
-
-// Each handler will use its own gopacketContext.
-func (ctx gopacketContext) Copy() interface{} {
-	newCtx := new(gopacketContext)
-	newCtx.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &newCtx.eth, &newCtx.ip4, &newCtx.ip6, &newCtx.tcp, &newCtx.udp)
-	return newCtx
-}
-
-func (ctx gopacketContext) Delete() {
-}
-
 func gopacketHandleFunc(currentPacket *packet.Packet, context flow.UserContext) {
-	ctx := context.(*gopacketContext)
-	parser := ctx.parser
-	decoded := []gopacket.LayerType{}
-
-	packetData := currentPacket.GetRawPacketBytes()
-	parser.DecodeLayers(packetData, &decoded)
+	parser := context.(*gopacketadapter.Parser)
+	parser.Decode(currentPacket)
 
 	if printOn {
 		fmt.Println("--------- Packet----------")
-		printLayersInfo(ctx, decoded)
+		printLayersInfo(parser)
 	}
 }
 
-func printLayersInfo(ctx *gopacketContext, decoded []gopacket.LayerType) {
-	for _, layerType := range decoded {
+func printLayersInfo(ctx *gopacketadapter.Parser) {
+	for _, layerType := range ctx.Decoded {
 		switch layerType {
 		case layers.LayerTypeEthernet:
 			fmt.Println("Ethernet layer detected.")
-			fmt.Printf("	From %s to %s\n", ctx.eth.SrcMAC, ctx.eth.DstMAC)
-			fmt.Printf("	Protocol: %v\n", ctx.eth.EthernetType)
+			fmt.Printf("	From %s to %s\n", ctx.Ethernet.SrcMAC, ctx.Ethernet.DstMAC)
+			fmt.Printf("	Protocol: %v\n", ctx.Ethernet.EthernetType)
 		case layers.LayerTypeIPv4:
 			fmt.Println("IPv4 layer detected.")
-			fmt.Printf("	From %s to %s\n", ctx.ip4.SrcIP, ctx.ip4.DstIP)
-			fmt.Printf("	Protocol: %v\n", ctx.ip4.Protocol)
+			fmt.Printf("	From %s to %s\n", ctx.IPv4.SrcIP, ctx.IPv4.DstIP)
+			fmt.Printf("	Protocol: %v\n", ctx.IPv4.Protocol)
 		case layers.LayerTypeIPv6:
 			fmt.Println("IPv6 layer detected.")
-			fmt.Printf("	From %s to %s\n", ctx.ip6.SrcIP, ctx.ip6.DstIP)
-			fmt.Printf("	Protocol: %v\n", ctx.ip6.NextHeader)
+			fmt.Printf("	From %s to %s\n", ctx.IPv6.SrcIP, ctx.IPv6.DstIP)
+			fmt.Printf("	Protocol: %v\n", ctx.IPv6.NextHeader)
 		case layers.LayerTypeTCP:
 			fmt.Println("TCP layer detected.")
-			fmt.Printf("	From port %d to %d\n", ctx.tcp.SrcPort, ctx.tcp.DstPort)
-			fmt.Printf("	Sequence number: %d\n", ctx.tcp.Seq)
+			fmt.Printf("	From port %d to %d\n", ctx.TCP.SrcPort, ctx.TCP.DstPort)
+			fmt.Printf("	Sequence number: %d\n", ctx.TCP.Seq)
 		case layers.LayerTypeUDP:
 			fmt.Println("UDP layer detected.")
-			fmt.Printf("	From port %d to %d\n", ctx.udp.SrcPort, ctx.udp.DstPort)
+			fmt.Printf("	From port %d to %d\n", ctx.UDP.SrcPort, ctx.UDP.DstPort)
 		}
 	}
 }
@@ -0,0 +1,345 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// MAC-learning L2 bridge
+// Bridge is a flow function which connects several flows (bridge ports).
+// Each packet which comes from input flow of a port is used to learn
+// pair of its source MAC address and VLAN ID. After that packet is
+// forwarded to output flow of the port where its destination MAC address
+// was learned. Broadcast, multicast and unknown unicast packets are
+// flooded to output flows of all other ports. Learned entries are removed
+// after aging time if no packets were received from them. Static entries
+// can be added by user and are never aged.
+
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// BridgeConfig is a struct with bridge parameters.
+type BridgeConfig struct {
+	// Time after which learned entry is removed from forwarding database
+	// if no packets with its source address were received. Default value
+	// is 300 seconds.
+	AgingTime time.Duration
+	// Maximum number of learned entries in forwarding database. New
+	// addresses are not learned if database is full, packets to them are
+	// flooded. Static entries are not limited. Default value is 65536.
+	MaxEntries int
+}
+
+type fdbKey struct {
+	mac  [common.EtherAddrLen]uint8
+	vlan uint16
+}
+
+type fdbEntry struct {
+	port     int32
+	lastSeen int64
+	static   bool
+	// Position of learned entry in list of learned keys
+	index int
+}
+
+const (
+	// Bridge checks agingBatch learned entries every agingPeriod, so
+	// packets aren't delayed by aging of the whole database. Expired
+	// entries aren't used even if they aren't removed yet.
+	agingBatch  = 128
+	agingPeriod = int64(time.Millisecond)
+)
+
+// Bridge is a forwarding database of bridge flow function. It is returned
+// from SetBridge and can be used to manage static entries while bridge works.
+type Bridge struct {
+	sync.RWMutex
+	fdb map[fdbKey]*fdbEntry
+	// Keys of learned entries and position of next entry to age
+	learned    []fdbKey
+	cursor     int
+	portsNum   int
+	agingTime  int64
+	maxEntries int
+}
+
+func newBridge(portsNum int, config *BridgeConfig) *Bridge {
+	bridge := new(Bridge)
+	bridge.fdb = make(map[fdbKey]*fdbEntry)
+	bridge.portsNum = portsNum
+	bridge.agingTime = int64(300 * time.Second)
+	bridge.maxEntries = 65536
+	if config != nil {
+		if config.AgingTime != 0 {
+			bridge.agingTime = int64(config.AgingTime)
+		}
+		if config.MaxEntries != 0 {
+			bridge.maxEntries = config.MaxEntries
+		}
+	}
+	return bridge
+}
+
+// AddStaticEntry adds entry which forwards packets with given destination
+// MAC address and VLAN ID to given bridge port. VLAN ID 0 is used for
+// untagged packets. Port is an index of input flow in SetBridge arguments.
+// Static entry replaces learned entry with the same address.
+func (bridge *Bridge) AddStaticEntry(mac [common.EtherAddrLen]uint8, vlan uint16, port int) error {
+	if port < 0 || port >= bridge.portsNum {
+		return common.WrapWithNFError(nil, "Bridge port number is out of range", common.BadArgument)
+	}
+	bridge.Lock()
+	key := fdbKey{mac, vlan}
+	if old, ok := bridge.fdb[key]; ok && !old.static {
+		bridge.remove(key, old)
+	}
+	bridge.fdb[key] = &fdbEntry{port: int32(port), static: true}
+	bridge.Unlock()
+	return nil
+}
+
+// RemoveStaticEntry removes static entry with given MAC address and VLAN ID.
+// Returns false if there was no such static entry.
+func (bridge *Bridge) RemoveStaticEntry(mac [common.EtherAddrLen]uint8, vlan uint16) bool {
+	bridge.Lock()
+	defer bridge.Unlock()
+	key := fdbKey{mac, vlan}
+	if entry, ok := bridge.fdb[key]; ok && entry.static {
+		delete(bridge.fdb, key)
+		return true
+	}
+	return false
+}
+
+// Flush removes all learned entries from forwarding database. Static
+// entries remain.
+func (bridge *Bridge) Flush() {
+	bridge.Lock()
+	for _, key := range bridge.learned {
+		delete(bridge.fdb, key)
+	}
+	bridge.learned = bridge.learned[:0]
+	bridge.cursor = 0
+	bridge.Unlock()
+}
+
+// Lookup returns bridge port for given MAC address and VLAN ID. Returns
+// false if there is no entry or learned entry is expired.
+func (bridge *Bridge) Lookup(mac [common.EtherAddrLen]uint8, vlan uint16) (int, bool) {
+	return bridge.lookup(fdbKey{mac, vlan}, time.Now().UnixNano())
+}
+
+func (bridge *Bridge) lookup(key fdbKey, now int64) (int, bool) {
+	bridge.RLock()
+	entry, ok := bridge.fdb[key]
+	bridge.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	if !entry.static && now-atomic.LoadInt64(&entry.lastSeen) > bridge.agingTime {
+		return 0, false
+	}
+	return int(atomic.LoadInt32(&entry.port)), true
+}
+
+func (bridge *Bridge) learn(key fdbKey, port int32, now int64) {
+	bridge.RLock()
+	entry, ok := bridge.fdb[key]
+	bridge.RUnlock()
+	if ok {
+		if !entry.static {
+			// Station could move to another port, so port is updated too
+			atomic.StoreInt32(&entry.port, port)
+			atomic.StoreInt64(&entry.lastSeen, now)
+		}
+		return
+	}
+	bridge.Lock()
+	if _, ok := bridge.fdb[key]; !ok && len(bridge.learned) < bridge.maxEntries {
+		bridge.fdb[key] = &fdbEntry{port: port, lastSeen: now, index: len(bridge.learned)}
+		bridge.learned = append(bridge.learned, key)
+	}
+	bridge.Unlock()
+}
+
+// Should be called with write lock. Last learned key takes place of
+// removed one.
+func (bridge *Bridge) remove(key fdbKey, entry *fdbEntry) {
+	last := len(bridge.learned) - 1
+	moved := bridge.learned[last]
+	bridge.learned[entry.index] = moved
+	bridge.fdb[moved].index = entry.index
+	bridge.learned = bridge.learned[:last]
+	delete(bridge.fdb, key)
+}
+
+// age checks at most agingBatch learned entries starting from the place
+// where previous call stopped and removes expired ones.
+func (bridge *Bridge) age(now int64) {
+	bridge.Lock()
+	checks := agingBatch
+	if checks > len(bridge.learned) {
+		checks = len(bridge.learned)
+	}
+	for i := 0; i < checks; i++ {
+		if bridge.cursor >= len(bridge.learned) {
+			bridge.cursor = 0
+		}
+		key := bridge.learned[bridge.cursor]
+		entry := bridge.fdb[key]
+		if now-atomic.LoadInt64(&entry.lastSeen) > bridge.agingTime {
+			// Other entry is moved to cursor and is checked next
+			bridge.remove(key, entry)
+		} else {
+			bridge.cursor++
+		}
+	}
+	bridge.Unlock()
+}
+
+type bridgeParameters struct {
+	in      []low.Rings
+	out     []low.Rings
+	mempool *low.Mempool
+	bridge  *Bridge
+}
+
+func addBridge(in []low.Rings, out []low.Rings, bridge *Bridge) {
+	par := new(bridgeParameters)
+	par.in = in
+	par.out = out
+	par.bridge = bridge
+	par.mempool = low.CreateMempool("bridge")
+	schedState.addFF("bridge", pbridge, nil, nil, par, nil, readWrite, 0, anySocket)
+}
+
+// SetBridge adds MAC-learning L2 bridge function to flow graph.
+// Gets input flows which are considered as bridge ports and bridge
+// configuration. Returns output flows with the same order as input
+// flows and bridge forwarding database for static entries management.
+// All input flows will be closed. Packets received from one port are
+// forwarded to output flow of the port where destination address was
+// learned or flooded to output flows of all other ports.
+// Bridge function is not clonable.
+func SetBridge(IN []*Flow, config *BridgeConfig) (OUT []*Flow, bridge *Bridge, err error) {
+	if len(IN) < 2 {
+		return nil, nil, common.WrapWithNFError(nil, "Bridge should have at least two ports", common.BadArgument)
+	}
+	for i := range IN {
+		if err := checkFlow(IN[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	in := make([]low.Rings, len(IN), len(IN))
+	out := make([]low.Rings, len(IN), len(IN))
+	OUT = make([]*Flow, len(IN), len(IN))
+	for i := range IN {
+		in[i] = finishFlow(IN[i])
+		out[i] = low.CreateRings(burstSize*sizeMultiplier, 1)
+		OUT[i] = newFlow(out[i], 1, anySocket)
+	}
+	bridge = newBridge(len(IN), config)
+	addBridge(in, out, bridge)
+	return OUT, bridge, nil
+}
+
+func pbridge(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	bp := parameters.(*bridgeParameters)
+	bridge := bp.bridge
+	portsNum := len(bp.in)
+
+	bufIn := make([]uintptr, burstSize)
+	var copyMbuf uintptr
+	bufDrop := make([]uintptr, burstSize)
+	bufOut := make([][]uintptr, portsNum)
+	countOut := make([]uint, portsNum)
+	for i := range bufOut {
+		bufOut[i] = make([]uintptr, burstSize)
+	}
+	lastAging := time.Now().UnixNano()
+
+	for {
+		select {
+		case <-stopper[0]:
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			now := time.Now().UnixNano()
+			if now-lastAging > agingPeriod {
+				bridge.age(now)
+				lastAging = now
+			}
+			for p := 0; p < portsNum; p++ {
+				// Input flow of a port can have several queues after receive
+				for q := range bp.in[p] {
+					n := bp.in[p][q].DequeueBurst(bufIn, burstSize)
+					if n == 0 {
+						continue
+					}
+					countDrop := 0
+					for i := uint(0); i < n; i++ {
+						pkt := packet.ExtractPacket(bufIn[i])
+						var vlan uint16
+						if vhdr := pkt.GetVLAN(); vhdr != nil {
+							vlan = vhdr.GetVLANTagIdentifier()
+						}
+						// Multicast source addresses are invalid and are not learned
+						if pkt.Ether.SAddr[0]&1 == 0 {
+							bridge.learn(fdbKey{pkt.Ether.SAddr, vlan}, int32(p), now)
+						}
+						if pkt.Ether.DAddr[0]&1 == 0 {
+							if dst, ok := bridge.lookup(fdbKey{pkt.Ether.DAddr, vlan}, now); ok {
+								if dst == p {
+									// Destination is behind the same port. Filter it.
+									bufDrop[countDrop] = bufIn[i]
+									countDrop++
+								} else {
+									bufOut[dst][countOut[dst]] = bufIn[i]
+									countOut[dst]++
+								}
+								continue
+							}
+						}
+						// Broadcast, multicast or unknown unicast. Original packet
+						// is sent to the last port, all others get copies.
+						last := portsNum - 1
+						if last == p {
+							last--
+						}
+						for o := 0; o < last; o++ {
+							if o == p {
+								continue
+							}
+							if err := low.AllocateMbuf(&copyMbuf, bp.mempool); err != nil {
+								common.LogWarning(common.Debug, "Bridge can't allocate mbuf for flooding:", err)
+								continue
+							}
+							packet.GeneratePacketFromByte(packet.ExtractPacket(copyMbuf), pkt.GetRawPacketBytes())
+							bufOut[o][countOut[o]] = copyMbuf
+							countOut[o]++
+						}
+						bufOut[last][countOut[last]] = bufIn[i]
+						countOut[last]++
+					}
+					for o := 0; o < portsNum; o++ {
+						if countOut[o] != 0 {
+							safeEnqueue(bp.out[o][0], bufOut[o], countOut[o])
+							countOut[o] = 0
+						}
+					}
+					if countDrop != 0 {
+						low.DirectStop(countDrop, bufDrop)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+var (
+	stationA  = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, 0, 0x0a}
+	stationB  = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, 0, 0x0b}
+	stationC  = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, 0, 0x0c}
+	stationD  = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, 0, 0x0d}
+	broadcast = [common.EtherAddrLen]uint8{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	multicast = [common.EtherAddrLen]uint8{0x01, 0x00, 0x5e, 0, 0, 1}
+)
+
+// makeEtherFrame returns bytes of Ethernet frame with given addresses.
+// Frame is tagged if vlan isn't zero. Number is written to payload to
+// distinguish frames.
+func makeEtherFrame(dst, src [common.EtherAddrLen]uint8, vlan uint16, number byte) []byte {
+	data := make([]byte, 64)
+	copy(data[0:], dst[:])
+	copy(data[6:], src[:])
+	payload := data[12:]
+	if vlan != 0 {
+		binary.BigEndian.PutUint16(payload[0:], common.VLANNumber)
+		binary.BigEndian.PutUint16(payload[2:], vlan)
+		payload = payload[4:]
+	}
+	// Local experimental EtherType
+	binary.BigEndian.PutUint16(payload[0:], 0x88b5)
+	payload[2] = number
+	return data
+}
+
+// bridgeStep injects frame into port and checks that it is sent only to
+// given ports.
+func bridgeStep(t *testing.T, name string, port uint16, frame []byte, to ...uint16) {
+	CheckFatal(InjectPackets(port, frame))
+	for _, o := range to {
+		sent, err := WaitSentPackets(o, 1, 10*time.Second)
+		if err != nil {
+			t.Fatalf("%s: frame isn't sent to port %d", name, o)
+		}
+		if len(sent) != 1 || !bytes.Equal(sent[0], frame) {
+			t.Errorf("%s: port %d got %d wrong frames", name, o, len(sent))
+		}
+	}
+	// Give bridge time to send frame to wrong ports
+	time.Sleep(20 * time.Millisecond)
+	for o := uint16(0); o < 3; o++ {
+		expected := false
+		for _, e := range to {
+			expected = expected || e == o
+		}
+		if sent, _ := WaitSentPackets(o, 0, 0); !expected && len(sent) != 0 {
+			t.Errorf("%s: frame is sent to port %d", name, o)
+		}
+	}
+}
+
+// waitLearned waits until station is learned on given port. Returns
+// false if it isn't learned in time.
+func waitLearned(bridge *Bridge, mac [common.EtherAddrLen]uint8, vlan uint16, port int) bool {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if p, ok := bridge.Lookup(mac, vlan); ok && p == port {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+// setBridgeGraph connects three simulated ports by bridge.
+func setBridgeGraph(config *BridgeConfig) *Bridge {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 3, DisableScheduler: true, LogType: common.No}))
+	in := make([]*Flow, 3)
+	for i := range in {
+		var err error
+		in[i], err = SetReceiver(uint16(i))
+		CheckFatal(err)
+	}
+	out, bridge, err := SetBridge(in, config)
+	CheckFatal(err)
+	for i := range out {
+		CheckFatal(SetSender(out[i], uint16(i)))
+	}
+	return bridge
+}
+
+func TestBridge(t *testing.T) {
+	bridge := setBridgeGraph(nil)
+	go SystemStart()
+	defer SystemStop()
+
+	// Stations A, B and C are behind ports 0, 1 and 2
+	bridgeStep(t, "unknown unicast", 0, makeEtherFrame(stationB, stationA, 0, 1), 1, 2)
+	if !waitLearned(bridge, stationA, 0, 0) {
+		t.Error("Station A isn't learned on port 0")
+	}
+	bridgeStep(t, "learned unicast", 1, makeEtherFrame(stationA, stationB, 0, 2), 0)
+	bridgeStep(t, "learned unicast back", 0, makeEtherFrame(stationB, stationA, 0, 3), 1)
+	bridgeStep(t, "broadcast", 0, makeEtherFrame(broadcast, stationA, 0, 4), 1, 2)
+	bridgeStep(t, "multicast", 2, makeEtherFrame(multicast, stationC, 0, 5), 0, 1)
+	// Destination behind the same port is filtered. Frame isn't sent
+	// anywhere, so move of station is the only sign of its processing.
+	bridgeStep(t, "same port", 2, makeEtherFrame(stationC, stationA, 0, 6))
+	if !waitLearned(bridge, stationA, 0, 2) {
+		t.Fatal("Moved station A isn't learned on port 2")
+	}
+	bridgeStep(t, "moved station", 1, makeEtherFrame(stationA, stationB, 0, 7), 2)
+
+	// Addresses are learned per VLAN
+	bridgeStep(t, "unknown in VLAN", 1, makeEtherFrame(stationC, stationB, 10, 8), 0, 2)
+	bridgeStep(t, "other VLAN", 0, makeEtherFrame(stationB, stationC, 10, 9), 1)
+	bridgeStep(t, "learned in VLAN", 1, makeEtherFrame(stationC, stationB, 10, 10), 0)
+	bridgeStep(t, "untagged", 1, makeEtherFrame(stationC, stationB, 0, 11), 2)
+	if _, ok := bridge.Lookup(stationA, 10); ok {
+		t.Error("Station A is learned in VLAN where it didn't send")
+	}
+
+	// Static entries are not replaced by learning
+	CheckFatal(bridge.AddStaticEntry(stationD, 0, 2))
+	bridgeStep(t, "static", 0, makeEtherFrame(stationD, stationC, 0, 12), 2)
+	bridgeStep(t, "static source", 1, makeEtherFrame(broadcast, stationD, 0, 13), 0, 2)
+	bridgeStep(t, "static after learning", 0, makeEtherFrame(stationD, stationC, 0, 14), 2)
+	if bridge.AddStaticEntry(stationD, 0, 3) == nil {
+		t.Error("Static entry to wrong port is added")
+	}
+	if !bridge.RemoveStaticEntry(stationD, 0) || bridge.RemoveStaticEntry(stationD, 0) {
+		t.Error("Static entry isn't removed once")
+	}
+	bridgeStep(t, "removed static", 0, makeEtherFrame(stationD, stationC, 0, 15), 1, 2)
+
+	bridge.Flush()
+	bridgeStep(t, "flushed", 2, makeEtherFrame(stationB, stationA, 0, 16), 0, 1)
+}
+
+func TestBridgeAging(t *testing.T) {
+	const aging = 200 * time.Millisecond
+	bridge := setBridgeGraph(&BridgeConfig{AgingTime: aging})
+	go SystemStart()
+	defer SystemStop()
+
+	bridgeStep(t, "unknown unicast", 0, makeEtherFrame(stationB, stationA, 0, 1), 1, 2)
+	bridgeStep(t, "learned unicast", 1, makeEtherFrame(stationA, stationB, 0, 2), 0)
+	time.Sleep(2 * aging)
+	if _, ok := bridge.Lookup(stationA, 0); ok {
+		t.Error("Station A isn't aged")
+	}
+	bridgeStep(t, "aged unicast", 1, makeEtherFrame(stationA, stationB, 0, 3), 0, 2)
+}
+
+func TestBridgeDatabase(t *testing.T) {
+	const aging = int64(time.Second)
+	bridge := newBridge(2, &BridgeConfig{AgingTime: time.Duration(aging), MaxEntries: 2})
+	bridge.learn(fdbKey{stationA, 0}, 0, 0)
+	bridge.learn(fdbKey{stationB, 0}, 1, 0)
+	// Database is full
+	bridge.learn(fdbKey{stationC, 0}, 1, 0)
+	if _, ok := bridge.lookup(fdbKey{stationC, 0}, 0); ok {
+		t.Error("Entry is learned above limit")
+	}
+	CheckFatal(bridge.AddStaticEntry(stationD, 5, 1))
+	if port, ok := bridge.lookup(fdbKey{stationD, 5}, 0); !ok || port != 1 {
+		t.Error("Static entry isn't added to full database")
+	}
+
+	// Entry which is seen again isn't aged
+	bridge.learn(fdbKey{stationB, 0}, 1, aging)
+	bridge.age(aging + 1)
+	if _, ok := bridge.lookup(fdbKey{stationA, 0}, aging+1); ok {
+		t.Error("Expired entry is found")
+	}
+	if _, ok := bridge.lookup(fdbKey{stationB, 0}, aging+1); !ok {
+		t.Error("Refreshed entry is aged")
+	}
+	if _, ok := bridge.lookup(fdbKey{stationD, 5}, 10*aging); !ok {
+		t.Error("Static entry is aged")
+	}
+	// Aged entry frees place for new one
+	bridge.learn(fdbKey{stationC, 0}, 0, aging+1)
+	if port, ok := bridge.lookup(fdbKey{stationC, 0}, aging+1); !ok || port != 0 {
+		t.Error("Entry isn't learned after aging")
+	}
+}
+
+func TestBridgeAgingBatch(t *testing.T) {
+	const aging = int64(time.Second)
+	const number = agingBatch*3 + 10
+	bridge := newBridge(2, &BridgeConfig{AgingTime: time.Duration(aging)})
+	for i := 0; i < number; i++ {
+		mac := stationA
+		binary.BigEndian.PutUint16(mac[3:], uint16(i+1))
+		bridge.learn(fdbKey{mac, 0}, 0, 0)
+	}
+	bridge.learn(fdbKey{stationA, 0}, 1, aging)
+	// Each call checks only a batch of entries
+	bridge.age(aging + 1)
+	if left := len(bridge.fdb); left < number+1-agingBatch || left == number+1 {
+		t.Fatalf("%d of %d entries are left after aging of one batch", left, number+1)
+	}
+	for i := 0; i < 3; i++ {
+		bridge.age(aging + 1)
+	}
+	if len(bridge.fdb) != 1 || len(bridge.learned) != 1 {
+		t.Fatalf("%d entries are left after aging of all batches", len(bridge.fdb))
+	}
+	if port, ok := bridge.lookup(fdbKey{stationA, 0}, aging+1); !ok || port != 1 {
+		t.Error("Refreshed entry is aged")
+	}
+}
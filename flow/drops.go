@@ -0,0 +1,171 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Packets which are sent to stopper by handlers, separators and
+// splitters and packets which don't fit into shaper queues are counted
+// by flow function and by reason. User functions
+// can tag packet with reason by packet.SetDropReason before dropping it.
+
+// Maximum number of drop reasons including unknown reason
+const maxDropReasons = 256
+
+var dropReasons = struct {
+	sync.Mutex
+	names []string
+}{names: []string{"unknown"}}
+
+// RegisterDropReason returns code of drop reason with given name. Code
+// is registered if name wasn't used before.
+func RegisterDropReason(name string) (common.DropReason, error) {
+	dropReasons.Lock()
+	defer dropReasons.Unlock()
+	for i := range dropReasons.names {
+		if dropReasons.names[i] == name {
+			return common.DropReason(i), nil
+		}
+	}
+	if len(dropReasons.names) == maxDropReasons {
+		return 0, common.WrapWithNFError(nil, "Too many drop reasons", common.BadArgument)
+	}
+	dropReasons.names = append(dropReasons.names, name)
+	return common.DropReason(len(dropReasons.names) - 1), nil
+}
+
+func dropReasonName(reason int) string {
+	dropReasons.Lock()
+	defer dropReasons.Unlock()
+	if reason < len(dropReasons.names) {
+		return dropReasons.names[reason]
+	}
+	return "unregistered"
+}
+
+// dropCounters are numbers of dropped packets of flow function by reason.
+type dropCounters [maxDropReasons]uint64
+
+// add adds counters of clone to counters of flow function and clears them.
+func (c *dropCounters) add(local *dropCounters) {
+	for r := range local {
+		if local[r] != 0 {
+			atomic.AddUint64(&c[r], local[r])
+			local[r] = 0
+		}
+	}
+}
+
+// countDrop counts dropped packet and captures it if required.
+func (c *dropCounters) countDrop(pkt *packet.Packet) {
+	c[pkt.GetDropReason()]++
+	dropCapture.write(pkt)
+}
+
+// DropStat is a number of packets which were dropped by flow function
+// with given reason.
+type DropStat struct {
+	FlowFunction string
+	Reason       string
+	Packets      uint64
+}
+
+// GetDropStats returns numbers of packets which were dropped by flow
+// functions since SystemStart by reason. Only non zero numbers are
+// returned. Numbers are updated by flow functions each ScaleTime.
+func GetDropStats() []DropStat {
+	schedState.mutex.Lock()
+	defer schedState.mutex.Unlock()
+	var stats []DropStat
+	for _, ff := range schedState.ff {
+		stats = append(stats, ff.dropStats()...)
+	}
+	return stats
+}
+
+func (ff *flowFunction) dropStats() []DropStat {
+	var drops *dropCounters
+	switch par := ff.Parameters.(type) {
+	case *segmentParameters:
+		drops = par.drops
+	case *shaperParameters:
+		drops = par.drops
+	default:
+		return nil
+	}
+	var stats []DropStat
+	for r := range drops {
+		if n := atomic.LoadUint64(&drops[r]); n != 0 {
+			stats = append(stats, DropStat{ff.name, dropReasonName(r), n})
+		}
+	}
+	return stats
+}
+
+func (ff *flowFunction) printDrops() {
+	for _, s := range ff.dropStats() {
+		common.LogDrop(common.Debug, "Flow function", s.FlowFunction, "dropped", s.Packets, "packets with reason", s.Reason)
+	}
+}
+
+// dropCaptureState is a pcap file for sampled dropped packets.
+type dropCaptureState struct {
+	mutex   sync.Mutex
+	file    *os.File
+	sample  uint64
+	counter uint64
+}
+
+var dropCapture dropCaptureState
+
+// write writes each sample packet to capture file.
+func (c *dropCaptureState) write(pkt *packet.Packet) {
+	sample := atomic.LoadUint64(&c.sample)
+	if sample == 0 || atomic.AddUint64(&c.counter, 1)%sample != 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file != nil {
+		if err := pkt.WritePcapOnePacket(c.file); err != nil {
+			common.LogWarning(common.Debug, "Cannot write dropped packet:", err)
+		}
+	}
+}
+
+// SetDropCapture starts writing each sample dropped packet to pcap
+// file with given name. It is used for troubleshooting because packets
+// are written by flow functions themselves. Zero sample stops capture.
+func SetDropCapture(filename string, sample uint) error {
+	dropCapture.mutex.Lock()
+	defer dropCapture.mutex.Unlock()
+	atomic.StoreUint64(&dropCapture.sample, 0)
+	if dropCapture.file != nil {
+		dropCapture.file.Close()
+		dropCapture.file = nil
+	}
+	if sample == 0 {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return common.WrapWithNFError(err, "Cannot create drop capture file", common.FileErr)
+	}
+	if err := packet.WritePcapGlobalHdr(f); err != nil {
+		f.Close()
+		return err
+	}
+	dropCapture.file = f
+	atomic.StoreUint64(&dropCapture.counter, 0)
+	atomic.StoreUint64(&dropCapture.sample, uint64(sample))
+	return nil
+}
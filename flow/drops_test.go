@@ -0,0 +1,87 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+var oddPortReason common.DropReason
+
+func dropOddPortsWithReason(pkt *packet.Packet, ctx UserContext) bool {
+	if !dropOddPorts(pkt, ctx) {
+		pkt.SetDropReason(oddPortReason)
+		return false
+	}
+	return true
+}
+
+func TestDropReasons(t *testing.T) {
+	const number = 40
+	var err error
+	if oddPortReason, err = RegisterDropReason("odd port"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := RegisterDropReason("odd port"); again != oddPortReason {
+		t.Errorf("Reason was registered twice: %d and %d", oddPortReason, again)
+	}
+	dir, err := ioutil.TempDir("", "drops")
+	CheckFatal(err)
+	defer os.RemoveAll(dir)
+	capture := filepath.Join(dir, "drops.pcap")
+	CheckFatal(SetDropCapture(capture, 2))
+
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetHandlerDrop(in, dropOddPortsWithReason, nil, WithName("filter")))
+	CheckFatal(SetSender(in, 1))
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(2000+i))))
+	}
+	go SystemStart()
+	if sent, err := WaitSentPackets(1, number/2, 10*time.Second); err != nil {
+		CheckFatal(SystemStop())
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	var stats []DropStat
+	// Counters are updated by segment each ScaleTime
+	for start := time.Now(); (len(stats) == 0 || stats[0].Packets < number/2) && time.Since(start) < 10*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+		stats = GetDropStats()
+	}
+	CheckFatal(SystemStop())
+	CheckFatal(SetDropCapture("", 0))
+	if len(stats) != 1 || stats[0] != (DropStat{"filter", "odd port", number / 2}) {
+		t.Errorf("Wrong drop statistics: %+v", stats)
+	}
+
+	f, err := os.Open(capture)
+	CheckFatal(err)
+	defer f.Close()
+	if err := packet.ReadPcapGlobalHdr(f, new(packet.PcapGlobHdr)); err != nil {
+		t.Fatal(err)
+	}
+	captured := 0
+	var hdr packet.PcapRecHdr
+	for binary.Read(f, binary.LittleEndian, &hdr) == nil {
+		if _, err := f.Seek(int64(hdr.InclLen), io.SeekCurrent); err != nil {
+			t.Fatal(err)
+		}
+		captured++
+	}
+	if captured != number/4 {
+		t.Errorf("Captured %d dropped packets, expected %d", captured, number/4)
+	}
+}
@@ -32,6 +32,7 @@ package flow
 import (
 	"os"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -44,6 +45,7 @@ import (
 var openFlowsNumber = uint32(0)
 var createdPorts []port
 var portPair map[uint32](*port)
+var portMaxInIndex int32
 var schedState *scheduler
 var vEach [10][burstSize]uint8
 
@@ -54,10 +56,18 @@ type Timer struct {
 	checks   []*bool
 }
 
+type linkHandler struct {
+	port    uint16
+	handler func(uint16, common.LinkStatus, UserContext)
+	context UserContext
+	last    common.LinkStatus
+}
+
 type processSegment struct {
 	out      []low.Rings
 	contexts []UserContext
 	stype    uint8
+	ff       *flowFunction
 }
 
 // Flow is an abstraction for connecting flow functions with each other.
@@ -67,6 +77,8 @@ type Flow struct {
 	segment       *processSegment
 	previous      **Func
 	inIndexNumber int32
+	// NUMA node where packets of flow were received or anySocket
+	socket int
 }
 
 type partitionCtx struct {
@@ -98,6 +110,8 @@ type Func struct {
 	bufIndex        uint
 	contextIndex    int
 	followingNumber uint8
+	// Name given by WithName option
+	name string
 }
 
 // GenerateFunction is a function type for user defined function which generates packets.
@@ -151,16 +165,15 @@ type receiveParameters struct {
 	kni  bool
 }
 
-func addReceiver(portId uint16, kni bool, out low.Rings, inIndexNumber int32) {
+func addReceiver(portId uint16, kni bool, out low.Rings, inIndexNumber int32) *flowFunction {
 	par := new(receiveParameters)
 	par.port = low.GetPort(portId)
 	par.out = out
 	par.kni = kni
 	if kni {
-		schedState.addFF("KNI receiver", nil, recvKNI, nil, par, nil, sendReceiveKNI, 0)
-	} else {
-		schedState.addFF("receiver", nil, recvRSS, nil, par, nil, receiveRSS, inIndexNumber)
+		return schedState.addFF("KNI receiver", nil, recvKNI, nil, par, nil, sendReceiveKNI, 0, anySocket)
 	}
+	return schedState.addFF("receiver", nil, recvRSS, nil, par, nil, receiveRSS, inIndexNumber, low.GetPortSocket(portId))
 }
 
 type generateParameters struct {
@@ -177,7 +190,7 @@ func addGenerator(out low.Rings, generateFunction GenerateFunction, context User
 	par.generateFunction = generateFunction
 	ctx := make([]UserContext, 1, 1)
 	ctx[0] = context
-	schedState.addFF("generator", nil, nil, pGenerate, par, &ctx, generate, 0)
+	schedState.addFF("generator", nil, nil, pGenerate, par, &ctx, generate, 0, anySocket)
 }
 
 func addFastGenerator(out low.Rings, generateFunction GenerateFunction,
@@ -198,7 +211,7 @@ func addFastGenerator(out low.Rings, generateFunction GenerateFunction,
 	par.targetSpeed = fTargetSpeed
 	ctx := make([]UserContext, 1, 1)
 	ctx[0] = context
-	schedState.addFF("fast generator", nil, nil, pFastGenerate, par, &ctx, fastGenerate, 0)
+	schedState.addFF("fast generator", nil, nil, pFastGenerate, par, &ctx, fastGenerate, 0, anySocket)
 	return nil
 }
 
@@ -214,9 +227,9 @@ func addSender(port uint16, queue int16, in low.Rings, inIndexNumber int32) {
 	par.queue = queue
 	par.in = in
 	if queue != -1 {
-		schedState.addFF("sender", nil, send, nil, par, nil, sendReceiveKNI, inIndexNumber)
+		schedState.addFF("sender", nil, send, nil, par, nil, sendReceiveKNI, inIndexNumber, low.GetPortSocket(port))
 	} else {
-		schedState.addFF("KNI sender", nil, send, nil, par, nil, sendReceiveKNI, inIndexNumber)
+		schedState.addFF("KNI sender", nil, send, nil, par, nil, sendReceiveKNI, inIndexNumber, anySocket)
 	}
 }
 
@@ -227,13 +240,13 @@ type copyParameters struct {
 	mempool *low.Mempool
 }
 
-func addCopier(in low.Rings, out low.Rings, outCopy low.Rings, inIndexNumber int32) {
+func addCopier(in low.Rings, out low.Rings, outCopy low.Rings, inIndexNumber int32, socket int) {
 	par := new(copyParameters)
 	par.in = in
 	par.out = out
 	par.outCopy = outCopy
-	par.mempool = low.CreateMempool("copy")
-	schedState.addFF("copy", nil, nil, pcopy, par, nil, segmentCopy, inIndexNumber)
+	par.mempool = low.CreateMempoolOnSocket("copy", socket)
+	schedState.addFF("copy", nil, nil, pcopy, par, nil, segmentCopy, inIndexNumber, socket)
 }
 
 func makePartitioner(N uint64, M uint64) *Func {
@@ -287,7 +300,7 @@ func addWriter(filename string, in low.Rings, inIndexNumber int32) {
 	par := new(writeParameters)
 	par.in = in
 	par.filename = filename
-	schedState.addFF("writer", write, nil, nil, par, nil, readWrite, inIndexNumber)
+	schedState.addFF("writer", write, nil, nil, par, nil, readWrite, inIndexNumber, anySocket)
 }
 
 type readParameters struct {
@@ -301,7 +314,7 @@ func addReader(filename string, out low.Rings, repcount int32) {
 	par.out = out
 	par.filename = filename
 	par.repcount = repcount
-	schedState.addFF("reader", read, nil, nil, par, nil, readWrite, 0)
+	schedState.addFF("reader", read, nil, nil, par, nil, readWrite, 0, anySocket)
 }
 
 func makeSlice(out low.Rings, segment *processSegment) *Func {
@@ -319,9 +332,11 @@ type segmentParameters struct {
 	out       *([]low.Rings)
 	firstFunc *Func
 	stype     *uint8
+	// Packets dropped by functions of segment
+	drops *dropCounters
 }
 
-func addSegment(in low.Rings, first *Func, inIndexNumber int32) *processSegment {
+func addSegment(in low.Rings, first *Func, inIndexNumber int32, socket int) *processSegment {
 	par := new(segmentParameters)
 	par.in = in
 	par.firstFunc = first
@@ -330,7 +345,8 @@ func addSegment(in low.Rings, first *Func, inIndexNumber int32) *processSegment
 	segment.contexts = make([](UserContext), 0, 0)
 	par.out = &segment.out
 	par.stype = &segment.stype
-	schedState.addFF("segment", nil, nil, segmentProcess, par, &segment.contexts, segmentCopy, inIndexNumber)
+	par.drops = new(dropCounters)
+	segment.ff = schedState.addFF("segment", nil, nil, segmentProcess, par, &segment.contexts, segmentCopy, inIndexNumber, socket)
 	return segment
 }
 
@@ -379,6 +395,47 @@ type port struct {
 	port           uint16
 	MAC            [common.EtherAddrLen]uint8
 	InIndex        int32
+	config         PortConfig
+	started        bool // was port created and started
+}
+
+// MAC addresses of ports can be changed by KNI requests while
+// predefined handlers answer with them.
+var portMACLock sync.RWMutex
+
+func (p *port) getMAC() [common.EtherAddrLen]uint8 {
+	portMACLock.RLock()
+	defer portMACLock.RUnlock()
+	return p.MAC
+}
+
+func (p *port) setMAC(mac [common.EtherAddrLen]uint8) {
+	portMACLock.Lock()
+	p.MAC = mac
+	portMACLock.Unlock()
+}
+
+// PortConfig contains optional parameters of an Ethernet port. Zero
+// values keep defaults of NFF-GO and driver.
+type PortConfig struct {
+	// MTU of port. Jumbo frames are enabled if it is greater than
+	// 1500. Received jumbo frames consist of several chained mbufs.
+	// Port initialization fails if port doesn't support jumbo frames
+	// or MTU exceeds maximum receive packet length of port.
+	MTU uint16
+	// If true, port receives only packets to its MAC address, broadcast
+	// and multicast packets. By default port is promiscuous.
+	DisablePromiscuous bool
+	// If true, port receives all multicast packets.
+	AllMulticast bool
+	// Numbers of descriptors in each receive and send queue of port.
+	// They are adjusted to limits of driver.
+	RXDescriptors uint16
+	TXDescriptors uint16
+	// MAC address which replaces default MAC address of port.
+	MAC [common.EtherAddrLen]uint8
+	// Multicast addresses which port receives.
+	MulticastAddrs [][common.EtherAddrLen]uint8
 }
 
 // Config is a struct with all parameters, which user can pass to NFF-GO library
@@ -414,7 +471,7 @@ type Config struct {
 	// 1500.
 	ScaleTime uint
 	// Time in miliseconds for scheduler to check changing of flow
-	// function behaviour. Default value is 10000.
+	// function behaviour and link state of ports. Default value is 10000.
 	CheckTime uint
 	// Time in miliseconds for scheduler to display statistics.
 	// Default value is 1000.
@@ -434,6 +491,20 @@ type Config struct {
 	// Scheduler should clone functions even if ti can lead to reordering.
 	// This option should be switch off for all high level reassembling like TCP or HTTP
 	RestrictedCloning bool
+	// Run flow graph without DPDK. Rings, mbufs and ports are emulated
+	// in process memory, so graphs can be checked without NICs and
+	// hugepages. Packets are injected into virtual ports by InjectPackets
+	// and InjectPcap and sent packets are collected by SentPackets.
+	// DPDKArgs and NeedKNI are ignored. Default value is false.
+	Simulation bool
+	// Number of virtual ports in simulation mode. Default value is 1.
+	SimulationPorts uint16
+	// Policy which decides when scheduler adds and removes clones and
+	// instances of flow functions. Default value is DefaultSchedulerPolicy.
+	SchedulerPolicy SchedulerPolicy
+	// Virtual devices which are created after DPDK initialization.
+	// Their ports are stored to Port fields of elements.
+	VdevPorts []VdevPort
 }
 
 // SystemInit is initialization of system. This function should be always called before graph construction.
@@ -442,6 +513,10 @@ func SystemInit(args *Config) error {
 		args = &Config{}
 	}
 	CPUCoresNumber := runtime.NumCPU()
+	if args.Simulation && CPUCoresNumber < simulationCores {
+		// Flow functions are not bound to cores in simulation mode
+		CPUCoresNumber = simulationCores
+	}
 	var cpus []int
 	var err error
 	if args.CPUList != "" {
@@ -516,31 +591,42 @@ func SystemInit(args *Config) error {
 		maxInIndex = args.MaxInIndex
 	}
 
-	argc, argv := low.InitDPDKArguments(args.DPDKArgs)
-	// We want to add new clone if input ring is approximately 80% full
-	maxPacketsToClone := uint32(sizeMultiplier * burstSize / 5 * 4)
-	// TODO all low level initialization here! Now everything is default.
-	// Init eal
-	common.LogTitle(common.Initialization, "------------***-------- Initializing DPDK --------***------------")
-	if err := low.InitDPDK(argc, argv, burstSize, mbufNumber, mbufCacheSize, needKNI); err != nil {
-		return err
+	policy := args.SchedulerPolicy
+	if policy == nil {
+		policy = DefaultSchedulerPolicy{}
 	}
-	// Init Ports
-	createdPorts = make([]port, low.GetPortsNumber(), low.GetPortsNumber())
-	for i := range createdPorts {
-		createdPorts[i].port = uint16(i)
-		if maxInIndex > low.CheckPortRSS(createdPorts[i].port) {
-			createdPorts[i].InIndex = low.CheckPortRSS(createdPorts[i].port)
-		} else {
-			createdPorts[i].InIndex = maxInIndex
+	if args.Simulation {
+		simulationPorts := uint16(1)
+		if args.SimulationPorts != 0 {
+			simulationPorts = args.SimulationPorts
+		}
+		common.LogTitle(common.Initialization, "------------***------ Initializing simulation ----***------------")
+		if err := low.InitSimulation(burstSize, mbufNumber, mbufCacheSize, simulationPorts); err != nil {
+			return err
+		}
+	} else {
+		argc, argv := low.InitDPDKArguments(args.DPDKArgs)
+		// TODO all low level initialization here! Now everything is default.
+		// Init eal
+		common.LogTitle(common.Initialization, "------------***-------- Initializing DPDK --------***------------")
+		if err := low.InitDPDK(argc, argv, burstSize, mbufNumber, mbufCacheSize, needKNI); err != nil {
+			return err
 		}
 	}
+	// Init Ports
+	portMaxInIndex = maxInIndex
+	createdPorts = nil
 	portPair = make(map[uint32](*port))
+	localPorts = make(map[localPortKey]uint16)
+	addPorts(low.GetPortsNumber())
+	if err := createVdevPorts(args.VdevPorts); err != nil {
+		return err
+	}
 	// Init scheduler
 	common.LogTitle(common.Initialization, "------------***------ Initializing scheduler -----***------------")
 	StopRing := low.CreateRings(burstSize*sizeMultiplier, maxInIndex)
 	common.LogDebug(common.Initialization, "Scheduler can use cores:", cpus)
-	schedState = newScheduler(cpus, schedulerOff, schedulerOffRemove, stopDedicatedCore, StopRing, checkTime, debugTime, maxPacketsToClone, maxRecv, anyway)
+	schedState = newScheduler(cpus, schedulerOff, schedulerOffRemove, stopDedicatedCore, StopRing, checkTime, debugTime, uint32(sizeMultiplier*burstSize), maxRecv, anyway, policy)
 	// Init packet processing
 	packet.SetHWTXChecksumFlag(hwtxchecksum)
 	for i := 0; i < 10; i++ {
@@ -551,6 +637,40 @@ func SystemInit(args *Config) error {
 	return nil
 }
 
+// addPorts adds ports up to given number to created ports.
+func addPorts(number int) {
+	for i := len(createdPorts); i < number; i++ {
+		p := port{port: uint16(i), InIndex: portMaxInIndex}
+		if portMaxInIndex > low.CheckPortRSS(p.port) {
+			p.InIndex = low.CheckPortRSS(p.port)
+		}
+		createdPorts = append(createdPorts, p)
+	}
+	// Ports could be moved, so pointers are updated
+	for ip, p := range portPair {
+		portPair[ip] = &createdPorts[p.port]
+	}
+}
+
+// create creates and starts port with requested queues.
+func (p *port) create() error {
+	config := &p.config
+	if err := low.CreatePort(p.port, p.willReceive, uint16(p.txQueuesNumber), hwtxchecksum, p.InIndex,
+		&low.PortConfig{
+			Promiscuous:    !config.DisablePromiscuous,
+			AllMulticast:   config.AllMulticast,
+			MTU:            config.MTU,
+			RXDescriptors:  config.RXDescriptors,
+			TXDescriptors:  config.TXDescriptors,
+			MAC:            config.MAC,
+			MulticastAddrs: config.MulticastAddrs,
+		}); err != nil {
+		return err
+	}
+	p.started = true
+	return nil
+}
+
 // SystemInitPortsAndMemory performs all initialization necessary to
 // create and send new packets before scheduler may be started.
 func SystemInitPortsAndMemory() error {
@@ -560,13 +680,12 @@ func SystemInitPortsAndMemory() error {
 	common.LogTitle(common.Initialization, "------------***---------- Creating ports ---------***------------")
 	for i := range createdPorts {
 		if createdPorts[i].wasRequested {
-			if err := low.CreatePort(createdPorts[i].port, createdPorts[i].willReceive,
-				uint16(createdPorts[i].txQueuesNumber), true, hwtxchecksum, createdPorts[i].InIndex); err != nil {
+			if err := createdPorts[i].create(); err != nil {
 				return err
 			}
 		}
-		createdPorts[i].MAC = GetPortMACAddress(createdPorts[i].port)
-		common.LogDebug(common.Initialization, "Port", createdPorts[i].port, "MAC address:", packet.MACToString(createdPorts[i].MAC))
+		createdPorts[i].setMAC(GetPortMACAddress(createdPorts[i].port))
+		common.LogDebug(common.Initialization, "Port", createdPorts[i].port, "MAC address:", packet.MACToString(createdPorts[i].getMAC()))
 	}
 	common.LogTitle(common.Initialization, "------------***------ Starting FlowFunctions -----***------------")
 	// Init low performance mempool
@@ -609,6 +728,7 @@ func SystemStop() error {
 		if createdPorts[i].wasRequested {
 			low.StopPort(createdPorts[i].port)
 			createdPorts[i].wasRequested = false
+			createdPorts[i].started = false
 			createdPorts[i].txQueuesNumber = 0
 			createdPorts[i].willReceive = false
 		}
@@ -652,25 +772,44 @@ func SetSenderFile(IN *Flow, filename string) error {
 func SetReceiverFile(filename string, repcount int32) (OUT *Flow) {
 	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
 	addReader(filename, rings, repcount)
-	return newFlow(rings, 1)
+	return newFlow(rings, 1, anySocket)
 }
 
 // SetReceiver adds receive function to flow graph.
 // Gets port number from which packets will be received.
 // Receive queue will be added to port automatically.
+// Options can pin receiver to cores, limit its instances or name it.
 // Returns new opened flow with received packets
-func SetReceiver(portId uint16) (OUT *Flow, err error) {
+func SetReceiver(portId uint16, opts ...FlowOption) (OUT *Flow, err error) {
 	if portId >= uint16(len(createdPorts)) {
 		return nil, common.WrapWithNFError(nil, "Requested receive port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
 	}
 	if createdPorts[portId].willReceive {
 		return nil, common.WrapWithNFError(nil, "Requested receive port was already set to receive. Two receives from one port are prohibited.", common.MultipleReceivePort)
 	}
+	if createdPorts[portId].started {
+		return nil, common.WrapWithNFError(nil, "Requested receive port is already started, its queues can't be changed.", common.WrongPort)
+	}
 	createdPorts[portId].wasRequested = true
 	createdPorts[portId].willReceive = true
-	rings := low.CreateRings(burstSize*sizeMultiplier, createdPorts[portId].InIndex)
-	addReceiver(portId, false, rings, createdPorts[portId].InIndex)
-	return newFlow(rings, createdPorts[portId].InIndex), nil
+	// Rings and following flow functions are placed on NUMA node of port
+	socket := low.GetPortSocket(portId)
+	rings := low.CreateRingsOnSocket(burstSize*sizeMultiplier, createdPorts[portId].InIndex, socket)
+	if err := schedState.applyOptions(addReceiver(portId, false, rings, createdPorts[portId].InIndex), opts); err != nil {
+		return nil, err
+	}
+	return newFlow(rings, createdPorts[portId].InIndex, socket), nil
+}
+
+// SetPortConfig sets optional parameters of port. They are applied
+// when port is initialized by SystemInitPortsAndMemory or SystemStart
+// if port is used by flow graph, so should be set before them.
+func SetPortConfig(portId uint16, config PortConfig) error {
+	if portId >= uint16(len(createdPorts)) {
+		return common.WrapWithNFError(nil, "Requested port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	createdPorts[portId].config = config
+	return nil
 }
 
 // SetReceiverKNI adds function receive from KNI to flow graph.
@@ -680,7 +819,7 @@ func SetReceiver(portId uint16) (OUT *Flow, err error) {
 func SetReceiverKNI(kni *Kni) (OUT *Flow) {
 	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
 	addReceiver(kni.portId, true, rings, 1)
-	return newFlow(rings, 1)
+	return newFlow(rings, 1, anySocket)
 }
 
 // SetFastGenerator adds clonable generate function to flow graph.
@@ -692,7 +831,7 @@ func SetFastGenerator(f GenerateFunction, targetSpeed uint64, context UserContex
 	if err := addFastGenerator(rings, f, nil, targetSpeed, context); err != nil {
 		return nil, err
 	}
-	return newFlow(rings, 1), nil
+	return newFlow(rings, 1, anySocket), nil
 }
 
 // SetVectorFastGenerator adds clonable vector generate function to flow graph.
@@ -704,7 +843,7 @@ func SetVectorFastGenerator(f VectorGenerateFunction, targetSpeed uint64, contex
 	if err := addFastGenerator(rings, nil, f, targetSpeed, context); err != nil {
 		return nil, err
 	}
-	return newFlow(rings, 1), nil
+	return newFlow(rings, 1, anySocket), nil
 }
 
 // SetGenerator adds non-clonable generate flow function to flow graph.
@@ -715,7 +854,7 @@ func SetVectorFastGenerator(f VectorGenerateFunction, targetSpeed uint64, contex
 func SetGenerator(f GenerateFunction, context UserContext) (OUT *Flow) {
 	rings := low.CreateRings(burstSize*sizeMultiplier, 1)
 	addGenerator(rings, f, context)
-	return newFlow(rings, 1)
+	return newFlow(rings, 1, anySocket)
 }
 
 // SetSender adds send function to flow graph.
@@ -728,6 +867,9 @@ func SetSender(IN *Flow, portId uint16) error {
 	if portId >= uint16(len(createdPorts)) {
 		return common.WrapWithNFError(nil, "Requested send port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
 	}
+	if createdPorts[portId].started {
+		return common.WrapWithNFError(nil, "Requested send port is already started, its queues can't be changed.", common.WrongPort)
+	}
 	createdPorts[portId].wasRequested = true
 	addSender(portId, createdPorts[portId].txQueuesNumber, finishFlow(IN), IN.inIndexNumber)
 	createdPorts[portId].txQueuesNumber++
@@ -751,19 +893,19 @@ func SetCopier(IN *Flow) (OUT *Flow, err error) {
 	if err := checkFlow(IN); err != nil {
 		return nil, err
 	}
-	ringFirst := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
-	ringSecond := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+	ringFirst := low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
+	ringSecond := low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 	if IN.segment == nil {
-		addCopier(IN.current, ringFirst, ringSecond, IN.inIndexNumber)
+		addCopier(IN.current, ringFirst, ringSecond, IN.inIndexNumber, IN.socket)
 	} else {
-		tRing := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+		tRing := low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 		ms := makeSlice(tRing, IN.segment)
 		segmentInsert(IN, ms, false, nil, 0, 0)
-		addCopier(tRing, ringFirst, ringSecond, IN.inIndexNumber)
+		addCopier(tRing, ringFirst, ringSecond, IN.inIndexNumber, IN.socket)
 		IN.segment = nil
 	}
 	IN.current = ringFirst
-	return newFlow(ringSecond, IN.inIndexNumber), nil
+	return newFlow(ringSecond, IN.inIndexNumber, IN.socket), nil
 }
 
 // SetPartitioner adds partition function to flow graph.
@@ -784,31 +926,39 @@ func SetPartitioner(IN *Flow, N uint64, M uint64) (OUT *Flow, err error) {
 	if err := segmentInsert(IN, partition, false, *ctx, 0, 0); err != nil {
 		return nil, err
 	}
-	return newFlowSegment(IN.segment, &partition.next[1], IN.inIndexNumber), nil
+	return newFlowSegment(IN.segment, &partition.next[1], IN.inIndexNumber, IN.socket), nil
 }
 
 // SetSeparator adds separate function to flow graph.
 // Gets flow, user defined separate function and context. Returns new opened flow.
 // Each packet from input flow will be remain inside input packet if
 // user defined function returns "true" and is sent to new flow otherwise.
-func SetSeparator(IN *Flow, separateFunction SeparateFunction, context UserContext) (OUT *Flow, err error) {
+// Options are hints for scheduler about flow function of separator.
+func SetSeparator(IN *Flow, separateFunction SeparateFunction, context UserContext, opts ...FlowOption) (OUT *Flow, err error) {
 	separate := makeSeparator(separateFunction, nil)
 	if err := segmentInsert(IN, separate, false, context, 1, 1); err != nil {
 		return nil, err
 	}
-	return newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber), nil
+	if err := schedState.applyFuncOptions(IN.segment, separate, opts); err != nil {
+		return nil, err
+	}
+	return newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber, IN.socket), nil
 }
 
 // SetVectorSeparator adds vector separate function to flow graph.
 // Gets flow, user defined vector separate function and context. Returns new opened flow.
 // Each packet from input flow will be remain inside input packet if
 // user defined function returns "true" and is sent to new flow otherwise.
-func SetVectorSeparator(IN *Flow, vectorSeparateFunction VectorSeparateFunction, context UserContext) (OUT *Flow, err error) {
+// Options are hints for scheduler about flow function of separator.
+func SetVectorSeparator(IN *Flow, vectorSeparateFunction VectorSeparateFunction, context UserContext, opts ...FlowOption) (OUT *Flow, err error) {
 	separate := makeSeparator(nil, vectorSeparateFunction)
 	if err := segmentInsert(IN, separate, false, context, 2, 1); err != nil {
 		return nil, err
 	}
-	return newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber), nil
+	if err := schedState.applyFuncOptions(IN.segment, separate, opts); err != nil {
+		return nil, err
+	}
+	return newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber, IN.socket), nil
 }
 
 // SetSplitter adds split function to flow graph.
@@ -816,15 +966,19 @@ func SetVectorSeparator(IN *Flow, vectorSeparateFunction VectorSeparateFunction,
 // Returns array of new opened flows with corresponding length.
 // Each packet from input flow will be sent to one of new flows based on
 // user defined function output for this packet.
-func SetSplitter(IN *Flow, splitFunction SplitFunction, flowNumber uint, context UserContext) (OutArray [](*Flow), err error) {
+// Options are hints for scheduler about flow function of splitter.
+func SetSplitter(IN *Flow, splitFunction SplitFunction, flowNumber uint, context UserContext, opts ...FlowOption) (OutArray [](*Flow), err error) {
 	if err := checkFlow(IN); err != nil {
 		return nil, err
 	}
 	split := makeSplitter(splitFunction, nil, uint8(flowNumber))
 	segmentInsert(IN, split, true, context, 1, 0)
+	if err := schedState.applyFuncOptions(IN.segment, split, opts); err != nil {
+		return nil, err
+	}
 	OutArray = make([](*Flow), flowNumber, flowNumber)
 	for i := range OutArray {
-		OutArray[i] = newFlowSegment(IN.segment, &split.next[i], IN.inIndexNumber)
+		OutArray[i] = newFlowSegment(IN.segment, &split.next[i], IN.inIndexNumber, IN.socket)
 	}
 	return OutArray, nil
 }
@@ -834,15 +988,19 @@ func SetSplitter(IN *Flow, splitFunction SplitFunction, flowNumber uint, context
 // Returns array of new opened flows with corresponding length.
 // Each packet from input flow will be sent to one of new flows based on
 // user defined function output for this packet.
-func SetVectorSplitter(IN *Flow, vectorSplitFunction VectorSplitFunction, flowNumber uint, context UserContext) (OutArray [](*Flow), err error) {
+// Options are hints for scheduler about flow function of splitter.
+func SetVectorSplitter(IN *Flow, vectorSplitFunction VectorSplitFunction, flowNumber uint, context UserContext, opts ...FlowOption) (OutArray [](*Flow), err error) {
 	if err := checkFlow(IN); err != nil {
 		return nil, err
 	}
 	split := makeSplitter(nil, vectorSplitFunction, uint8(flowNumber))
 	segmentInsert(IN, split, true, context, 2, 0)
+	if err := schedState.applyFuncOptions(IN.segment, split, opts); err != nil {
+		return nil, err
+	}
 	OutArray = make([](*Flow), flowNumber, flowNumber)
 	for i := range OutArray {
-		OutArray[i] = newFlowSegment(IN.segment, &split.next[i], IN.inIndexNumber)
+		OutArray[i] = newFlowSegment(IN.segment, &split.next[i], IN.inIndexNumber, IN.socket)
 	}
 	return OutArray, nil
 }
@@ -875,42 +1033,58 @@ func DealARPICMP(IN *Flow) error {
 // Gets flow, user defined handle function and context.
 // Each packet from input flow will be handle inside user defined function
 // and sent further in the same flow.
-func SetHandler(IN *Flow, handleFunction HandleFunction, context UserContext) error {
+// Options are hints for scheduler about flow function of handler.
+func SetHandler(IN *Flow, handleFunction HandleFunction, context UserContext, opts ...FlowOption) error {
 	handle := makeHandler(handleFunction, nil)
-	return segmentInsert(IN, handle, false, context, 1, 0)
+	if err := segmentInsert(IN, handle, false, context, 1, 0); err != nil {
+		return err
+	}
+	return schedState.applyFuncOptions(IN.segment, handle, opts)
 }
 
 // SetVectorHandler adds vector handle function to flow graph.
 // Gets flow, user defined vector handle function and context.
 // Each packet from input flow will be handle inside user defined function
 // and sent further in the same flow.
-func SetVectorHandler(IN *Flow, vectorHandleFunction VectorHandleFunction, context UserContext) error {
+// Options are hints for scheduler about flow function of handler.
+func SetVectorHandler(IN *Flow, vectorHandleFunction VectorHandleFunction, context UserContext, opts ...FlowOption) error {
 	handle := makeHandler(nil, vectorHandleFunction)
-	return segmentInsert(IN, handle, false, context, 2, 0)
+	if err := segmentInsert(IN, handle, false, context, 2, 0); err != nil {
+		return err
+	}
+	return schedState.applyFuncOptions(IN.segment, handle, opts)
 }
 
 // SetHandlerDrop adds vector handle function to flow graph.
 // Gets flow, user defined handle function and context.
 // User defined function can return boolean value.
 // If user function returns false after handling a packet it is dropped automatically.
-func SetHandlerDrop(IN *Flow, separateFunction SeparateFunction, context UserContext) error {
+// Options are hints for scheduler about flow function of handler.
+func SetHandlerDrop(IN *Flow, separateFunction SeparateFunction, context UserContext, opts ...FlowOption) error {
 	separate := makeSeparator(separateFunction, nil)
 	if err := segmentInsert(IN, separate, false, context, 1, 1); err != nil {
 		return err
 	}
-	return SetStopper(newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber))
+	if err := schedState.applyFuncOptions(IN.segment, separate, opts); err != nil {
+		return err
+	}
+	return SetStopper(newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber, IN.socket))
 }
 
 // SetVectorHandlerDrop adds vector handle function to flow graph.
 // Gets flow, user defined vector handle function and context.
 // User defined function can return boolean value.
 // If user function returns false after handling a packet it is dropped automatically.
-func SetVectorHandlerDrop(IN *Flow, vectorSeparateFunction VectorSeparateFunction, context UserContext) error {
+// Options are hints for scheduler about flow function of handler.
+func SetVectorHandlerDrop(IN *Flow, vectorSeparateFunction VectorSeparateFunction, context UserContext, opts ...FlowOption) error {
 	separate := makeSeparator(nil, vectorSeparateFunction)
 	if err := segmentInsert(IN, separate, false, context, 2, 1); err != nil {
 		return err
 	}
-	return SetStopper(newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber))
+	if err := schedState.applyFuncOptions(IN.segment, separate, opts); err != nil {
+		return err
+	}
+	return SetStopper(newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber, IN.socket))
 }
 
 // SetMerger adds merge function to flow graph.
@@ -919,12 +1093,19 @@ func SetVectorHandlerDrop(IN *Flow, vectorSeparateFunction VectorSeparateFunctio
 // This function isn't use any cores. It changes output flows of other functions at initialization stage.
 func SetMerger(InArray ...*Flow) (OUT *Flow, err error) {
 	max := int32(0)
+	socket := anySocket
 	for i := range InArray {
 		if InArray[i].inIndexNumber > max {
 			max = InArray[i].inIndexNumber
 		}
+		// Merged flow is local only if all input flows are on one node
+		if i == 0 {
+			socket = InArray[i].socket
+		} else if InArray[i].socket != socket {
+			socket = anySocket
+		}
 	}
-	rings := low.CreateRings(burstSize*sizeMultiplier, max)
+	rings := low.CreateRingsOnSocket(burstSize*sizeMultiplier, max, socket)
 	for i := range InArray {
 		if err := checkFlow(InArray[i]); err != nil {
 			return nil, err
@@ -938,7 +1119,7 @@ func SetMerger(InArray ...*Flow) (OUT *Flow, err error) {
 			segmentInsert(InArray[i], ms, true, nil, 0, 0)
 		}
 	}
-	return newFlow(rings, max), nil
+	return newFlow(rings, max, socket), nil
 }
 
 // GetPortMACAddress returns default MAC address of an Ethernet port.
@@ -946,6 +1127,37 @@ func GetPortMACAddress(port uint16) [common.EtherAddrLen]uint8 {
 	return low.GetPortMACAddress(port)
 }
 
+// GetPortStats returns basic statistics of an Ethernet port.
+func GetPortStats(port uint16) (common.PortStats, error) {
+	return low.GetPortStats(port)
+}
+
+// GetPortXstats returns all extended statistics of an Ethernet port
+// by their names. Set of names depends on driver.
+func GetPortXstats(port uint16) (map[string]uint64, error) {
+	return low.GetPortXstats(port)
+}
+
+// GetPortXstat returns extended statistic of an Ethernet port with
+// given name, for example "rx_good_packets".
+func GetPortXstat(port uint16, name string) (uint64, error) {
+	xstats, err := low.GetPortXstats(port)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := xstats[name]
+	if !ok {
+		return 0, common.WrapWithNFError(nil, "Port doesn't have extended statistic "+name, common.BadArgument)
+	}
+	return value, nil
+}
+
+// GetPortLinkStatus returns current link state of an Ethernet port:
+// up or down, speed and duplex.
+func GetPortLinkStatus(port uint16) (common.LinkStatus, error) {
+	return low.GetPortLinkStatus(port)
+}
+
 // SetIPForPort sets IP for specified port if it was created. Not thread safe.
 // Return error if requested port isn't exist or wasn't previously requested.
 func SetIPForPort(port uint16, ip uint32) error {
@@ -959,16 +1171,17 @@ func SetIPForPort(port uint16, ip uint32) error {
 }
 
 // Service functions for Flow
-func newFlow(rings low.Rings, inIndexNumber int32) *Flow {
+func newFlow(rings low.Rings, inIndexNumber int32, socket int) *Flow {
 	OUT := new(Flow)
 	OUT.current = rings
 	OUT.inIndexNumber = inIndexNumber
+	OUT.socket = socket
 	openFlowsNumber++
 	return OUT
 }
 
-func newFlowSegment(segment *processSegment, previous **Func, inIndexNumber int32) *Flow {
-	OUT := newFlow(nil, inIndexNumber)
+func newFlowSegment(segment *processSegment, previous **Func, inIndexNumber int32, socket int) *Flow {
+	OUT := newFlow(nil, inIndexNumber, socket)
 	OUT.segment = segment
 	OUT.previous = previous
 	return OUT
@@ -980,7 +1193,7 @@ func finishFlow(IN *Flow) low.Rings {
 		ring = IN.current
 		closeFlow(IN)
 	} else {
-		ring = low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+		ring = low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 		ms := makeSlice(ring, IN.segment)
 		segmentInsert(IN, ms, true, nil, 0, 0)
 	}
@@ -998,12 +1211,12 @@ func segmentInsert(IN *Flow, f *Func, willClose bool, context UserContext, setTy
 		return err
 	}
 	if IN.segment == nil {
-		IN.segment = addSegment(IN.current, f, IN.inIndexNumber)
+		IN.segment = addSegment(IN.current, f, IN.inIndexNumber, IN.socket)
 		IN.segment.stype = setType
 	} else {
 		if setType > 0 && IN.segment.stype > 0 && setType != IN.segment.stype {
 			// Try to combine scalar and vector code. Start new segment
-			ring := low.CreateRings(burstSize*sizeMultiplier, IN.inIndexNumber)
+			ring := low.CreateRingsOnSocket(burstSize*sizeMultiplier, IN.inIndexNumber, IN.socket)
 			ms := makeSlice(ring, IN.segment)
 			segmentInsert(IN, ms, false, nil, 0, 0)
 			IN.segment = nil
@@ -1037,10 +1250,14 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 	InputMbufs := make([]uintptr, burstSize, burstSize)
 	OutputMbufs := make([][]uintptr, outNumber)
 	countOfPackets := make([]int, outNumber)
+	// Outputs to stopper, packets sent to them are counted as dropped
+	toStop := make([]bool, outNumber)
 	for index := range OutputMbufs {
 		OutputMbufs[index] = make([]uintptr, burstSize)
 		countOfPackets[index] = 0
+		toStop[index] = lp.drops != nil && len(OUT[index]) != 0 && OUT[index][0] == schedState.StopRing[0]
 	}
+	var drops dropCounters
 	var currentState reportPair
 	var pause int
 	firstFunc := lp.firstFunc
@@ -1064,6 +1281,9 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 			tick.Stop()
 			if pause == -1 {
 				// It is time to close this clone
+				if lp.drops != nil {
+					lp.drops.add(&drops)
+				}
 				for i := range context {
 					if context[i] != nil {
 						context[i].Delete()
@@ -1080,6 +1300,9 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 		case <-tick.C:
 			report <- currentState
 			currentState = reportPair{}
+			if lp.drops != nil {
+				lp.drops.add(&drops)
+			}
 		default:
 			for q := int32(1); q < inIndex[0]+1; q++ {
 				n := IN[inIndex[q]].DequeueBurst(InputMbufs, burstSize)
@@ -1106,6 +1329,9 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 								// We have constructSlice -> put packets to output slices
 								OutputMbufs[nextIndex][countOfPackets[nextIndex]] = InputMbufs[i]
 								countOfPackets[nextIndex]++
+								if toStop[nextIndex] {
+									drops.countDrop(tempPacket)
+								}
 								if reportMbits {
 									currentState.V.Bytes += uint64(tempPacket.GetPacketLen())
 								}
@@ -1134,6 +1360,13 @@ func segmentProcess(parameters interface{}, inIndex []int32, stopper [2]chan int
 						cur.vFunc(tempPackets, &def[st].mask, &answers, cur, context[cur.contextIndex])
 						if cur.followingNumber == 0 {
 							// We have constructSlice -> put packets inside ring, it is an end of segment
+							if toStop[answers[0]] {
+								for i := uint(0); i < n; i++ {
+									if def[st].mask[i] {
+										drops.countDrop(tempPackets[i])
+									}
+								}
+							}
 							count := FillSliceFromMask(InputMbufs, &def[st].mask, OutputMbufs[0])
 							safeEnqueue(OUT[answers[0]][inIndex[q]], OutputMbufs[0], uint(count))
 							currentState.V.Packets += uint64(count)
@@ -1367,6 +1600,16 @@ func merge(from low.Rings, to low.Rings) {
 			if parameters.outCopy[0] == from[0] {
 				parameters.outCopy = to
 			}
+		case *bridgeParameters:
+			for j := range parameters.out {
+				if parameters.out[j][0] == from[0] {
+					parameters.out[j] = to
+				}
+			}
+		case *shaperParameters:
+			if parameters.out[0] == from[0] {
+				parameters.out = to
+			}
 		}
 	}
 }
@@ -1567,19 +1810,77 @@ func checkFlow(f *Flow) error {
 	return nil
 }
 
+// KniOps are handlers of requests which kernel sends when KNI interface
+// is configured, for example by "ip link set". Handlers are called with
+// port of KNI device and should apply change to it. Nil handler means
+// default behaviour which applies change to port by DPDK, failed
+// request is reported to kernel as error. Packet counters of interface
+// are maintained by KNI kernel module, they count packets which were
+// passed through KNI device.
+type KniOps struct {
+	// ChangeMTU is called when MTU of interface is changed.
+	ChangeMTU func(port uint16, mtu uint16) error
+	// ConfigLink is called when interface is set up or down.
+	ConfigLink func(port uint16, up bool) error
+	// ConfigMAC is called when MAC address of interface is changed.
+	ConfigMAC func(port uint16, mac [common.EtherAddrLen]uint8) error
+}
+
+func defaultKniChangeMTU(port uint16, mtu uint16) error {
+	return low.SetPortMTU(port, mtu)
+}
+
+func defaultKniConfigLink(port uint16, up bool) error {
+	return low.SetPortLinkUp(port, up)
+}
+
+func defaultKniConfigMAC(port uint16, mac [common.EtherAddrLen]uint8) error {
+	if err := low.SetPortMACAddress(port, mac); err != nil {
+		return err
+	}
+	// Predefined handlers answer with MAC address of port
+	createdPorts[port].setMAC(mac)
+	return nil
+}
+
+// lowOps returns handlers for low with default handlers instead of nil.
+func (ops *KniOps) lowOps() *low.KniOps {
+	res := &low.KniOps{
+		ChangeMTU:  ops.ChangeMTU,
+		ConfigLink: ops.ConfigLink,
+		ConfigMAC:  ops.ConfigMAC,
+	}
+	if res.ChangeMTU == nil {
+		res.ChangeMTU = defaultKniChangeMTU
+	}
+	if res.ConfigLink == nil {
+		res.ConfigLink = defaultKniConfigLink
+	}
+	if res.ConfigMAC == nil {
+		res.ConfigMAC = defaultKniConfigMAC
+	}
+	return res
+}
+
 // CreateKniDevice creates KNI device for using in receive or send functions.
-// Gets unique port, and unique name of future KNI device.
-func CreateKniDevice(portId uint16, name string) (*Kni, error) {
+// Gets unique port, and unique name of future KNI device. Optional ops
+// replace default handlers of configuration requests, only first of them
+// is used.
+func CreateKniDevice(portId uint16, name string, ops ...KniOps) (*Kni, error) {
 	if portId >= uint16(len(createdPorts)) {
 		return nil, common.WrapWithNFError(nil, "Requested KNI port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
 	}
 	if createdPorts[portId].willKNI {
 		return nil, common.WrapWithNFError(nil, "Requested KNI port already has KNI. Two KNIs for one port are prohibited.", common.MultipleKNIPort)
 	}
-	if core, coreIndex, err := schedState.getCore(); err != nil {
+	if core, coreIndex, err := schedState.getCore(low.GetPortSocket(portId), nil); err != nil {
 		return nil, err
 	} else {
-		if err := low.CreateKni(portId, uint(core), name); err != nil {
+		var kniOps KniOps
+		if len(ops) != 0 {
+			kniOps = ops[0]
+		}
+		if err := low.CreateKni(portId, uint(core), name, kniOps.lowOps()); err != nil {
 			return nil, err
 		}
 		kni := new(Kni)
@@ -1606,6 +1907,8 @@ func FillSliceFromMask(input []uintptr, mask *[burstSize]bool, output []uintptr)
 // AddTimer adds a timer which may call handler function every d milliseconds
 // It is required to add at least one variant of this timer for working
 // TODO d should be approximate as schedTime because handler will be call from scheduler
+// All variants share one duration and are checked every tick, so
+// TimerWheel should be used for large number of session timers.
 // Return created timer
 func AddTimer(d time.Duration, handler func(UserContext)) *Timer {
 	t := new(Timer)
@@ -1617,6 +1920,25 @@ func AddTimer(d time.Duration, handler func(UserContext)) *Timer {
 	return t
 }
 
+// SetLinkStateChangeHandler adds handler which is called by scheduler
+// when link of port goes up or down or changes its speed or duplex.
+// Handler gets port, new link state and given context. Link state is
+// checked every CheckTime, so short changes can be missed. Handlers are
+// called one by one outside of scheduler loop.
+func SetLinkStateChangeHandler(port uint16, handler func(uint16, common.LinkStatus, UserContext), context UserContext) error {
+	link, err := low.GetPortLinkStatus(port)
+	if err != nil {
+		return err
+	}
+	schedState.linkHandlers = append(schedState.linkHandlers, &linkHandler{
+		port:    port,
+		handler: handler,
+		context: context,
+		last:    link,
+	})
+	return nil
+}
+
 // AddVariant adds a variant for an existing timer. Variant is a context parameter
 // which will be passed to handler callback from AddTimer function
 // Function return a pointer to variable which should be set to "true"
@@ -0,0 +1,266 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Hash splitter
+// Hash splitter distributes packets between output flows according to
+// hash of chosen header fields, so all packets of one session go to the
+// same flow. Symmetric hash doesn't depend on order of source and
+// destination, so both directions of a session go to the same flow.
+// Consistent hash moves minimal share of sessions if number of flows
+// is changed.
+
+package flow
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// HashFields is a set of packet header fields which are used by hash
+// splitter together with hashing options.
+type HashFields uint
+
+// Header fields and options for hash splitter
+const (
+	// Source IPv4 or IPv6 address
+	HashSrcAddr HashFields = 1 << iota
+	// Destination IPv4 or IPv6 address
+	HashDstAddr
+	// L4 protocol number
+	HashProto
+	// Source TCP or UDP port
+	HashSrcPort
+	// Destination TCP or UDP port
+	HashDstPort
+	// VLAN ID, 0 for untagged packets
+	HashVLAN
+	// Hash doesn't depend on order of source and destination
+	HashSymmetric
+	// Jump consistent hash is used to choose output flow instead of modulo
+	HashConsistent
+	// Toeplitz hash is used in the same way as NIC RSS does it with default
+	// redirection table of 128 entries. Addresses are always used, ports
+	// are used if any port field is chosen. Other fields are ignored.
+	// Symmetric RSS key is used with HashSymmetric.
+	HashToeplitz
+	// Inner headers of GTP-U tunnelled packets are used. Other packets
+	// are hashed by their own headers.
+	HashInner
+
+	// Classic 5-tuple
+	Hash5Tuple = HashSrcAddr | HashDstAddr | HashProto | HashSrcPort | HashDstPort
+)
+
+type hashSplitterContext struct {
+	fields     HashFields
+	flowNumber uint
+}
+
+func (ctx hashSplitterContext) Copy() interface{} {
+	return hashSplitterContext{fields: ctx.fields, flowNumber: ctx.flowNumber}
+}
+
+func (ctx hashSplitterContext) Delete() {
+}
+
+const (
+	hashPrime = 0x100000001b3
+	hashBasis = 0xcbf29ce484222325
+)
+
+func hashMix(h, v uint64) uint64 {
+	return (h ^ v) * hashPrime
+}
+
+// Finalizer from MurmurHash3, it is required because modulo and jump
+// hash use only part of bits.
+func hashFinalize(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// hashEndpoint is one side of a session: address and port.
+type hashEndpoint struct {
+	addr [2]uint64
+	port uint16
+}
+
+func (e *hashEndpoint) less(other *hashEndpoint) bool {
+	if e.addr[0] != other.addr[0] {
+		return e.addr[0] < other.addr[0]
+	}
+	if e.addr[1] != other.addr[1] {
+		return e.addr[1] < other.addr[1]
+	}
+	return e.port < other.port
+}
+
+// packetHash calculates hash of given packet header fields. IPv4 and
+// IPv6 are supported with possible VLAN tag and IPv6 extension headers.
+// Only fields which are present in packet are used, so truncated packets
+// are hashed by addresses.
+func packetHash(pkt *packet.Packet, fields HashFields) uint64 {
+	var src, dst hashEndpoint
+	var proto uint8
+	var l3, l4 unsafe.Pointer
+
+	vlan := pkt.ParseL3CheckVLAN()
+	if fields&HashInner != 0 {
+		l3 = pkt.GetGTPUInnerL3()
+	}
+	if l3 == nil {
+		if etherType := pkt.GetEtherType(); etherType == common.IPV4Number || etherType == common.IPV6Number {
+			l3 = pkt.L3
+		}
+	}
+	// Headers are looked for only in the first segment
+	var length uintptr
+	if end := uintptr(unsafe.Pointer(pkt.Ether)) + uintptr(pkt.GetPacketSegmentLen()); l3 != nil && uintptr(l3) < end {
+		length = end - uintptr(l3)
+	}
+	if fields&HashToeplitz != 0 {
+		if l3 == nil {
+			return 0
+		}
+		key := packet.RSSDefaultKey
+		if fields&HashSymmetric != 0 {
+			key = packet.RSSSymmetricKey
+		}
+		inputSet := packet.RSSIP
+		if fields&(HashSrcPort|HashDstPort) != 0 {
+			inputSet = packet.RSSAll
+		}
+		return uint64(packet.CalculateRSSHash(l3, length, key, inputSet))
+	}
+
+	if length != 0 {
+		switch *(*uint8)(l3) >> 4 {
+		case 4:
+			if length >= common.IPv4MinLen {
+				ipv4 := (*packet.IPv4Hdr)(l3)
+				src.addr[0] = uint64(ipv4.SrcAddr)
+				dst.addr[0] = uint64(ipv4.DstAddr)
+			}
+		case 6:
+			if length >= common.IPv6Len {
+				ipv6 := (*packet.IPv6Hdr)(l3)
+				src.addr = *(*[2]uint64)(unsafe.Pointer(&ipv6.SrcAddr))
+				dst.addr = *(*[2]uint64)(unsafe.Pointer(&ipv6.DstAddr))
+			}
+		}
+		proto, l4 = packet.FindL4(l3, length)
+	}
+	if l4 != nil && (proto == common.TCPNumber || proto == common.UDPNumber) {
+		// Ports have the same offsets in TCP and UDP headers
+		udp := (*packet.UDPHdr)(l4)
+		src.port = udp.SrcPort
+		dst.port = udp.DstPort
+	}
+
+	if fields&HashSrcAddr == 0 {
+		src.addr = [2]uint64{}
+	}
+	if fields&HashDstAddr == 0 {
+		dst.addr = [2]uint64{}
+	}
+	if fields&HashSrcPort == 0 {
+		src.port = 0
+	}
+	if fields&HashDstPort == 0 {
+		dst.port = 0
+	}
+	if fields&HashSymmetric != 0 && dst.less(&src) {
+		src, dst = dst, src
+	}
+
+	h := uint64(hashBasis)
+	h = hashMix(h, src.addr[0])
+	h = hashMix(h, src.addr[1])
+	h = hashMix(h, dst.addr[0])
+	h = hashMix(h, dst.addr[1])
+	h = hashMix(h, uint64(src.port)<<16|uint64(dst.port))
+	if fields&HashProto != 0 {
+		h = hashMix(h, uint64(proto))
+	}
+	if fields&HashVLAN != 0 && vlan != nil {
+		h = hashMix(h, uint64(vlan.GetVLANTagIdentifier()))
+	}
+	return hashFinalize(h)
+}
+
+// jumpHash is a consistent hash from "A Fast, Minimal Memory, Consistent
+// Hash Algorithm" by Lamping and Veach.
+func jumpHash(key uint64, buckets uint) uint {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return uint(b)
+}
+
+func hashToFlow(h uint64, ctx *hashSplitterContext) uint {
+	if ctx.fields&HashConsistent != 0 {
+		return jumpHash(h, ctx.flowNumber)
+	}
+	if ctx.fields&HashToeplitz != 0 {
+		return packet.RSSQueue(uint32(h), 128, ctx.flowNumber)
+	}
+	return uint(h % uint64(ctx.flowNumber))
+}
+
+func hashSplit(pkt *packet.Packet, context UserContext) uint {
+	ctx := context.(hashSplitterContext)
+	return hashToFlow(packetHash(pkt, ctx.fields), &ctx)
+}
+
+func vHashSplit(packets []*packet.Packet, mask *[burstSize]bool, answers *[burstSize]uint8, context UserContext) {
+	ctx := context.(hashSplitterContext)
+	for i := range packets {
+		if mask[i] {
+			answers[i] = uint8(hashToFlow(packetHash(packets[i], ctx.fields), &ctx))
+		}
+	}
+}
+
+func checkHashSplitter(flowNumber uint, fields HashFields) error {
+	if flowNumber == 0 || flowNumber > 256 {
+		return common.WrapWithNFError(nil, "Hash splitter number of flows should be from 1 to 256", common.BadArgument)
+	}
+	if fields&(Hash5Tuple|HashVLAN|HashToeplitz) == 0 {
+		return common.WrapWithNFError(nil, "Hash splitter should have at least one header field", common.BadArgument)
+	}
+	return nil
+}
+
+// SetHashSplitter adds hash split function to flow graph.
+// Gets flow, number of new flows and set of header fields with hashing
+// options. Returns array of new opened flows with corresponding length.
+// Each packet from input flow will be sent to one of new flows based on
+// hash of chosen fields, so packets of the same session go to the same flow.
+func SetHashSplitter(IN *Flow, flowNumber uint, fields HashFields) (OutArray [](*Flow), err error) {
+	if err := checkHashSplitter(flowNumber, fields); err != nil {
+		return nil, err
+	}
+	return SetSplitter(IN, hashSplit, flowNumber, hashSplitterContext{fields: fields, flowNumber: flowNumber})
+}
+
+// SetVectorHashSplitter adds vector hash split function to flow graph.
+// Gets flow, number of new flows and set of header fields with hashing
+// options. Returns array of new opened flows with corresponding length.
+// Each packet from input flow will be sent to one of new flows based on
+// hash of chosen fields, so packets of the same session go to the same flow.
+func SetVectorHashSplitter(IN *Flow, flowNumber uint, fields HashFields) (OutArray [](*Flow), err error) {
+	if err := checkHashSplitter(flowNumber, fields); err != nil {
+		return nil, err
+	}
+	return SetVectorSplitter(IN, vHashSplit, flowNumber, hashSplitterContext{fields: fields, flowNumber: flowNumber})
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Verification suite from Microsoft RSS specification
+var hashTests = []struct {
+	src, dst         string
+	srcPort, dstPort uint16
+	wantIP, wantTCP  uint32
+}{
+	{"66.9.149.187", "161.142.100.80", 2794, 1766, 0x323e8fc2, 0x51ccc178},
+	{"199.92.111.2", "65.69.140.83", 14230, 4739, 0xd718262a, 0xc626b0ea},
+	{"24.19.198.95", "12.22.207.184", 12898, 38024, 0xd2d0a5de, 0x5c2b394a},
+	{"3ffe:2501:200:1fff::7", "3ffe:2501:200:3::1", 2794, 1766, 0x2cc18cd5, 0x40207d3d},
+	{"3ffe:501:8::260:97ff:fe40:efab", "ff02::1", 14230, 4739, 0x0f0c461c, 0xdde51bbf},
+}
+
+// initHashPackets initializes simulation and mempool of packets which
+// are created by tests without SystemStart.
+func initHashPackets() {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, LogType: common.No}))
+	packet.SetNonPerfMempool(low.CreateMempool("hash test"))
+}
+
+// makeHashPacket returns TCP packet with given addresses and ports.
+// Packet is IPv6 if addresses are IPv6 ones. Packet is tagged if vlan
+// isn't zero.
+func makeHashPacket(src, dst string, srcPort, dstPort, vlan uint16) *packet.Packet {
+	pkt, err := packet.NewPacket()
+	CheckFatal(err)
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if s, d := srcIP.To4(), dstIP.To4(); s != nil {
+		packet.InitEmptyIPv4TCPPacket(pkt, 0)
+		ipv4 := pkt.GetIPv4()
+		ipv4.SrcAddr = packet.BytesToIPv4(s[0], s[1], s[2], s[3])
+		ipv4.DstAddr = packet.BytesToIPv4(d[0], d[1], d[2], d[3])
+	} else {
+		packet.InitEmptyIPv6TCPPacket(pkt, 0)
+		copy(pkt.GetIPv6().SrcAddr[:], srcIP)
+		copy(pkt.GetIPv6().DstAddr[:], dstIP)
+	}
+	pkt.GetTCPNoCheck().SrcPort = packet.SwapBytesUint16(srcPort)
+	pkt.GetTCPNoCheck().DstPort = packet.SwapBytesUint16(dstPort)
+	if vlan != 0 && !pkt.AddVLANTag(vlan) {
+		common.LogFatal(common.Debug, "Can't add VLAN tag")
+	}
+	return pkt
+}
+
+func TestPacketHashToeplitz(t *testing.T) {
+	initHashPackets()
+	for _, tt := range hashTests {
+		for _, vlan := range []uint16{0, 10} {
+			pkt := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, vlan)
+			// Ports are used if any of them is chosen
+			if got := packetHash(pkt, HashToeplitz|HashSrcAddr); got != uint64(tt.wantIP) {
+				t.Errorf("Incorrect hash for %s -> %s in VLAN %d: got %x, want %x", tt.src, tt.dst, vlan, got, tt.wantIP)
+			}
+			if got := packetHash(pkt, HashToeplitz|HashDstPort); got != uint64(tt.wantTCP) {
+				t.Errorf("Incorrect TCP hash for %s -> %s in VLAN %d: got %x, want %x", tt.src, tt.dst, vlan, got, tt.wantTCP)
+			}
+		}
+	}
+}
+
+func TestPacketHashSymmetric(t *testing.T) {
+	initHashPackets()
+	for _, tt := range hashTests {
+		for _, vlan := range []uint16{0, 10} {
+			forward := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, vlan)
+			backward := makeHashPacket(tt.dst, tt.src, tt.dstPort, tt.srcPort, vlan)
+			for _, fields := range []HashFields{Hash5Tuple, Hash5Tuple | HashVLAN, HashToeplitz | HashSrcPort} {
+				if packetHash(forward, fields) == packetHash(backward, fields) {
+					t.Errorf("Hash %x of %s -> %s in VLAN %d doesn't depend on direction", fields, tt.src, tt.dst, vlan)
+				}
+				fields |= HashSymmetric
+				if f, b := packetHash(forward, fields), packetHash(backward, fields); f != b {
+					t.Errorf("Symmetric hash %x of %s -> %s in VLAN %d gives different hashes: %x and %x",
+						fields, tt.src, tt.dst, vlan, f, b)
+				}
+			}
+		}
+	}
+}
+
+func TestPacketHashFields(t *testing.T) {
+	initHashPackets()
+	for _, tt := range hashTests {
+		pkt := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 0)
+		otherPorts := makeHashPacket(tt.src, tt.dst, tt.srcPort+1, tt.dstPort+1, 0)
+		otherSrc := makeHashPacket(tt.dst, tt.dst, tt.srcPort, tt.dstPort, 0)
+		tagged := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 10)
+		otherVLAN := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 20)
+
+		if packetHash(pkt, HashSrcAddr) != packetHash(otherPorts, HashSrcAddr) {
+			t.Errorf("Address hash of %s depends on ports", tt.src)
+		}
+		if packetHash(pkt, HashSrcAddr) == packetHash(otherSrc, HashSrcAddr) {
+			t.Errorf("Address hash of %s doesn't depend on address", tt.src)
+		}
+		if packetHash(pkt, Hash5Tuple) == packetHash(otherPorts, Hash5Tuple) {
+			t.Errorf("5-tuple hash of %s doesn't depend on ports", tt.src)
+		}
+		// VLAN tag is skipped and its ID is used only if it is chosen
+		if packetHash(pkt, Hash5Tuple) != packetHash(tagged, Hash5Tuple) {
+			t.Errorf("5-tuple hash of %s depends on VLAN", tt.src)
+		}
+		if packetHash(tagged, Hash5Tuple|HashVLAN) == packetHash(otherVLAN, Hash5Tuple|HashVLAN) {
+			t.Errorf("VLAN hash of %s doesn't depend on VLAN ID", tt.src)
+		}
+		if packetHash(pkt, Hash5Tuple|HashVLAN) != packetHash(pkt, Hash5Tuple) {
+			t.Errorf("VLAN hash of untagged %s depends on VLAN field", tt.src)
+		}
+	}
+
+	// Non-first fragments are hashed without ports
+	tt := hashTests[0]
+	pkt := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 0)
+	otherPorts := makeHashPacket(tt.src, tt.dst, tt.srcPort+1, tt.dstPort+1, 0)
+	pkt.GetIPv4().FragmentOffset = packet.SwapBytesUint16(100)
+	otherPorts.GetIPv4().FragmentOffset = packet.SwapBytesUint16(100)
+	if packetHash(pkt, Hash5Tuple) != packetHash(otherPorts, Hash5Tuple) {
+		t.Error("Hash of non-first fragment depends on ports")
+	}
+}
+
+// withIPv6Ext returns copy of IPv6 packet with extension header inserted
+// after IPv6 header. Next header field of extension is set by function.
+func withIPv6Ext(pkt *packet.Packet, ext []byte) *packet.Packet {
+	raw := pkt.GetRawPacketBytes()
+	l3 := raw[common.EtherLen:]
+	data := append([]byte(nil), raw[:common.EtherLen+common.IPv6Len]...)
+	data = append(data, ext...)
+	data = append(data, l3[common.IPv6Len:]...)
+	// Next header of extension is the original one, IPv6 header points
+	// to extension
+	data[common.EtherLen+common.IPv6Len] = l3[6]
+	data[common.EtherLen+6] = ext[0]
+	ret, err := packet.NewPacket()
+	CheckFatal(err)
+	packet.GeneratePacketFromByte(ret, data)
+	return ret
+}
+
+func TestPacketHashIPv6Extensions(t *testing.T) {
+	initHashPackets()
+	const hopByHop, fragment = 0, 44
+	for _, tt := range hashTests[3:] {
+		pkt := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 0)
+		otherPorts := makeHashPacket(tt.src, tt.dst, tt.srcPort+1, tt.dstPort+1, 0)
+		// Type of extension is passed in next header byte and is replaced
+		hbh := withIPv6Ext(pkt, []byte{hopByHop, 0, 1, 4, 0, 0, 0, 0})
+		if got := packetHash(hbh, HashToeplitz|HashDstPort); got != uint64(tt.wantTCP) {
+			t.Errorf("Incorrect TCP hash for %s -> %s with Hop-by-Hop header: got %x, want %x", tt.src, tt.dst, got, tt.wantTCP)
+		}
+		if packetHash(hbh, Hash5Tuple) != packetHash(pkt, Hash5Tuple) {
+			t.Errorf("Hop-by-Hop header changes hash of %s", tt.src)
+		}
+		// First fragment has ports, other fragments are hashed by addresses
+		first := withIPv6Ext(pkt, []byte{fragment, 0, 0, 1, 0, 0, 0, 1})
+		if packetHash(first, Hash5Tuple) != packetHash(pkt, Hash5Tuple) {
+			t.Errorf("Hash of first fragment of %s differs from unfragmented packet", tt.src)
+		}
+		next := withIPv6Ext(pkt, []byte{fragment, 0, 0, 0x80, 0, 0, 0, 1})
+		nextOtherPorts := withIPv6Ext(otherPorts, []byte{fragment, 0, 0, 0x80, 0, 0, 0, 1})
+		if packetHash(next, Hash5Tuple) != packetHash(nextOtherPorts, Hash5Tuple) {
+			t.Errorf("Hash of non-first fragment of %s depends on ports", tt.src)
+		}
+		if got := packetHash(next, HashToeplitz|HashDstPort); got != uint64(tt.wantIP) {
+			t.Errorf("Incorrect hash of non-first fragment of %s: got %x, want %x", tt.src, got, tt.wantIP)
+		}
+	}
+}
+
+func TestPacketHashTruncated(t *testing.T) {
+	initHashPackets()
+	for _, tt := range hashTests {
+		pkt := makeHashPacket(tt.src, tt.dst, tt.srcPort, tt.dstPort, 0)
+		otherPorts := makeHashPacket(tt.src, tt.dst, tt.srcPort+1, tt.dstPort+1, 0)
+		// Only two bytes of TCP header are left
+		for _, p := range []*packet.Packet{pkt, otherPorts} {
+			p.ParseL3()
+			l4 := uint(uintptr(p.L4) - uintptr(unsafe.Pointer(p.Ether)))
+			if !low.TrimMbuf(p.CMbuf, p.GetPacketLen()-l4-2) {
+				t.Fatal("Can't trim packet")
+			}
+		}
+		if packetHash(pkt, Hash5Tuple) != packetHash(otherPorts, Hash5Tuple) {
+			t.Errorf("Hash of truncated packet %s depends on ports behind its end", tt.src)
+		}
+		if got := packetHash(pkt, HashToeplitz|HashDstPort); got != uint64(tt.wantIP) {
+			t.Errorf("Incorrect hash of truncated packet %s: got %x, want %x", tt.src, got, tt.wantIP)
+		}
+		// Packet is cut inside L3 header, so addresses aren't used
+		swapped := makeHashPacket(tt.dst, tt.src, tt.dstPort, tt.srcPort, 0)
+		for _, p := range []*packet.Packet{pkt, swapped} {
+			if !low.TrimMbuf(p.CMbuf, p.GetPacketLen()-common.EtherLen-8) {
+				t.Fatal("Can't trim packet")
+			}
+		}
+		if packetHash(pkt, Hash5Tuple|HashToeplitz) != 0 {
+			t.Errorf("Packet %s without complete L3 header has Toeplitz hash", tt.src)
+		}
+		if packetHash(pkt, Hash5Tuple) != packetHash(swapped, Hash5Tuple) {
+			t.Errorf("Hash of packet %s depends on addresses behind its end", tt.src)
+		}
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	const keys = 10000
+	r := rand.New(rand.NewSource(1))
+	buckets := make([]uint, keys)
+	hashes := make([]uint64, keys)
+	for i := range hashes {
+		hashes[i] = r.Uint64()
+	}
+	for n := uint(1); n <= 64; n++ {
+		moved := 0
+		for i, h := range hashes {
+			b := jumpHash(h, n)
+			if b >= n {
+				t.Fatalf("Key %x is mapped to bucket %d of %d", h, b, n)
+			}
+			// Keys move only to new bucket when number of buckets grows
+			if n > 1 && b != buckets[i] {
+				if b != n-1 {
+					t.Fatalf("Key %x moved from bucket %d to %d of %d", h, buckets[i], b, n)
+				}
+				moved++
+			}
+			buckets[i] = b
+		}
+		// New bucket gets its share of keys
+		if expected := keys / int(n); n > 1 && (moved < expected*7/10 || moved > expected*13/10) {
+			t.Errorf("%d keys moved to bucket %d instead of %d", moved, n-1, expected)
+		}
+	}
+}
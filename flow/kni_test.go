@@ -0,0 +1,60 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"testing"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+func TestKniOps(t *testing.T) {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, LogType: common.No}))
+	if _, err := CreateKniDevice(0, "kni0"); err == nil {
+		t.Error("KNI device is created in simulation mode")
+	}
+
+	// User handler replaces only default handler of its request
+	var userMTU uint16
+	ops := (&KniOps{ChangeMTU: func(port uint16, mtu uint16) error {
+		userMTU = mtu
+		return nil
+	}}).lowOps()
+	CheckFatal(ops.ChangeMTU(0, 9000))
+	if mtu, _ := low.GetPortMTU(0); userMTU != 9000 || mtu == 9000 {
+		t.Errorf("User MTU handler got %d, MTU of port is %d", userMTU, mtu)
+	}
+
+	// Default handlers apply requests to port
+	ops = (&KniOps{}).lowOps()
+	CheckFatal(ops.ChangeMTU(0, 2000))
+	if mtu, _ := low.GetPortMTU(0); mtu != 2000 {
+		t.Errorf("MTU of port is %d after request", mtu)
+	}
+	CheckFatal(ops.ConfigLink(0, false))
+	if link, _ := low.GetPortLinkStatus(0); link.Up {
+		t.Error("Link of port is up after request")
+	}
+	CheckFatal(ops.ConfigLink(0, true))
+	if link, _ := low.GetPortLinkStatus(0); !link.Up {
+		t.Error("Link of port is down after request")
+	}
+
+	// MAC address is changed while predefined handlers can read it
+	mac := [common.EtherAddrLen]uint8{0x02, 0xaa, 0, 0, 0, 1}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			createdPorts[0].getMAC()
+		}
+		close(done)
+	}()
+	CheckFatal(ops.ConfigMAC(0, mac))
+	<-done
+	if createdPorts[0].getMAC() != mac || GetPortMACAddress(0) != mac {
+		t.Errorf("MAC address of port is %v after request", createdPorts[0].getMAC())
+	}
+}
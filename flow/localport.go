@@ -0,0 +1,85 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+// Local ports connect NFF-GO processes on one host through UNIX socket
+// and shared memory. DPDK used by NFF-GO doesn't have memif driver yet,
+// so local port is a vhost-user device in server process and
+// virtio-user device in client process. Both processes should use the
+// same socket path, server should be started first. In simulation mode
+// server and client ports with the same path in one process are
+// connected to each other. Library built with novdev tag doesn't have
+// vhost driver and can create only client ports.
+
+type localPortKey struct {
+	path   string
+	server bool
+}
+
+var localPorts = make(map[localPortKey]uint16)
+
+// CreateLocalPort creates port connected to another process by UNIX
+// socket with given path. Server creates socket, client connects to it.
+// Port can be used by SetReceiver, SetSender and other port functions.
+// It should be created after SystemInit. If port with the same path and
+// role was already created, it is returned.
+func CreateLocalPort(path string, server bool) (uint16, error) {
+	key := localPortKey{path, server}
+	if port, ok := localPorts[key]; ok {
+		return port, nil
+	}
+	var name, args string
+	if server {
+		name = "net_vhost" + strconv.Itoa(len(localPorts))
+		args = "iface=" + path + ",queues=1"
+	} else {
+		name = "net_virtio_user" + strconv.Itoa(len(localPorts))
+		args = "path=" + path + ",queues=1"
+	}
+	port, err := low.AttachVdev(name, args)
+	if err != nil {
+		return 0, err
+	}
+	common.LogDebug(common.Initialization, "Local port", port, "is created for", path)
+	addPorts(int(port) + 1)
+	localPorts[key] = port
+	if peer, ok := localPorts[localPortKey{path, !server}]; ok && low.IsSimulation() {
+		if err := low.ConnectSimulatedPorts(port, peer); err != nil {
+			return 0, err
+		}
+	}
+	return port, nil
+}
+
+// SetReceiverLocal adds receive function from local port with given
+// socket path to flow graph. Returns new opened flow with received packets.
+func SetReceiverLocal(path string, server bool, opts ...FlowOption) (OUT *Flow, err error) {
+	port, err := CreateLocalPort(path, server)
+	if err != nil {
+		return nil, err
+	}
+	return SetReceiver(port, opts...)
+}
+
+// SetSenderLocal adds send function to local port with given socket
+// path to flow graph. Gets flow which will be closed and its packets
+// will be sent.
+func SetSenderLocal(IN *Flow, path string, server bool) error {
+	if err := checkFlow(IN); err != nil {
+		return err
+	}
+	port, err := CreateLocalPort(path, server)
+	if err != nil {
+		return err
+	}
+	return SetSender(IN, port)
+}
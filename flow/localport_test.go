@@ -0,0 +1,53 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+func TestLocalPorts(t *testing.T) {
+	const number = 10
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	dir, err := ioutil.TempDir("", "local")
+	CheckFatal(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chain.sock")
+	// Packets go from port 0 to port 1 through pair of local ports
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetSenderLocal(in, path, true))
+	chained, err := SetReceiverLocal(path, false)
+	CheckFatal(err)
+	CheckFatal(SetSender(chained, 1))
+	server, err := CreateLocalPort(path, true)
+	CheckFatal(err)
+	client, err := CreateLocalPort(path, false)
+	CheckFatal(err)
+	if server == client || server < 2 || client < 2 {
+		t.Errorf("Wrong ports %d and %d of local ports", server, client)
+	}
+
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(2000+i))))
+	}
+	go SystemStart()
+	sent, err := WaitSentPackets(1, number, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	for i, data := range sent {
+		if udpDstPort(data) != uint16(2000+i) {
+			t.Errorf("Packet %d has destination port %d", i, udpDstPort(data))
+		}
+	}
+}
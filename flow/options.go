@@ -0,0 +1,140 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// FlowOption is an optional hint for scheduler about flow function. It
+// can be passed to SetReceiver, SetHandler, SetSeparator, SetSplitter and
+// their variants. Handlers, separators and splitters which follow each
+// other are executed by one flow function, so options of all of them
+// are combined: core lists are joined, the least number of clones is
+// used and the last name is used.
+type FlowOption func(*flowOptions)
+
+type flowOptions struct {
+	name      string
+	cores     []int
+	maxClones int
+}
+
+// WithName sets human-readable name of flow function which is used in
+// debug output and in scheduler policy reports. For handlers, separators
+// and splitters it also names the function itself, so it can be changed
+// after SystemStart by SwapHandler, RemoveHandler, InsertHandler and
+// InsertSeparator. Names of such functions should be unique.
+func WithName(name string) FlowOption {
+	return func(o *flowOptions) {
+		o.name = name
+	}
+}
+
+// WithCores pins flow function to given cores. Its instances and clones
+// are placed only on these cores and other flow functions don't use
+// them. Cores should be in Config.CPUList.
+func WithCores(cores ...int) FlowOption {
+	return func(o *flowOptions) {
+		o.cores = append(o.cores, cores...)
+	}
+}
+
+// WithMaxClones limits total number of clones of all instances of flow
+// function, including the first one. Scheduler doesn't add clones and
+// instances over this limit.
+func WithMaxClones(n int) FlowOption {
+	return func(o *flowOptions) {
+		if o.maxClones == 0 || n < o.maxClones {
+			o.maxClones = n
+		}
+	}
+}
+
+// WithoutCloning forbids scheduler to clone flow function.
+func WithoutCloning() FlowOption {
+	return WithMaxClones(1)
+}
+
+// applyOptions sets options to flow function and reserves its cores.
+func (scheduler *scheduler) applyOptions(ff *flowFunction, opts []FlowOption) error {
+	if len(opts) == 0 {
+		return nil
+	}
+	var o flowOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if o.maxClones < 0 {
+		return common.WrapWithNFError(nil, "Maximum number of clones should be positive", common.BadArgument)
+	}
+	for _, id := range o.cores {
+		i := scheduler.coreByID(id)
+		if i == -1 {
+			return common.WrapWithNFError(nil, "Core "+strconv.Itoa(id)+" isn't available for scheduler", common.BadArgument)
+		}
+		if !scheduler.cores[i].isfree {
+			return common.WrapWithNFError(nil, "Core "+strconv.Itoa(id)+" is already used", common.BadArgument)
+		}
+		scheduler.cores[i].reserved = true
+	}
+	ff.cores = append(ff.cores, o.cores...)
+	if o.maxClones != 0 && (ff.maxClones == 0 || o.maxClones < ff.maxClones) {
+		ff.maxClones = o.maxClones
+	}
+	if o.name != "" {
+		ff.name = scheduler.uniqueName(o.name)
+	}
+	common.LogDebug(common.Initialization, "Flow function", ff.name, "has cores", ff.cores, "and maximum", ff.maxClones, "clones")
+	return nil
+}
+
+// applyFuncOptions registers name of function of segment and sets
+// options to flow function of segment.
+func (scheduler *scheduler) applyFuncOptions(segment *processSegment, f *Func, opts []FlowOption) error {
+	var o flowOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.name != "" {
+		scheduler.mutex.Lock()
+		if _, ok := scheduler.funcs[o.name]; ok {
+			scheduler.mutex.Unlock()
+			return common.WrapWithNFError(nil, "Function name "+o.name+" is already used", common.BadArgument)
+		}
+		f.name = o.name
+		scheduler.funcs[o.name] = &namedFunc{segment, f}
+		scheduler.mutex.Unlock()
+	}
+	return scheduler.applyOptions(segment.ff, opts)
+}
+
+// coreByID returns index of core with given id or -1.
+func (scheduler *scheduler) coreByID(id int) int {
+	for i := range scheduler.cores {
+		if scheduler.cores[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// clones returns number of clones of all instances of flow function.
+func (ff *flowFunction) clones() int {
+	n := 0
+	for _, ffi := range ff.instance {
+		n += ffi.cloneNumber
+	}
+	return n
+}
+
+// canClone returns true if one more clone doesn't exceed limit of user.
+func (ff *flowFunction) canClone() bool {
+	return ff.maxClones == 0 || ff.clones() < ff.maxClones
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"testing"
+)
+
+func TestFlowOptions(t *testing.T) {
+	s := newScheduler([]int{0, 1, 2, 3}, false, false, false, nil, 0, 0, 1, 1, false, DefaultSchedulerPolicy{})
+	ff := &flowFunction{name: "segment"}
+	if err := s.applyOptions(ff, []FlowOption{WithCores(2), WithMaxClones(3), WithName("parser")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.applyOptions(ff, []FlowOption{WithCores(3), WithoutCloning()}); err != nil {
+		t.Fatal(err)
+	}
+	if ff.name != "parser" || ff.maxClones != 1 || len(ff.cores) != 2 {
+		t.Errorf("Wrong options of flow function: name %s, cores %v, maximum %d clones", ff.name, ff.cores, ff.maxClones)
+	}
+	if !ff.canClone() {
+		t.Error("Flow function without instances should be able to start one")
+	}
+	ff.instance = []*instance{{cloneNumber: 1}}
+	if ff.canClone() {
+		t.Error("Flow function without cloning shouldn't be cloned")
+	}
+
+	// Reserved cores are used only by pinned flow function
+	for i := 0; i < 2; i++ {
+		if core, _, err := s.getCore(anySocket, nil); err != nil || core >= 2 {
+			t.Errorf("Got core %d, error %v for not pinned flow function", core, err)
+		}
+	}
+	if _, _, err := s.getCore(anySocket, nil); err == nil {
+		t.Error("Reserved core was given to not pinned flow function")
+	}
+	if core, _, err := s.getCore(anySocket, ff.cores); err != nil || (core != 2 && core != 3) {
+		t.Errorf("Got core %d, error %v for pinned flow function", core, err)
+	}
+
+	if err := s.applyOptions(&flowFunction{}, []FlowOption{WithCores(5)}); err == nil {
+		t.Error("Core outside of scheduler list was accepted")
+	}
+	if err := s.applyOptions(&flowFunction{}, []FlowOption{WithCores(0)}); err == nil {
+		t.Error("Used core was accepted")
+	}
+}
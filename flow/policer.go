@@ -0,0 +1,337 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Token bucket policer
+// Policer meters packets of a flow with single rate three color marker
+// (RFC 2697) or two rate three color marker (RFC 2698) in color-blind mode.
+// Packets can be metered all together or per key which is extracted by
+// user function. Buckets are shared between all clones of policer, so rate
+// limit doesn't depend on number of cores which scheduler gives to it.
+
+package flow
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// PolicerMode is a type of three color marker used by policer.
+type PolicerMode int
+
+const (
+	// SingleRate is a single rate three color marker (RFC 2697). It uses
+	// CIR, CBS and EBS parameters.
+	SingleRate PolicerMode = iota
+	// TwoRate is a two rate three color marker (RFC 2698). It uses CIR,
+	// CBS, PIR and PBS parameters.
+	TwoRate
+)
+
+// PolicerAction is an action which policer does with metered packets.
+type PolicerAction int
+
+const (
+	// PolicerDrop drops red packets. Green and yellow packets are passed.
+	PolicerDrop PolicerAction = iota
+	// PolicerMarkDSCP passes all packets and sets DSCP field of IPv4 or
+	// IPv6 header according to packet color.
+	PolicerMarkDSCP
+	// PolicerSeparate sends red packets to separate flow which is
+	// returned from SetPolicer. Green and yellow packets are passed.
+	PolicerSeparate
+)
+
+// packetColor is a result of packet metering.
+type packetColor uint8
+
+const (
+	green packetColor = iota
+	yellow
+	red
+)
+
+// PolicerConfig is a struct with policer parameters. Rates are in bytes
+// per second and burst sizes are in bytes. Packet size is a length of the
+// whole frame.
+type PolicerConfig struct {
+	Mode   PolicerMode
+	Action PolicerAction
+	// Committed information rate and committed burst size.
+	CIR uint64
+	CBS uint64
+	// Excess burst size for SingleRate mode.
+	EBS uint64
+	// Peak information rate and peak burst size for TwoRate mode.
+	// PIR should be not less than CIR.
+	PIR uint64
+	PBS uint64
+	// DSCP values which are set to packets of each color in
+	// PolicerMarkDSCP mode.
+	GreenDSCP  uint8
+	YellowDSCP uint8
+	RedDSCP    uint8
+	// Function which extracts key from packet. Each key is metered with
+	// its own buckets. If nil, all packets of flow are metered together.
+	// Function is called from all clones of policer concurrently.
+	Key func(*packet.Packet) uint64
+	// Maximum number of keys. When it is reached, a few least recently
+	// used buckets are checked and one of them which was idle long
+	// enough to be refilled is removed. Packets of new key share one
+	// common bucket if there is no such bucket. Default value is 65536.
+	MaxKeys int
+}
+
+// tokenBucket holds two buckets of three color marker. For SingleRate
+// mode they are committed and excess buckets, for TwoRate mode they are
+// committed and peak buckets.
+type tokenBucket struct {
+	sync.Mutex
+	tc         float64
+	te         float64
+	lastUpdate int64
+	// Key of bucket which is removed from table on eviction
+	key uint64
+}
+
+// Number of buckets which are checked for eviction when new key comes
+// and table of keys is full, so new key is added in constant time.
+const policerEvictionScan = 8
+
+type policer struct {
+	sync.RWMutex
+	config  PolicerConfig
+	shared  tokenBucket
+	buckets map[uint64]*tokenBucket
+	// Buckets from least to most recently used. Buckets are added to
+	// the back and checked for eviction from the front, used bucket is
+	// moved to the back when it is checked. So packets don't take write
+	// lock to update the list.
+	lru *list.List
+}
+
+func newPolicer(config *PolicerConfig) *policer {
+	p := new(policer)
+	p.config = *config
+	if p.config.MaxKeys == 0 {
+		p.config.MaxKeys = 65536
+	}
+	p.initBucket(&p.shared, time.Now().UnixNano())
+	if p.config.Key != nil {
+		p.buckets = make(map[uint64]*tokenBucket)
+		p.lru = list.New()
+	}
+	return p
+}
+
+func (p *policer) initBucket(b *tokenBucket, now int64) {
+	b.tc = float64(p.config.CBS)
+	if p.config.Mode == SingleRate {
+		b.te = float64(p.config.EBS)
+	} else {
+		b.te = float64(p.config.PBS)
+	}
+	b.lastUpdate = now
+}
+
+// refill adds tokens to buckets according to time passed since last update.
+// Should be called with bucket lock.
+func (p *policer) refill(b *tokenBucket, now int64) {
+	elapsed := float64(now-b.lastUpdate) / float64(time.Second)
+	if elapsed <= 0 {
+		return
+	}
+	b.lastUpdate = now
+	c := &p.config
+	if c.Mode == SingleRate {
+		// Tokens which don't fit into committed bucket go to excess bucket
+		b.tc += float64(c.CIR) * elapsed
+		if b.tc > float64(c.CBS) {
+			b.te += b.tc - float64(c.CBS)
+			b.tc = float64(c.CBS)
+			if b.te > float64(c.EBS) {
+				b.te = float64(c.EBS)
+			}
+		}
+	} else {
+		b.tc += float64(c.CIR) * elapsed
+		if b.tc > float64(c.CBS) {
+			b.tc = float64(c.CBS)
+		}
+		b.te += float64(c.PIR) * elapsed
+		if b.te > float64(c.PBS) {
+			b.te = float64(c.PBS)
+		}
+	}
+}
+
+func (p *policer) meter(b *tokenBucket, size float64, now int64) packetColor {
+	b.Lock()
+	defer b.Unlock()
+	p.refill(b, now)
+	if p.config.Mode == SingleRate {
+		if b.tc >= size {
+			b.tc -= size
+			return green
+		}
+		if b.te >= size {
+			b.te -= size
+			return yellow
+		}
+		return red
+	}
+	if b.te < size {
+		return red
+	}
+	if b.tc < size {
+		b.te -= size
+		return yellow
+	}
+	b.te -= size
+	b.tc -= size
+	return green
+}
+
+func (p *policer) getBucket(key uint64, now int64) *tokenBucket {
+	p.RLock()
+	b, ok := p.buckets[key]
+	p.RUnlock()
+	if ok {
+		return b
+	}
+	p.Lock()
+	defer p.Unlock()
+	if b, ok = p.buckets[key]; ok {
+		return b
+	}
+	if len(p.buckets) >= p.config.MaxKeys && !p.evict(now) {
+		return &p.shared
+	}
+	b = new(tokenBucket)
+	p.initBucket(b, now)
+	b.key = key
+	p.lru.PushBack(b)
+	p.buckets[key] = b
+	return b
+}
+
+// evict removes one of least recently used buckets which was idle long
+// enough to be refilled, so removal doesn't give extra tokens to its
+// key. Buckets which are not full are moved to the back of list.
+// Returns false if no bucket was removed. Should be called with
+// policer lock.
+func (p *policer) evict(now int64) bool {
+	for i := 0; i < policerEvictionScan && p.lru.Len() != 0; i++ {
+		e := p.lru.Front()
+		old := e.Value.(*tokenBucket)
+		old.Lock()
+		p.refill(old, now)
+		full := old.tc >= float64(p.config.CBS) && old.te >= p.initialExcess()
+		old.Unlock()
+		if full {
+			p.lru.Remove(e)
+			delete(p.buckets, old.key)
+			return true
+		}
+		p.lru.MoveToBack(e)
+	}
+	return false
+}
+
+func (p *policer) initialExcess() float64 {
+	if p.config.Mode == SingleRate {
+		return float64(p.config.EBS)
+	}
+	return float64(p.config.PBS)
+}
+
+func (p *policer) color(pkt *packet.Packet) packetColor {
+	now := time.Now().UnixNano()
+	b := &p.shared
+	if p.config.Key != nil {
+		b = p.getBucket(p.config.Key(pkt), now)
+	}
+	return p.meter(b, float64(pkt.GetPacketLen()), now)
+}
+
+// policerContext is a context of policer separate function. It holds
+// pointer to policer state, so all clones share the same buckets.
+type policerContext struct {
+	p *policer
+}
+
+func (ctx policerContext) Copy() interface{} {
+	return policerContext{p: ctx.p}
+}
+
+func (ctx policerContext) Delete() {
+}
+
+func policerSeparate(pkt *packet.Packet, ctx UserContext) bool {
+	p := ctx.(policerContext).p
+	color := p.color(pkt)
+	if p.config.Action == PolicerMarkDSCP {
+		switch color {
+		case green:
+			setDSCP(pkt, p.config.GreenDSCP)
+		case yellow:
+			setDSCP(pkt, p.config.YellowDSCP)
+		case red:
+			setDSCP(pkt, p.config.RedDSCP)
+		}
+		return true
+	}
+	return color != red
+}
+
+func setDSCP(pkt *packet.Packet, dscp uint8) {
+	ipv4, ipv6, _ := pkt.ParseAllKnownL3CheckVLAN()
+	if ipv4 != nil {
+		ipv4.TypeOfService = dscp<<2 | ipv4.TypeOfService&0x03
+		ipv4.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4))
+	} else if ipv6 != nil {
+		// Traffic class is placed in bits 20-27 of first word
+		vtc := packet.SwapBytesUint32(ipv6.VtcFlow)
+		vtc = vtc&^(0x3f<<22) | uint32(dscp&0x3f)<<22
+		ipv6.VtcFlow = packet.SwapBytesUint32(vtc)
+	}
+}
+
+func checkPolicerConfig(config *PolicerConfig) error {
+	if config == nil || config.CIR == 0 || config.CBS == 0 {
+		return common.WrapWithNFError(nil, "Policer CIR and CBS should be set", common.BadArgument)
+	}
+	if config.Mode == TwoRate && (config.PIR < config.CIR || config.PBS == 0) {
+		return common.WrapWithNFError(nil, "Policer PIR should be not less than CIR and PBS should be set", common.BadArgument)
+	}
+	if config.Action == PolicerMarkDSCP &&
+		(config.GreenDSCP > 0x3f || config.YellowDSCP > 0x3f || config.RedDSCP > 0x3f) {
+		return common.WrapWithNFError(nil, "DSCP value should be less than 64", common.BadArgument)
+	}
+	return nil
+}
+
+// SetPolicer adds token bucket policer to flow graph.
+// Gets flow and policer configuration. Packets are metered and colored
+// with single rate or two rate three color marker. In PolicerSeparate
+// mode returns new opened flow with red packets, otherwise returns nil.
+// Policer state is shared between clones of the function.
+func SetPolicer(IN *Flow, config *PolicerConfig) (OUT *Flow, err error) {
+	if err := checkPolicerConfig(config); err != nil {
+		return nil, err
+	}
+	ctx := policerContext{p: newPolicer(config)}
+	separate := makeSeparator(policerSeparate, nil)
+	if err := segmentInsert(IN, separate, false, ctx, 1, 1); err != nil {
+		return nil, err
+	}
+	OUT = newFlowSegment(IN.segment, &separate.next[0], IN.inIndexNumber, IN.socket)
+	if config.Action == PolicerSeparate {
+		return OUT, nil
+	}
+	return nil, SetStopper(OUT)
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// checkColors meters packets of given size at given time and compares
+// their colors with expected ones.
+func checkColors(t *testing.T, p *policer, now int64, size float64, expected ...packetColor) {
+	for i, e := range expected {
+		if c := p.meter(&p.shared, size, now); c != e {
+			t.Errorf("Packet %d at %d is %d instead of %d", i, now, c, e)
+		}
+	}
+}
+
+func TestPolicerSingleRate(t *testing.T) {
+	const second = int64(time.Second)
+	p := newPolicer(&PolicerConfig{Mode: SingleRate, CIR: 1000, CBS: 200, EBS: 300})
+	start := p.shared.lastUpdate
+	// Full buckets give 2 green and 3 yellow packets
+	checkColors(t, p, start, 100, green, green, yellow, yellow, yellow, red)
+	// Tokens go to committed bucket first
+	checkColors(t, p, start+second/10, 100, green, red)
+	// Tokens which don't fit into committed bucket go to excess bucket
+	checkColors(t, p, start+second/10+second/2, 100, green, green, yellow, yellow, yellow, red)
+	// Packet larger than any bucket is red
+	checkColors(t, p, start+10*second, 400, red)
+	checkColors(t, p, start+10*second, 250, yellow)
+}
+
+func TestPolicerTwoRate(t *testing.T) {
+	const second = int64(time.Second)
+	p := newPolicer(&PolicerConfig{Mode: TwoRate, CIR: 1000, CBS: 200, PIR: 2000, PBS: 400})
+	start := p.shared.lastUpdate
+	// Green packets take tokens from both buckets
+	checkColors(t, p, start, 100, green, green, yellow, yellow, red)
+	// Peak bucket gets tokens twice faster
+	checkColors(t, p, start+second/20, 100, yellow, red)
+	checkColors(t, p, start+second/10+second/20, 100, green, yellow, red)
+	// Packet which exceeds committed burst is yellow and exceeding peak
+	// burst is red
+	checkColors(t, p, start+10*second, 300, yellow)
+	checkColors(t, p, start+20*second, 500, red)
+}
+
+func TestPolicerClones(t *testing.T) {
+	const workers = 4
+	const packets = 1000
+	ctx := policerContext{p: newPolicer(&PolicerConfig{CIR: 1000, CBS: 5000, EBS: 1000,
+		Key: func(*packet.Packet) uint64 { return 0 }})}
+	now := time.Now().UnixNano()
+	var greens, yellows int
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		clone := ctx.Copy().(policerContext)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < packets; j++ {
+				c := clone.p.meter(clone.p.getBucket(7, now), 100, now)
+				lock.Lock()
+				if c == green {
+					greens++
+				} else if c == yellow {
+					yellows++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// All clones take tokens from the same bucket
+	if greens != 50 || yellows != 10 {
+		t.Errorf("Clones passed %d green and %d yellow packets instead of 50 and 10", greens, yellows)
+	}
+	if len(ctx.p.buckets) != 1 {
+		t.Errorf("Clones created %d buckets for one key", len(ctx.p.buckets))
+	}
+}
+
+func TestPolicerEviction(t *testing.T) {
+	const second = int64(time.Second)
+	p := newPolicer(&PolicerConfig{CIR: 1000, CBS: 100, EBS: 100, MaxKeys: 2,
+		Key: func(*packet.Packet) uint64 { return 0 }})
+	now := time.Now().UnixNano()
+	p.meter(p.getBucket(1, now), 100, now)
+	p.meter(p.getBucket(2, now), 100, now)
+	// Buckets are used recently and aren't full, so new key uses common bucket
+	if b := p.getBucket(3, now); b != &p.shared || len(p.buckets) != 2 {
+		t.Fatal("Bucket is removed before it is refilled")
+	}
+	// Key 1 is used again, so idle key 2 is removed
+	later := now + second
+	p.meter(p.getBucket(1, later), 100, later)
+	p.meter(p.getBucket(1, later), 100, later)
+	if b := p.getBucket(3, later); b == &p.shared {
+		t.Fatal("Idle bucket isn't removed")
+	}
+	if _, ok := p.buckets[2]; ok || len(p.buckets) != 2 || p.lru.Len() != 2 {
+		t.Errorf("Wrong bucket is removed: %d keys, %d in list", len(p.buckets), p.lru.Len())
+	}
+	if _, ok := p.buckets[1]; !ok {
+		t.Error("Used bucket is removed")
+	}
+}
+
+// packetDSCP returns DSCP field of IPv4 or IPv6 packet and checks IPv4
+// header checksum.
+func packetDSCP(t *testing.T, data []byte) uint8 {
+	ip := data[common.EtherLen:]
+	if binary.BigEndian.Uint16(data[12:]) == common.IPV6Number {
+		return uint8(binary.BigEndian.Uint32(ip) >> 22 & 0x3f)
+	}
+	var sum uint32
+	for i := 0; i < common.IPv4MinLen; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	if sum != 0xffff {
+		t.Errorf("Wrong IPv4 checksum of packet with DSCP %d", ip[1]>>2)
+	}
+	return ip[1] >> 2
+}
+
+func TestPolicerMarkDSCP(t *testing.T) {
+	const greenDSCP, yellowDSCP, redDSCP = 10, 20, 30
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	size := uint64(len(makeUDPPacket(1000, 2000)))
+	// Rate is negligible, so packets are colored by burst sizes only.
+	// IPv4 and IPv6 packets are metered separately.
+	config := &PolicerConfig{Action: PolicerMarkDSCP, CIR: 1, CBS: 2 * size, EBS: size,
+		GreenDSCP: greenDSCP, YellowDSCP: yellowDSCP, RedDSCP: redDSCP,
+		Key: func(pkt *packet.Packet) uint64 { return uint64(pkt.Ether.EtherType) }}
+	out, err := SetPolicer(in, config)
+	CheckFatal(err)
+	if out != nil {
+		t.Error("Policer returned flow in PolicerMarkDSCP mode")
+	}
+	CheckFatal(SetSender(in, 1))
+	for i := 0; i < 4; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(2000+i))))
+	}
+	for i := 0; i < 4; i++ {
+		// IPv6 packets are larger than excess burst, so they are green
+		// or red
+		CheckFatal(InjectPackets(0, makeUDPv6Packet(1000, uint16(3000+i))))
+	}
+	go SystemStart()
+	sent, err := WaitSentPackets(1, 8, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	expected := []uint8{greenDSCP, greenDSCP, yellowDSCP, redDSCP, greenDSCP, redDSCP, redDSCP, redDSCP}
+	for i := range sent {
+		if dscp := packetDSCP(t, sent[i]); dscp != expected[i] {
+			t.Errorf("Packet %d has DSCP %d instead of %d", i, dscp, expected[i])
+		}
+	}
+}
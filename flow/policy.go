@@ -0,0 +1,249 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+// SchedulerPolicy decides how many instances and clones flow functions
+// should have. Scheduler calls Decide for every scalable flow function
+// each ScaleTime milliseconds and applies returned decisions in their
+// order. Decisions which can't be applied, for example because there
+// are no free cores, are skipped. Remove decisions are ignored if
+// Config.PersistentClones is set, all decisions are ignored if
+// Config.DisableScheduler is set.
+type SchedulerPolicy interface {
+	Decide(report *FlowFunctionReport) []SchedulerDecision
+}
+
+// FlowFunctionKind determines which decisions are possible for flow function.
+type FlowFunctionKind int
+
+const (
+	// ProcessingKind is a handling, separating, splitting or copying
+	// flow function. It can have several instances, each of them handles
+	// its own part of input rings, and several clones of instance.
+	ProcessingKind FlowFunctionKind = iota
+	// GeneratorKind is a generator with target speed. It has one
+	// instance which can have several clones and pause between packets.
+	GeneratorKind
+	// ReceiverKind is a receiving from port. It can have several
+	// instances, each of them handles its own part of RSS queues.
+	ReceiverKind
+)
+
+// FlowFunctionReport describes state of flow function since previous
+// scheduler iteration.
+type FlowFunctionReport struct {
+	// Name of flow function
+	Name string
+	Kind FlowFunctionKind
+	// Target speed of generator in packets per second
+	TargetSpeed float64
+	// Time between scheduler iterations in milliseconds
+	ScaleTime uint
+	// Number of cores which can be used for new clones and instances
+	FreeCores int
+	// Maximum number of receiving instances, Config.MaxRecv
+	MaxInstances int
+	// Maximum number of clones of all instances set by WithMaxClones or
+	// zero. Decisions over this limit are skipped.
+	MaxClones int
+	// False if Config.RestrictedCloning is set. Clones of one instance
+	// can reorder packets.
+	CloningAllowed bool
+	// Capacity of rings between flow functions in packets
+	RingSize  uint32
+	Instances []InstanceReport
+}
+
+// InstanceReport describes state of one instance of flow function.
+type InstanceReport struct {
+	// Speed of all clones of instance in packets and megabits per second.
+	// Speed is reported only by processing flow functions and generators.
+	Packets uint64
+	Mbits   uint64
+	// Number of clones including instance itself
+	Clones int
+	// Number of input rings or RSS queues handled by this instance
+	Queues int
+	// Ratio of time which was spent by clones without packets to
+	// process. Value greater than 1 means that clones were idle for all
+	// time and one of them can be removed. Reported only by processing
+	// flow functions.
+	Idle float64
+	// Maximum number of packets in input rings or RSS queues
+	MaxInput uint32
+	// Minimum number of packets in output rings. It is maximum uint32
+	// if instance doesn't have output rings.
+	MinOutput uint32
+	// Speed before last clone was removed or zero. Scheduler forgets
+	// this speed each CheckTime milliseconds because it can be changed
+	// due to external reasons.
+	SpeedWithMoreClones uint64
+	// Speed before last clone was added or zero
+	SpeedWithLessClones uint64
+	// Current pause of generator in nanoseconds
+	Pause int
+}
+
+// SchedulerAction is a change of flow function.
+type SchedulerAction int
+
+const (
+	// AddClone starts new clone of instance.
+	AddClone SchedulerAction = iota
+	// RemoveClone stops the last clone of instance.
+	RemoveClone
+	// AddInstance moves half of input rings or RSS queues of instance to
+	// new instance.
+	AddInstance
+	// RemoveInstance stops instance and moves its input rings or RSS
+	// queues to Target instance.
+	RemoveInstance
+	// SetPause changes pause of generator.
+	SetPause
+)
+
+// SchedulerDecision is a change which policy requests for flow
+// function. Instance and Target are indexes in FlowFunctionReport.Instances.
+type SchedulerDecision struct {
+	Action   SchedulerAction
+	Instance int
+	Target   int
+	// Pause in nanoseconds for SetPause
+	Pause int
+}
+
+// TODO "5" and "39" constants derived empirically. Need to investigate more elegant thresholds.
+const RSSCloneMin = 5
+const RSSCloneMax = 39
+
+// Clones are removed if they are idle more than this ratio of time
+const maxIdle = 1.05
+
+// DefaultSchedulerPolicy adds clones and instances if input rings are
+// filled more than 80% and there is space in output rings, and removes
+// them if they are idle or if speed was higher with less clones.
+// Generators are cloned and paused to reach their target speed.
+type DefaultSchedulerPolicy struct{}
+
+// Decide implements SchedulerPolicy.
+func (DefaultSchedulerPolicy) Decide(r *FlowFunctionReport) []SchedulerDecision {
+	switch r.Kind {
+	case ProcessingKind:
+		return decideProcessing(r)
+	case GeneratorKind:
+		return decideGenerator(r)
+	case ReceiverKind:
+		return decideReceiver(r)
+	}
+	return nil
+}
+
+func decideProcessing(r *FlowFunctionReport) (ret []SchedulerDecision) {
+	// Firstly we check removing clones. We can remove clone if it is
+	// idle or speed was higher with less clones.
+	maxPacketsToClone := r.RingSize / 5 * 4
+	removed := make([]bool, len(r.Instances))
+	max0, max1 := -1, -1
+	for q := range r.Instances {
+		ffi := &r.Instances[q]
+		if ffi.Clones > 1 {
+			if ffi.Idle > maxIdle || ffi.SpeedWithLessClones > ffi.Packets {
+				ret = append(ret, SchedulerDecision{Action: RemoveClone, Instance: q})
+				removed[q] = true
+			}
+		} else if max0 == -1 || ffi.Idle > r.Instances[max0].Idle {
+			max1, max0 = max0, q
+		} else if max1 == -1 || ffi.Idle > r.Instances[max1].Idle {
+			max1 = q
+		}
+	}
+	// Two the most idle instances are merged if they are idle together
+	if max0 != -1 && max1 != -1 && r.Instances[max1].Idle != 0 &&
+		r.Instances[max0].Idle+r.Instances[max1].Idle > maxIdle {
+		return append(ret, SchedulerDecision{Action: RemoveInstance, Instance: max0, Target: max1})
+	}
+	// Secondly we check adding instances and clones. Instance with
+	// several input rings is split if input is overloaded. Instance
+	// with one input ring is cloned if output rings have space and
+	// clone didn't decrease speed last time.
+	split := false
+	for q := range r.Instances {
+		if r.Instances[q].Queues > 1 && r.Instances[q].MaxInput > maxPacketsToClone {
+			ret = append(ret, SchedulerDecision{Action: AddInstance, Instance: q})
+			split = true
+		}
+	}
+	if split {
+		return ret
+	}
+	for q := range r.Instances {
+		ffi := &r.Instances[q]
+		if !removed[q] && ffi.Queues == 1 && r.CloningAllowed && ffi.MaxInput > maxPacketsToClone &&
+			ffi.MinOutput <= maxPacketsToClone && (ffi.SpeedWithMoreClones == 0 || ffi.SpeedWithMoreClones > ffi.Packets) {
+			ret = append(ret, SchedulerDecision{Action: AddClone, Instance: q})
+		}
+	}
+	return ret
+}
+
+func decideGenerator(r *FlowFunctionReport) []SchedulerDecision {
+	ffi := &r.Instances[0]
+	speed := float64(ffi.Packets)
+	pause := ffi.Pause
+	if speed > 1.1*r.TargetSpeed {
+		// Current speed is much bigger than target speed
+		// TODO strange heuristic, it is required to check this
+		if ffi.Clones > 1 && r.TargetSpeed/float64(ffi.Clones+1)*float64(ffi.Clones) > speed {
+			return []SchedulerDecision{{Action: RemoveClone}}
+		}
+		if pause == 0 {
+			// This is proportion between required and current speeds.
+			// 1000000000 is converting to nanoseconds
+			pause = int((speed - r.TargetSpeed) / speed / r.TargetSpeed * 1000000000)
+		} else if float64(pause)*generatePauseStep < 2 {
+			// Multiplying can't be used here due to integer truncation
+			pause += 3
+		} else {
+			pause = int((1 + generatePauseStep) * float64(pause))
+		}
+	} else if speed < r.TargetSpeed {
+		// Speed is not enough
+		if pause != 0 {
+			pause = int((1 - generatePauseStep) * float64(pause))
+		} else if ffi.MinOutput <= r.RingSize/5*4 {
+			return []SchedulerDecision{{Action: AddClone}}
+		}
+	}
+	if pause != ffi.Pause {
+		return []SchedulerDecision{{Action: SetPause, Pause: pause}}
+	}
+	return nil
+}
+
+func decideReceiver(r *FlowFunctionReport) []SchedulerDecision {
+	// Instance is removed if all its RSS queues are almost empty
+	if len(r.Instances) > 1 {
+		first := -1
+		for q := range r.Instances {
+			if r.Instances[q].MaxInput <= RSSCloneMin {
+				if first != -1 {
+					return []SchedulerDecision{{Action: RemoveInstance, Instance: first, Target: q}}
+				}
+				first = q
+			}
+		}
+	}
+	// Instance is added if number of packets in RSS queue is big enough
+	// to cause overwrite (drop) problems
+	if len(r.Instances) < r.MaxInstances {
+		for q := range r.Instances {
+			if r.Instances[q].Queues > 1 && r.Instances[q].MaxInput > RSSCloneMax &&
+				r.Instances[q].MinOutput <= r.RingSize/5*4 {
+				return []SchedulerDecision{{Action: AddInstance, Instance: q}}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultSchedulerPolicy(t *testing.T) {
+	const ringSize = 1000
+	tests := []struct {
+		name   string
+		report FlowFunctionReport
+		want   []SchedulerDecision
+	}{
+		{"overloaded instance is cloned",
+			FlowFunctionReport{Kind: ProcessingKind, CloningAllowed: true, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 1, Queues: 1, MaxInput: 900}}},
+			[]SchedulerDecision{{Action: AddClone}}},
+		{"restricted cloning",
+			FlowFunctionReport{Kind: ProcessingKind, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 1, Queues: 1, MaxInput: 900}}},
+			nil},
+		{"full output ring",
+			FlowFunctionReport{Kind: ProcessingKind, CloningAllowed: true, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 1, Queues: 1, MaxInput: 900, MinOutput: 900}}},
+			nil},
+		{"clone was slower",
+			FlowFunctionReport{Kind: ProcessingKind, CloningAllowed: true, RingSize: ringSize,
+				Instances: []InstanceReport{{Packets: 100, Clones: 1, Queues: 1, MaxInput: 900, SpeedWithMoreClones: 90}}},
+			nil},
+		{"idle clone is removed",
+			FlowFunctionReport{Kind: ProcessingKind, CloningAllowed: true, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 2, Queues: 1, Idle: 2}}},
+			[]SchedulerDecision{{Action: RemoveClone}}},
+		{"idle instances are merged",
+			FlowFunctionReport{Kind: ProcessingKind, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 1, Queues: 1, Idle: 0.6}, {Clones: 1, Queues: 1, Idle: 0.7}}},
+			[]SchedulerDecision{{Action: RemoveInstance, Instance: 1, Target: 0}}},
+		{"overloaded instance is split",
+			FlowFunctionReport{Kind: ProcessingKind, RingSize: ringSize,
+				Instances: []InstanceReport{{Clones: 1, Queues: 4, MaxInput: 900}}},
+			[]SchedulerDecision{{Action: AddInstance}}},
+		{"slow generator is cloned",
+			FlowFunctionReport{Kind: GeneratorKind, TargetSpeed: 1000, RingSize: ringSize,
+				Instances: []InstanceReport{{Packets: 500, Clones: 1}}},
+			[]SchedulerDecision{{Action: AddClone}}},
+		{"slow generator pause is decreased",
+			FlowFunctionReport{Kind: GeneratorKind, TargetSpeed: 1000, RingSize: ringSize,
+				Instances: []InstanceReport{{Packets: 500, Clones: 1, Pause: 100}}},
+			[]SchedulerDecision{{Action: SetPause, Pause: 90}}},
+		{"fast generator pause is increased",
+			FlowFunctionReport{Kind: GeneratorKind, TargetSpeed: 1000, RingSize: ringSize,
+				Instances: []InstanceReport{{Packets: 2000, Clones: 1, Pause: 100}}},
+			[]SchedulerDecision{{Action: SetPause, Pause: 110}}},
+		{"empty receivers are merged",
+			FlowFunctionReport{Kind: ReceiverKind, MaxInstances: 2, RingSize: ringSize,
+				Instances: []InstanceReport{{Queues: 1}, {Queues: 1, MaxInput: RSSCloneMin}}},
+			[]SchedulerDecision{{Action: RemoveInstance, Instance: 0, Target: 1}}},
+		{"overloaded receiver is split",
+			FlowFunctionReport{Kind: ReceiverKind, MaxInstances: 2, RingSize: ringSize,
+				Instances: []InstanceReport{{Queues: 2, MaxInput: RSSCloneMax + 1}}},
+			[]SchedulerDecision{{Action: AddInstance}}},
+		{"receiver instances limit",
+			FlowFunctionReport{Kind: ReceiverKind, MaxInstances: 1, RingSize: ringSize,
+				Instances: []InstanceReport{{Queues: 2, MaxInput: RSSCloneMax + 1}}},
+			nil},
+	}
+	for _, test := range tests {
+		got := DefaultSchedulerPolicy{}.Decide(&test.report)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got decisions %+v, expected %+v", test.name, got, test.want)
+		}
+	}
+}
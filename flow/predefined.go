@@ -31,7 +31,7 @@ func handleARPICMPRequests(current *packet.Packet, context UserContext) bool {
 		if err != nil {
 			common.LogFatal(common.Debug, err)
 		}
-		packet.InitARPReplyPacket(answerPacket, port.MAC, arp.SHA, packet.ArrayToIPv4(arp.TPA), packet.ArrayToIPv4(arp.SPA))
+		packet.InitARPReplyPacket(answerPacket, port.getMAC(), arp.SHA, packet.ArrayToIPv4(arp.TPA), packet.ArrayToIPv4(arp.SPA))
 		answerPacket.SendPacket(port.port)
 
 		return false
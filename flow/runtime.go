@@ -0,0 +1,256 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+// Flow graph can be changed after SystemStart. Handlers, separators and
+// splitters which were named by WithName option can be swapped, removed
+// or get new functions inserted before them. New branches can be added
+// by usual Set functions, for example to send packets to port which
+// wasn't used before. All changes are collected and applied together
+// by ApplyGraphChanges. It stops clones of changed segments only, so
+// their packets wait in input rings and other flow functions continue
+// to work. Ports which are already started can't get new queues.
+
+type namedFunc struct {
+	segment *processSegment
+	f       *Func
+}
+
+// runningFunc returns segment and function with given name if graph is running.
+func (scheduler *scheduler) runningFunc(name string) (*namedFunc, error) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if !scheduler.running {
+		return nil, common.WrapWithNFError(nil, "Graph can be changed by this function only after SystemStart", common.Fail)
+	}
+	nf, ok := scheduler.funcs[name]
+	if !ok {
+		return nil, common.WrapWithNFError(nil, "There is no function with name "+name, common.BadArgument)
+	}
+	return nf, nil
+}
+
+// change saves change of segment for ApplyGraphChanges.
+func (scheduler *scheduler) change(segment *processSegment, f func()) {
+	scheduler.mutex.Lock()
+	scheduler.pending = append(scheduler.pending, f)
+	scheduler.affected[segment.ff] = true
+	scheduler.mutex.Unlock()
+}
+
+// parentOf returns pointer to f inside function tree of segment or nil.
+func (segment *processSegment) parentOf(f *Func) **Func {
+	var find func(p **Func) **Func
+	find = func(p **Func) **Func {
+		if *p == f {
+			return p
+		}
+		if *p == nil {
+			return nil
+		}
+		for i := range (*p).next {
+			if r := find(&(*p).next[i]); r != nil {
+				return r
+			}
+		}
+		return nil
+	}
+	return find(&segment.ff.Parameters.(*segmentParameters).firstFunc)
+}
+
+// insertFunc inserts f before function with given name. Packets which
+// f sends to its last branch go to that function.
+func (scheduler *scheduler) insertFunc(before string, f *Func, context UserContext, opts []FlowOption) (*processSegment, error) {
+	target, err := scheduler.runningFunc(before)
+	if err != nil {
+		return nil, err
+	}
+	segment := target.segment
+	if segment.stype == 2 {
+		return nil, common.WrapWithNFError(nil, "Scalar function can't be inserted before vector function "+before, common.BadArgument)
+	}
+	if err := scheduler.applyFuncOptions(segment, f, opts); err != nil {
+		return nil, err
+	}
+	scheduler.change(segment, func() {
+		parent := segment.parentOf(target.f)
+		if parent == nil {
+			common.LogWarning(common.Debug, "Function", before, "was removed before insertion")
+			return
+		}
+		if segment.stype == 0 {
+			segment.stype = 1
+		}
+		f.next[len(f.next)-1] = target.f
+		*parent = f
+		segment.contexts = append(segment.contexts, context)
+		f.contextIndex = len(segment.contexts) - 1
+	})
+	return segment, nil
+}
+
+// InsertHandler inserts handle function before handler, separator or
+// splitter with given name in running flow graph. Options can name new
+// handler. Change is applied by ApplyGraphChanges.
+func InsertHandler(before string, handleFunction HandleFunction, context UserContext, opts ...FlowOption) error {
+	_, err := schedState.insertFunc(before, makeHandler(handleFunction, nil), context, opts)
+	return err
+}
+
+// InsertSeparator inserts separate function before handler, separator
+// or splitter with given name in running flow graph. Packets for which
+// function returns "true" go to that function, other packets are sent
+// to returned new flow. New flow should be closed before change is
+// applied by ApplyGraphChanges.
+func InsertSeparator(before string, separateFunction SeparateFunction, context UserContext, opts ...FlowOption) (OUT *Flow, err error) {
+	separate := makeSeparator(separateFunction, nil)
+	segment, err := schedState.insertFunc(before, separate, context, opts)
+	if err != nil {
+		return nil, err
+	}
+	ff := segment.ff
+	ring := low.CreateRingsOnSocket(burstSize*sizeMultiplier, ff.inIndexNumber, ff.socket)
+	schedState.change(segment, func() {
+		separate.next[0] = makeSlice(ring, segment)
+	})
+	return newFlow(ring, ff.inIndexNumber, ff.socket), nil
+}
+
+// RemoveHandler removes handler with given name from running flow graph.
+// Packets go directly to following function. Change is applied by
+// ApplyGraphChanges.
+func RemoveHandler(name string) error {
+	target, err := schedState.runningFunc(name)
+	if err != nil {
+		return err
+	}
+	if target.f.sHandleFunction == nil && target.f.vHandleFunction == nil {
+		return common.WrapWithNFError(nil, "Function "+name+" isn't a handler", common.BadArgument)
+	}
+	schedState.mutex.Lock()
+	delete(schedState.funcs, name)
+	schedState.mutex.Unlock()
+	schedState.change(target.segment, func() {
+		if parent := target.segment.parentOf(target.f); parent != nil {
+			*parent = target.f.next[0]
+		}
+	})
+	return nil
+}
+
+// SwapHandler replaces function and context of handler with given name
+// in running flow graph. Change is applied by ApplyGraphChanges.
+func SwapHandler(name string, handleFunction HandleFunction, context UserContext) error {
+	target, err := schedState.runningFunc(name)
+	if err != nil {
+		return err
+	}
+	if target.f.sHandleFunction == nil {
+		return common.WrapWithNFError(nil, "Function "+name+" isn't a scalar handler", common.BadArgument)
+	}
+	schedState.change(target.segment, func() {
+		target.f.sHandleFunction = handleFunction
+		target.segment.contexts[target.f.contextIndex] = context
+	})
+	return nil
+}
+
+// SwapVectorHandler replaces function and context of vector handler
+// with given name in running flow graph. Change is applied by
+// ApplyGraphChanges.
+func SwapVectorHandler(name string, vectorHandleFunction VectorHandleFunction, context UserContext) error {
+	target, err := schedState.runningFunc(name)
+	if err != nil {
+		return err
+	}
+	if target.f.vHandleFunction == nil {
+		return common.WrapWithNFError(nil, "Function "+name+" isn't a vector handler", common.BadArgument)
+	}
+	schedState.change(target.segment, func() {
+		target.f.vHandleFunction = vectorHandleFunction
+		target.segment.contexts[target.f.contextIndex] = context
+	})
+	return nil
+}
+
+// ApplyGraphChanges applies changes of running flow graph which were
+// made after SystemStart. Ports which are used first time are started.
+// Clones of changed segments are stopped while their functions are
+// changed and are started again with the same number. New flow
+// functions are started. All flows should be closed. Function can be
+// called from timer or link state change handlers.
+func ApplyGraphChanges() error {
+	if openFlowsNumber != 0 {
+		return common.WrapWithNFError(nil, "Some flows are left open at the end of graph changes!", common.OpenedFlowAtTheEnd)
+	}
+	return schedState.applyChanges()
+}
+
+func (scheduler *scheduler) applyChanges() error {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if !scheduler.running {
+		return common.WrapWithNFError(nil, "Graph changes can be applied only after SystemStart", common.Fail)
+	}
+	for i := range createdPorts {
+		if createdPorts[i].wasRequested && !createdPorts[i].started {
+			common.LogDebug(common.Debug, "Start port", createdPorts[i].port)
+			if err := createdPorts[i].create(); err != nil {
+				return err
+			}
+		}
+	}
+	var stopped []*flowFunction
+	var clones [][]int
+	for _, ff := range scheduler.ff {
+		if scheduler.affected[ff] {
+			stopped = append(stopped, ff)
+			clones = append(clones, ff.quiesce(scheduler))
+		}
+	}
+	for _, f := range scheduler.pending {
+		f()
+	}
+	scheduler.pending = nil
+	scheduler.affected = make(map[*flowFunction]bool)
+	for i, ff := range stopped {
+		if err := ff.resume(scheduler, clones[i]); err != nil {
+			return err
+		}
+	}
+	return scheduler.startNew()
+}
+
+// quiesce stops all clones of flow function keeping its instances and
+// returns number of clones of each instance. Packets remain in input rings.
+func (ff *flowFunction) quiesce(scheduler *scheduler) []int {
+	common.LogDebug(common.Debug, "Quiesce", ff.name)
+	clones := make([]int, len(ff.instance))
+	for q, ffi := range ff.instance {
+		clones[q] = ffi.cloneNumber
+		for ffi.cloneNumber != 0 {
+			ff.stopClone(ffi, scheduler)
+		}
+	}
+	return clones
+}
+
+// resume starts clones of flow function stopped by quiesce.
+func (ff *flowFunction) resume(scheduler *scheduler, clones []int) error {
+	common.LogDebug(common.Debug, "Resume", ff.name)
+	for q, ffi := range ff.instance {
+		for ffi.cloneNumber < clones[q] {
+			if err := ffi.startNewClone(scheduler, q); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+func udpDstPort(data []byte) uint16 {
+	return binary.BigEndian.Uint16(data[common.EtherLen+common.IPv4MinLen+2:])
+}
+
+func TestGraphChanges(t *testing.T) {
+	const number = 20
+	err := SystemInit(&Config{Simulation: true, SimulationPorts: 3, DisableScheduler: true, LogType: common.No})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetHandler(in, setSrcMAC, nil, WithName("mac")))
+	CheckFatal(SetSender(in, 1))
+	go SystemStart()
+
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(2000+i))))
+	}
+	if sent, err := WaitSentPackets(1, number, 10*time.Second); err != nil {
+		CheckFatal(SystemStop())
+		t.Fatal(err, "sent", len(sent), "packets before changes")
+	}
+
+	// Odd ports are mirrored to port 2 which wasn't used before
+	mirror, err := InsertSeparator("mac", dropOddPorts, nil, WithName("mirror"))
+	CheckFatal(err)
+	CheckFatal(SetSender(mirror, 2))
+	if err := SetSender(mirror, 1); err == nil {
+		t.Error("Closed flow was accepted")
+	}
+	if err := InsertHandler("unknown", setSrcMAC, nil); err == nil {
+		t.Error("Function was inserted before unknown function")
+	}
+	CheckFatal(ApplyGraphChanges())
+
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(3000+i))))
+	}
+	even, err1 := WaitSentPackets(1, number/2, 10*time.Second)
+	odd, err2 := WaitSentPackets(2, number/2, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err1 != nil || err2 != nil {
+		t.Fatal("Packets weren't sent after changes:", err1, err2)
+	}
+	for _, data := range even {
+		if udpDstPort(data)%2 != 0 {
+			t.Errorf("Packet to port %d was sent to port 1", udpDstPort(data))
+		}
+	}
+	for _, data := range odd {
+		if udpDstPort(data)%2 != 1 {
+			t.Errorf("Packet to port %d was sent to port 2", udpDstPort(data))
+		}
+	}
+}
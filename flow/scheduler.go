@@ -22,6 +22,7 @@ package flow
 import (
 	"runtime"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -37,10 +38,6 @@ const process = 1
 const stopRequest = 2
 const wasStopped = 9
 
-// TODO "5" and "39" constants derived empirically. Need to investigate more elegant thresholds.
-const RSSCloneMin = 5
-const RSSCloneMax = 39
-
 // Tuple of current speed in packets and bytes
 type speedPair struct {
 	Packets uint64
@@ -78,7 +75,6 @@ type instance struct {
 	cloneNumber int
 	pause       int
 	ff          *flowFunction
-	removed     bool
 }
 
 // UserContext is used inside flow packet and is going for user via it
@@ -123,21 +119,21 @@ type flowFunction struct {
 	context       *[]UserContext
 	fType         ffType
 	inIndexNumber int32
+	// NUMA node where clones of this function should be placed
+	socket int
+	// Cores to which function is pinned by user
+	cores []int
+	// Maximum number of clones set by user or zero
+	maxClones int
 }
 
 // Adding every flow function to scheduler list
 func (scheduler *scheduler) addFF(name string, ucfn uncloneFlowFunction, Cfn cFlowFunction, cfn cloneFlowFunction,
-	par interface{}, context *[]UserContext, fType ffType, inIndexNumber int32) {
+	par interface{}, context *[]UserContext, fType ffType, inIndexNumber int32, socket int) *flowFunction {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
 	ff := new(flowFunction)
-	nameC := 1
-	tName := name
-	for i := range scheduler.ff {
-		if scheduler.ff[i].name == tName {
-			tName = name + strconv.Itoa(nameC)
-			nameC++
-		}
-	}
-	ff.name = tName
+	ff.name = scheduler.uniqueName(name)
 	ff.uncloneFunction = ucfn
 	ff.cFunction = Cfn
 	ff.cloneFunction = cfn
@@ -145,10 +141,25 @@ func (scheduler *scheduler) addFF(name string, ucfn uncloneFlowFunction, Cfn cFl
 	ff.context = context
 	ff.fType = fType
 	ff.inIndexNumber = inIndexNumber
+	ff.socket = socket
 	if inIndexNumber > scheduler.maxInIndex {
 		scheduler.maxInIndex = inIndexNumber
 	}
 	scheduler.ff = append(scheduler.ff, ff)
+	return ff
+}
+
+// uniqueName adds number to name if there is flow function with it.
+func (scheduler *scheduler) uniqueName(name string) string {
+	nameC := 1
+	tName := name
+	for i := range scheduler.ff {
+		if scheduler.ff[i].name == tName {
+			tName = name + strconv.Itoa(nameC)
+			nameC++
+		}
+	}
+	return tName
 }
 
 type scheduler struct {
@@ -163,41 +174,66 @@ type scheduler struct {
 	checkTime         uint
 	debugTime         uint
 	Dropped           uint
-	maxPacketsToClone uint32
+	ringSize          uint32
+	policy            SchedulerPolicy
 	stopFlag          int32
 	maxRecv           int
 	Timers            []*Timer
+	linkHandlers      []*linkHandler
+	taps              []*Tap
 	nAttempts         []uint64
 	pAttempts         []uint64
 	maxInIndex        int32
 	measureRings      low.Rings
 	coreIndex         int
+	// Protects flow functions and cores from changes of running graph
+	mutex sync.Mutex
+	// True after systemStart, graph changes are collected then
+	running bool
+	// Number of flow functions which were started
+	startedNumber int
+	// Functions named by WithName
+	funcs map[string]*namedFunc
+	// Changes of running graph and segments changed by them
+	pending  []func()
+	affected map[*flowFunction]bool
 }
 
 type core struct {
 	id     int
+	node   int
 	isfree bool
+	// Core is reserved for flow functions pinned to it
+	reserved bool
 }
 
+// Flow function can be placed on any NUMA node
+const anySocket = -1
+
 func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDedicatedCore bool,
-	stopRing low.Rings, checkTime uint, debugTime uint, maxPacketsToClone uint32, maxRecv int, anyway bool) *scheduler {
+	stopRing low.Rings, checkTime uint, debugTime uint, ringSize uint32, maxRecv int, anyway bool, policy SchedulerPolicy) *scheduler {
 	coresNumber := len(cpus)
 	// Init scheduler
 	scheduler := new(scheduler)
 	scheduler.cores = make([]core, coresNumber, coresNumber)
+	nodes := common.GetNUMANodes(cpus)
 	for i, cpu := range cpus {
-		scheduler.cores[i] = core{id: cpu, isfree: true}
+		scheduler.cores[i] = core{id: cpu, node: nodes[i], isfree: true}
 	}
+	common.LogDebug(common.Initialization, "NUMA nodes of cores:", nodes)
 	scheduler.off = schedulerOff
 	scheduler.offRemove = schedulerOff || schedulerOffRemove
 	scheduler.stopDedicatedCore = stopDedicatedCore
 	scheduler.StopRing = stopRing
 	scheduler.checkTime = checkTime
 	scheduler.debugTime = debugTime
-	scheduler.maxPacketsToClone = maxPacketsToClone
+	scheduler.ringSize = ringSize
+	scheduler.policy = policy
 	scheduler.maxRecv = maxRecv
 	scheduler.anyway = anyway
 	scheduler.pAttempts = make([]uint64, len(scheduler.cores), len(scheduler.cores))
+	scheduler.funcs = make(map[string]*namedFunc)
+	scheduler.affected = make(map[*flowFunction]bool)
 
 	return scheduler
 }
@@ -205,7 +241,7 @@ func newScheduler(cpus []int, schedulerOff bool, schedulerOffRemove bool, stopDe
 func (scheduler *scheduler) systemStart() (err error) {
 	scheduler.stopFlag = process
 	var core int
-	if core, scheduler.coreIndex, err = scheduler.getCore(); err != nil {
+	if core, scheduler.coreIndex, err = scheduler.getCore(anySocket, nil); err != nil {
 		return err
 	}
 	common.LogDebug(common.Initialization, "Start SCHEDULER at", core, "core")
@@ -213,7 +249,7 @@ func (scheduler *scheduler) systemStart() (err error) {
 		common.LogFatal(common.Initialization, "Failed to set affinity to", core, "core: ", err)
 	}
 	if scheduler.stopDedicatedCore {
-		if core, _, err = scheduler.getCore(); err != nil {
+		if core, _, err = scheduler.getCore(anySocket, nil); err != nil {
 			return err
 		}
 		common.LogDebug(common.Initialization, "Start STOP at", core, "core")
@@ -223,15 +259,32 @@ func (scheduler *scheduler) systemStart() (err error) {
 	go func() {
 		low.Stop(scheduler.StopRing, &scheduler.stopFlag, core)
 	}()
-	for i := range scheduler.ff {
-		if err = scheduler.ff[i].startNewInstance(constructNewIndex(scheduler.ff[i].inIndexNumber), scheduler); err != nil {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if err = scheduler.startNew(); err != nil {
+		return err
+	}
+	scheduler.running = true
+	return nil
+}
+
+// startNew starts flow functions which were added after previous call
+// and measures attempts for new numbers of input rings.
+func (scheduler *scheduler) startNew() error {
+	for _, ff := range scheduler.ff[scheduler.startedNumber:] {
+		if err := ff.startNewInstance(constructNewIndex(ff.inIndexNumber), scheduler); err != nil {
 			return err
 		}
+		scheduler.startedNumber++
+	}
+	if len(scheduler.nAttempts) == 0 {
+		scheduler.nAttempts = append(scheduler.nAttempts, 0)
 	}
-	scheduler.measureRings = low.CreateRings(burstSize*sizeMultiplier, scheduler.maxInIndex+1)
-	scheduler.nAttempts = make([]uint64, scheduler.maxInIndex+1, scheduler.maxInIndex+1)
-	for i := int32(1); i < scheduler.maxInIndex+1; i++ {
-		scheduler.nAttempts[i] = scheduler.measure(int32(i), 1)
+	if scheduler.maxInIndex >= int32(len(scheduler.nAttempts)) {
+		scheduler.measureRings = low.CreateRings(burstSize*sizeMultiplier, scheduler.maxInIndex+1)
+		for i := int32(len(scheduler.nAttempts)); i < scheduler.maxInIndex+1; i++ {
+			scheduler.nAttempts = append(scheduler.nAttempts, scheduler.measure(i, 1))
+		}
 	}
 	return nil
 }
@@ -252,12 +305,12 @@ func (ff *flowFunction) startNewInstance(inIndex []int32, scheduler *scheduler)
 
 func (ffi *instance) startNewClone(scheduler *scheduler, n int) (err error) {
 	ff := ffi.ff
-	core, index, err := scheduler.getCore()
+	core, index, err := scheduler.getCore(ff.socket, ff.cores)
 	if err != nil {
 		common.LogWarning(common.Debug, "Can't start new clone for", ff.name, "instance", n)
 		return err
 	}
-	common.LogDebug(common.Debug, "Start new clone for", ff.name, "instance", n, "at", core, "core")
+	common.LogDebug(common.Debug, "Start new clone for", ff.name, "instance", n, "at", core, "core on NUMA node", scheduler.cores[index].node)
 	ffi.clone = append(ffi.clone, &clonePair{index, [2]chan int{nil, nil}, process})
 	ffi.cloneNumber++
 	if ff.fType != receiveRSS && ff.fType != sendReceiveKNI {
@@ -300,7 +353,6 @@ func (ff *flowFunction) stopClone(ffi *instance, scheduler *scheduler) {
 	scheduler.setCoreByIndex(ffi.clone[ffi.cloneNumber-1].index)
 	ffi.clone = ffi.clone[:len(ffi.clone)-1]
 	ffi.cloneNumber--
-	ffi.removed = true
 }
 
 func (ff *flowFunction) stopInstance(from int, to int, scheduler *scheduler) {
@@ -329,6 +381,9 @@ func (scheduler *scheduler) systemStop() {
 		// We need to wait because scheduler can sleep at this moment
 		runtime.Gosched()
 	}
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.running = false
 	for i := range scheduler.ff {
 		for scheduler.ff[i].instanceNumber != 0 {
 			scheduler.ff[i].stopInstance(0, -1, scheduler)
@@ -339,6 +394,51 @@ func (scheduler *scheduler) systemStop() {
 		scheduler.setCoreByIndex(scheduler.coreIndex + 1)
 	}
 	scheduler.ff = nil
+	scheduler.startedNumber = 0
+	scheduler.funcs = make(map[string]*namedFunc)
+	scheduler.pending = nil
+	scheduler.affected = make(map[*flowFunction]bool)
+}
+
+// linkEvent is a change of link state which should be passed to handler.
+type linkEvent struct {
+	h    *linkHandler
+	link common.LinkStatus
+}
+
+// checkLinks sends changes of link state since previous check to
+// dispatchLinks. Change which can't be sent now is sent on next check.
+func (scheduler *scheduler) checkLinks(events chan<- linkEvent) {
+	for _, h := range scheduler.linkHandlers {
+		link, err := low.GetPortLinkStatus(h.port)
+		if err != nil || link == h.last {
+			continue
+		}
+		select {
+		case events <- linkEvent{h, link}:
+			common.LogDebug(common.Debug, "Link of port", h.port, "changed to", link)
+			h.last = link
+		default:
+		}
+	}
+}
+
+// dispatchLinks calls link state change handlers one by one, so slow
+// handler doesn't stop scheduler.
+func dispatchLinks(events <-chan linkEvent) {
+	for e := range events {
+		e.h.handler(e.h.port, e.link, e.h.context)
+	}
+}
+
+// checkTaps mirrors link state and MTU of ports to their TAP interfaces.
+func (scheduler *scheduler) checkTaps() {
+	scheduler.mutex.Lock()
+	taps := scheduler.taps
+	scheduler.mutex.Unlock()
+	for _, t := range taps {
+		t.mirror()
+	}
 }
 
 // Main loop after framework was started
@@ -346,6 +446,8 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 	tick := time.Tick(time.Duration(scheduler.checkTime) * time.Millisecond)
 	debugTick := time.Tick(time.Duration(scheduler.debugTime) * time.Millisecond)
 	checkRequired := false
+	linkEvents := make(chan linkEvent, len(scheduler.linkHandlers))
+	go dispatchLinks(linkEvents)
 	for atomic.LoadInt32(&scheduler.stopFlag) == process {
 		time.Sleep(time.Millisecond * time.Duration(schedTime))
 		// We have an array of Timers which can be increated by AddTimer function
@@ -360,27 +462,32 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 				// current session from other sessions. Variant's check is a
 				// variable that user will set to "true" if he wants to reset timer
 				// for example if packet of this session was arrived.
-				for i, c := range t.checks {
+				for i := 0; i < len(t.checks); {
 					// We set all checks to false. If we saw false check it means
 					// that user didn't see any event and timer handler should be called.
 					// We use boolean checks for performance reasons because reset timer
 					// after each packet is quite slow.
-					if *c == false {
+					if *t.checks[i] == false {
 						t.handler(t.contexts[i])
 						// We remove current variant after handler calling, because this
-						// session was finished.
+						// session was finished. Next variant takes its index.
 						t.contexts = append(t.contexts[:i], t.contexts[i+1:]...)
 						t.checks = append(t.checks[:i], t.checks[i+1:]...)
 						continue
 					}
-					*c = false
+					*t.checks[i] = false
+					i++
 				}
 			default:
 			}
 		}
+		scheduler.checkTaps()
+		// Graph can be changed by user at this time
+		scheduler.mutex.Lock()
 		select {
 		case <-tick:
 			checkRequired = true
+			scheduler.checkLinks(linkEvents)
 		case <-debugTick:
 			// Report current state of system
 			common.LogDebug(common.Debug, "---------------")
@@ -388,6 +495,7 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 			low.Statistics(float32(scheduler.debugTime) / 1000)
 			for i := range scheduler.ff {
 				scheduler.ff[i].printDebug(schedTime)
+				scheduler.ff[i].printDrops()
 			}
 			if scheduler.Dropped != 0 {
 				common.LogDrop(common.Debug, "Flow functions together dropped", scheduler.Dropped, "packets")
@@ -404,173 +512,26 @@ func (scheduler *scheduler) schedule(schedTime uint) {
 			if ff.fType == segmentCopy || ff.fType == fastGenerate {
 				ff.updateReportedState() // TODO also for debug
 			}
-			// Firstly we check removing clones. We can remove one clone if:
-			// 1. flow function has clones or it is fastGenerate
-			// 2. scheduler removing is switched on
-			if scheduler.offRemove == false {
-				switch ff.fType {
-				case segmentCopy: // Both instances and clones
-					maxZeroAttempts0 := -1
-					maxZeroAttempts1 := -1
-					for q := 0; q < ff.instanceNumber; q++ {
-						ffi := ff.instance[q]
-						if ffi.cloneNumber > 1 {
-							ffi.reportedState.ZeroAttempts[0] = ffi.reportedState.ZeroAttempts[0] * scheduler.pAttempts[ffi.cloneNumber]
-							if ffi.reportedState.ZeroAttempts[0] > uint64(schedTime)*uint64(1000000*1.05) || ffi.decreasedSpeed > ffi.reportedState.V.Packets {
-								// Save current speed as speed of flow function with this number of clones before removing
-								ffi.increasedSpeed = ffi.reportedState.V.Packets
-								ffi.decreasedSpeed = 0
-								ff.stopClone(ffi, scheduler)
-								ffi.updatePause(ffi.cloneNumber - 1)
-							}
-						} else {
-							for z := int32(0); z < ffi.inIndex[0]; z++ {
-								if ffi.reportedState.ZeroAttempts[z] < ffi.reportedState.ZeroAttempts[0] {
-									ffi.reportedState.ZeroAttempts[0] = ffi.reportedState.ZeroAttempts[z]
-								}
-							}
-							ffi.reportedState.ZeroAttempts[0] = ffi.reportedState.ZeroAttempts[0] * scheduler.nAttempts[ffi.inIndex[0]]
-							if maxZeroAttempts0 == -1 || ffi.reportedState.ZeroAttempts[0] > ff.instance[maxZeroAttempts0].reportedState.ZeroAttempts[0] {
-								maxZeroAttempts1 = maxZeroAttempts0
-								maxZeroAttempts0 = q
-							} else if maxZeroAttempts1 == -1 || ffi.reportedState.ZeroAttempts[0] > ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] {
-								maxZeroAttempts1 = q
-							}
-						}
-					}
-					if maxZeroAttempts0 != -1 && maxZeroAttempts1 != -1 && ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] != 0 {
-						if ff.instance[maxZeroAttempts0].reportedState.ZeroAttempts[0]+ff.instance[maxZeroAttempts1].reportedState.ZeroAttempts[0] > uint64(schedTime)*uint64(1.05*1000000) {
-							toInstance := ff.instance[maxZeroAttempts1]
-							ff.stopInstance(maxZeroAttempts0, maxZeroAttempts1, scheduler)
-							toInstance.updatePause(0)
-						}
-					}
-				case fastGenerate: // Only clones, no instances
-					targetSpeed := (ff.Parameters.(*generateParameters)).targetSpeed
-					speedPKTS := float64(ff.instance[0].reportedState.V.normalize(schedTime).Packets)
-					// 3. Current speed is much bigger than target speed
-					if speedPKTS > 1.1*targetSpeed {
-						ffi := ff.instance[0]
-						// 4. TODO strange heuristic, it is required to check this
-						if ffi.cloneNumber > 1 && targetSpeed/float64(ffi.cloneNumber+1)*float64(ffi.cloneNumber) > speedPKTS {
-							ff.stopClone(ffi, scheduler)
-							ffi.updatePause(0)
-							continue
-						} else {
-							if ffi.pause == 0 {
-								// This is proportion between required and current speeds.
-								// 1000000000 is converting to nanoseconds
-								ffi.updatePause(int((speedPKTS - targetSpeed) / speedPKTS / targetSpeed * 1000000000))
-							} else if float64(ffi.pause)*generatePauseStep < 2 {
-								// Multiplying can't be used here due to integer truncation
-								ffi.updatePause(ffi.pause + 3)
-							} else {
-								ffi.updatePause(int((1 + generatePauseStep) * float64(ffi.pause)))
-							}
-						}
-					}
-				case receiveRSS: // Only instances, no clones
-					if ff.instanceNumber > 1 {
-						first := -1
-						for q := 0; q < ff.instanceNumber; q++ {
-							var q1 int32
-							for q1 = 1; q1 < ff.instance[q].inIndex[0]+1; q1++ {
-								if low.CheckRSSPacketCount(ff.Parameters.(*receiveParameters).port, int16(ff.instance[q].inIndex[q1])) > int64(RSSCloneMin) {
-									break
-								}
-							}
-							if q1 == ff.instance[q].inIndex[0]+1 {
-								if first == -1 {
-									first = q
-								} else {
-									ff.stopInstance(first, q, scheduler)
-									break
-								}
-							}
-						}
-					}
-				case readWrite, generate, sendReceiveKNI:
-				}
-			}
-			// Secondly we check adding clones. We can add one clone if:
-			// 1. scheduler is switched on
-			// 2. ouput ring is quite empty
-			if scheduler.off == false {
-				switch ff.fType {
-				case segmentCopy: // Both instances and clones
-					l := ff.instanceNumber
-					a := true
-					for q := 0; q < l; q++ {
-						ffi := ff.instance[q]
-						if ffi.inIndex[0] > 1 && ffi.checkInputRingClonable(scheduler.maxPacketsToClone) {
-							if ff.startNewInstance(constructZeroIndex(ffi.inIndex), scheduler) == nil {
-								constructDuplicatedIndex(ffi.inIndex, ff.instance[ff.instanceNumber-1].inIndex)
-								a = false
-								ffi.updatePause(0)
-							}
-						}
-					}
-					if a == true {
-						for q := 0; q < l; q++ {
-							ffi := ff.instance[q]
-							if ffi.removed {
-								ffi.removed = false
-								continue
-							}
-							if ffi.inIndex[0] == 1 && scheduler.anyway && ffi.checkInputRingClonable(scheduler.maxPacketsToClone) &&
-								ffi.checkOutputRingClonable(scheduler.maxPacketsToClone) &&
-								(ffi.increasedSpeed == 0 || ffi.increasedSpeed > ffi.reportedState.V.Packets) {
-								if scheduler.pAttempts[ffi.cloneNumber+1] == 0 {
-									scheduler.pAttempts[ffi.cloneNumber+1] = scheduler.measure(1, ffi.cloneNumber+1)
-								}
-								if ffi.startNewClone(scheduler, q) == nil {
-									ffi.decreasedSpeed = ffi.reportedState.V.Packets
-									ffi.increasedSpeed = 0
-									ffi.updatePause(ffi.cloneNumber - 1)
-									continue
-								}
-							}
-							// Scheduler can't add new clones if saved instance speed with more
-							// clones is slower than current. However this speed can be changed due to
-							// external reasons. So flow function should "forget" this speed regularly
-							// to try to clone function and get new increasedSpeed.
-							if checkRequired == true {
-								ffi.increasedSpeed = 0
-							}
-						}
-					}
-				case fastGenerate: // Only clones, no instances
-					// 3. speed is not enough
-					if float64(ff.instance[0].reportedState.V.normalize(schedTime).Packets) < (ff.Parameters.(*generateParameters)).targetSpeed {
-						if ff.instance[0].pause != 0 {
-							ff.instance[0].updatePause(int((1 - generatePauseStep) * float64(ff.instance[0].pause)))
-						} else if ff.instance[0].checkOutputRingClonable(scheduler.maxPacketsToClone) {
-							// 3. there is no pause
-							if ff.instance[0].startNewClone(scheduler, 0) == nil {
-								ff.instance[0].updatePause(0)
-							}
-							continue
+			if scheduler.off == false && (ff.fType == segmentCopy || ff.fType == fastGenerate || ff.fType == receiveRSS) {
+				changed := scheduler.apply(ff, scheduler.policy.Decide(scheduler.report(ff, schedTime)), schedTime)
+				// Scheduler can't add new clones if saved instance speed with more
+				// clones is slower than current. However this speed can be changed due to
+				// external reasons. So flow function should "forget" this speed regularly
+				// to try to clone function and get new increasedSpeed.
+				if checkRequired == true {
+					for _, ffi := range ff.instance {
+						if !changed[ffi] {
+							ffi.increasedSpeed = 0
 						}
 					}
-				case receiveRSS: // Only instances, no clones
-					// 3. Number of packets in RSS is big enogh to cause overwrite (drop) problems
-					if ff.instanceNumber < scheduler.maxRecv && ff.inIndexNumber > 1 {
-						for q := 0; q < ff.instanceNumber; q++ {
-							if ff.instance[q].checkInputRingClonable(RSSCloneMax) && ff.instance[q].checkOutputRingClonable(scheduler.maxPacketsToClone) {
-								if ff.startNewInstance(constructZeroIndex(ff.instance[q].inIndex), scheduler) == nil {
-									constructDuplicatedIndex(ff.instance[q].inIndex, ff.instance[ff.instanceNumber-1].inIndex)
-								}
-								break
-							}
-						}
-					}
-				case readWrite, generate, sendReceiveKNI:
 				}
 			}
 		}
+		scheduler.mutex.Unlock()
 		checkRequired = false
 		runtime.Gosched()
 	}
+	close(linkEvents)
 	atomic.StoreInt32(&scheduler.stopFlag, stopRequest+1)
 }
 
@@ -662,71 +623,248 @@ func (scheduler *scheduler) setCoreByIndex(i int) {
 	scheduler.usedCores--
 }
 
-func (scheduler *scheduler) getCore() (int, int, error) {
+// getCore returns free core and its index. If allowed cores are given
+// only they are used, otherwise cores reserved by pinned flow functions
+// are skipped. Core on given NUMA node is preferred, core on other node
+// is returned if there are no free local cores.
+func (scheduler *scheduler) getCore(socket int, allowed []int) (int, int, error) {
+	index := -1
 	for i := range scheduler.cores {
+		if allowed == nil && scheduler.cores[i].reserved || allowed != nil && !containsCore(allowed, scheduler.cores[i].id) {
+			continue
+		}
 		if scheduler.cores[i].isfree == true {
-			scheduler.cores[i].isfree = false
-			scheduler.usedCores++
-			return scheduler.cores[i].id, i, nil
+			if socket == anySocket || scheduler.cores[i].node == socket {
+				index = i
+				break
+			}
+			if index == -1 {
+				index = i
+			}
 		}
 	}
-	return 0, 0, common.WrapWithNFError(nil, "Requested number of cores isn't enough.", common.NotEnoughCores)
+	if index == -1 {
+		return 0, 0, common.WrapWithNFError(nil, "Requested number of cores isn't enough.", common.NotEnoughCores)
+	}
+	if socket != anySocket && scheduler.cores[index].node != socket {
+		common.LogDebug(common.Debug, "No free cores on NUMA node", socket, "- core", scheduler.cores[index].id,
+			"on node", scheduler.cores[index].node, "is used")
+	}
+	scheduler.cores[index].isfree = false
+	scheduler.usedCores++
+	return scheduler.cores[index].id, index, nil
 }
 
-func (ffi *instance) checkInputRingClonable(min uint32) bool {
-	switch ffi.ff.Parameters.(type) {
-	case *segmentParameters:
-		for q := int32(0); q < ffi.inIndex[0]; q++ {
-			if ffi.ff.Parameters.(*segmentParameters).in[ffi.inIndex[q+1]].GetRingCount() > min {
-				return true
-			}
+func containsCore(cores []int, id int) bool {
+	for _, c := range cores {
+		if c == id {
+			return true
 		}
-	case *copyParameters:
-		for q := int32(0); q < ffi.inIndex[0]; q++ {
-			if ffi.ff.Parameters.(*copyParameters).in[ffi.inIndex[q+1]].GetRingCount() > min {
-				return true
-			}
+	}
+	return false
+}
+
+// maxInput returns maximum number of packets in input rings or RSS
+// queues of instance.
+func (ffi *instance) maxInput() uint32 {
+	var ret uint32
+	for q := int32(0); q < ffi.inIndex[0]; q++ {
+		var count uint32
+		switch p := ffi.ff.Parameters.(type) {
+		case *segmentParameters:
+			count = p.in[ffi.inIndex[q+1]].GetRingCount()
+		case *copyParameters:
+			count = p.in[ffi.inIndex[q+1]].GetRingCount()
+		case *receiveParameters:
+			count = uint32(low.CheckRSSPacketCount(p.port, int16(ffi.inIndex[q+1])))
 		}
-	case *receiveParameters:
-		for q := int32(0); q < ffi.inIndex[0]; q++ {
-			if low.CheckRSSPacketCount(ffi.ff.Parameters.(*receiveParameters).port, int16(ffi.inIndex[q+1])) > int64(min) {
-				return true
-			}
+		if count > ret {
+			ret = count
 		}
 	}
-	return false
+	return ret
 }
 
-func (ffi *instance) checkOutputRingClonable(max uint32) bool {
-	switch ffi.ff.Parameters.(type) {
-	case *generateParameters:
-		if ffi.ff.Parameters.(*generateParameters).out[0].GetRingCount() <= max {
-			return true
+// minOutput returns minimum number of packets in output rings of instance.
+func (ffi *instance) minOutput() uint32 {
+	ret := ^uint32(0)
+	min := func(count uint32) {
+		if count < ret {
+			ret = count
 		}
+	}
+	switch p := ffi.ff.Parameters.(type) {
+	case *generateParameters:
+		min(p.out[0].GetRingCount())
 	case *receiveParameters:
 		for q := int32(0); q < ffi.inIndex[0]; q++ {
-			if ffi.ff.Parameters.(*receiveParameters).out[ffi.inIndex[q+1]].GetRingCount() <= max {
-				return true
-			}
+			min(p.out[ffi.inIndex[q+1]].GetRingCount())
 		}
 	case *copyParameters:
 		for q := int32(0); q < ffi.inIndex[0]; q++ {
-			if ffi.ff.Parameters.(*copyParameters).out[ffi.inIndex[q+1]].GetRingCount() <= max ||
-				ffi.ff.Parameters.(*copyParameters).outCopy[ffi.inIndex[q+1]].GetRingCount() <= max {
-				return true
-			}
+			min(p.out[ffi.inIndex[q+1]].GetRingCount())
+			min(p.outCopy[ffi.inIndex[q+1]].GetRingCount())
 		}
 	case *segmentParameters:
-		p := *(ffi.ff.Parameters.(*segmentParameters).out)
-		for i := range p {
+		for _, out := range *p.out {
 			for q := int32(0); q < ffi.inIndex[0]; q++ {
-				if p[i][ffi.inIndex[q+1]].GetRingCount() <= max {
-					return true
+				min(out[ffi.inIndex[q+1]].GetRingCount())
+			}
+		}
+	}
+	return ret
+}
+
+// idle returns ratio of time which was spent by clones of instance
+// without packets. It is counted from number of attempts to get packets
+// from empty rings, which was measured for one clone and each number
+// of clones when system was started.
+func (ffi *instance) idle(scheduler *scheduler, schedTime uint) float64 {
+	zeroAttempts := ffi.reportedState.ZeroAttempts[0]
+	if ffi.cloneNumber > 1 {
+		zeroAttempts *= scheduler.pAttempts[ffi.cloneNumber]
+	} else {
+		for z := int32(0); z < ffi.inIndex[0]; z++ {
+			if ffi.reportedState.ZeroAttempts[z] < zeroAttempts {
+				zeroAttempts = ffi.reportedState.ZeroAttempts[z]
+			}
+		}
+		zeroAttempts *= scheduler.nAttempts[ffi.inIndex[0]]
+	}
+	return float64(zeroAttempts) / float64(uint64(schedTime)*1000000)
+}
+
+// report collects current state of flow function for scheduler policy.
+func (scheduler *scheduler) report(ff *flowFunction, schedTime uint) *FlowFunctionReport {
+	r := &FlowFunctionReport{
+		Name:           ff.name,
+		ScaleTime:      schedTime,
+		FreeCores:      len(scheduler.cores) - int(scheduler.usedCores),
+		MaxInstances:   scheduler.maxRecv,
+		MaxClones:      ff.maxClones,
+		CloningAllowed: scheduler.anyway,
+		RingSize:       scheduler.ringSize,
+		Instances:      make([]InstanceReport, ff.instanceNumber),
+	}
+	switch ff.fType {
+	case segmentCopy:
+		r.Kind = ProcessingKind
+	case fastGenerate:
+		r.Kind = GeneratorKind
+		r.TargetSpeed = ff.Parameters.(*generateParameters).targetSpeed
+	case receiveRSS:
+		r.Kind = ReceiverKind
+	}
+	for q, ffi := range ff.instance {
+		speed := ffi.reportedState.V.normalize(schedTime)
+		r.Instances[q] = InstanceReport{
+			Packets:             speed.Packets,
+			Mbits:               speed.Bytes,
+			Clones:              ffi.cloneNumber,
+			Queues:              int(ffi.inIndex[0]),
+			MaxInput:            ffi.maxInput(),
+			MinOutput:           ffi.minOutput(),
+			SpeedWithMoreClones: ffi.increasedSpeed,
+			SpeedWithLessClones: ffi.decreasedSpeed,
+			Pause:               ffi.pause,
+		}
+		if ff.fType == segmentCopy {
+			r.Instances[q].Idle = ffi.idle(scheduler, schedTime)
+		}
+	}
+	return r
+}
+
+// apply makes changes of flow function which were requested by policy.
+// Returns instances which clones were added or removed.
+func (scheduler *scheduler) apply(ff *flowFunction, decisions []SchedulerDecision, schedTime uint) map[*instance]bool {
+	changed := make(map[*instance]bool)
+	// Decisions refer to instances which existed when report was made
+	instances := append([]*instance(nil), ff.instance...)
+	find := func(index int) (*instance, int) {
+		if index < 0 || index >= len(instances) {
+			return nil, -1
+		}
+		for q := range ff.instance {
+			if ff.instance[q] == instances[index] {
+				return instances[index], q
+			}
+		}
+		return nil, -1
+	}
+	for _, d := range decisions {
+		ffi, q := find(d.Instance)
+		if ffi == nil {
+			common.LogDebug(common.Debug, "Scheduler policy requested unknown instance", d.Instance, "of", ff.name)
+			continue
+		}
+		if scheduler.offRemove && (d.Action == RemoveClone || d.Action == RemoveInstance) {
+			continue
+		}
+		if (d.Action == AddClone || d.Action == AddInstance) && !ff.canClone() {
+			continue
+		}
+		switch d.Action {
+		case AddClone:
+			if ff.fType == receiveRSS {
+				continue
+			}
+			if ff.fType == segmentCopy && ffi.cloneNumber+1 < len(scheduler.pAttempts) && scheduler.pAttempts[ffi.cloneNumber+1] == 0 {
+				scheduler.pAttempts[ffi.cloneNumber+1] = scheduler.measure(1, ffi.cloneNumber+1)
+			}
+			speed := ffi.reportedState.V.normalize(schedTime).Packets
+			if ffi.startNewClone(scheduler, q) != nil {
+				continue
+			}
+			changed[ffi] = true
+			if ff.fType == segmentCopy {
+				ffi.decreasedSpeed = speed
+				ffi.increasedSpeed = 0
+				ffi.updatePause(ffi.cloneNumber - 1)
+			} else {
+				ffi.updatePause(0)
+			}
+		case RemoveClone:
+			if ffi.cloneNumber < 2 {
+				continue
+			}
+			changed[ffi] = true
+			if ff.fType == segmentCopy {
+				// Save current speed as speed of flow function with this number of clones before removing
+				ffi.increasedSpeed = ffi.reportedState.V.normalize(schedTime).Packets
+				ffi.decreasedSpeed = 0
+				ff.stopClone(ffi, scheduler)
+				ffi.updatePause(ffi.cloneNumber - 1)
+			} else {
+				ff.stopClone(ffi, scheduler)
+				ffi.updatePause(0)
+			}
+		case AddInstance:
+			if ff.fType == fastGenerate || ffi.inIndex[0] < 2 {
+				continue
+			}
+			if ff.startNewInstance(constructZeroIndex(ffi.inIndex), scheduler) == nil {
+				constructDuplicatedIndex(ffi.inIndex, ff.instance[ff.instanceNumber-1].inIndex)
+				if ff.fType == segmentCopy {
+					ffi.updatePause(0)
 				}
 			}
+		case RemoveInstance:
+			to, t := find(d.Target)
+			if ff.fType == fastGenerate || to == nil || to == ffi {
+				continue
+			}
+			ff.stopInstance(q, t, scheduler)
+			if ff.fType == segmentCopy {
+				to.updatePause(0)
+			}
+		case SetPause:
+			if ff.fType == fastGenerate {
+				ffi.updatePause(d.Pause)
+			}
 		}
 	}
-	return false
+	return changed
 }
 
 func constructZeroIndex(old []int32) []int32 {
@@ -760,7 +898,7 @@ func constructNewIndex(inIndexNumber int32) []int32 {
 // replaced by "inline" time measurements which will cost
 // 3 time.Now = ~210nn per each burstSize (32) packets.
 func (scheduler *scheduler) measure(N int32, clones int) uint64 {
-	core, index, err := scheduler.getCore()
+	core, index, err := scheduler.getCore(anySocket, nil)
 	if err != nil {
 		return 0
 	}
@@ -0,0 +1,301 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Egress traffic shaper
+// Shaper holds packets of a flow in bounded queues and releases them to
+// output flow not faster than configured rate. Packets can be classified
+// into several traffic classes with their own queues. Classes are served
+// with strict priority or weighted round-robin. Packets which don't fit
+// into full queue are dropped. Packets wait in queues while output flow
+// is full. Shaper is usually placed before SetSender.
+
+package flow
+
+import (
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// ShaperScheduling is a discipline of serving shaper traffic classes.
+type ShaperScheduling int
+
+const (
+	// StrictPriority serves class with lower index while it has packets.
+	StrictPriority ShaperScheduling = iota
+	// WeightedRoundRobin serves classes in turn, each class can send
+	// number of packets equal to its weight per round.
+	WeightedRoundRobin
+)
+
+// ShaperConfig is a struct with shaper parameters. At least one of
+// rates should be set.
+type ShaperConfig struct {
+	// Rate limit in bits per second. Zero means no limit.
+	Rate uint64
+	// Rate limit in packets per second. Zero means no limit.
+	PacketRate uint64
+	// Maximum burst in bytes for Rate limit. Default value is an amount
+	// of bytes which is sent with Rate during 1 millisecond, but not less
+	// than 1518 bytes.
+	Burst uint64
+	// Maximum burst in packets for PacketRate limit. Default value is
+	// burstSize (32) packets.
+	PacketBurst uint64
+	// Size of queue of each traffic class in packets. Default value is 1024.
+	QueueSize int
+	// Number of traffic classes. Default value is 1.
+	Classes int
+	// Function which returns traffic class of a packet. Class should be
+	// less than Classes. If nil, all packets go to class 0.
+	Classifier func(*packet.Packet) uint
+	// Discipline of serving classes. Default value is StrictPriority.
+	Scheduling ShaperScheduling
+	// Weights of classes for WeightedRoundRobin. Default weight is 1.
+	Weights []uint
+}
+
+// shaperQueue is a circular buffer of mbufs.
+type shaperQueue struct {
+	buf   []uintptr
+	head  int
+	count int
+}
+
+func (q *shaperQueue) push(mbuf uintptr) bool {
+	if q.count == len(q.buf) {
+		return false
+	}
+	q.buf[(q.head+q.count)%len(q.buf)] = mbuf
+	q.count++
+	return true
+}
+
+func (q *shaperQueue) pop() uintptr {
+	mbuf := q.buf[q.head]
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+	return mbuf
+}
+
+type shaperParameters struct {
+	in     low.Rings
+	out    low.Rings
+	config ShaperConfig
+	// Packets dropped because queue of their class was full
+	reason common.DropReason
+	drops  *dropCounters
+}
+
+func addShaper(in low.Rings, out low.Rings, config *ShaperConfig, reason common.DropReason, inIndexNumber int32) {
+	par := new(shaperParameters)
+	par.in = in
+	par.out = out
+	par.config = *config
+	par.reason = reason
+	par.drops = new(dropCounters)
+	schedState.addFF("shaper", pshaper, nil, nil, par, nil, readWrite, inIndexNumber, anySocket)
+}
+
+func checkShaperConfig(config *ShaperConfig) error {
+	if config == nil || (config.Rate == 0 && config.PacketRate == 0) {
+		return common.WrapWithNFError(nil, "Shaper should have bit or packet rate", common.BadArgument)
+	}
+	if config.Classes < 0 || config.QueueSize < 0 {
+		return common.WrapWithNFError(nil, "Shaper classes and queue size should be positive", common.BadArgument)
+	}
+	if config.Scheduling == WeightedRoundRobin && len(config.Weights) > config.Classes && config.Classes != 0 {
+		return common.WrapWithNFError(nil, "Shaper has more weights than classes", common.BadArgument)
+	}
+	return nil
+}
+
+// SetShaper adds traffic shaper to flow graph.
+// Gets flow and shaper configuration. Returns new opened flow with shaped
+// traffic. Input flow will be closed. Packets are held in queues of
+// traffic classes and released according to configured rates.
+// Shaper function is not clonable.
+func SetShaper(IN *Flow, config *ShaperConfig) (OUT *Flow, err error) {
+	if err := checkFlow(IN); err != nil {
+		return nil, err
+	}
+	if err := checkShaperConfig(config); err != nil {
+		return nil, err
+	}
+	reason, err := RegisterDropReason("shaper queue is full")
+	if err != nil {
+		return nil, err
+	}
+	inIndexNumber := IN.inIndexNumber
+	out := low.CreateRings(burstSize*sizeMultiplier, 1)
+	addShaper(finishFlow(IN), out, config, reason, inIndexNumber)
+	return newFlow(out, 1, anySocket), nil
+}
+
+func pshaper(parameters interface{}, inIndex []int32, stopper [2]chan int) {
+	sp := parameters.(*shaperParameters)
+	config := &sp.config
+
+	classes := config.Classes
+	if classes == 0 {
+		classes = 1
+	}
+	queueSize := config.QueueSize
+	if queueSize == 0 {
+		queueSize = 1024
+	}
+	burst := float64(config.Burst)
+	if burst == 0 {
+		burst = float64(config.Rate) / 8 / 1000
+		if burst < 1518 {
+			burst = 1518
+		}
+	}
+	packetBurst := float64(config.PacketBurst)
+	if packetBurst == 0 {
+		packetBurst = burstSize
+	}
+	weights := make([]uint, classes)
+	for i := range weights {
+		weights[i] = 1
+		if i < len(config.Weights) && config.Weights[i] != 0 {
+			weights[i] = config.Weights[i]
+		}
+	}
+
+	queues := make([]shaperQueue, classes)
+	for i := range queues {
+		queues[i].buf = make([]uintptr, queueSize)
+	}
+	bufIn := make([]uintptr, burstSize)
+	bufOut := make([]uintptr, burstSize)
+	bufDrop := make([]uintptr, burstSize)
+	var drops dropCounters
+	// Tokens are allowed to become negative, so packet bigger than
+	// burst can be sent too. Next packets wait until debt is paid.
+	byteTokens := burst
+	packetTokens := packetBurst
+	lastUpdate := time.Now().UnixNano()
+	queued := 0
+	// Released packets at the beginning of bufOut which didn't fit
+	// into output ring. They are enqueued before other packets.
+	pending := uint(0)
+	// Current class and its remaining credit for round-robin
+	current := 0
+	credit := weights[0]
+
+	for {
+		select {
+		case <-stopper[0]:
+			// Free packets which weren't sent
+			if pending != 0 {
+				low.DirectStop(int(pending), bufOut)
+			}
+			for q := range queues {
+				for queues[q].count != 0 {
+					n := 0
+					for ; n < burstSize && queues[q].count != 0; n++ {
+						bufDrop[n] = queues[q].pop()
+					}
+					low.DirectStop(n, bufDrop)
+				}
+			}
+			// It is time to close this clone
+			stopper[1] <- 1
+			return
+		default:
+			// Enqueue new packets to class queues
+			for q := range sp.in {
+				n := sp.in[q].DequeueBurst(bufIn, burstSize)
+				countDrop := 0
+				for i := uint(0); i < n; i++ {
+					class := uint(0)
+					if config.Classifier != nil {
+						class = config.Classifier(packet.ExtractPacket(bufIn[i]))
+						if class >= uint(classes) {
+							class = uint(classes) - 1
+						}
+					}
+					if queues[class].push(bufIn[i]) {
+						queued++
+					} else {
+						bufDrop[countDrop] = bufIn[i]
+						countDrop++
+					}
+				}
+				if countDrop != 0 {
+					for i := 0; i < countDrop; i++ {
+						pkt := packet.ExtractPacket(bufDrop[i])
+						pkt.SetDropReason(sp.reason)
+						drops.countDrop(pkt)
+					}
+					sp.drops.add(&drops)
+					low.DirectStop(countDrop, bufDrop)
+				}
+			}
+			// Output ring can be shared with other flow functions,
+			// for example after merge, so it can become full at any
+			// moment
+			if pending != 0 {
+				n := sp.out[0].EnqueueBurst(bufOut, pending)
+				copy(bufOut, bufOut[n:pending])
+				pending -= n
+			}
+			if queued == 0 || pending != 0 {
+				continue
+			}
+
+			// Refill tokens
+			now := time.Now().UnixNano()
+			elapsed := float64(now-lastUpdate) / float64(time.Second)
+			lastUpdate = now
+			if config.Rate != 0 {
+				byteTokens += float64(config.Rate) / 8 * elapsed
+				if byteTokens > burst {
+					byteTokens = burst
+				}
+			}
+			if config.PacketRate != 0 {
+				packetTokens += float64(config.PacketRate) * elapsed
+				if packetTokens > packetBurst {
+					packetTokens = packetBurst
+				}
+			}
+
+			// Release packets while tokens are available
+			countOut := uint(0)
+			for queued != 0 && countOut < burstSize &&
+				(config.Rate == 0 || byteTokens > 0) &&
+				(config.PacketRate == 0 || packetTokens >= 1) {
+				var class int
+				if config.Scheduling == StrictPriority {
+					for class = 0; queues[class].count == 0; class++ {
+					}
+				} else {
+					for queues[current].count == 0 || credit == 0 {
+						current = (current + 1) % classes
+						credit = weights[current]
+					}
+					class = current
+					credit--
+				}
+				mbuf := queues[class].pop()
+				queued--
+				if config.Rate != 0 {
+					byteTokens -= float64(packet.ExtractPacket(mbuf).GetPacketLen())
+				}
+				packetTokens--
+				bufOut[countOut] = mbuf
+				countOut++
+			}
+			if countOut != 0 {
+				n := sp.out[0].EnqueueBurst(bufOut, countOut)
+				copy(bufOut, bufOut[n:countOut])
+				pending = countOut - n
+			}
+		}
+	}
+}
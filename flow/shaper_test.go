@@ -0,0 +1,274 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Packets to UDP port 3000 are in class 1, other packets are in class 0
+func classifyByPort(pkt *packet.Packet) uint {
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	if packet.SwapBytesUint16(pkt.GetUDPNoCheck().DstPort) == 3000 {
+		return 1
+	}
+	return 0
+}
+
+// packetClass returns class of sent packet by its destination port.
+func packetClass(data []byte) uint {
+	if binary.BigEndian.Uint16(data[common.EtherLen+common.IPv4MinLen+2:]) == 3000 {
+		return 1
+	}
+	return 0
+}
+
+// setShaperGraph connects two simulated ports by shaper and injects
+// packets to the first port. Packets are sent to the second port.
+func setShaperGraph(config *ShaperConfig, packets ...[]byte) {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	out, err := SetShaper(in, config)
+	CheckFatal(err)
+	CheckFatal(SetSender(out, 1))
+	CheckFatal(InjectPackets(0, packets...))
+}
+
+// makeClassPackets returns number packets of class 0 and class 1 each.
+func makeClassPackets(class0, class1 int) [][]byte {
+	var packets [][]byte
+	for i := 0; i < class1; i++ {
+		packets = append(packets, makeUDPPacket(uint16(i), 3000))
+	}
+	for i := 0; i < class0; i++ {
+		packets = append(packets, makeUDPPacket(uint16(i), 2000))
+	}
+	return packets
+}
+
+func TestShaperRate(t *testing.T) {
+	const number = 30
+	const rate = 50
+	size := uint64(len(makeUDPPacket(1000, 2000)))
+	configs := map[string]*ShaperConfig{
+		// Both limits are 50 packets per second with burst of 10
+		// packets
+		"bit rate":    {Rate: rate * size * 8, Burst: 10 * size},
+		"packet rate": {PacketRate: rate, PacketBurst: 10},
+	}
+	for name, config := range configs {
+		setShaperGraph(config)
+		go SystemStart()
+		// Wait until graph is started and buckets are full again
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, 2000)))
+		_, err := WaitSentPackets(1, 1, 10*time.Second)
+		CheckFatal(err)
+		time.Sleep(10 * time.Second / rate)
+
+		CheckFatal(InjectPackets(0, makeClassPackets(number, 0)...))
+		// Burst is sent immediately
+		sent, err := WaitSentPackets(1, 10, 10*time.Second)
+		start := time.Now()
+		if err != nil || len(sent) > 15 {
+			t.Errorf("%s: %d packets are sent in burst", name, len(sent))
+		}
+		// Other packets are sent with configured rate
+		rest := number - len(sent)
+		_, err = WaitSentPackets(1, rest, 10*time.Second)
+		elapsed := time.Since(start)
+		CheckFatal(SystemStop())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expected := time.Duration(rest) * time.Second / rate
+		if elapsed < expected*8/10 || elapsed > expected+time.Second {
+			t.Errorf("%s: %d packets are sent in %v instead of %v", name, rest, elapsed, expected)
+		}
+	}
+}
+
+func TestShaperStrictPriority(t *testing.T) {
+	setShaperGraph(&ShaperConfig{PacketRate: 1000, PacketBurst: 1, Classes: 2, Classifier: classifyByPort},
+		makeClassPackets(10, 10)...)
+	go SystemStart()
+	sent, err := WaitSentPackets(1, 20, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	// The first packet can be released before other packets are
+	// received. Queued packets of class 0 are released before class 1.
+	for i := 1; i < 10; i++ {
+		if packetClass(sent[i]) != 0 {
+			t.Fatalf("Packet %d of class 1 is sent before class 0 packets", i)
+		}
+	}
+}
+
+func TestShaperWeightedRoundRobin(t *testing.T) {
+	setShaperGraph(&ShaperConfig{PacketRate: 1000, PacketBurst: 1, Classes: 2, Classifier: classifyByPort,
+		Scheduling: WeightedRoundRobin, Weights: []uint{3, 1}}, makeClassPackets(30, 10)...)
+	go SystemStart()
+	sent, err := WaitSentPackets(1, 40, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	// Class 1 gets one of each four packets while both classes have
+	// packets
+	class1 := uint(0)
+	for i := 1; i < 21; i++ {
+		class1 += packetClass(sent[i])
+		if i > 1 && packetClass(sent[i]) == 1 && packetClass(sent[i-1]) == 1 {
+			t.Errorf("Packets %d and %d are both of class 1", i-1, i)
+		}
+	}
+	if class1 < 4 || class1 > 6 {
+		t.Errorf("%d of 20 packets are of class 1 instead of 5", class1)
+	}
+}
+
+func TestShaperQueueFull(t *testing.T) {
+	const number = 20
+	setShaperGraph(&ShaperConfig{PacketRate: 1000, PacketBurst: 1, QueueSize: 4}, makeClassPackets(number, 0)...)
+	go SystemStart()
+	sent, err := WaitSentPackets(1, 4, 10*time.Second)
+	// Wait until all queued packets are sent
+	time.Sleep(50 * time.Millisecond)
+	rest, _ := WaitSentPackets(1, 0, 0)
+	sent = append(sent, rest...)
+	stats := GetDropStats()
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	if len(stats) != 1 || stats[0].FlowFunction != "shaper" || stats[0].Reason != "shaper queue is full" {
+		t.Fatalf("Shaper drops are %+v", stats)
+	}
+	if dropped := int(stats[0].Packets); dropped+len(sent) != number || dropped < number-6 {
+		t.Errorf("%d packets are sent and %d are dropped", len(sent), dropped)
+	}
+}
+
+func TestShaperFullOutput(t *testing.T) {
+	const number = 150
+	// Output ring of shaper holds 63 packets
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, RingSize: 2, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	out, err := SetShaper(in, &ShaperConfig{PacketRate: 1000000})
+	CheckFatal(err)
+	// Handler doesn't take packets from shaper until release and then
+	// records their order
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var ports []uint16
+	CheckFatal(SetHandler(out, func(pkt *packet.Packet, ctx UserContext) {
+		<-release
+		pkt.ParseL3()
+		pkt.ParseL4ForIPv4()
+		lock.Lock()
+		ports = append(ports, packet.SwapBytesUint16(pkt.GetUDPNoCheck().SrcPort))
+		lock.Unlock()
+	}, nil))
+	CheckFatal(SetStopper(out))
+	go SystemStart()
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(uint16(i), 2000)))
+		time.Sleep(100 * time.Microsecond)
+	}
+	// Packets wait in shaper queue while output is full
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for received := 0; received < number && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		lock.Lock()
+		received = len(ports)
+		lock.Unlock()
+	}
+	var shaperDrops []DropStat
+	for _, s := range GetDropStats() {
+		if s.FlowFunction == "shaper" {
+			shaperDrops = append(shaperDrops, s)
+		}
+	}
+	CheckFatal(SystemStop())
+	if len(shaperDrops) != 0 {
+		t.Errorf("Shaper drops %+v packets with full output", shaperDrops)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(ports) != number {
+		t.Fatalf("Shaper releases %d packets instead of %d", len(ports), number)
+	}
+	for i := range ports {
+		if ports[i] != uint16(i) {
+			t.Fatalf("Packet %d is released instead of %d", ports[i], i)
+		}
+	}
+}
+
+func TestShaperMergedOutput(t *testing.T) {
+	const number = 150
+	// Output ring of shaper is shared with other receiver after merge
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, RingSize: 2, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	shaped, err := SetShaper(in, &ShaperConfig{PacketRate: 1000000})
+	CheckFatal(err)
+	other, err := SetReceiver(1)
+	CheckFatal(err)
+	out, err := SetMerger(shaped, other)
+	CheckFatal(err)
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var ports []uint16
+	CheckFatal(SetHandler(out, func(pkt *packet.Packet, ctx UserContext) {
+		<-release
+		pkt.ParseL3()
+		pkt.ParseL4ForIPv4()
+		if udp := pkt.GetUDPNoCheck(); packet.SwapBytesUint16(udp.DstPort) == 2000 {
+			lock.Lock()
+			ports = append(ports, packet.SwapBytesUint16(udp.SrcPort))
+			lock.Unlock()
+		}
+	}, nil))
+	CheckFatal(SetStopper(out))
+	go SystemStart()
+	// Other receiver fills output ring before shaper gets packets
+	CheckFatal(InjectPackets(1, makeClassPackets(0, 100)...))
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(uint16(i), 2000)))
+		time.Sleep(100 * time.Microsecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for received := 0; received < number && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		lock.Lock()
+		received = len(ports)
+		lock.Unlock()
+	}
+	CheckFatal(SystemStop())
+	lock.Lock()
+	defer lock.Unlock()
+	if len(ports) != number {
+		t.Fatalf("Shaper releases %d packets instead of %d", len(ports), number)
+	}
+	for i := range ports {
+		if ports[i] != uint16(i) {
+			t.Fatalf("Packet %d is released instead of %d", ports[i], i)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Simulation mode
+// If Config.Simulation is set, flow graph works without DPDK on virtual
+// ports. Each virtual port receives packets which were injected into it
+// and collects packets which were sent to it. Typical test starts graph
+// by SystemStart in separate goroutine, injects packets, waits for sent
+// packets with WaitSentPackets, checks them and calls SystemStop.
+
+package flow
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Number of virtual cores which scheduler can use in simulation mode
+// if machine has less cores.
+const simulationCores = 64
+
+// InjectPackets adds packets to input of virtual port in simulation
+// mode. Packets are copied and will be received by SetReceiver of this
+// port in the same order.
+func InjectPackets(port uint16, packets ...[]byte) error {
+	return low.InjectPackets(port, packets)
+}
+
+// InjectPcap adds all packets from pcap file to input of virtual port
+// in simulation mode.
+func InjectPcap(port uint16, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return common.WrapWithNFError(err, "Can't open pcap file", common.FileErr)
+	}
+	defer f.Close()
+
+	var glHdr packet.PcapGlobHdr
+	if err := packet.ReadPcapGlobalHdr(f, &glHdr); err != nil {
+		return err
+	}
+	var packets [][]byte
+	for {
+		var hdr packet.PcapRecHdr
+		if err := binary.Read(f, binary.LittleEndian, &hdr); err == io.EOF {
+			break
+		} else if err != nil {
+			return common.WrapWithNFError(err, "read pcap header failed", common.PcapReadFail)
+		}
+		data := make([]byte, hdr.InclLen)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return common.WrapWithNFError(err, "read packet data from pcap failed", common.PcapReadFail)
+		}
+		packets = append(packets, data)
+	}
+	return low.InjectPackets(port, packets)
+}
+
+// SentPackets returns packets which were sent to virtual port in
+// simulation mode since previous call.
+func SentPackets(port uint16) ([][]byte, error) {
+	return low.TakeSentPackets(port)
+}
+
+// WaitSentPackets waits until at least number packets are sent to
+// virtual port in simulation mode or timeout expires. Returns all
+// packets which were sent since previous call. Error is returned if
+// timeout expires.
+func WaitSentPackets(port uint16, number int, timeout time.Duration) ([][]byte, error) {
+	var sent [][]byte
+	deadline := time.Now().Add(timeout)
+	for {
+		packets, err := low.TakeSentPackets(port)
+		if err != nil {
+			return nil, err
+		}
+		sent = append(sent, packets...)
+		if len(sent) >= number {
+			return sent, nil
+		}
+		if time.Now().After(deadline) {
+			return sent, common.WrapWithNFError(nil, "Timeout while waiting for sent packets", common.Fail)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
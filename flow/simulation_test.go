@@ -0,0 +1,339 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// makeUDPPacket returns bytes of Ether/IPv4/UDP packet with given
+// destination port.
+func makeUDPPacket(srcPort, dstPort uint16) []byte {
+	data := make([]byte, common.EtherLen+common.IPv4MinLen+common.UDPLen+8)
+	binary.BigEndian.PutUint16(data[12:], common.IPV4Number)
+	ip := data[common.EtherLen:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+	ip[8] = 64
+	ip[9] = common.UDPNumber
+	copy(ip[12:], []byte{192, 168, 1, 1})
+	copy(ip[16:], []byte{192, 168, 2, 1})
+	udp := ip[common.IPv4MinLen:]
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	return data
+}
+
+// makeUDPv6Packet returns bytes of Ether/IPv6/UDP packet with given
+// ports.
+func makeUDPv6Packet(srcPort, dstPort uint16) []byte {
+	data := make([]byte, common.EtherLen+common.IPv6Len+common.UDPLen+8)
+	binary.BigEndian.PutUint16(data[12:], common.IPV6Number)
+	ip := data[common.EtherLen:]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], common.UDPLen+8)
+	ip[6] = common.UDPNumber
+	ip[7] = 64
+	copy(ip[8:], []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	copy(ip[24:], []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2})
+	udp := ip[common.IPv6Len:]
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], common.UDPLen+8)
+	return data
+}
+
+func dropOddPorts(pkt *packet.Packet, ctx UserContext) bool {
+	pkt.ParseL3()
+	pkt.ParseL4ForIPv4()
+	return packet.SwapBytesUint16(pkt.GetUDPNoCheck().DstPort)%2 == 0
+}
+
+func setSrcMAC(pkt *packet.Packet, ctx UserContext) {
+	pkt.Ether.SAddr = GetPortMACAddress(1)
+}
+
+type linkContext struct {
+	changes chan common.LinkStatus
+}
+
+func (ctx linkContext) Copy() interface{} {
+	return ctx
+}
+
+func (ctx linkContext) Delete() {
+}
+
+func linkChanged(port uint16, link common.LinkStatus, ctx UserContext) {
+	ctx.(linkContext).changes <- link
+}
+
+func TestSimulation(t *testing.T) {
+	const number = 100
+	err := SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, CheckTime: 100, LogType: common.No})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	flows, err := SetHashSplitter(in, 2, Hash5Tuple)
+	CheckFatal(err)
+	for i := range flows {
+		CheckFatal(SetHandlerDrop(flows[i], dropOddPorts, nil))
+	}
+	out, err := SetMerger(flows...)
+	CheckFatal(err)
+	CheckFatal(SetHandler(out, setSrcMAC, nil))
+	CheckFatal(SetSender(out, 1))
+	mac := [common.EtherAddrLen]uint8{0x02, 0xaa, 0xbb, 0xcc, 0xdd, 0xee}
+	CheckFatal(SetPortConfig(1, PortConfig{MAC: mac}))
+	changes := make(chan common.LinkStatus, 1)
+	CheckFatal(SetLinkStateChangeHandler(1, linkChanged, linkContext{changes}))
+
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(uint16(1000+i), uint16(2000+i))))
+	}
+	go SystemStart()
+	sent, err := WaitSentPackets(1, number/2, 10*time.Second)
+	if err != nil {
+		CheckFatal(SystemStop())
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	CheckFatal(low.SetSimulatedLinkStatus(1, common.LinkStatus{}))
+	select {
+	case link := <-changes:
+		if link.Up {
+			t.Errorf("Link state change handler got %s instead of down", link)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Link state change handler wasn't called")
+	}
+	CheckFatal(SystemStop())
+
+	stats, err := GetPortStats(0)
+	CheckFatal(err)
+	if stats.RxPackets != number || stats.RxBytes != number*uint64(len(makeUDPPacket(0, 0))) {
+		t.Errorf("Incorrect statistics of receive port: %+v", stats)
+	}
+	stats, err = GetPortStats(1)
+	CheckFatal(err)
+	if stats.TxPackets != number/2 {
+		t.Errorf("Incorrect statistics of send port: %+v", stats)
+	}
+	if tx, err := GetPortXstat(1, "tx_good_packets"); err != nil || tx != number/2 {
+		t.Errorf("Incorrect extended statistic tx_good_packets: %d, %v", tx, err)
+	}
+
+	// Packets of each session stay in order, but sessions of different
+	// hash flows can be reordered.
+	seen := make(map[uint16]bool)
+	for _, data := range sent {
+		if len(data) != len(makeUDPPacket(0, 0)) {
+			t.Fatalf("Incorrect length of sent packet: %d", len(data))
+		}
+		if string(data[6:12]) != string(mac[:]) {
+			t.Errorf("Source MAC wasn't changed: %x", data[6:12])
+		}
+		port := binary.BigEndian.Uint16(data[common.EtherLen+common.IPv4MinLen+2:])
+		if port%2 != 0 || seen[port] {
+			t.Errorf("Unexpected packet to port %d", port)
+		}
+		seen[port] = true
+	}
+	if len(seen) != number/2 {
+		t.Errorf("Sent %d packets, expected %d", len(seen), number/2)
+	}
+}
+
+func TestLinkStateChangeHandler(t *testing.T) {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, ScaleTime: 10, CheckTime: 20, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetStopper(in))
+	// Handler is blocked until release
+	blocked := make(chan struct{}, 2)
+	release := make(chan struct{})
+	changes := make(chan common.LinkStatus, 2)
+	CheckFatal(SetLinkStateChangeHandler(0, func(port uint16, link common.LinkStatus, ctx UserContext) {
+		blocked <- struct{}{}
+		<-release
+		changes <- link
+	}, nil))
+	started := make(chan struct{})
+	AddTimer(10*time.Millisecond, func(UserContext) { close(started) }).AddVariant(nil)
+	timer := make(chan struct{})
+	AddTimer(time.Second, func(UserContext) { close(timer) }).AddVariant(nil)
+	go SystemStart()
+	defer SystemStop()
+	<-started
+
+	CheckFatal(low.SetSimulatedLinkStatus(0, common.LinkStatus{}))
+	select {
+	case <-blocked:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Link state change handler wasn't called")
+	}
+	CheckFatal(low.SetSimulatedLinkStatus(0, common.LinkStatus{Up: true}))
+	// Scheduler isn't stopped by handler
+	select {
+	case <-timer:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timer isn't called while link handler is blocked")
+	}
+	close(release)
+	for _, up := range []bool{false, true} {
+		select {
+		case link := <-changes:
+			if link.Up != up {
+				t.Errorf("Link state change handler got %s instead of up %v", link, up)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Link state change handler wasn't called")
+		}
+	}
+}
+
+func TestPortConfig(t *testing.T) {
+	const mtu = 1600
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetSender(in, 1))
+	mac := [common.EtherAddrLen]uint8{0x02, 0xaa, 0xbb, 0xcc, 0xdd, 0x01}
+	CheckFatal(SetPortConfig(0, PortConfig{MTU: mtu, MAC: mac}))
+	CheckFatal(SystemInitPortsAndMemory())
+	if m, err := low.GetPortMTU(0); err != nil || m != mtu {
+		t.Errorf("MTU of port is %d instead of %d", m, mtu)
+	}
+	if m, _ := low.GetPortMTU(1); m != 1500 {
+		t.Errorf("MTU of port without config is %d", m)
+	}
+	if GetPortMACAddress(0) != mac {
+		t.Errorf("MAC address of port is %v", GetPortMACAddress(0))
+	}
+
+	// Frames which are longer than MTU allows are dropped, VLAN tag is
+	// allowed
+	maxLen := mtu + common.EtherLen + common.VLANLen
+	for _, size := range []int{maxLen, maxLen + 1, 100} {
+		CheckFatal(InjectPackets(0, make([]byte, size)))
+	}
+	go SystemStartScheduler()
+	sent, err := WaitSentPackets(1, 2, 10*time.Second)
+	stats, _ := GetPortStats(0)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	if len(sent) != 2 || len(sent[0]) != maxLen || len(sent[1]) != 100 {
+		t.Errorf("%d wrong packets are sent", len(sent))
+	}
+	if stats.RxPackets != 2 || stats.RxErrors != 1 {
+		t.Errorf("Incorrect statistics of receive port: %+v", stats)
+	}
+
+	// Port isn't initialized with MTU which it doesn't support
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, LogType: common.No}))
+	in, err = SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetStopper(in))
+	CheckFatal(SetPortConfig(0, PortConfig{MTU: 9000}))
+	if SystemInitPortsAndMemory() == nil {
+		t.Error("Port is initialized with too large MTU")
+	}
+}
+
+// findFF returns flow function with given name from scheduler list.
+func findFF(name string) *flowFunction {
+	for _, ff := range schedState.ff {
+		if ff.name == name {
+			return ff
+		}
+	}
+	return nil
+}
+
+func TestNUMAPlacement(t *testing.T) {
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true, LogType: common.No}))
+	// Second half of virtual cores is on node 1
+	for i := range schedState.cores {
+		schedState.cores[i].node = i * 2 / len(schedState.cores)
+	}
+	CheckFatal(low.SetSimulatedPortSocket(0, 0))
+	CheckFatal(low.SetSimulatedPortSocket(1, 1))
+	in1, err := SetReceiver(1)
+	CheckFatal(err)
+	copied, err := SetCopier(in1)
+	CheckFatal(err)
+	CheckFatal(SetHandler(in1, setSrcMAC, nil))
+	CheckFatal(SetSender(in1, 0))
+	in0, err := SetReceiver(0)
+	CheckFatal(err)
+	if s := low.GetRingSocket(copied.current[0]); s != 1 {
+		t.Errorf("Ring of copied flow from port 1 is on node %d", s)
+	}
+	// Flows from different nodes are merged to ring on any node
+	merged, err := SetMerger(in0, copied)
+	CheckFatal(err)
+	if s := low.GetRingSocket(merged.current[0]); s != anySocket {
+		t.Errorf("Ring of merged flow is on node %d", s)
+	}
+	CheckFatal(SetStopper(merged))
+	if s := low.GetMempoolSocket(findFF("copy").Parameters.(*copyParameters).mempool); s != 1 {
+		t.Errorf("Mempool of copier is on node %d", s)
+	}
+
+	go SystemStart()
+	defer func() { CheckFatal(SystemStop()) }()
+	deadline := time.Now().Add(10 * time.Second)
+	schedState.mutex.Lock()
+	for !schedState.running && time.Now().Before(deadline) {
+		schedState.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		schedState.mutex.Lock()
+	}
+	defer schedState.mutex.Unlock()
+	if !schedState.running {
+		t.Fatal("Graph isn't started")
+	}
+	// Receiver of port 1 and following functions are on node 1, sender
+	// to port 0 is on node 0
+	for name, node := range map[string]int{"receiver": 1, "copy": 1, "segment": 1, "sender": 0, "receiver1": 0} {
+		ff := findFF(name)
+		if ff == nil || len(ff.instance) == 0 {
+			t.Fatalf("Flow function %s isn't started", name)
+		}
+		if ff.socket != node {
+			t.Errorf("Flow function %s is placed on node %d instead of %d", name, ff.socket, node)
+		}
+		if index := ff.instance[0].clone[0].index; schedState.cores[index].node != node {
+			t.Errorf("Flow function %s is started on node %d instead of %d", name, schedState.cores[index].node, node)
+		}
+	}
+	// Scheduler adds clones on the same node
+	ffi := findFF("segment").instance[0]
+	CheckFatal(ffi.startNewClone(schedState, 0))
+	if index := ffi.clone[ffi.cloneNumber-1].index; schedState.cores[index].node != 1 {
+		t.Errorf("Clone of segment is started on node %d", schedState.cores[index].node)
+	}
+
+	// Local core is preferred, other node is used when it has no free cores
+	s := newScheduler([]int{0, 1, 2, 3}, false, false, false, nil, 0, 0, 1, 1, false, DefaultSchedulerPolicy{})
+	for i := range s.cores {
+		s.cores[i].node = i / 2
+	}
+	for _, want := range []int{2, 3, 0} {
+		if core, _, err := s.getCore(1, nil); err != nil || core != want {
+			t.Errorf("Got core %d, error %v instead of core %d for node 1", core, err, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Tap is a kernel TAP interface which is an exception path of port
+// like KNI device. Unlike KNI it doesn't require kernel module and
+// NeedKNI option. Interface has MAC address of port, link state of
+// port is copied to interface and MTU is kept equal on both of them,
+// so kernel network stack can process control traffic of port.
+type Tap struct {
+	portId uint16
+	tapId  uint16
+	name   string
+	// Last mirrored state
+	up  bool
+	mtu uint16
+}
+
+// CreateTapDevice creates TAP interface with given name for port. It
+// is used in SetReceiverTap and SetSenderTap to pass packets between
+// port and kernel.
+func CreateTapDevice(portId uint16, name string) (*Tap, error) {
+	if int(portId) >= len(createdPorts) {
+		return nil, common.WrapWithNFError(nil, "Requested TAP port exceeds number of ports which can be used by DPDK (bind to DPDK).", common.ReqTooManyPorts)
+	}
+	schedState.mutex.Lock()
+	defer schedState.mutex.Unlock()
+	for _, t := range schedState.taps {
+		if t.portId == portId {
+			return nil, common.WrapWithNFError(nil, "Requested TAP port already has TAP. Two TAPs for one port are prohibited.", common.BadArgument)
+		}
+	}
+	vdev := VdevPort{
+		Type:  VdevTap,
+		Iface: name,
+		Args:  "mac=" + packet.MACToString(low.GetPortMACAddress(portId)),
+	}
+	tapId, err := CreateVdevPort(&vdev)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tap{portId: portId, tapId: tapId, name: name}
+	t.mirror()
+	schedState.taps = append(schedState.taps, t)
+	return t, nil
+}
+
+// Port returns port of TAP interface itself. It can be used to get
+// statistics of interface.
+func (t *Tap) Port() uint16 {
+	return t.tapId
+}
+
+// SetReceiverTap adds function receiving from TAP interface to flow
+// graph. Packets which kernel sends through interface are received.
+// Returns new opened flow with received packets.
+func SetReceiverTap(tap *Tap, opts ...FlowOption) (OUT *Flow, err error) {
+	return SetReceiver(tap.tapId, opts...)
+}
+
+// SetSenderTap adds function sending to TAP interface to flow graph.
+// Gets flow which will be closed and its packets will be passed to
+// kernel.
+func SetSenderTap(IN *Flow, tap *Tap) error {
+	return SetSender(IN, tap.tapId)
+}
+
+// mirror copies link state of port to TAP interface and synchronizes
+// MTU. MTU changed on port is copied to interface, otherwise MTU
+// changed on interface by kernel is copied to port.
+func (t *Tap) mirror() {
+	if link, err := low.GetPortLinkStatus(t.portId); err == nil && link.Up != t.up {
+		if err := low.SetPortLinkUp(t.tapId, link.Up); err != nil {
+			common.LogWarning(common.Debug, "Cannot change link of TAP", t.name, ":", err)
+		} else {
+			t.up = link.Up
+		}
+	}
+	portMTU, err := low.GetPortMTU(t.portId)
+	if err != nil {
+		return
+	}
+	tapMTU, err := low.GetPortMTU(t.tapId)
+	if err != nil {
+		return
+	}
+	if portMTU == tapMTU {
+		t.mtu = portMTU
+		return
+	}
+	if portMTU == t.mtu {
+		if err = low.SetPortMTU(t.portId, tapMTU); err == nil {
+			t.mtu = tapMTU
+			return
+		}
+		// Port can't use new MTU, so interface gets old one back
+		common.LogWarning(common.Debug, "Cannot change MTU of port", t.portId, "to MTU of TAP", t.name, ":", err)
+	}
+	if err := low.SetPortMTU(t.tapId, portMTU); err != nil {
+		common.LogWarning(common.Debug, "Cannot change MTU of TAP", t.name, ":", err)
+	} else {
+		t.mtu = portMTU
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+// waitFor checks condition until it is true or timeout expires.
+func waitFor(condition func() bool) bool {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTap(t *testing.T) {
+	const number = 10
+	CheckFatal(SystemInit(&Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, ScaleTime: 10, DebugTime: 10, LogType: common.No}))
+	tap, err := CreateTapDevice(0, "tap0")
+	CheckFatal(err)
+	if _, err := CreateTapDevice(0, "tap1"); err == nil {
+		t.Error("Second TAP is created for port")
+	}
+	// Packets from port go to kernel and back
+	in, err := SetReceiver(0)
+	CheckFatal(err)
+	CheckFatal(SetSenderTap(in, tap))
+	back, err := SetReceiverTap(tap)
+	CheckFatal(err)
+	CheckFatal(SetSender(back, 0))
+	for i := 0; i < number; i++ {
+		CheckFatal(InjectPackets(0, makeUDPPacket(1000, uint16(2000+i))))
+	}
+	go SystemStart()
+	defer SystemStop()
+	passed, err := WaitSentPackets(tap.Port(), number, 10*time.Second)
+	if err != nil {
+		t.Fatal(err, "passed", len(passed), "packets")
+	}
+	CheckFatal(InjectPackets(tap.Port(), passed...))
+	if sent, err := WaitSentPackets(0, number, 10*time.Second); err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+
+	tapLink := func(up bool) func() bool {
+		return func() bool {
+			link, _ := low.GetPortLinkStatus(tap.Port())
+			return link.Up == up
+		}
+	}
+	mtuEqual := func(mtu uint16) func() bool {
+		return func() bool {
+			portMTU, _ := low.GetPortMTU(0)
+			tapMTU, _ := low.GetPortMTU(tap.Port())
+			return portMTU == mtu && tapMTU == mtu
+		}
+	}
+	CheckFatal(low.SetSimulatedLinkStatus(0, common.LinkStatus{}))
+	if !waitFor(tapLink(false)) {
+		t.Error("Link down of port isn't mirrored to TAP")
+	}
+	CheckFatal(low.SetSimulatedLinkStatus(0, common.LinkStatus{Up: true}))
+	if !waitFor(tapLink(true)) {
+		t.Error("Link up of port isn't mirrored to TAP")
+	}
+	CheckFatal(low.SetPortMTU(0, 2000))
+	if !waitFor(mtuEqual(2000)) {
+		t.Error("MTU of port isn't mirrored to TAP")
+	}
+	CheckFatal(low.SetPortMTU(tap.Port(), 1400))
+	if !waitFor(mtuEqual(1400)) {
+		t.Error("MTU of TAP isn't mirrored to port")
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"sync"
+	"time"
+)
+
+// TimerWheel is a hierarchical timer wheel for large number of timers
+// with their own timeouts, for example session timers. Adding, resetting
+// and canceling of timer takes constant time, so it can be done for each
+// packet. Wheel can be used from cloned handlers concurrently. Handlers
+// of expired timers are called from goroutine of wheel, not from
+// scheduler, so they shouldn't block for long time.
+//
+// First level of wheel has a slot for each tick of granularity. Each
+// following level has slots for whole previous level. Timers from slot
+// of following level are moved to previous levels when time reaches it.
+type TimerWheel struct {
+	mutex       sync.Mutex
+	granularity time.Duration
+	// Next tick which should be processed
+	next   uint64
+	root   [wheelRootSize]timerSlot
+	levels [wheelLevels - 1][wheelLevelSize]timerSlot
+	count  int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelLevels    = 4
+	wheelRootSize  = 1 << wheelRootBits
+	wheelLevelSize = 1 << wheelLevelBits
+	// Timers with longer timeouts are placed to the last slot of wheel
+	// and are moved there again until their time comes
+	wheelMaxTicks = 1<<(wheelRootBits+(wheelLevels-1)*wheelLevelBits) - 1
+)
+
+// TimerEntry is a timer of TimerWheel.
+type TimerEntry struct {
+	wheel   *TimerWheel
+	handler func(UserContext)
+	context UserContext
+	// Tick when timer expires
+	expires uint64
+	// Slot of wheel and neighbours in it, slot is nil if timer isn't pending
+	slot *timerSlot
+	prev *TimerEntry
+	next *TimerEntry
+}
+
+type timerSlot struct {
+	first *TimerEntry
+}
+
+func (s *timerSlot) push(e *TimerEntry) {
+	e.slot = s
+	e.prev = nil
+	e.next = s.first
+	if s.first != nil {
+		s.first.prev = e
+	}
+	s.first = e
+}
+
+func (e *TimerEntry) unlink() {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		e.slot.first = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.slot = nil
+	e.prev = nil
+	e.next = nil
+}
+
+// NewTimerWheel creates timer wheel with given granularity and starts
+// its goroutine. Timeouts are rounded up to granularity.
+func NewTimerWheel(granularity time.Duration) *TimerWheel {
+	w := newTimerWheel(granularity)
+	go w.run()
+	return w
+}
+
+func newTimerWheel(granularity time.Duration) *TimerWheel {
+	if granularity <= 0 {
+		granularity = time.Millisecond
+	}
+	return &TimerWheel{
+		granularity: granularity,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Add adds timer which calls handler with given context after timeout.
+func (w *TimerWheel) Add(timeout time.Duration, handler func(UserContext), context UserContext) *TimerEntry {
+	e := &TimerEntry{wheel: w, handler: handler, context: context}
+	w.mutex.Lock()
+	w.schedule(e, timeout)
+	w.count++
+	w.mutex.Unlock()
+	return e
+}
+
+// Reset moves expiration of timer to timeout from now. Timer which has
+// already expired or was canceled is started again.
+func (e *TimerEntry) Reset(timeout time.Duration) {
+	w := e.wheel
+	w.mutex.Lock()
+	if e.slot != nil {
+		e.unlink()
+	} else {
+		w.count++
+	}
+	w.schedule(e, timeout)
+	w.mutex.Unlock()
+}
+
+// Cancel stops timer. Returns false if timer has already expired or was
+// canceled, its handler can be running at this moment.
+func (e *TimerEntry) Cancel() bool {
+	w := e.wheel
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if e.slot == nil {
+		return false
+	}
+	e.unlink()
+	w.count--
+	return true
+}
+
+// Len returns number of pending timers.
+func (w *TimerWheel) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.count
+}
+
+// Stop stops goroutine of wheel. Pending timers aren't called.
+func (w *TimerWheel) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *TimerWheel) schedule(e *TimerEntry, timeout time.Duration) {
+	ticks := uint64((timeout + w.granularity - 1) / w.granularity)
+	if timeout <= 0 {
+		ticks = 0
+	}
+	e.expires = w.next + ticks
+	w.place(e)
+}
+
+// place puts timer to slot of level which covers its expiration.
+func (w *TimerWheel) place(e *TimerEntry) {
+	if e.expires < w.next {
+		w.root[w.next&(wheelRootSize-1)].push(e)
+		return
+	}
+	delta := e.expires - w.next
+	if delta < wheelRootSize {
+		w.root[e.expires&(wheelRootSize-1)].push(e)
+		return
+	}
+	expires := e.expires
+	if delta > wheelMaxTicks {
+		expires = w.next + wheelMaxTicks
+		delta = wheelMaxTicks
+	}
+	for l := 0; l < wheelLevels-1; l++ {
+		shift := uint(wheelRootBits + l*wheelLevelBits)
+		if delta < 1<<(shift+wheelLevelBits) {
+			w.levels[l][(expires>>shift)&(wheelLevelSize-1)].push(e)
+			return
+		}
+	}
+}
+
+// cascade moves timers of current slots of levels to previous levels.
+// Next level is cascaded when current level starts from the beginning.
+func (w *TimerWheel) cascade() {
+	for l := 0; l < wheelLevels-1; l++ {
+		shift := uint(wheelRootBits + l*wheelLevelBits)
+		index := (w.next >> shift) & (wheelLevelSize - 1)
+		s := &w.levels[l][index]
+		e := s.first
+		s.first = nil
+		for e != nil {
+			next := e.next
+			e.slot = nil
+			e.prev = nil
+			e.next = nil
+			w.place(e)
+			e = next
+		}
+		if index != 0 {
+			return
+		}
+	}
+}
+
+// expire processes ticks up to now and returns expired timers.
+func (w *TimerWheel) expire(now uint64) []*TimerEntry {
+	var expired []*TimerEntry
+	for w.next <= now {
+		index := w.next & (wheelRootSize - 1)
+		if index == 0 {
+			w.cascade()
+		}
+		s := &w.root[index]
+		for s.first != nil {
+			e := s.first
+			e.unlink()
+			expired = append(expired, e)
+		}
+		w.count -= len(expired)
+		w.next++
+		if len(expired) != 0 {
+			// Other ticks are processed at next call, so
+			// handlers are called as soon as possible
+			break
+		}
+	}
+	return expired
+}
+
+// fire calls handlers of timers expired up to now.
+func (w *TimerWheel) fire(now uint64) {
+	for {
+		w.mutex.Lock()
+		expired := w.expire(now)
+		w.mutex.Unlock()
+		if len(expired) == 0 {
+			return
+		}
+		for _, e := range expired {
+			e.handler(e.context)
+		}
+	}
+}
+
+func (w *TimerWheel) run() {
+	start := time.Now()
+	ticker := time.NewTicker(w.granularity)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			close(w.done)
+			return
+		case <-ticker.C:
+			w.fire(uint64(time.Since(start) / w.granularity))
+		}
+	}
+}
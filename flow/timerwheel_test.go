@@ -0,0 +1,89 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+type tickContext struct {
+	tick uint64
+}
+
+func (ctx *tickContext) Copy() interface{} {
+	return &tickContext{ctx.tick}
+}
+
+func (ctx *tickContext) Delete() {
+}
+
+func TestTimerWheel(t *testing.T) {
+	w := newTimerWheel(time.Millisecond)
+	fired := make(map[uint64]uint64)
+	handler := func(ctx UserContext) {
+		// Handlers are called after their tick was processed
+		fired[ctx.(*tickContext).tick] = w.next - 1
+	}
+	// Timeouts of all levels and longer than wheel
+	ticks := []uint64{1, 2, 255, 256, 257, 1000, 1 << 14, 1<<14 + 3, 1 << 20, 1<<20 + 5, 1<<26 + 7}
+	for _, n := range ticks {
+		w.Add(time.Duration(n)*time.Millisecond, handler, &tickContext{n})
+	}
+	canceled := w.Add(10*time.Millisecond, handler, &tickContext{10})
+	reset := w.Add(20*time.Millisecond, handler, &tickContext{20})
+	if w.Len() != len(ticks)+2 {
+		t.Errorf("Wheel has %d timers, expected %d", w.Len(), len(ticks)+2)
+	}
+	if !canceled.Cancel() || canceled.Cancel() {
+		t.Error("Timer should be canceled only once")
+	}
+	reset.Reset(300 * time.Millisecond)
+
+	for _, now := range []uint64{0, 1, 3, 1 << 10, 1 << 15, 1 << 21, 1<<26 + 10} {
+		w.fire(now)
+	}
+	for _, n := range ticks {
+		if fired[n] != n {
+			t.Errorf("Timer with timeout %d fired at %d", n, fired[n])
+		}
+	}
+	if _, ok := fired[10]; ok {
+		t.Error("Canceled timer fired")
+	}
+	if fired[20] != 300 {
+		t.Errorf("Reset timer fired at %d instead of 300", fired[20])
+	}
+	if w.Len() != 0 {
+		t.Errorf("Wheel has %d timers after expiration", w.Len())
+	}
+}
+
+func TestTimerWheelGoroutine(t *testing.T) {
+	w := NewTimerWheel(time.Millisecond)
+	defer w.Stop()
+	done := make(chan UserContext, 1)
+	ctx := &tickContext{1}
+	start := time.Now()
+	e := w.Add(5*time.Millisecond, func(ctx UserContext) {
+		// Timers can be changed from handlers
+		w.Add(time.Hour, func(UserContext) {}, nil).Cancel()
+		done <- ctx
+	}, ctx)
+	select {
+	case got := <-done:
+		if got != ctx {
+			t.Error("Handler got wrong context")
+		}
+		if time.Since(start) < 5*time.Millisecond {
+			t.Error("Timer fired too early")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timer didn't fire")
+	}
+	if e.Cancel() {
+		t.Error("Expired timer was canceled")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+// VdevType is a type of DPDK virtual device which can be created by
+// SystemInit.
+type VdevType int
+
+const (
+	// VdevPcap receives packets from RxPcap file and writes sent
+	// packets to TxPcap file.
+	VdevPcap VdevType = iota
+	// VdevTap creates kernel TAP interface with Iface name. Packets
+	// sent to port are received by kernel and vice versa.
+	VdevTap
+	// VdevNull drops sent packets and receives empty packets as fast
+	// as possible. It is used for performance measurements.
+	VdevNull
+	// VdevRing passes sent packets through DPDK ring back to receive
+	// of the same port.
+	VdevRing
+	// VdevAfPacket receives and sends packets through existing kernel
+	// interface Iface by AF_PACKET socket with memory mapped rings
+	// (TPACKET_V2) of DPDK af_packet driver. Interface isn't dedicated
+	// to DPDK, so it can be veth or any other kernel managed interface.
+	// AF_XDP isn't supported because DPDK 18.11 has no driver for it.
+	VdevAfPacket
+)
+
+var vdevDrivers = [...]string{
+	VdevPcap:     "net_pcap",
+	VdevTap:      "net_tap",
+	VdevNull:     "net_null",
+	VdevRing:     "net_ring",
+	VdevAfPacket: "net_af_packet",
+}
+
+func (t VdevType) String() string {
+	if t < 0 || int(t) >= len(vdevDrivers) {
+		return "unknown vdev " + strconv.Itoa(int(t))
+	}
+	return vdevDrivers[t]
+}
+
+// VdevPort is a virtual device port which is created by SystemInit
+// instead of passing --vdev in DPDKArgs. Port of created device is
+// stored to Port field and can be got by GetVdevPort, so it can be
+// used by SetReceiver, SetSender and other port functions.
+//
+// In simulation mode each device is a virtual port. Packets from
+// RxPcap are injected into it and VdevRing port receives packets
+// which were sent to it. Other parameters are ignored.
+//
+// Pcap and TAP drivers aren't linked into library built with novdev
+// tag, so such devices can't be created by it.
+type VdevPort struct {
+	Type VdevType
+	// Name by which port is found by GetVdevPort. Default value is
+	// name of DPDK device. DPDK device is always named by driver name
+	// with number of previously created devices, for example
+	// "net_pcap0", because DPDK chooses driver by prefix of name.
+	Name string
+	// Pcap file with packets which are received by VdevPcap port.
+	RxPcap string
+	// Pcap file for packets which are sent to VdevPcap port.
+	TxPcap string
+	// Name of kernel interface of VdevTap and VdevAfPacket ports. It
+	// is required for VdevAfPacket. Default value for VdevTap is
+	// chosen by DPDK.
+	Iface string
+	// Additional driver arguments separated by commas.
+	Args string
+	// Port of created device. It is set when device is created.
+	Port uint16
+}
+
+var vdevPorts = make(map[string]uint16)
+
+// Number of created devices which is used in names of DPDK devices
+var vdevNumber int
+
+// args returns driver arguments of device.
+func (v *VdevPort) args() string {
+	var args []string
+	switch v.Type {
+	case VdevPcap:
+		if v.RxPcap != "" {
+			args = append(args, "rx_pcap="+v.RxPcap)
+		}
+		if v.TxPcap != "" {
+			args = append(args, "tx_pcap="+v.TxPcap)
+		}
+	case VdevTap:
+		if v.Iface != "" {
+			args = append(args, "iface="+v.Iface)
+		}
+	case VdevAfPacket:
+		args = append(args, "iface="+v.Iface, "qpairs=1")
+	}
+	if v.Args != "" {
+		args = append(args, v.Args)
+	}
+	return strings.Join(args, ",")
+}
+
+func createVdevPorts(vdevs []VdevPort) error {
+	vdevPorts = make(map[string]uint16)
+	vdevNumber = 0
+	for i := range vdevs {
+		if _, err := CreateVdevPort(&vdevs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateVdevPort creates virtual device after SystemInit and stores its
+// port to Port field. Port can be used in flow graph like ports
+// created by SystemInit, for example to use kernel interface which
+// appeared at runtime.
+func CreateVdevPort(v *VdevPort) (uint16, error) {
+	if v.Type < 0 || int(v.Type) >= len(vdevDrivers) {
+		return 0, common.WrapWithNFError(nil, "Unknown type of virtual device "+v.Name, common.BadArgument)
+	}
+	if v.Type == VdevAfPacket && v.Iface == "" {
+		return 0, common.WrapWithNFError(nil, "Interface of "+v.Type.String()+" device isn't specified", common.BadArgument)
+	}
+	device := v.Type.String() + strconv.Itoa(vdevNumber)
+	if v.Name == "" {
+		v.Name = device
+	}
+	if _, ok := vdevPorts[v.Name]; ok {
+		return 0, common.WrapWithNFError(nil, "Virtual device "+v.Name+" is already created", common.BadArgument)
+	}
+	port, err := low.AttachVdev(device, v.args())
+	if err != nil {
+		return 0, err
+	}
+	vdevNumber++
+	addPorts(int(port) + 1)
+	if low.IsSimulation() {
+		if err := simulateVdev(v, port); err != nil {
+			return 0, err
+		}
+	}
+	common.LogDebug(common.Initialization, "Virtual device", v.Name, "is created by DPDK as", device, "port", port)
+	v.Port = port
+	vdevPorts[v.Name] = port
+	return port, nil
+}
+
+func simulateVdev(v *VdevPort, port uint16) error {
+	switch v.Type {
+	case VdevPcap:
+		if v.RxPcap != "" {
+			return InjectPcap(port, v.RxPcap)
+		}
+	case VdevRing:
+		return low.ConnectSimulatedPorts(port, port)
+	}
+	return nil
+}
+
+// GetVdevPort returns port of virtual device with given name which
+// was created by SystemInit.
+func GetVdevPort(name string) (uint16, error) {
+	port, ok := vdevPorts[name]
+	if !ok {
+		return 0, common.WrapWithNFError(nil, "Virtual device "+name+" wasn't created", common.BadArgument)
+	}
+	return port, nil
+}
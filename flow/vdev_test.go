@@ -0,0 +1,85 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+func writePcap(t *testing.T, filename string, packets ...[]byte) {
+	f, err := os.Create(filename)
+	CheckFatal(err)
+	defer f.Close()
+	CheckFatal(packet.WritePcapGlobalHdr(f))
+	for _, data := range packets {
+		hdr := packet.PcapRecHdr{InclLen: uint32(len(data)), OrigLen: uint32(len(data))}
+		CheckFatal(binary.Write(f, binary.LittleEndian, &hdr))
+		_, err := f.Write(data)
+		CheckFatal(err)
+	}
+}
+
+func TestVdevPorts(t *testing.T) {
+	const number = 10
+	var packets [][]byte
+	for i := 0; i < number; i++ {
+		packets = append(packets, makeUDPPacket(1000, uint16(2000+i)))
+	}
+	dir, err := ioutil.TempDir("", "vdev")
+	CheckFatal(err)
+	defer os.RemoveAll(dir)
+	rx := filepath.Join(dir, "rx.pcap")
+	writePcap(t, rx, packets...)
+	config := &Config{Simulation: true, SimulationPorts: 1, DisableScheduler: true, LogType: common.No,
+		VdevPorts: []VdevPort{{Type: VdevPcap, RxPcap: rx}, {Type: VdevRing, Name: "loop"}}}
+	CheckFatal(SystemInit(config))
+	pcap, ring := config.VdevPorts[0].Port, config.VdevPorts[1].Port
+	if config.VdevPorts[0].Name != "net_pcap0" || pcap != 1 || ring != 2 {
+		t.Errorf("Wrong virtual devices: %+v", config.VdevPorts)
+	}
+	if port, err := GetVdevPort("loop"); err != nil || port != ring {
+		t.Errorf("Port of loop is %d, error %v", port, err)
+	}
+	if _, err := CreateVdevPort(&VdevPort{Type: VdevNull, Name: "loop"}); err == nil {
+		t.Error("Device with name of existing device is created")
+	}
+	if _, err := GetVdevPort("missing"); err == nil {
+		t.Error("Port of device which wasn't created is returned")
+	}
+	if _, err := CreateVdevPort(&VdevPort{Type: VdevAfPacket}); err == nil {
+		t.Error("AF_PACKET device without interface is created")
+	}
+	kernel := VdevPort{Type: VdevAfPacket, Iface: "veth0"}
+	if port, err := CreateVdevPort(&kernel); err != nil || port != 3 || kernel.Name != "net_af_packet2" {
+		t.Errorf("AF_PACKET device %+v is created as port %d, error %v", kernel, port, err)
+	}
+
+	// Packets from pcap go through ring loop to port 0
+	in, err := SetReceiver(pcap)
+	CheckFatal(err)
+	CheckFatal(SetSender(in, ring))
+	looped, err := SetReceiver(ring)
+	CheckFatal(err)
+	CheckFatal(SetSender(looped, 0))
+	go SystemStart()
+	sent, err := WaitSentPackets(0, number, 10*time.Second)
+	CheckFatal(SystemStop())
+	if err != nil {
+		t.Fatal(err, "sent", len(sent), "packets")
+	}
+	for i, data := range sent {
+		if udpDstPort(data) != uint16(2000+i) {
+			t.Errorf("Packet %d has destination port %d", i, udpDstPort(data))
+		}
+	}
+}
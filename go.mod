@@ -6,7 +6,7 @@ require (
 	github.com/docker/go-connections v0.4.0
 	github.com/docker/go-units v0.3.3 // indirect
 	github.com/flier/gohs v1.0.0
-	github.com/google/gopacket v1.1.15 // indirect
+	github.com/google/gopacket v1.1.15
 	github.com/pkg/errors v0.8.0
 	github.com/vishvananda/netlink v1.0.0 // indirect
 	github.com/vishvananda/netns v0.0.0-20180720170159-13995c7128cc // indirect
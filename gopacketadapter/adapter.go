@@ -0,0 +1,103 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gopacketadapter connects NFF-GO packets and flows with
+// github.com/google/gopacket. Packets can be decoded into gopacket
+// layers, gopacket layers can be serialized into packets and flows can
+// be read by gopacket based analysis code as gopacket.PacketDataSource.
+package gopacketadapter
+
+import (
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// Decode decodes packet into gopacket.Packet starting from Ethernet
+// layer. Packet data is not copied, so returned gopacket.Packet and its
+// layers are valid only until packet is changed, sent or freed.
+// NoCopy is always set in options.
+func Decode(pkt *packet.Packet, options gopacket.DecodeOptions) gopacket.Packet {
+	options.NoCopy = true
+	return gopacket.NewPacket(pkt.GetRawPacketBytes(), layers.LayerTypeEthernet, options)
+}
+
+// Parser decodes packets into preallocated layers by
+// gopacket.DecodingLayerParser. It is much faster than Decode because
+// nothing is allocated for each packet. Layers which were found in last
+// decoded packet are listed in Decoded. Layers refer to packet data, so
+// they are valid only until packet is changed, sent or freed.
+//
+// Parser implements flow.UserContext, so it can be passed as context to
+// handlers and each handler instance gets its own Parser.
+type Parser struct {
+	Ethernet layers.Ethernet
+	Dot1Q    layers.Dot1Q
+	IPv4     layers.IPv4
+	IPv6     layers.IPv6
+	TCP      layers.TCP
+	UDP      layers.UDP
+	ICMPv4   layers.ICMPv4
+	ICMPv6   layers.ICMPv6
+	ARP      layers.ARP
+	Payload  gopacket.Payload
+
+	Decoded []gopacket.LayerType
+	parser  *gopacket.DecodingLayerParser
+}
+
+// NewParser creates Parser which decodes packets starting from Ethernet
+// layer. Unsupported layers stop decoding without error.
+func NewParser() *Parser {
+	p := new(Parser)
+	p.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&p.Ethernet, &p.Dot1Q, &p.IPv4, &p.IPv6, &p.TCP, &p.UDP,
+		&p.ICMPv4, &p.ICMPv6, &p.ARP, &p.Payload)
+	p.parser.IgnoreUnsupported = true
+	p.Decoded = make([]gopacket.LayerType, 0, 10)
+	return p
+}
+
+// Decode decodes packet into layers of parser. Error is returned if
+// some layer is malformed, Decoded lists layers which were decoded
+// successfully before it.
+func (p *Parser) Decode(pkt *packet.Packet) error {
+	return p.parser.DecodeLayers(pkt.GetRawPacketBytes(), &p.Decoded)
+}
+
+// Copy returns new Parser. Is used by flow functions to give each
+// handler instance its own parser.
+func (p *Parser) Copy() interface{} {
+	return NewParser()
+}
+
+// Delete is required by flow.UserContext.
+func (p *Parser) Delete() {
+}
+
+var serializeBuffers = sync.Pool{
+	New: func() interface{} {
+		return gopacket.NewSerializeBuffer()
+	},
+}
+
+// Serialize serializes gopacket layers into empty packet, for example
+// just allocated by packet.NewPacket. Lengths and checksums are fixed
+// according to options. Checksums of TCP, UDP and ICMPv6 layers are
+// computed only if SetNetworkLayerForChecksum was called for them.
+func Serialize(pkt *packet.Packet, options gopacket.SerializeOptions, layers ...gopacket.SerializableLayer) error {
+	buf := serializeBuffers.Get().(gopacket.SerializeBuffer)
+	defer serializeBuffers.Put(buf)
+	if err := gopacket.SerializeLayers(buf, options, layers...); err != nil {
+		return common.WrapWithNFError(err, "Can't serialize gopacket layers", common.BadArgument)
+	}
+	if !packet.GeneratePacketFromByte(pkt, buf.Bytes()) {
+		return common.WrapWithNFError(nil, "Can't append serialized layers to packet", common.AllocMbufErr)
+	}
+	return nil
+}
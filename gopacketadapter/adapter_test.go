@@ -0,0 +1,159 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gopacketadapter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+func init() {
+	if err := flow.SystemInit(&flow.Config{Simulation: true, DisableScheduler: true, LogType: common.No}); err != nil {
+		panic(err)
+	}
+	// Mempool for packet.NewPacket is created only by SystemStart.
+	packet.SetNonPerfMempool(low.CreateMempool("Test"))
+}
+
+var testPayload = []byte("payload of gopacket adapter test")
+
+func testLayers() []gopacket.SerializableLayer {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0x01, 0x11, 0x21, 0x31, 0x41, 0x51},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 4000}
+	udp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{eth, ip, udp, gopacket.Payload(testPayload)}
+}
+
+func serializeTestPacket(t *testing.T) *packet.Packet {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := Serialize(pkt, options, testLayers()...); err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestSerialize(t *testing.T) {
+	pkt := serializeTestPacket(t)
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil || packet.SwapBytesUint16(ipv4.TotalLength) != common.IPv4MinLen+common.UDPLen+uint16(len(testPayload)) {
+		t.Fatalf("Incorrect IPv4 header: %v", ipv4)
+	}
+	if ipv4.HdrChecksum != packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4)) {
+		t.Errorf("Incorrect IPv4 checksum %x", packet.SwapBytesUint16(ipv4.HdrChecksum))
+	}
+	pkt.ParseL4ForIPv4()
+	udp := pkt.GetUDPForIPv4()
+	if udp == nil || packet.SwapBytesUint16(udp.DstPort) != 4000 {
+		t.Fatalf("Incorrect UDP header: %v", udp)
+	}
+	pkt.ParseData()
+	if udp.DgramCksum != packet.SwapBytesUint16(packet.CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data)) {
+		t.Errorf("Incorrect UDP checksum %x", packet.SwapBytesUint16(udp.DgramCksum))
+	}
+	if data, ok := pkt.GetPacketPayload(); !ok || !bytes.Equal(data, testPayload) {
+		t.Errorf("Incorrect payload %q", data)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	pkt := serializeTestPacket(t)
+	gp := Decode(pkt, gopacket.Default)
+	if err := gp.ErrorLayer(); err != nil {
+		t.Fatal(err.Error())
+	}
+	udp, ok := gp.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != 1234 || udp.DstPort != 4000 {
+		t.Fatalf("Incorrect UDP layer: %v", gp)
+	}
+	if !bytes.Equal(gp.ApplicationLayer().Payload(), testPayload) {
+		t.Errorf("Incorrect payload %q", gp.ApplicationLayer().Payload())
+	}
+	// Layers refer to packet data.
+	pkt.ParseL3()
+	pkt.GetIPv4().TimeToLive = 1
+	if gp.Layer(layers.LayerTypeIPv4).LayerContents()[8] != 1 {
+		t.Error("Decoded packet doesn't share data with packet")
+	}
+}
+
+func TestParser(t *testing.T) {
+	pkt := serializeTestPacket(t)
+	p := NewParser().Copy().(*Parser)
+	if err := p.Decode(pkt); err != nil {
+		t.Fatal(err)
+	}
+	want := []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeUDP, gopacket.LayerTypePayload}
+	if len(p.Decoded) != len(want) {
+		t.Fatalf("Incorrect decoded layers %v, expected %v", p.Decoded, want)
+	}
+	for i := range want {
+		if p.Decoded[i] != want[i] {
+			t.Fatalf("Incorrect decoded layers %v, expected %v", p.Decoded, want)
+		}
+	}
+	if !p.IPv4.DstIP.Equal(net.IP{10, 0, 0, 2}) || p.UDP.SrcPort != 1234 || !bytes.Equal(p.Payload, testPayload) {
+		t.Errorf("Incorrect decoded fields: %v %v", p.IPv4, p.UDP)
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	const number = 10
+	in, err := flow.SetReceiver(0)
+	flow.CheckFatal(err)
+	source, err := NewDataSource(in, number)
+	flow.CheckFatal(err)
+	flow.CheckFatal(flow.SetStopper(in))
+
+	buf := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	flow.CheckFatal(gopacket.SerializeLayers(buf, options, testLayers()...))
+	for i := 0; i < number; i++ {
+		flow.CheckFatal(flow.InjectPackets(0, buf.Bytes()))
+	}
+	go flow.SystemStart()
+	defer flow.SystemStop()
+
+	ps := gopacket.NewPacketSource(source, layers.LinkTypeEthernet)
+	for i := 0; i < number; i++ {
+		select {
+		case gp := <-ps.Packets():
+			if !bytes.Equal(gp.Data(), buf.Bytes()) {
+				t.Fatalf("Incorrect packet %x", gp.Data())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timeout, received %d packets", i)
+		}
+	}
+	source.Close()
+	if _, _, err := source.ReadPacketData(); err == nil {
+		t.Error("ReadPacketData should return error after Close")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gopacketadapter
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+)
+
+// DataSource is gopacket.PacketDataSource which returns copies of
+// packets of NFF-GO flow. It allows to run existing gopacket based
+// analysis code, for example gopacket.PacketSource, on flows. Packets
+// themselves are not changed and continue their way in flow.
+type DataSource struct {
+	packets chan dataSourcePacket
+	done    chan struct{}
+	close   sync.Once
+	dropped uint64
+}
+
+type dataSourcePacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// Handler context which is shared between all clones of handler.
+type dataSourceContext struct {
+	source *DataSource
+}
+
+func (ctx dataSourceContext) Copy() interface{} {
+	return ctx
+}
+
+func (ctx dataSourceContext) Delete() {
+}
+
+// NewDataSource adds handler to IN flow which copies packets to
+// returned DataSource. Up to queueSize copies are kept for reading,
+// other packets are counted as dropped if reader is too slow.
+// Should be called before SystemStart.
+func NewDataSource(IN *flow.Flow, queueSize uint) (*DataSource, error) {
+	s := &DataSource{
+		packets: make(chan dataSourcePacket, queueSize),
+		done:    make(chan struct{}),
+	}
+	if err := flow.SetHandler(IN, dataSourceHandler, dataSourceContext{s}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func dataSourceHandler(pkt *packet.Packet, context flow.UserContext) {
+	s := context.(dataSourceContext).source
+	data := pkt.GetRawPacketBytes()
+	p := dataSourcePacket{
+		data: append([]byte(nil), data...),
+		ci: gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: len(data),
+			Length:        int(pkt.GetPacketLen()),
+		},
+	}
+	select {
+	case s.packets <- p:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// ReadPacketData returns next packet of flow. It blocks until packet is
+// available. io.EOF is returned after Close.
+func (s *DataSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	select {
+	case <-s.done:
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	default:
+	}
+	select {
+	case p := <-s.packets:
+		return p.data, p.ci, nil
+	case <-s.done:
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+}
+
+// Close stops reading of DataSource, blocked and following calls of
+// ReadPacketData return io.EOF. Packets still go through handler of
+// flow and are counted as dropped.
+func (s *DataSource) Close() {
+	s.close.Do(func() {
+		close(s.done)
+	})
+}
+
+// Dropped returns number of packets which weren't copied to DataSource
+// because its queue was full.
+func (s *DataSource) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
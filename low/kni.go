@@ -0,0 +1,84 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package low
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// KniOps are handlers of requests which kernel sends when KNI
+// interface is configured. Handlers are called from receive function
+// of KNI device. Nil handler ignores request.
+type KniOps struct {
+	ChangeMTU  func(port uint16, mtu uint16) error
+	ConfigLink func(port uint16, up bool) error
+	ConfigMAC  func(port uint16, mac [common.EtherAddrLen]uint8) error
+}
+
+var kniOps = struct {
+	sync.Mutex
+	ops map[uint16]*KniOps
+}{ops: make(map[uint16]*KniOps)}
+
+// setKniOps sets handlers of KNI device of port, nil removes them.
+func setKniOps(port uint16, ops *KniOps) {
+	kniOps.Lock()
+	defer kniOps.Unlock()
+	if ops == nil {
+		delete(kniOps.ops, port)
+	} else {
+		kniOps.ops[port] = ops
+	}
+}
+
+func getKniOps(port uint16) *KniOps {
+	kniOps.Lock()
+	defer kniOps.Unlock()
+	if ops, ok := kniOps.ops[port]; ok {
+		return ops
+	}
+	return &KniOps{}
+}
+
+// kniResult converts result of handler to result of KNI request,
+// which is zero or negative errno.
+func kniResult(request string, port uint16, err error) int {
+	if err != nil {
+		common.LogWarning(common.Debug, "KNI: Can't", request, "of port", port, ":", err)
+		return -int(syscall.EINVAL)
+	}
+	common.LogDebug(common.Debug, "KNI: Request to", request, "of port", port, "is done")
+	return 0
+}
+
+func handleKniChangeMTU(port uint16, mtu uint) int {
+	ops := getKniOps(port)
+	if ops.ChangeMTU == nil {
+		return 0
+	}
+	if mtu > uint(^uint16(0)) {
+		return -int(syscall.EINVAL)
+	}
+	return kniResult("change MTU", port, ops.ChangeMTU(port, uint16(mtu)))
+}
+
+func handleKniConfigLink(port uint16, up bool) int {
+	ops := getKniOps(port)
+	if ops.ConfigLink == nil {
+		return 0
+	}
+	return kniResult("configure link", port, ops.ConfigLink(port, up))
+}
+
+func handleKniConfigMAC(port uint16, mac [common.EtherAddrLen]uint8) int {
+	ops := getKniOps(port)
+	if ops.ConfigMAC == nil {
+		return 0
+	}
+	return kniResult("configure MAC address", port, ops.ConfigMAC(port, mac))
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !nodpdk
+// +build !nodpdk
+
+package low
+
+// Functions exported to C can't be in file with C definitions, so
+// callbacks of KNI requests are placed here. They are called by
+// rte_kni_handle_request from receive function of KNI device.
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+//export kniChangeMTU
+func kniChangeMTU(port C.uint16_t, mtu C.uint) C.int {
+	return C.int(handleKniChangeMTU(uint16(port), uint(mtu)))
+}
+
+//export kniConfigLink
+func kniConfigLink(port C.uint16_t, up C.uint8_t) C.int {
+	return C.int(handleKniConfigLink(uint16(port), up != 0))
+}
+
+//export kniConfigMAC
+func kniConfigMAC(port C.uint16_t, addr *C.uint8_t) C.int {
+	var mac [common.EtherAddrLen]uint8
+	copy(mac[:], (*[common.EtherAddrLen]uint8)(unsafe.Pointer(addr))[:])
+	return C.int(handleKniConfigMAC(uint16(port), mac))
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package low
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+func TestKniOps(t *testing.T) {
+	const port = 5
+	if ops := getKniOps(port); ops == nil || ops.ChangeMTU != nil || ops.ConfigLink != nil || ops.ConfigMAC != nil {
+		t.Fatalf("Port without KNI has handlers %+v", ops)
+	}
+	// Requests are accepted if there are no handlers
+	if handleKniChangeMTU(port, 9000) != 0 || handleKniConfigLink(port, true) != 0 ||
+		handleKniConfigMAC(port, [common.EtherAddrLen]uint8{}) != 0 {
+		t.Error("Request without handler is rejected")
+	}
+
+	var mtu uint16
+	var up bool
+	var mac [common.EtherAddrLen]uint8
+	fail := false
+	result := func() error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	setKniOps(port, &KniOps{
+		ChangeMTU: func(p uint16, m uint16) error {
+			if p != port {
+				t.Errorf("MTU handler is called for port %d", p)
+			}
+			mtu = m
+			return result()
+		},
+		ConfigLink: func(p uint16, u bool) error {
+			up = u
+			return result()
+		},
+		ConfigMAC: func(p uint16, m [common.EtherAddrLen]uint8) error {
+			mac = m
+			return result()
+		},
+	})
+	defer setKniOps(port, nil)
+	// Other ports still have no handlers
+	if getKniOps(port+1).ChangeMTU != nil {
+		t.Error("Handlers of port are used for another port")
+	}
+
+	if ret := handleKniChangeMTU(port, 9000); ret != 0 || mtu != 9000 {
+		t.Errorf("MTU request returned %d, handler got MTU %d", ret, mtu)
+	}
+	for _, bad := range []uint{65536, 1 << 31} {
+		if ret := handleKniChangeMTU(port, bad); ret != -int(syscall.EINVAL) || mtu != 9000 {
+			t.Errorf("MTU %d request returned %d, handler got MTU %d", bad, ret, mtu)
+		}
+	}
+	if ret := handleKniChangeMTU(port, 65535); ret != 0 || mtu != 65535 {
+		t.Errorf("Maximum MTU request returned %d, handler got MTU %d", ret, mtu)
+	}
+	if ret := handleKniConfigLink(port, true); ret != 0 || !up {
+		t.Errorf("Link request returned %d, handler got %v", ret, up)
+	}
+	addr := [common.EtherAddrLen]uint8{0x02, 1, 2, 3, 4, 5}
+	if ret := handleKniConfigMAC(port, addr); ret != 0 || mac != addr {
+		t.Errorf("MAC request returned %d, handler got %v", ret, mac)
+	}
+
+	// Errors of handlers are reported to kernel
+	fail = true
+	if ret := handleKniChangeMTU(port, 1500); ret != -int(syscall.EINVAL) {
+		t.Errorf("Failed MTU request returned %d", ret)
+	}
+	if ret := handleKniConfigLink(port, false); ret != -int(syscall.EINVAL) {
+		t.Errorf("Failed link request returned %d", ret)
+	}
+	if ret := handleKniConfigMAC(port, addr); ret != -int(syscall.EINVAL) {
+		t.Errorf("Failed MAC request returned %d", ret)
+	}
+
+	setKniOps(port, nil)
+	if getKniOps(port).ChangeMTU != nil {
+		t.Error("Handlers aren't removed")
+	}
+}
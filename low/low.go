@@ -2,6 +2,9 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build !nodpdk
+// +build !nodpdk
+
 package low
 
 // Libraries below are DPDK libraries. PMD drivers and some basic DPDK
@@ -10,7 +13,8 @@ package low
 // linked entirely and dependencies for them have to be resolved
 // circularly. Don't add any more libraries inside of --whole-archive
 // group of libraries unless they are really necessary there because
-// it increases executable size and build time.
+// it increases executable size and build time. Drivers of virtual
+// devices are linked in vdev_dpdk.go.
 
 /*
 #cgo LDFLAGS: -lrte_distributor -lrte_reorder -lrte_kni -lrte_pipeline -lrte_table -lrte_port -lrte_timer -lrte_jobstats -lrte_lpm -lrte_power -lrte_acl -lrte_meter -lrte_sched -lrte_vhost -lrte_ip_frag -lrte_cfgfile -Wl,--whole-archive -Wl,--start-group -lrte_kvargs -lrte_mbuf -lrte_hash -lrte_ethdev -lrte_mempool -lrte_ring -lrte_mempool_ring -lrte_eal -lrte_cmdline -lrte_net -lrte_bus_pci -lrte_pci -lrte_bus_vdev -lrte_timer -lrte_pmd_bond -lrte_pmd_vmxnet3_uio -lrte_pmd_virtio -lrte_pmd_cxgbe -lrte_pmd_enic -lrte_pmd_i40e -lrte_pmd_fm10k -lrte_pmd_ixgbe -lrte_pmd_e1000 -lrte_pmd_ena -lrte_pmd_ring -lrte_pmd_af_packet -lrte_pmd_null -Wl,--end-group -Wl,--no-whole-archive -lrt -lm -ldl -lnuma
@@ -34,11 +38,24 @@ var ringName = 1
 
 // DirectStop frees mbufs.
 func DirectStop(pktsForFreeNumber int, buf []uintptr) {
+	if simulation {
+		simFreeMbufs(buf[:pktsForFreeNumber])
+		return
+	}
 	C.directStop(C.int(pktsForFreeNumber), (**C.struct_rte_mbuf)(unsafe.Pointer(&(buf[0]))))
 }
 
 // DirectSend sends one mbuf.
 func DirectSend(m *Mbuf, port uint16) bool {
+	if simulation {
+		buf := []uintptr{uintptr(unsafe.Pointer(m))}
+		if int(port) >= len(simPorts) {
+			simFreeMbufs(buf)
+			return false
+		}
+		simTransmit(port, buf)
+		return true
+	}
 	return bool(C.directSend((*C.struct_rte_mbuf)(m), C.uint16_t(port)))
 }
 
@@ -72,6 +89,9 @@ func GetPort(n uint16) *Port {
 }
 
 func CheckRSSPacketCount(p *Port, queue int16) int64 {
+	if simulation {
+		return simPendingPackets(uint16(p.PortId))
+	}
 	return int64(C.checkRSSPacketCount((*C.struct_cPort)(p), (C.int16_t(queue))))
 }
 
@@ -80,6 +100,12 @@ func GetPortMACAddress(port uint16) [common.EtherAddrLen]uint8 {
 	var mac [common.EtherAddrLen]uint8
 	var cmac C.struct_ether_addr
 
+	if simulation {
+		if int(port) < len(simPorts) {
+			mac = simPorts[port].mac
+		}
+		return mac
+	}
 	C.rte_eth_macaddr_get(C.uint16_t(port), &cmac)
 	for i := range mac {
 		mac[i] = uint8(cmac.addr_bytes[i])
@@ -210,6 +236,37 @@ func SetTXIPv6TCPOLFlags(mb *Mbuf, l2len, l3len uint32) {
 	setMbufLen(mb, l2len, l3len)
 }
 
+// Checksum states which NIC reports for received mbufs
+const (
+	RXChecksumUnknown = iota // NIC didn't check checksum
+	RXChecksumGood
+	RXChecksumBad
+)
+
+func rxChecksumState(flags, good, bad uint64) int {
+	switch flags & (good | bad) {
+	case good:
+		return RXChecksumGood
+	case bad:
+		return RXChecksumBad
+	}
+	return RXChecksumUnknown
+}
+
+// GetRXIPChecksumState returns state of IPv4 header checksum which
+// NIC reported for received mbuf.
+func GetRXIPChecksumState(mb *Mbuf) int {
+	// PKT_RX_IP_CKSUM_GOOD, PKT_RX_IP_CKSUM_BAD
+	return rxChecksumState(uint64(mb.ol_flags), 1<<7, 1<<4)
+}
+
+// GetRXL4ChecksumState returns state of TCP or UDP checksum which NIC
+// reported for received mbuf.
+func GetRXL4ChecksumState(mb *Mbuf) int {
+	// PKT_RX_L4_CKSUM_GOOD, PKT_RX_L4_CKSUM_BAD
+	return rxChecksumState(uint64(mb.ol_flags), 1<<8, 1<<3)
+}
+
 // These constants are used by packet package to parse protocol headers
 const (
 	RtePtypeL2Ether = C.RTE_PTYPE_L2_ETHER
@@ -228,18 +285,45 @@ const (
 
 // CreateRing creates ring with given name and count.
 func CreateRing(count uint) *Ring {
+	return CreateRingOnSocket(count, C.SOCKET_ID_ANY)
+}
+
+// CreateRingOnSocket creates ring with given count in memory of given
+// NUMA node. Negative socket means any node.
+func CreateRingOnSocket(count uint, socket int) *Ring {
 	name := strconv.Itoa(ringName)
 	ringName++
 
+	if socket < 0 {
+		socket = C.SOCKET_ID_ANY
+	}
+	if simulation {
+		r := simCreateRing(C.CString(name), count)
+		// Simulated rings aren't bound to NUMA nodes, node is only recorded
+		r.socket_id = C.int(socket)
+		return r
+	}
 	// Flag 0x0000 means ring default mode which is Multiple Consumer / Multiple Producer
-	return (*Ring)(unsafe.Pointer(C.nff_go_ring_create(C.CString(name), C.uint(count), C.SOCKET_ID_ANY, 0x0000)))
+	return (*Ring)(unsafe.Pointer(C.nff_go_ring_create(C.CString(name), C.uint(count), C.int(socket), 0x0000)))
+}
+
+// GetRingSocket returns NUMA node which ring was created for or -1 if
+// it can be placed on any node.
+func GetRingSocket(ring *Ring) int {
+	return int(ring.socket_id)
 }
 
 // CreateRings creates ring with given name and count.
 func CreateRings(count uint, inIndexNumber int32) Rings {
+	return CreateRingsOnSocket(count, inIndexNumber, C.SOCKET_ID_ANY)
+}
+
+// CreateRingsOnSocket creates rings with given count in memory of
+// given NUMA node. Negative socket means any node.
+func CreateRingsOnSocket(count uint, inIndexNumber int32, socket int) Rings {
 	rings := make(Rings, inIndexNumber, inIndexNumber)
 	for i := int32(0); i < inIndexNumber; i++ {
-		rings[i] = CreateRing(count)
+		rings[i] = CreateRingOnSocket(count, socket)
 	}
 	return rings
 }
@@ -448,6 +532,10 @@ func (ring *Ring) GetRingCount() uint32 {
 
 // ReceiveRSS - get packets from port and enqueue on a Ring.
 func ReceiveRSS(port uint16, inIndex []int32, OUT Rings, flag *int32, coreID int) {
+	if simulation {
+		simReceive(port, inIndex, OUT, flag)
+		return
+	}
 	if C.rte_eth_dev_socket_id(C.uint16_t(port)) != C.int(C.rte_lcore_to_socket_id(C.uint(coreID))) {
 		common.LogWarning(common.Initialization, "Receive port", port, "is on remote NUMA node to polling thread - not optimal performance.")
 	}
@@ -461,6 +549,10 @@ func ReceiveKNI(port uint16, OUT *Ring, flag *int32, coreID int) {
 
 // Send - dequeue packets and send.
 func Send(port uint16, queue int16, IN Rings, inIndexNumber int32, flag *int32, coreID int) {
+	if simulation {
+		simSend(port, IN, inIndexNumber, flag)
+		return
+	}
 	t := C.rte_eth_dev_socket_id(C.uint16_t(port))
 	if queue != -1 && t != C.int(C.rte_lcore_to_socket_id(C.uint(coreID))) {
 		common.LogWarning(common.Initialization, "Send port", port, "is on remote NUMA node to polling thread - not optimal performance.")
@@ -470,6 +562,10 @@ func Send(port uint16, queue int16, IN Rings, inIndexNumber int32, flag *int32,
 
 // Stop - dequeue and free packets.
 func Stop(IN Rings, flag *int32, coreID int) {
+	if simulation {
+		simStop(IN, flag)
+		return
+	}
 	C.nff_go_stop(C.extractDPDKRings((**C.struct_nff_go_ring)(unsafe.Pointer(&(IN[0]))), C.int32_t(len(IN))), C.int(len(IN)), (*C.int)(unsafe.Pointer(flag)), C.int(coreID))
 }
 
@@ -501,55 +597,191 @@ func InitDPDK(argc C.int, argv **C.char, burstSize uint, mbufNumber uint, mbufCa
 }
 
 func StopDPDK() {
+	if simulation {
+		return
+	}
 	C.rte_eal_cleanup()
 }
 
 func FreeMempools() {
-	for i := range usedMempools {
-		C.rte_mempool_free(usedMempools[i].mempool)
+	if simulation {
+		simFreeMempools()
+	} else {
+		for i := range usedMempools {
+			C.rte_mempool_free(usedMempools[i].mempool)
+		}
 	}
 	usedMempools = nil
 }
 
 func StopPort(port uint16) {
+	if simulation {
+		return
+	}
 	C.rte_eth_dev_stop(C.uint16_t(port))
 }
 
 func FreeKNI(port uint16) error {
+	if simulation {
+		return nil
+	}
+	setKniOps(port, nil)
 	if C.free_kni(C.uint16_t(port)) < 0 {
 		return common.WrapWithNFError(nil, "Problem with KNI releasing\n", common.FailToReleaseKNI)
 	}
 	return nil
 }
 
+// AttachVdev creates virtual device with given name and arguments after
+// EAL initialization, for example "net_vhost0" with "iface=/tmp/sock".
+// Returns port of created device. In simulation mode new virtual port
+// is created.
+func AttachVdev(name, args string) (uint16, error) {
+	if simulation {
+		return simAttachVdev(), nil
+	}
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	cargs := C.CString(args)
+	defer C.free(unsafe.Pointer(cargs))
+	var port C.uint16_t
+	if ret := C.attach_vdev(cname, cargs, &port); ret < 0 {
+		return 0, common.WrapWithNFError(nil, "Cannot attach virtual device "+name+": "+C.GoString(C.rte_strerror(-ret)), common.CreatePortErr)
+	}
+	return uint16(port), nil
+}
+
 // GetPortsNumber gets total number of available Ethernet devices.
 func GetPortsNumber() int {
+	if simulation {
+		return len(simPorts)
+	}
 	return int(C.rte_eth_dev_count())
 }
 
 func CheckPortRSS(port uint16) int32 {
+	if simulation {
+		// Virtual ports have one receive queue
+		return 1
+	}
 	return int32(C.check_port_rss(C.uint16_t(port)))
 }
 
+// PortConfig contains parameters of port which are applied by
+// CreatePort. Zero values of MTU, descriptors numbers and MAC mean
+// defaults of driver.
+type PortConfig struct {
+	Promiscuous    bool
+	AllMulticast   bool
+	MTU            uint16
+	RXDescriptors  uint16
+	TXDescriptors  uint16
+	MAC            [common.EtherAddrLen]uint8
+	MulticastAddrs [][common.EtherAddrLen]uint8
+}
+
 // CreatePort initializes a new port using global settings and parameters.
-func CreatePort(port uint16, willReceive bool, sendQueuesNumber uint16, promiscuous bool, hwtxchecksum bool, inIndex int32) error {
-	var mempools **C.struct_rte_mempool
-	if willReceive {
-		m := CreateMempools("receive", inIndex)
-		mempools = (**C.struct_rte_mempool)(unsafe.Pointer(&(m[0])))
+func CreatePort(port uint16, willReceive bool, sendQueuesNumber uint16, hwtxchecksum bool, inIndex int32, config *PortConfig) error {
+	if simulation {
+		if err := simCreatePort(port, willReceive, inIndex, config.MTU); err != nil {
+			return err
+		}
 	} else {
-		mempools = nil
+		switch C.check_port_mtu(C.uint16_t(port), C.uint16_t(config.MTU)) {
+		case -1:
+			return common.WrapWithNFError(nil, "Port "+strconv.Itoa(int(port))+" doesn't support jumbo frames", common.FailToInitPort)
+		case -2:
+			return common.WrapWithNFError(nil, "MTU of port "+strconv.Itoa(int(port))+" exceeds its maximum receive packet length", common.FailToInitPort)
+		}
+		var mempools **C.struct_rte_mempool
+		if willReceive {
+			// Receive mempools are placed on NUMA node of port
+			m := CreateMempools("receive", inIndex, GetPortSocket(port))
+			mempools = (**C.struct_rte_mempool)(unsafe.Pointer(&(m[0])))
+		} else {
+			mempools = nil
+		}
+		if C.port_init(C.uint16_t(port), C.bool(willReceive), C.uint16_t(sendQueuesNumber),
+			mempools, C._Bool(config.Promiscuous), C._Bool(hwtxchecksum), C.int32_t(inIndex),
+			C.uint16_t(config.MTU), C._Bool(config.AllMulticast),
+			C.uint16_t(config.RXDescriptors), C.uint16_t(config.TXDescriptors)) != 0 {
+			msg := common.LogError(common.Initialization, "Cannot init port ", port, "!")
+			return common.WrapWithNFError(nil, msg, common.FailToInitPort)
+		}
+	}
+	if config.MAC != [common.EtherAddrLen]uint8{} {
+		if err := SetPortMACAddress(port, config.MAC); err != nil {
+			return err
+		}
+	}
+	if len(config.MulticastAddrs) != 0 {
+		return SetPortMulticastAddrs(port, config.MulticastAddrs)
+	}
+	return nil
+}
+
+// SetPortMACAddress replaces default MAC address of port.
+func SetPortMACAddress(port uint16, mac [common.EtherAddrLen]uint8) error {
+	if simulation {
+		p, err := getSimPort(port)
+		if err != nil {
+			return err
+		}
+		p.mac = mac
+		return nil
+	}
+	var cmac C.struct_ether_addr
+	for i := range mac {
+		cmac.addr_bytes[i] = C.uint8_t(mac[i])
+	}
+	if C.rte_eth_dev_default_mac_addr_set(C.uint16_t(port), &cmac) != 0 {
+		msg := common.LogError(common.Initialization, "Cannot set MAC address of port ", port, "!")
+		return common.WrapWithNFError(nil, msg, common.FailToInitPort)
+	}
+	return nil
+}
+
+// SetPortMulticastAddrs sets list of multicast addresses which port
+// receives. Previous list is replaced, empty list removes all addresses.
+func SetPortMulticastAddrs(port uint16, addrs [][common.EtherAddrLen]uint8) error {
+	if simulation {
+		// Virtual ports receive all injected packets
+		_, err := getSimPort(port)
+		return err
+	}
+	var cAddrsPtr *C.struct_ether_addr
+	cAddrs := make([]C.struct_ether_addr, len(addrs))
+	for i := range addrs {
+		for j := range addrs[i] {
+			cAddrs[i].addr_bytes[j] = C.uint8_t(addrs[i][j])
+		}
+	}
+	if len(cAddrs) != 0 {
+		cAddrsPtr = &cAddrs[0]
 	}
-	if C.port_init(C.uint16_t(port), C.bool(willReceive), C.uint16_t(sendQueuesNumber),
-		mempools, C._Bool(promiscuous), C._Bool(hwtxchecksum), C.int32_t(inIndex)) != 0 {
-		msg := common.LogError(common.Initialization, "Cannot init port ", port, "!")
+	if C.rte_eth_dev_set_mc_addr_list(C.uint16_t(port), cAddrsPtr, C.uint32_t(len(cAddrs))) != 0 {
+		msg := common.LogError(common.Initialization, "Cannot set multicast addresses of port ", port, "!")
 		return common.WrapWithNFError(nil, msg, common.FailToInitPort)
 	}
 	return nil
 }
 
+// GetPortSocket returns NUMA node of port or -1 if it is unknown.
+func GetPortSocket(port uint16) int {
+	if simulation {
+		return simGetPortSocket(port)
+	}
+	return int(C.rte_eth_dev_socket_id(C.uint16_t(port)))
+}
+
 // CreateMempool creates and returns a new memory pool.
 func CreateMempool(name string) *Mempool {
+	return CreateMempoolOnSocket(name, -1)
+}
+
+// CreateMempoolOnSocket creates and returns a new memory pool in memory
+// of given NUMA node. Negative socket means node of current core.
+func CreateMempoolOnSocket(name string, socket int) *Mempool {
 	nameC := 1
 	tName := name
 	for i := range usedMempools {
@@ -558,15 +790,32 @@ func CreateMempool(name string) *Mempool {
 			nameC++
 		}
 	}
-	mempool := C.createMempool(C.uint32_t(mbufNumberT), C.uint32_t(mbufCacheSizeT))
+	var mempool *C.struct_rte_mempool
+	if simulation {
+		mempool = (*C.struct_rte_mempool)(simCreateMempool())
+		// Simulated mempools aren't bound to NUMA nodes, node is only recorded
+		if socket < 0 {
+			socket = C.SOCKET_ID_ANY
+		}
+		mempool.socket_id = C.int(socket)
+	} else {
+		mempool = C.createMempool(C.uint32_t(mbufNumberT), C.uint32_t(mbufCacheSizeT), C.int(socket))
+	}
 	usedMempools = append(usedMempools, mempoolPair{mempool, tName})
 	return (*Mempool)(mempool)
 }
 
-func CreateMempools(name string, inIndex int32) []*Mempool {
+// GetMempoolSocket returns NUMA node of memory pool or -1 if it is
+// unknown.
+func GetMempoolSocket(mempool *Mempool) int {
+	return int((*C.struct_rte_mempool)(mempool).socket_id)
+}
+
+// CreateMempools creates inIndex memory pools on given NUMA node.
+func CreateMempools(name string, inIndex int32, socket int) []*Mempool {
 	m := make([]*Mempool, inIndex, inIndex)
 	for i := int32(0); i < inIndex; i++ {
-		m[i] = CreateMempool(name)
+		m[i] = CreateMempoolOnSocket(name, socket)
 	}
 	return m
 }
@@ -576,6 +825,10 @@ func SetAffinity(coreID int) error {
 	// go tool trace shows that each proc executes different goroutine. However it is expected behavior
 	// (golang issue #20853) and each goroutine is locked to one OS thread.
 	runtime.LockOSThread()
+	if simulation {
+		// Cores are virtual in simulation mode
+		return nil
+	}
 
 	var cpuset C.cpu_set_t
 	C.initCPUSet(C.int(coreID), &cpuset)
@@ -588,6 +841,9 @@ func SetAffinity(coreID int) error {
 
 // AllocateMbufs allocates n mbufs.
 func AllocateMbufs(mb []uintptr, mempool *Mempool, n uint) error {
+	if simulation {
+		return simAllocateMbufs(mb, mempool, n)
+	}
 	if err := C.allocateMbufs((*C.struct_rte_mempool)(mempool), (**C.struct_rte_mbuf)(unsafe.Pointer(&mb[0])), C.unsigned(n)); err != 0 {
 		msg := common.LogError(common.Debug, "AllocateMbufs cannot allocate mbuf, dpdk returned: ", err)
 		return common.WrapWithNFError(nil, msg, common.AllocMbufErr)
@@ -597,6 +853,9 @@ func AllocateMbufs(mb []uintptr, mempool *Mempool, n uint) error {
 
 // AllocateMbuf allocates one mbuf.
 func AllocateMbuf(mb *uintptr, mempool *Mempool) error {
+	if simulation {
+		return simAllocateMbufs((*[1]uintptr)(unsafe.Pointer(mb))[:], mempool, 1)
+	}
 	if err := C.allocateMbufs((*C.struct_rte_mempool)(mempool), (**C.struct_rte_mbuf)(unsafe.Pointer(mb)), 1); err != 0 {
 		msg := common.LogError(common.Debug, "AllocateMbuf cannot allocate mbuf, dpdk returned: ", err)
 		return common.WrapWithNFError(nil, msg, common.AllocMbufErr)
@@ -629,16 +888,191 @@ func GetDataLenMbuf(mb *Mbuf) uint {
 	return uint(mb.data_len)
 }
 
+// GetNextMbuf returns next segment of mbuf chain or nil if mbuf is the
+// last segment.
+func GetNextMbuf(mb *Mbuf) *Mbuf {
+	return (*Mbuf)(unsafe.Pointer(mb.next))
+}
+
+// GetMbufTailroom returns number of bytes which can be appended to mbuf.
+func GetMbufTailroom(mb *Mbuf) uint {
+	return uint(mb.buf_len - mb.data_off - mb.data_len)
+}
+
+// AppendMbufSegment allocates new segment from mempool of head mbuf,
+// copies to it as much of data as fits and adds it to the end of chain.
+// Returns new segment or nil if mempool is empty.
+func AppendMbufSegment(head *Mbuf, data []byte) *Mbuf {
+	var p uintptr
+	if err := AllocateMbuf(&p, (*Mempool)(unsafe.Pointer(head.pool))); err != nil {
+		return nil
+	}
+	seg := (*Mbuf)(unsafe.Pointer(p))
+	length := GetMbufTailroom(seg)
+	if uint(len(data)) < length {
+		length = uint(len(data))
+	}
+	WriteDataToMbuf(seg, data[:length])
+	seg.data_len = C.uint16_t(length)
+	seg.pkt_len = C.uint32_t(length)
+	last := head
+	for GetNextMbuf(last) != nil {
+		last = GetNextMbuf(last)
+	}
+	last.next = (*C.struct_rte_mbuf)(unsafe.Pointer(seg))
+	head.nb_segs++
+	head.pkt_len += C.uint32_t(length)
+	return seg
+}
+
+// LinearizeMbuf moves data of all segments of mbuf chain to the first
+// segment and frees other segments. Returns false if data doesn't fit
+// into the first segment.
+func LinearizeMbuf(mb *Mbuf) bool {
+	tail := GetNextMbuf(mb)
+	if tail == nil {
+		return true
+	}
+	if uint(mb.pkt_len) > uint(mb.buf_len-mb.data_off) {
+		return false
+	}
+	dst := GetRawPacketBytesMbuf(mb)[:mb.pkt_len]
+	offset := uint(mb.data_len)
+	for seg := tail; seg != nil; seg = GetNextMbuf(seg) {
+		offset += uint(copy(dst[offset:], GetRawPacketBytesMbuf(seg)))
+	}
+	mb.next = nil
+	mb.nb_segs = 1
+	mb.data_len = C.uint16_t(mb.pkt_len)
+	DirectStop(1, []uintptr{uintptr(unsafe.Pointer(tail))})
+	return true
+}
+
 // Statistics print statistics about current
 // speed of stop ring, recv/send speed and drops.
 func Statistics(N float32) {
 	C.statistics(C.float(N))
 }
 
+// GetPortStats returns basic statistics of port.
+func GetPortStats(port uint16) (common.PortStats, error) {
+	if simulation {
+		return simGetPortStats(port)
+	}
+	var s C.struct_rte_eth_stats
+	if C.rte_eth_stats_get(C.uint16_t(port), &s) != 0 {
+		return common.PortStats{}, common.WrapWithNFError(nil, "Can't get statistics of port", common.FailToGetPortStats)
+	}
+	return common.PortStats{
+		RxPackets: uint64(s.ipackets),
+		TxPackets: uint64(s.opackets),
+		RxBytes:   uint64(s.ibytes),
+		TxBytes:   uint64(s.obytes),
+		RxMissed:  uint64(s.imissed),
+		RxErrors:  uint64(s.ierrors),
+		TxErrors:  uint64(s.oerrors),
+		RxNoMbuf:  uint64(s.rx_nombuf),
+	}, nil
+}
+
+// GetPortXstats returns extended statistics of port by their names.
+// Set of names depends on driver.
+func GetPortXstats(port uint16) (map[string]uint64, error) {
+	if simulation {
+		return simGetPortXstats(port)
+	}
+	n := C.rte_eth_xstats_get_names(C.uint16_t(port), nil, 0)
+	if n < 0 {
+		return nil, common.WrapWithNFError(nil, "Can't get extended statistics names of port", common.FailToGetPortStats)
+	}
+	if n == 0 {
+		return map[string]uint64{}, nil
+	}
+	names := make([]C.struct_rte_eth_xstat_name, n)
+	if C.rte_eth_xstats_get_names(C.uint16_t(port), &names[0], C.uint(n)) != n {
+		return nil, common.WrapWithNFError(nil, "Can't get extended statistics names of port", common.FailToGetPortStats)
+	}
+	xstats := make([]C.struct_rte_eth_xstat, n)
+	if C.rte_eth_xstats_get(C.uint16_t(port), &xstats[0], C.uint(n)) != n {
+		return nil, common.WrapWithNFError(nil, "Can't get extended statistics of port", common.FailToGetPortStats)
+	}
+	result := make(map[string]uint64, n)
+	for i := range xstats {
+		result[C.GoString(&names[xstats[i].id].name[0])] = uint64(xstats[i].value)
+	}
+	return result, nil
+}
+
+// GetPortLinkStatus returns current link state of port without
+// waiting for link negotiation.
+func GetPortLinkStatus(port uint16) (common.LinkStatus, error) {
+	if simulation {
+		return simGetPortLinkStatus(port)
+	}
+	var speed C.uint32_t
+	var duplex, autoneg, up C.uint8_t
+	if C.port_link_status(C.uint16_t(port), &speed, &duplex, &autoneg, &up) != 0 {
+		return common.LinkStatus{}, common.WrapWithNFError(nil, "Can't get link status of port", common.FailToGetPortStats)
+	}
+	return common.LinkStatus{
+		Up:         up != 0,
+		Speed:      uint32(speed),
+		FullDuplex: duplex != 0,
+		Autoneg:    autoneg != 0,
+	}, nil
+}
+
+// GetPortMTU returns current MTU of port.
+func GetPortMTU(port uint16) (uint16, error) {
+	if simulation {
+		return simGetPortMTU(port)
+	}
+	var mtu C.uint16_t
+	if C.rte_eth_dev_get_mtu(C.uint16_t(port), &mtu) != 0 {
+		return 0, common.WrapWithNFError(nil, "Can't get MTU of port", common.FailToGetPortStats)
+	}
+	return uint16(mtu), nil
+}
+
+// SetPortMTU changes MTU of port. Some drivers can change MTU only
+// before port is started.
+func SetPortMTU(port uint16, mtu uint16) error {
+	if simulation {
+		return simSetPortMTU(port, mtu)
+	}
+	if ret := C.rte_eth_dev_set_mtu(C.uint16_t(port), C.uint16_t(mtu)); ret != 0 {
+		return common.WrapWithNFError(nil, "Can't set MTU of port: "+C.GoString(C.rte_strerror(-ret)), common.FailToInitPort)
+	}
+	return nil
+}
+
+// SetPortLinkUp sets link of port administratively up or down if
+// driver supports it.
+func SetPortLinkUp(port uint16, up bool) error {
+	if simulation {
+		return simSetPortLinkUp(port, up)
+	}
+	var ret C.int
+	if up {
+		ret = C.rte_eth_dev_set_link_up(C.uint16_t(port))
+	} else {
+		ret = C.rte_eth_dev_set_link_down(C.uint16_t(port))
+	}
+	if ret != 0 {
+		return common.WrapWithNFError(nil, "Can't change link of port: "+C.GoString(C.rte_strerror(-ret)), common.FailToInitPort)
+	}
+	return nil
+}
+
 // ReportMempoolsState prints used and free space of mempools.
 func ReportMempoolsState() {
 	for _, m := range usedMempools {
-		use := C.getMempoolSpace(m.mempool)
+		var use C.int
+		if simulation {
+			use = C.int(getSimMempool((*Mempool)(m.mempool)).inUse())
+		} else {
+			use = C.getMempoolSpace(m.mempool)
+		}
 		common.LogDebug(common.Verbose, "Mempool usage", m.name, use, "from", mbufNumberT)
 		if float32(mbufNumberT-uint(use))/float32(mbufNumberT)*100 < 10 {
 			common.LogDrop(common.Debug, m.name, "mempool has less than 10% free space. This can lead to dropping packets while receive.")
@@ -646,8 +1080,13 @@ func ReportMempoolsState() {
 	}
 }
 
-// CreateKni creates a KNI device
-func CreateKni(portId uint16, core uint, name string) error {
+// CreateKni creates a KNI device. Requests of kernel to change KNI
+// interface are passed to handlers of ops.
+func CreateKni(portId uint16, core uint, name string, ops *KniOps) error {
+	if simulation {
+		return common.WrapWithNFError(nil, "KNI isn't supported in simulation mode", common.FailToCreateKNI)
+	}
+	setKniOps(portId, ops)
 	mempool := (*C.struct_rte_mempool)(CreateMempool("KNI"))
 	if C.create_kni(C.uint16_t(portId), C.uint32_t(core), C.CString(name), mempool) != 0 {
 		return common.WrapWithNFError(nil, "Error with KNI allocation\n", common.FailToCreateKNI)
@@ -691,5 +1130,8 @@ func IntArrayToBool(value *[32]uint8) *[32]bool {
 }
 
 func CheckHWTXChecksumCapability(port uint16) bool {
+	if simulation {
+		return false
+	}
 	return bool(C.check_hwtxchecksum_capability(C.uint16_t(port)))
 }
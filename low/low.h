@@ -18,6 +18,8 @@
 #include <rte_bus_pci.h>
 #include <rte_kni.h>
 #include <rte_lpm.h>
+#include <rte_dev.h>
+#include <rte_errno.h>
 
 #define process 1
 #define stopRequest 2
@@ -39,9 +41,11 @@
 // 24 offset is L2 offset and is always begining of packet
 // 32 offset is CMbuf offset and is initilized when mempool is created
 // 40 offset is Next field. Should be 0. Will be filled later if required
+// 48 offset is drop reason. Should be 0
 #define mbufInit(buf) \
 *(char **)((char *)(buf) + mbufStructSize + 24) = (char *)(buf) + defaultStart; \
-*(char **)((char *)(buf) + mbufStructSize + 40) = 0;
+*(char **)((char *)(buf) + mbufStructSize + 40) = 0; \
+*((char *)(buf) + mbufStructSize + 48) = 0;
 
 #ifdef REASSEMBLY
 #define REASSEMBLY_INIT \
@@ -86,6 +90,10 @@ static int KNI_change_mtu(uint16_t port_id, unsigned int new_mtu);
 static int KNI_config_network_interface(uint16_t port_id, uint8_t if_up);
 static int KNI_config_mac_address(uint16_t port_id, uint8_t mac_addr[]);
 static int KNI_config_promiscusity(uint16_t port_id, uint8_t to_on);
+// Handlers of KNI requests are implemented in Go in kni.go
+extern int kniChangeMTU(uint16_t port, unsigned int mtu);
+extern int kniConfigLink(uint16_t port, uint8_t up);
+extern int kniConfigMAC(uint16_t port, uint8_t *addr);
 
 uint32_t BURST_SIZE;
 
@@ -158,6 +166,15 @@ int checkRSSPacketCount(struct cPort *port, int16_t queue) {
 	return rte_eth_rx_queue_count(port->PortId, queue);
 }
 
+// Virtual device is added to vdev bus and gets new port
+int attach_vdev(const char *name, const char *args, uint16_t *port) {
+	int ret = rte_eal_hotplug_add("vdev", name, args);
+	if (ret < 0) {
+		return ret;
+	}
+	return rte_eth_dev_get_port_by_name(name, port);
+}
+
 int check_port_rss(uint16_t port) {
 	struct rte_eth_dev_info dev_info;
 	memset(&dev_info, 0, sizeof(dev_info));
@@ -165,10 +182,45 @@ int check_port_rss(uint16_t port) {
 	return dev_info.max_rx_queues;
 }
 
+// Checks that port can receive frames of given MTU. Returns 0 if it can,
+// -1 if port doesn't support jumbo frames and -2 if frames are longer
+// than maximum receive packet length of port.
+int check_port_mtu(uint16_t port, uint16_t mtu) {
+	struct rte_eth_dev_info dev_info;
+
+	if (mtu <= ETHER_MTU)
+		return 0;
+	memset(&dev_info, 0, sizeof(dev_info));
+	rte_eth_dev_info_get(port, &dev_info);
+	if ((dev_info.rx_offload_capa & DEV_RX_OFFLOAD_JUMBO_FRAME) == 0)
+		return -1;
+	if (mtu + ETHER_HDR_LEN + ETHER_CRC_LEN > dev_info.max_rx_pktlen)
+		return -2;
+	return 0;
+}
+
+// Link status fields are bit fields, so they can't be accessed from Go.
+int port_link_status(uint16_t port, uint32_t *speed, uint8_t *duplex, uint8_t *autoneg, uint8_t *up) {
+	struct rte_eth_link link;
+
+	if (!rte_eth_dev_is_valid_port(port))
+		return -1;
+	memset(&link, 0, sizeof(link));
+	rte_eth_link_get_nowait(port, &link);
+	*speed = link.link_speed;
+	*duplex = link.link_duplex;
+	*autoneg = link.link_autoneg;
+	*up = link.link_status;
+	return 0;
+}
+
 // Initializes a given port using global settings and with the RX buffers
 // coming from the mbuf_pool passed as a parameter.
-int port_init(uint16_t port, bool willReceive, uint16_t sendQueuesNumber, struct rte_mempool **mbuf_pools, bool promiscuous, bool hwtxchecksum, int32_t inIndex) {
+int port_init(uint16_t port, bool willReceive, uint16_t sendQueuesNumber, struct rte_mempool **mbuf_pools, bool promiscuous, bool hwtxchecksum, int32_t inIndex,
+		uint16_t mtu, bool allmulticast, uint16_t rx_desc, uint16_t tx_desc) {
 	uint16_t rx_rings, tx_rings = sendQueuesNumber;
+	uint16_t rx_ring_size = rx_desc != 0 ? rx_desc : RX_RING_SIZE;
+	uint16_t tx_ring_size = tx_desc != 0 ? tx_desc : TX_RING_SIZE;
 
 	struct rte_eth_dev_info dev_info;
 	memset(&dev_info, 0, sizeof(dev_info));
@@ -201,15 +253,35 @@ int port_init(uint16_t port, bool willReceive, uint16_t sendQueuesNumber, struct
         /* Enable everything that is supported by hardware */
         port_conf_default.txmode.offloads = dev_info.tx_offload_capa;
 	}
+	/* Let NIC report checksum state of received packets if it can */
+	port_conf_default.rxmode.offloads = dev_info.rx_offload_capa & DEV_RX_OFFLOAD_CHECKSUM;
+
+	if (mtu > ETHER_MTU) {
+		port_conf_default.rxmode.offloads |= DEV_RX_OFFLOAD_JUMBO_FRAME;
+		port_conf_default.rxmode.max_rx_pkt_len = mtu + ETHER_HDR_LEN + ETHER_CRC_LEN;
+		/* Jumbo frames don't fit into one mbuf, so they are chained */
+		port_conf_default.rxmode.offloads |= dev_info.rx_offload_capa & DEV_RX_OFFLOAD_SCATTER;
+		port_conf_default.txmode.offloads |= dev_info.tx_offload_capa & DEV_TX_OFFLOAD_MULTI_SEGS;
+	}
 
 	/* Configure the Ethernet device. */
 	retval = rte_eth_dev_configure(port, rx_rings, tx_rings, &port_conf_default);
 	if (retval != 0)
 		return retval;
 
+	if (mtu != 0) {
+		retval = rte_eth_dev_set_mtu(port, mtu);
+		if (retval != 0)
+			return retval;
+	}
+
+	retval = rte_eth_dev_adjust_nb_rx_tx_desc(port, &rx_ring_size, &tx_ring_size);
+	if (retval != 0)
+		return retval;
+
 	/* Allocate and set up RX queues per Ethernet port. */
 	for (q = 0; q < rx_rings; q++) {
-		retval = rte_eth_rx_queue_setup(port, q, RX_RING_SIZE,
+		retval = rte_eth_rx_queue_setup(port, q, rx_ring_size,
 				rte_eth_dev_socket_id(port), NULL, mbuf_pools[q]);
 		if (retval < 0)
 			return retval;
@@ -217,7 +289,7 @@ int port_init(uint16_t port, bool willReceive, uint16_t sendQueuesNumber, struct
 
 	/* Allocate and set up TX queues per Ethernet port. */
 	for (q = 0; q < tx_rings; q++) {
-		retval = rte_eth_tx_queue_setup(port, q, TX_RING_SIZE,
+		retval = rte_eth_tx_queue_setup(port, q, tx_ring_size,
 				rte_eth_dev_socket_id(port), &dev_info.default_txconf);
 		if (retval < 0)
 			return retval;
@@ -231,6 +303,14 @@ int port_init(uint16_t port, bool willReceive, uint16_t sendQueuesNumber, struct
 	if (promiscuous == true) {
 		/* Enable RX in promiscuous mode for the Ethernet device. */
 		rte_eth_promiscuous_enable(port);
+	} else {
+		rte_eth_promiscuous_disable(port);
+	}
+
+	if (allmulticast == true) {
+		rte_eth_allmulticast_enable(port);
+	} else {
+		rte_eth_allmulticast_disable(port);
 	}
 
 	// Not to use .rx_deferred_start = 0 in custom configuration
@@ -277,6 +357,16 @@ static inline uint16_t handleReceived(struct rte_mbuf *bufs[BURST_SIZE], uint16_
 	        }
 	        bufs[temp_number] = bufs[i];
 	        temp_number++;
+#else
+	        // Scattered packets, for example jumbo frames
+	        if (unlikely(bufs[i]->next != NULL)) {
+	                struct rte_mbuf *temp = bufs[i];
+	                while (temp->next != NULL) {
+	                        mbufInit(temp->next);
+	                        mbufSetNext(temp);
+	                        temp = temp->next;
+	                }
+	        }
 #endif
 	}
 #ifdef REASSEMBLY
@@ -513,12 +603,16 @@ int eal_init(int argc, char *argv[], uint32_t burstSize, int32_t needKNI)
 
 int allocateMbufs(struct rte_mempool *mempool, struct rte_mbuf **bufs, unsigned count);
 
-struct rte_mempool * createMempool(uint32_t num_mbufs, uint32_t mbuf_cache_size) {
+struct rte_mempool * createMempool(uint32_t num_mbufs, uint32_t mbuf_cache_size, int socket_id) {
 	struct rte_mempool *mbuf_pool;
 
+	// Mempool is created on NUMA node of current core if node isn't given
+	if (socket_id < 0) {
+		socket_id = rte_socket_id();
+	}
 	/* Creates a new mempool in memory to hold the mbufs. */
 	mbuf_pool = rte_pktmbuf_pool_create(mempoolName, num_mbufs,
-		mbuf_cache_size, 0, RTE_MBUF_DEFAULT_BUF_SIZE, rte_socket_id());
+		mbuf_cache_size, 0, RTE_MBUF_DEFAULT_BUF_SIZE, socket_id);
 
 	mempoolName[7]++;
 
@@ -561,6 +655,8 @@ struct nff_go_ring {
 	// We need this second ring pointer because CGO can't calculate address for ring pointer variable. It is CGO limitation
 	void *internal_DPDK_ring;
 	uint32_t offset;
+	// NUMA node which ring was created for
+	int socket_id;
 };
 
 struct rte_ring** extractDPDKRings(struct nff_go_ring** r, int32_t inIndexNumber) {
@@ -576,6 +672,7 @@ nff_go_ring_create(const char *name, unsigned count, int socket_id, unsigned fla
 	struct nff_go_ring* r = malloc(sizeof(struct nff_go_ring));
 
 	r->DPDK_ring = rte_ring_create(name, count, socket_id, flags);
+	r->socket_id = socket_id;
 	// Ring elements are located immidiately behind rte_ring structure
 	// So ring[1] is pointed to the beginning of this data
 	r->internal_DPDK_ring = &(r->DPDK_ring)[1];
@@ -583,12 +680,79 @@ nff_go_ring_create(const char *name, unsigned count, int socket_id, unsigned fla
 	return r;
 }
 
+// Simulation mode doesn't initialize EAL, so rings and mbufs are placed
+// in usual process memory. Mbufs are never freed to DPDK mempools,
+// they are returned to pools by Go code of simulation.
+void sim_init(uint32_t burstSize) {
+	BURST_SIZE = burstSize;
+	mbufStructSize = sizeof(struct rte_mbuf);
+	headroomSize = RTE_PKTMBUF_HEADROOM;
+	defaultStart = mbufStructSize + headroomSize;
+}
+
+void *sim_ring_create(const char *name, unsigned count) {
+	ssize_t size = rte_ring_get_memsize(count);
+	if (size < 0)
+		return NULL;
+	struct nff_go_ring* r = malloc(sizeof(struct nff_go_ring));
+	if (posix_memalign((void **)&r->DPDK_ring, RTE_CACHE_LINE_SIZE, size) != 0) {
+		free(r);
+		return NULL;
+	}
+	// Flag 0x0000 means ring default mode which is Multiple Consumer / Multiple Producer
+	if (rte_ring_init(r->DPDK_ring, name, count, 0x0000) != 0) {
+		free(r->DPDK_ring);
+		free(r);
+		return NULL;
+	}
+	r->internal_DPDK_ring = &(r->DPDK_ring)[1];
+	r->offset = sizeof(void*);
+	return r;
+}
+
+// Allocates memory for mbufs and initializes them in the same way as
+// rte_pktmbuf_pool_create and createMempool do. Mempool itself is only
+// a tag which mbufs point to.
+void *sim_mempool_create(uint32_t num_mbufs, char **mem, uint32_t *elt_size) {
+	*elt_size = RTE_ALIGN_CEIL(mbufStructSize + RTE_MBUF_DEFAULT_BUF_SIZE, RTE_CACHE_LINE_SIZE);
+	if (posix_memalign((void **)mem, RTE_CACHE_LINE_SIZE, (size_t)*elt_size * num_mbufs) != 0)
+		return NULL;
+	struct rte_mempool *mp = calloc(1, sizeof(struct rte_mempool));
+	for (uint32_t i = 0; i < num_mbufs; i++) {
+		struct rte_mbuf *m = (struct rte_mbuf *)(*mem + (size_t)i * *elt_size);
+		memset(m, 0, mbufStructSize);
+		m->priv_size = 0;
+		m->buf_addr = (char *)m + mbufStructSize;
+		m->buf_len = RTE_MBUF_DEFAULT_BUF_SIZE;
+		m->pool = mp;
+		m->nb_segs = 1;
+		m->port = MBUF_INVALID_PORT;
+		rte_mbuf_refcnt_set(m, 1);
+		*(char**)((char*)m + mbufStructSize + 32) = (char*)m;
+	}
+	return mp;
+}
+
+void sim_mempool_free(void *mp, char *mem) {
+	free(mem);
+	free(mp);
+}
+
+void sim_mbufs_reset(struct rte_mbuf **bufs, unsigned count) {
+	for (unsigned i = 0; i < count; i++) {
+		rte_pktmbuf_reset(bufs[i]);
+		mbufInit(bufs[i]);
+	}
+}
+
 void *
 lpm_create(const char *name, int socket_id, uint32_t maxRules, uint32_t numberTbl8, uint32_t (**tbl24)[1], uint32_t (**tbl8)[1]) {
 	struct rte_lpm_config config;
 	config.max_rules = maxRules;
 	config.number_tbl8s = numberTbl8;
 	struct rte_lpm *lpm = rte_lpm_create(name, socket_id, &config);
+	if (lpm == NULL)
+		return NULL;
 	*tbl24 = (uint32_t(*)[1])lpm->tbl24;
 	*tbl8 = (uint32_t(*)[1])lpm->tbl8;
 	return (void*)lpm;
@@ -614,19 +778,17 @@ void lpm_free(void *lpm) {
 //     4. You should understand that calling rte_eth_dev_stop in the same time of rte_eth_rx_burst will result to errors
 //	 4a. One of these errors can be overloading of receive mempool
 //     5. You should understand that calling rte_eth_dev_start should include receive ring handling as in port_init
+//     6. Requests are applied to port by handlers from KniOps in kni.go
 static int KNI_change_mtu(uint16_t port_id, unsigned int new_mtu) {
-	fprintf(stderr, "DEBUG: KNI: Change MTU of port %d to %u\n", port_id, new_mtu);
-	return 0;
+	return kniChangeMTU(port_id, new_mtu);
 }
 
 static int KNI_config_network_interface(uint16_t port_id, uint8_t if_up) {
-	fprintf(stderr, "DEBUG: KNI: Configure network interface of port %d %s\n", port_id, if_up ? "up" : "down");
-	return 0;
+	return kniConfigLink(port_id, if_up);
 }
 
 static int KNI_config_mac_address(uint16_t port_id, uint8_t mac_addr[]) {
-	fprintf(stderr, "DEBUG: KNI: Configure new MAC address of port %d\n", port_id);
-	return 0;
+	return kniConfigMAC(port_id, mac_addr);
 }
 
 static int KNI_config_promiscusity(uint16_t port_id, uint8_t to_on) {
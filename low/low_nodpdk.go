@@ -0,0 +1,814 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build nodpdk
+// +build nodpdk
+
+package low
+
+// DPDK-free build of library
+// If library is built with "nodpdk" tag it doesn't need DPDK headers,
+// libraries and cgo. Only simulation mode is supported: mbufs,
+// mempools and rings are implemented by Go code with the same layout
+// of data which is used by packet package. Mbufs are placed in memory
+// which is mapped outside of Go heap, like hugepages of DPDK.
+// InitDPDK initializes simulation without ports, so code which needs
+// only mbufs can be run. KNI and LPM aren't supported.
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Sizes of mbuf data area, the same as RTE_PKTMBUF_HEADROOM and
+// RTE_MBUF_DEFAULT_BUF_SIZE of DPDK build.
+const (
+	mbufHeadroom = 128
+	mbufBufSize  = simMaxPacketLen + mbufHeadroom
+)
+
+// Ring is a ring buffer for pointers
+type Ring struct {
+	sync.Mutex
+	buf   []uintptr
+	head  uint
+	count uint
+	// NUMA node which ring was created for
+	socket int
+}
+
+type Rings []*Ring
+
+// Mbuf is a message buffer. Only fields which are used by library
+// are present.
+type Mbuf struct {
+	buf_addr uintptr
+	data_off uint16
+	data_len uint16
+	pkt_len  uint32
+	buf_len  uint16
+	nb_segs  uint16
+	ol_flags uint64
+	// Mbufs are placed in memory which isn't scanned by garbage
+	// collector, so they keep addresses instead of Go pointers
+	pool uintptr
+	next uintptr
+}
+
+// packetHeader has the same layout as beginning of packet.Packet which
+// is placed after mbuf.
+type packetHeader struct {
+	l3         uintptr
+	l4         uintptr
+	data       uintptr
+	ether      uintptr
+	cmbuf      uintptr
+	next       uintptr
+	dropReason uint8
+}
+
+// Mempool is a pool of objects.
+type Mempool struct {
+	sync.Mutex
+	mem    []byte
+	number uint
+	free   []uintptr
+	// Memory of released pool is unmapped when all its mbufs are free
+	released bool
+	// NUMA node which pool was created for
+	socket int
+}
+
+// Mempools which memory is mapped. Mbufs refer to their pools by
+// address, so pools are kept here until memory is unmapped.
+var simMempools = make(map[*Mempool]struct{})
+var simMempoolsLock sync.Mutex
+
+type Port struct {
+	PortId       uint16
+	QueuesNumber uint8
+}
+
+var mbufNumberT uint
+var mbufCacheSizeT uint
+
+type mempoolPair struct {
+	mempool *Mempool
+	name    string
+}
+
+var usedMempools []mempoolPair
+
+var mbufStructSize = unsafe.Sizeof(Mbuf{})
+
+// Size of mbuf with its data area rounded up to cache line
+var mbufEltSize = (mbufStructSize + mbufBufSize + 63) &^ 63
+
+// DirectStop frees mbufs.
+func DirectStop(pktsForFreeNumber int, buf []uintptr) {
+	simFreeMbufs(buf[:pktsForFreeNumber])
+}
+
+// DirectSend sends one mbuf.
+func DirectSend(m *Mbuf, port uint16) bool {
+	buf := []uintptr{uintptr(unsafe.Pointer(m))}
+	if int(port) >= len(simPorts) {
+		simFreeMbufs(buf)
+		return false
+	}
+	simTransmit(port, buf)
+	return true
+}
+
+func GetPort(n uint16) *Port {
+	p := new(Port)
+	p.PortId = n
+	p.QueuesNumber = 1
+	return p
+}
+
+func CheckRSSPacketCount(p *Port, queue int16) int64 {
+	return simPendingPackets(p.PortId)
+}
+
+// GetPortMACAddress gets MAC address of given port.
+func GetPortMACAddress(port uint16) [common.EtherAddrLen]uint8 {
+	var mac [common.EtherAddrLen]uint8
+	if int(port) < len(simPorts) {
+		mac = simPorts[port].mac
+	}
+	return mac
+}
+
+// GetPacketDataStartPointer returns the pointer to the
+// beginning of packet.
+func GetPacketDataStartPointer(mb *Mbuf) uintptr {
+	return mb.buf_addr + uintptr(mb.data_off)
+}
+
+var packetStructSize int
+
+// SetPacketStructSize sets the size of the packet.
+func SetPacketStructSize(t int) error {
+	if t > mbufHeadroom {
+		msg := common.LogError(common.Initialization, "Packet structure can't be placed inside mbuf.",
+			"Increase mbufHeadroom in low/low_nodpdk.go.")
+		return common.WrapWithNFError(nil, msg, common.PktMbufHeadRoomTooSmall)
+	}
+	packetStructSize = t
+	return nil
+}
+
+// PrependMbuf prepends length bytes to mbuf data area.
+func PrependMbuf(mb *Mbuf, length uint) bool {
+	if uint16(length) > mb.data_off-uint16(packetStructSize) {
+		return false
+	}
+	mb.data_off -= uint16(length)
+	mb.data_len += uint16(length)
+	mb.pkt_len += uint32(length)
+	return true
+}
+
+// AppendMbuf appends length bytes to mbuf.
+func AppendMbuf(mb *Mbuf, length uint) bool {
+	if uint16(length) > mb.buf_len-mb.data_off-mb.data_len {
+		return false
+	}
+	mb.data_len += uint16(length)
+	mb.pkt_len += uint32(length)
+	return true
+}
+
+// AdjMbuf removes length bytes at mbuf beginning.
+func AdjMbuf(m *Mbuf, length uint) bool {
+	if uint16(length) > m.data_len {
+		return false
+	}
+	m.data_off += uint16(length)
+	m.data_len -= uint16(length)
+	m.pkt_len -= uint32(length)
+	return true
+}
+
+// TrimMbuf removes length bytes at the mbuf end.
+func TrimMbuf(m *Mbuf, length uint) bool {
+	if uint16(length) > m.data_len {
+		return false
+	}
+	m.data_len -= uint16(length)
+	m.pkt_len -= uint32(length)
+	return true
+}
+
+// Virtual ports don't offload checksums, so TX flags are only stored.
+
+// SetTXIPv4OLFlags sets mbuf flags for IPv4 header
+// checksum calculation hardware offloading.
+func SetTXIPv4OLFlags(mb *Mbuf, l2len, l3len uint32) {
+	mb.ol_flags = (1 << 54) | (1 << 55)
+}
+
+// SetTXIPv4UDPOLFlags sets mbuf flags for IPv4 and UDP
+// headers checksum calculation hardware offloading.
+func SetTXIPv4UDPOLFlags(mb *Mbuf, l2len, l3len uint32) {
+	mb.ol_flags = (3 << 52) | (1 << 54) | (1 << 55)
+}
+
+// SetTXIPv4TCPOLFlags sets mbuf flags for IPv4 and TCP
+// headers checksum calculation hardware offloading.
+func SetTXIPv4TCPOLFlags(mb *Mbuf, l2len, l3len uint32) {
+	mb.ol_flags = (1 << 52) | (1 << 54) | (1 << 55)
+}
+
+// SetTXIPv6UDPOLFlags sets mbuf flags for IPv6 UDP header
+// checksum calculation hardware offloading.
+func SetTXIPv6UDPOLFlags(mb *Mbuf, l2len, l3len uint32) {
+	mb.ol_flags = (3 << 52) | (1 << 56)
+}
+
+// SetTXIPv6TCPOLFlags sets mbuf flags for IPv6 TCP
+// header checksum calculation hardware offloading.
+func SetTXIPv6TCPOLFlags(mb *Mbuf, l2len, l3len uint32) {
+	mb.ol_flags = (1 << 52) | (1 << 56)
+}
+
+// Checksum states which NIC reports for received mbufs
+const (
+	RXChecksumUnknown = iota // NIC didn't check checksum
+	RXChecksumGood
+	RXChecksumBad
+)
+
+// GetRXIPChecksumState returns state of IPv4 header checksum which
+// NIC reported for received mbuf. Virtual ports don't check it.
+func GetRXIPChecksumState(mb *Mbuf) int {
+	return RXChecksumUnknown
+}
+
+// GetRXL4ChecksumState returns state of TCP or UDP checksum which NIC
+// reported for received mbuf. Virtual ports don't check it.
+func GetRXL4ChecksumState(mb *Mbuf) int {
+	return RXChecksumUnknown
+}
+
+// These constants are used by packet package to parse protocol headers,
+// values are taken from rte_mbuf_ptype.h
+const (
+	RtePtypeL2Ether = 0x00000001
+	RtePtypeL3Ipv4  = 0x00000010
+	RtePtypeL3Ipv6  = 0x00000040
+	RtePtypeL4Tcp   = 0x00000100
+	RtePtypeL4Udp   = 0x00000200
+)
+
+// These constants are used by packet package for longest prefix match
+// lookup, values are taken from rte_lpm.h
+const (
+	RteLpmValidExtEntryBitmask = 0x03000000
+	RteLpmTbl8GroupNumEntries  = 256
+	RteLpmLookupSuccess        = 0x01000000
+)
+
+// CreateRing creates ring with given name and count.
+func CreateRing(count uint) *Ring {
+	return CreateRingOnSocket(count, -1)
+}
+
+// CreateRingOnSocket creates ring with given count. Socket is only
+// recorded, memory isn't bound to NUMA nodes.
+func CreateRingOnSocket(count uint, socket int) *Ring {
+	if count < 2 {
+		common.LogFatal(common.Initialization, "Cannot create ring of size", count)
+	}
+	r := simCreateRing(count)
+	r.socket = socket
+	return r
+}
+
+// GetRingSocket returns NUMA node which ring was created for or -1 if
+// it can be placed on any node.
+func GetRingSocket(ring *Ring) int {
+	return ring.socket
+}
+
+// CreateRings creates ring with given name and count.
+func CreateRings(count uint, inIndexNumber int32) Rings {
+	return CreateRingsOnSocket(count, inIndexNumber, -1)
+}
+
+// CreateRingsOnSocket creates rings with given count. Socket is only
+// recorded.
+func CreateRingsOnSocket(count uint, inIndexNumber int32, socket int) Rings {
+	rings := make(Rings, inIndexNumber, inIndexNumber)
+	for i := int32(0); i < inIndexNumber; i++ {
+		rings[i] = CreateRingOnSocket(count, socket)
+	}
+	return rings
+}
+
+// simCreateRing creates ring which holds count-1 pointers as DPDK
+// ring of the same count does.
+func simCreateRing(count uint) *Ring {
+	return &Ring{buf: make([]uintptr, count)}
+}
+
+// EnqueueBurst enqueues data to ring buffer.
+func (ring *Ring) EnqueueBurst(buffer []uintptr, count uint) uint {
+	ring.Lock()
+	defer ring.Unlock()
+	size := uint(len(ring.buf))
+	if free := size - 1 - ring.count; count > free {
+		count = free
+	}
+	for i := uint(0); i < count; i++ {
+		ring.buf[(ring.head+ring.count+i)%size] = buffer[i]
+	}
+	ring.count += count
+	return count
+}
+
+// DequeueBurst dequeues data from ring buffer.
+func (ring *Ring) DequeueBurst(buffer []uintptr, count uint) uint {
+	ring.Lock()
+	defer ring.Unlock()
+	size := uint(len(ring.buf))
+	if count > ring.count {
+		count = ring.count
+	}
+	for i := uint(0); i < count; i++ {
+		buffer[i] = ring.buf[(ring.head+i)%size]
+	}
+	ring.head = (ring.head + count) % size
+	ring.count -= count
+	return count
+}
+
+func (ring *Ring) GetRingCount() uint32 {
+	ring.Lock()
+	defer ring.Unlock()
+	return uint32(ring.count)
+}
+
+// ReceiveRSS - get packets from port and enqueue on a Ring.
+func ReceiveRSS(port uint16, inIndex []int32, OUT Rings, flag *int32, coreID int) {
+	simReceive(port, inIndex, OUT, flag)
+}
+
+// ReceiveKNI isn't used because KNI devices can't be created.
+func ReceiveKNI(port uint16, OUT *Ring, flag *int32, coreID int) {
+	simStop(nil, flag)
+}
+
+// Send - dequeue packets and send.
+func Send(port uint16, queue int16, IN Rings, inIndexNumber int32, flag *int32, coreID int) {
+	simSend(port, IN, inIndexNumber, flag)
+}
+
+// Stop - dequeue and free packets.
+func Stop(IN Rings, flag *int32, coreID int) {
+	simStop(IN, flag)
+}
+
+// InitDPDKArguments returns arguments for InitDPDK. They are ignored.
+func InitDPDKArguments(args []string) (int, []string) {
+	return len(args), args
+}
+
+// InitDPDK initializes simulation mode without ports because library
+// is built without DPDK.
+func InitDPDK(argc int, argv []string, burstSize uint, mbufNumber uint, mbufCacheSize uint, needKNI int) error {
+	if needKNI != 0 {
+		return common.WrapWithNFError(nil, "KNI isn't supported by library built with nodpdk tag", common.FailToInitDPDK)
+	}
+	common.LogWarning(common.Initialization, "Library is built with nodpdk tag, simulation mode without ports is used instead of DPDK")
+	return InitSimulation(burstSize, mbufNumber, mbufCacheSize, 0)
+}
+
+func StopDPDK() {
+}
+
+func FreeMempools() {
+	simFreeMempools()
+	usedMempools = nil
+}
+
+func StopPort(port uint16) {
+}
+
+func FreeKNI(port uint16) error {
+	return nil
+}
+
+// AttachVdev creates new virtual port for virtual device.
+func AttachVdev(name, args string) (uint16, error) {
+	if !simulation {
+		return 0, common.WrapWithNFError(nil, "Virtual ports exist only in simulation mode", common.NotInSimulation)
+	}
+	return simAttachVdev(), nil
+}
+
+// GetPortsNumber gets total number of available Ethernet devices.
+func GetPortsNumber() int {
+	return len(simPorts)
+}
+
+func CheckPortRSS(port uint16) int32 {
+	// Virtual ports have one receive queue
+	return 1
+}
+
+// PortConfig contains parameters of port which are applied by
+// CreatePort. Zero values of MTU, descriptors numbers and MAC mean
+// defaults of driver.
+type PortConfig struct {
+	Promiscuous    bool
+	AllMulticast   bool
+	MTU            uint16
+	RXDescriptors  uint16
+	TXDescriptors  uint16
+	MAC            [common.EtherAddrLen]uint8
+	MulticastAddrs [][common.EtherAddrLen]uint8
+}
+
+// CreatePort initializes a new port using global settings and parameters.
+func CreatePort(port uint16, willReceive bool, sendQueuesNumber uint16, hwtxchecksum bool, inIndex int32, config *PortConfig) error {
+	if err := simCreatePort(port, willReceive, inIndex, config.MTU); err != nil {
+		return err
+	}
+	if config.MAC != [common.EtherAddrLen]uint8{} {
+		if err := SetPortMACAddress(port, config.MAC); err != nil {
+			return err
+		}
+	}
+	if len(config.MulticastAddrs) != 0 {
+		return SetPortMulticastAddrs(port, config.MulticastAddrs)
+	}
+	return nil
+}
+
+// SetPortMACAddress replaces default MAC address of port.
+func SetPortMACAddress(port uint16, mac [common.EtherAddrLen]uint8) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	p.mac = mac
+	return nil
+}
+
+// SetPortMulticastAddrs sets list of multicast addresses which port
+// receives. Virtual ports receive all injected packets.
+func SetPortMulticastAddrs(port uint16, addrs [][common.EtherAddrLen]uint8) error {
+	_, err := getSimPort(port)
+	return err
+}
+
+// GetPortSocket returns NUMA node of port or -1 if it is unknown.
+func GetPortSocket(port uint16) int {
+	return simGetPortSocket(port)
+}
+
+// CreateMempool creates and returns a new memory pool.
+func CreateMempool(name string) *Mempool {
+	return CreateMempoolOnSocket(name, -1)
+}
+
+// CreateMempoolOnSocket creates and returns a new memory pool. Socket
+// is only recorded.
+func CreateMempoolOnSocket(name string, socket int) *Mempool {
+	nameC := 1
+	tName := name
+	for i := range usedMempools {
+		if usedMempools[i].name == tName {
+			tName = name + strconv.Itoa(nameC)
+			nameC++
+		}
+	}
+	mempool := simCreateMempool()
+	mempool.socket = socket
+	usedMempools = append(usedMempools, mempoolPair{mempool, tName})
+	return mempool
+}
+
+// GetMempoolSocket returns NUMA node which memory pool was created for
+// or -1 if it is unknown.
+func GetMempoolSocket(mempool *Mempool) int {
+	return mempool.socket
+}
+
+// CreateMempools creates inIndex memory pools.
+func CreateMempools(name string, inIndex int32, socket int) []*Mempool {
+	m := make([]*Mempool, inIndex, inIndex)
+	for i := int32(0); i < inIndex; i++ {
+		m[i] = CreateMempoolOnSocket(name, socket)
+	}
+	return m
+}
+
+// SetAffinity locks goroutine to its thread. Cores are virtual in
+// simulation mode.
+func SetAffinity(coreID int) error {
+	runtime.LockOSThread()
+	return nil
+}
+
+// AllocateMbufs allocates n mbufs.
+func AllocateMbufs(mb []uintptr, mempool *Mempool, n uint) error {
+	return simAllocateMbufs(mb, mempool, n)
+}
+
+// AllocateMbuf allocates one mbuf.
+func AllocateMbuf(mb *uintptr, mempool *Mempool) error {
+	return simAllocateMbufs((*[1]uintptr)(unsafe.Pointer(mb))[:], mempool, 1)
+}
+
+// WriteDataToMbuf copies data to mbuf.
+func WriteDataToMbuf(mb *Mbuf, data []byte) {
+	d := unsafe.Pointer(GetPacketDataStartPointer(mb))
+	copy((*[common.MaxLength]byte)(d)[:len(data)], data)
+}
+
+// GetRawPacketBytesMbuf returns raw data from packet.
+func GetRawPacketBytesMbuf(mb *Mbuf) []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(GetPacketDataStartPointer(mb)))[:mb.data_len]
+}
+
+// GetPktLenMbuf returns amount of data in a given chain of Mbufs - whole packet
+func GetPktLenMbuf(mb *Mbuf) uint {
+	return uint(mb.pkt_len)
+}
+
+// GetDataLenMbuf returns amount of data in a given Mbuf - one segment if scattered
+func GetDataLenMbuf(mb *Mbuf) uint {
+	return uint(mb.data_len)
+}
+
+// GetNextMbuf returns next segment of mbuf chain or nil if mbuf is the
+// last segment.
+func GetNextMbuf(mb *Mbuf) *Mbuf {
+	return (*Mbuf)(unsafe.Pointer(mb.next))
+}
+
+// GetMbufTailroom returns number of bytes which can be appended to mbuf.
+func GetMbufTailroom(mb *Mbuf) uint {
+	return uint(mb.buf_len - mb.data_off - mb.data_len)
+}
+
+// AppendMbufSegment allocates new segment from mempool of head mbuf,
+// copies to it as much of data as fits and adds it to the end of chain.
+// Returns new segment or nil if mempool is empty.
+func AppendMbufSegment(head *Mbuf, data []byte) *Mbuf {
+	var p uintptr
+	if err := AllocateMbuf(&p, (*Mempool)(unsafe.Pointer(head.pool))); err != nil {
+		return nil
+	}
+	seg := (*Mbuf)(unsafe.Pointer(p))
+	length := GetMbufTailroom(seg)
+	if uint(len(data)) < length {
+		length = uint(len(data))
+	}
+	WriteDataToMbuf(seg, data[:length])
+	seg.data_len = uint16(length)
+	seg.pkt_len = uint32(length)
+	last := head
+	for GetNextMbuf(last) != nil {
+		last = GetNextMbuf(last)
+	}
+	last.next = p
+	head.nb_segs++
+	head.pkt_len += uint32(length)
+	return seg
+}
+
+// LinearizeMbuf moves data of all segments of mbuf chain to the first
+// segment and frees other segments. Returns false if data doesn't fit
+// into the first segment.
+func LinearizeMbuf(mb *Mbuf) bool {
+	tail := GetNextMbuf(mb)
+	if tail == nil {
+		return true
+	}
+	if uint(mb.pkt_len) > uint(mb.buf_len-mb.data_off) {
+		return false
+	}
+	dst := (*[1 << 30]byte)(unsafe.Pointer(GetPacketDataStartPointer(mb)))[:mb.pkt_len]
+	offset := uint(mb.data_len)
+	for seg := tail; seg != nil; seg = GetNextMbuf(seg) {
+		offset += uint(copy(dst[offset:], GetRawPacketBytesMbuf(seg)))
+	}
+	mb.next = 0
+	mb.nb_segs = 1
+	mb.data_len = uint16(mb.pkt_len)
+	DirectStop(1, []uintptr{uintptr(unsafe.Pointer(tail))})
+	return true
+}
+
+// Statistics isn't collected by library built without DPDK.
+func Statistics(N float32) {
+}
+
+// GetPortStats returns basic statistics of port.
+func GetPortStats(port uint16) (common.PortStats, error) {
+	return simGetPortStats(port)
+}
+
+// GetPortXstats returns extended statistics of port by their names.
+func GetPortXstats(port uint16) (map[string]uint64, error) {
+	return simGetPortXstats(port)
+}
+
+// GetPortLinkStatus returns current link state of port.
+func GetPortLinkStatus(port uint16) (common.LinkStatus, error) {
+	return simGetPortLinkStatus(port)
+}
+
+// GetPortMTU returns current MTU of port.
+func GetPortMTU(port uint16) (uint16, error) {
+	return simGetPortMTU(port)
+}
+
+// SetPortMTU changes MTU of port.
+func SetPortMTU(port uint16, mtu uint16) error {
+	return simSetPortMTU(port, mtu)
+}
+
+// SetPortLinkUp sets link of port administratively up or down.
+func SetPortLinkUp(port uint16, up bool) error {
+	return simSetPortLinkUp(port, up)
+}
+
+// ReportMempoolsState prints used and free space of mempools.
+func ReportMempoolsState() {
+	for _, m := range usedMempools {
+		use := m.mempool.inUse()
+		common.LogDebug(common.Verbose, "Mempool usage", m.name, use, "from", mbufNumberT)
+		if float32(mbufNumberT-use)/float32(mbufNumberT)*100 < 10 {
+			common.LogDrop(common.Debug, m.name, "mempool has less than 10% free space. This can lead to dropping packets while receive.")
+		}
+	}
+}
+
+// CreateKni returns error because KNI requires DPDK.
+func CreateKni(portId uint16, core uint, name string, ops *KniOps) error {
+	return common.WrapWithNFError(nil, "KNI isn't supported by library built with nodpdk tag", common.FailToCreateKNI)
+}
+
+// CreateLPM returns nil because LPM tables are implemented by DPDK.
+func CreateLPM(name string, socket uint8, maxRules uint32, numberTbl8 uint32, tbl24 unsafe.Pointer, tbl8 unsafe.Pointer) unsafe.Pointer {
+	common.LogError(common.Initialization, "LPM isn't supported by library built with nodpdk tag")
+	return nil
+}
+
+// AddLPMRule adds one rule to LPM table
+func AddLPMRule(lpm unsafe.Pointer, ip uint32, depth uint8, nextHop uint32) int {
+	return -int(syscall.ENOTSUP)
+}
+
+// DeleteLPMRule removes one rule from LPM table
+func DeleteLPMRule(lpm unsafe.Pointer, ip uint32, depth uint8) int {
+	return -int(syscall.ENOTSUP)
+}
+
+// FreeLPM frees lpm structure
+func FreeLPM(lpm unsafe.Pointer) {
+}
+
+func BoolToInt(value bool) uint8 {
+	return *((*uint8)(unsafe.Pointer(&value)))
+}
+
+func IntArrayToBool(value *[32]uint8) *[32]bool {
+	return (*[32]bool)(unsafe.Pointer(value))
+}
+
+func CheckHWTXChecksumCapability(port uint16) bool {
+	return false
+}
+
+func simInit(burstSize uint) {
+}
+
+// simSetMbufLen sets length of data of one segment mbuf.
+func simSetMbufLen(mb *Mbuf, length uint) {
+	mb.data_len = uint16(length)
+	mb.pkt_len = uint32(length)
+}
+
+// simCreateMempool maps memory for mbufNumberT mbufs.
+func simCreateMempool() *Mempool {
+	m := new(Mempool)
+	m.number = mbufNumberT
+	mem, err := syscall.Mmap(-1, 0, int(uintptr(m.number)*mbufEltSize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		common.LogFatal(common.Initialization, "Cannot create mbuf pool:", err)
+	}
+	m.mem = mem
+	m.free = make([]uintptr, m.number)
+	for i := range m.free {
+		m.free[i] = uintptr(unsafe.Pointer(&mem[0])) + uintptr(i)*mbufEltSize
+	}
+	simMempoolsLock.Lock()
+	simMempools[m] = struct{}{}
+	simMempoolsLock.Unlock()
+	return m
+}
+
+// simFreeMempools releases all mempools. Mbufs can still be used by
+// user, so only pools which have all mbufs free are unmapped now, other
+// pools are unmapped when their last mbuf is freed.
+func simFreeMempools() {
+	for i := range simPorts {
+		simPorts[i].mempool = nil
+	}
+	simMempoolsLock.Lock()
+	for m := range simMempools {
+		m.Lock()
+		m.released = true
+		if m.unmapFree() {
+			delete(simMempools, m)
+		}
+		m.Unlock()
+	}
+	simMempoolsLock.Unlock()
+}
+
+// unmapFree unmaps memory of released mempool if all its mbufs are free.
+// Should be called with lock of mempool.
+func (m *Mempool) unmapFree() bool {
+	if !m.released || uint(len(m.free)) != m.number {
+		return false
+	}
+	syscall.Munmap(m.mem)
+	m.mem = nil
+	m.free = nil
+	return true
+}
+
+func (m *Mempool) inUse() uint {
+	m.Lock()
+	defer m.Unlock()
+	return m.number - uint(len(m.free))
+}
+
+// simAllocateMbufs takes n mbufs from mempool and initializes them as
+// receive function of DPDK build does.
+func simAllocateMbufs(mb []uintptr, mempool *Mempool, n uint) error {
+	mempool.Lock()
+	if mempool.released || uint(len(mempool.free)) < n {
+		mempool.Unlock()
+		return common.WrapWithNFError(nil, "Simulated mempool is empty", common.AllocMbufErr)
+	}
+	copy(mb, mempool.free[uint(len(mempool.free))-n:])
+	mempool.free = mempool.free[:uint(len(mempool.free))-n]
+	mempool.Unlock()
+	for i := uint(0); i < n; i++ {
+		simResetMbuf(mb[i], mempool)
+	}
+	return nil
+}
+
+// simResetMbuf initializes mbuf and fields of packet structure placed
+// after it, as rte_pktmbuf_reset and mbufInit of low.h do.
+func simResetMbuf(p uintptr, mempool *Mempool) {
+	mb := (*Mbuf)(unsafe.Pointer(p))
+	*mb = Mbuf{
+		buf_addr: p + mbufStructSize,
+		data_off: mbufHeadroom,
+		buf_len:  mbufBufSize,
+		nb_segs:  1,
+		pool:     uintptr(unsafe.Pointer(mempool)),
+	}
+	h := (*packetHeader)(unsafe.Pointer(p + mbufStructSize))
+	h.ether = p + mbufStructSize + mbufHeadroom
+	h.cmbuf = p
+	h.next = 0
+	h.dropReason = 0
+}
+
+// simFreeMbufs returns all segments of given mbufs to their pools.
+func simFreeMbufs(buf []uintptr) {
+	for i := range buf {
+		for mb := (*Mbuf)(unsafe.Pointer(buf[i])); mb != nil; {
+			next := GetNextMbuf(mb)
+			m := (*Mempool)(unsafe.Pointer(mb.pool))
+			m.Lock()
+			m.free = append(m.free, uintptr(unsafe.Pointer(mb)))
+			unmapped := m.unmapFree()
+			m.Unlock()
+			if unmapped {
+				simMempoolsLock.Lock()
+				delete(simMempools, m)
+				simMempoolsLock.Unlock()
+			}
+			mb = next
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build nodpdk
+// +build nodpdk
+
+package low
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestFreeMempoolsWithUsedMbufs(t *testing.T) {
+	m := CreateMempool("test")
+	var p uintptr
+	if err := AllocateMbuf(&p, m); err != nil {
+		t.Fatal(err)
+	}
+	FreeMempools()
+	if m.mem == nil {
+		t.Fatal("Mempool with used mbuf is unmapped")
+	}
+	WriteDataToMbuf((*Mbuf)(unsafe.Pointer(p)), []byte{1, 2, 3})
+	var other uintptr
+	if AllocateMbuf(&other, m) == nil {
+		t.Error("Mbuf is allocated from released mempool")
+	}
+	DirectStop(1, []uintptr{p})
+	if m.mem != nil {
+		t.Error("Released mempool isn't unmapped when all its mbufs are free")
+	}
+}
+
+func TestSimulatedPortSocket(t *testing.T) {
+	if err := InitSimulation(32, 1024, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSimulatedPortSocket(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if GetPortSocket(0) != -1 || GetPortSocket(1) != 1 {
+		t.Errorf("Ports are on nodes %d and %d", GetPortSocket(0), GetPortSocket(1))
+	}
+	// Receive mempool is created on node of port
+	if err := CreatePort(1, true, 1, false, 1, &PortConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := GetMempoolSocket(simPorts[1].mempool); s != 1 {
+		t.Errorf("Receive mempool of port is on node %d", s)
+	}
+	if s := GetRingSocket(CreateRingOnSocket(64, 1)); s != 1 {
+		t.Errorf("Ring is on node %d", s)
+	}
+	if SetSimulatedPortSocket(2, 0) == nil {
+		t.Error("Node is set for port which doesn't exist")
+	}
+}
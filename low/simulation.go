@@ -0,0 +1,426 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package low
+
+// Simulation mode
+// Library can work without EAL, hugepages and NICs. Rings are placed in
+// usual process memory, mbufs are taken from pools managed by Go code
+// and ports are virtual. Virtual port receives packets which were
+// injected into it and collects packets which were sent to it, so whole
+// flow graphs can be checked by usual go tests.
+//
+// Virtual ports are implemented in this file for both builds of
+// library. Mbufs, mempools and rings of simulation are implemented by
+// DPDK structures in simulation_dpdk.go or by pure Go code in
+// low_nodpdk.go if library is built with "nodpdk" tag.
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Values of flow function flags, the same as in low.h
+const (
+	simProcess     = 1
+	simStopRequest = 2
+	simWasStopped  = 9
+)
+
+// Maximum length of packet which fits into one simulated mbuf,
+// equal to RTE_MBUF_DEFAULT_DATAROOM.
+const simMaxPacketLen = 2048
+
+var simulation bool
+var simBurstSize uint
+
+type simPort struct {
+	sync.Mutex
+	mac     [common.EtherAddrLen]uint8
+	mempool *Mempool
+	input   [][]byte
+	sent    [][]byte
+	stats   common.PortStats
+	link    common.LinkStatus
+	mtu     uint16
+	// NUMA node which is reported for port, -1 by default
+	socket int
+	// Packets sent to port are received by peer port if it is set
+	peer *simPort
+}
+
+// Link status of virtual port after initialization
+var simDefaultLink = common.LinkStatus{Up: true, Speed: 10000, FullDuplex: true}
+
+// MTU of virtual port after initialization
+const simDefaultMTU = 1500
+
+// Received frame can be longer than MTU by Ethernet and VLAN headers.
+const simFrameOverhead = common.EtherLen + common.VLANLen
+
+var simPorts []*simPort
+
+// InitSimulation initializes library for simulation mode instead of
+// InitDPDK. Given number of virtual ports is created.
+func InitSimulation(burstSize uint, mbufNumber uint, mbufCacheSize uint, portsNumber uint16) error {
+	simInit(burstSize)
+	simulation = true
+	simBurstSize = burstSize
+	mbufNumberT = mbufNumber
+	mbufCacheSizeT = mbufCacheSize
+	simPorts = make([]*simPort, portsNumber)
+	for i := range simPorts {
+		simPorts[i] = new(simPort)
+		// Locally administered unicast addresses
+		simPorts[i].mac = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, uint8(i >> 8), uint8(i)}
+		simPorts[i].link = simDefaultLink
+		simPorts[i].mtu = simDefaultMTU
+		simPorts[i].socket = -1
+	}
+	return nil
+}
+
+// simAttachVdev adds virtual port for virtual device.
+func simAttachVdev() uint16 {
+	i := len(simPorts)
+	p := new(simPort)
+	p.mac = [common.EtherAddrLen]uint8{0x02, 0, 0, 0, uint8(i >> 8), uint8(i)}
+	p.link = simDefaultLink
+	p.mtu = simDefaultMTU
+	p.socket = -1
+	simPorts = append(simPorts, p)
+	return uint16(i)
+}
+
+// ConnectSimulatedPorts connects two virtual ports, so packets sent to
+// one of them are received by another one. It simulates pair of ports
+// connected by cable or shared memory.
+func ConnectSimulatedPorts(a, b uint16) error {
+	pa, err := getSimPort(a)
+	if err != nil {
+		return err
+	}
+	pb, err := getSimPort(b)
+	if err != nil {
+		return err
+	}
+	pa.peer = pb
+	pb.peer = pa
+	return nil
+}
+
+// IsSimulation returns true if library was initialized for
+// simulation mode.
+func IsSimulation() bool {
+	return simulation
+}
+
+func getSimPort(port uint16) (*simPort, error) {
+	if !simulation {
+		return nil, common.WrapWithNFError(nil, "Virtual ports exist only in simulation mode", common.NotInSimulation)
+	}
+	if int(port) >= len(simPorts) {
+		return nil, common.WrapWithNFError(nil, "Virtual port doesn't exist", common.WrongPort)
+	}
+	return simPorts[port], nil
+}
+
+// InjectPackets adds copies of given packets to input of virtual port.
+// They will be received by receive function of this port in order.
+func InjectPackets(port uint16, packets [][]byte) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	input := make([][]byte, len(packets))
+	for i := range packets {
+		if len(packets[i]) > simMaxPacketLen {
+			return common.WrapWithNFError(nil, "Packet is too long for simulated mbuf", common.BadArgument)
+		}
+		input[i] = append([]byte(nil), packets[i]...)
+	}
+	p.Lock()
+	p.input = append(p.input, input...)
+	p.Unlock()
+	return nil
+}
+
+// TakeSentPackets returns packets which were sent to virtual port since
+// previous call.
+func TakeSentPackets(port uint16) ([][]byte, error) {
+	p, err := getSimPort(port)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	sent := p.sent
+	p.sent = nil
+	p.Unlock()
+	return sent, nil
+}
+
+// SetSimulatedLinkStatus changes link status of virtual port. Link
+// state change handlers are called as for real ports.
+func SetSimulatedLinkStatus(port uint16, link common.LinkStatus) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	p.link = link
+	p.Unlock()
+	return nil
+}
+
+// SetSimulatedPortSocket sets NUMA node which is reported for virtual
+// port, so placement of flow functions, rings and mempools can be
+// checked in simulation mode. It should be called before port is used
+// in flow graph.
+func SetSimulatedPortSocket(port uint16, socket int) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	p.socket = socket
+	return nil
+}
+
+func simGetPortSocket(port uint16) int {
+	p, err := getSimPort(port)
+	if err != nil {
+		return -1
+	}
+	return p.socket
+}
+
+func simGetPortStats(port uint16) (common.PortStats, error) {
+	p, err := getSimPort(port)
+	if err != nil {
+		return common.PortStats{}, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.stats, nil
+}
+
+// Virtual ports have only basic statistics, they are named as basic
+// extended statistics of DPDK.
+func simGetPortXstats(port uint16) (map[string]uint64, error) {
+	s, err := simGetPortStats(port)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{
+		"rx_good_packets":           s.RxPackets,
+		"tx_good_packets":           s.TxPackets,
+		"rx_good_bytes":             s.RxBytes,
+		"tx_good_bytes":             s.TxBytes,
+		"rx_missed_errors":          s.RxMissed,
+		"rx_errors":                 s.RxErrors,
+		"tx_errors":                 s.TxErrors,
+		"rx_mbuf_allocation_errors": s.RxNoMbuf,
+	}, nil
+}
+
+func simGetPortLinkStatus(port uint16) (common.LinkStatus, error) {
+	p, err := getSimPort(port)
+	if err != nil {
+		return common.LinkStatus{}, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.link, nil
+}
+
+func simGetPortMTU(port uint16) (uint16, error) {
+	p, err := getSimPort(port)
+	if err != nil {
+		return 0, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.mtu, nil
+}
+
+func simSetPortMTU(port uint16, mtu uint16) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	if int(mtu)+simFrameOverhead > simMaxPacketLen {
+		return common.WrapWithNFError(nil, "MTU of simulated port exceeds its maximum packet length", common.BadArgument)
+	}
+	p.Lock()
+	p.mtu = mtu
+	p.Unlock()
+	return nil
+}
+
+func simSetPortLinkUp(port uint16, up bool) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	p.link.Up = up
+	p.Unlock()
+	return nil
+}
+
+// simTransmit copies data of all segments of given mbufs to sent
+// packets of virtual port and frees mbufs.
+func simTransmit(port uint16, buf []uintptr) {
+	p := simPorts[port]
+	sent := make([][]byte, len(buf))
+	var bytes uint64
+	for i := range buf {
+		mb := (*Mbuf)(unsafe.Pointer(buf[i]))
+		sent[i] = make([]byte, 0, GetPktLenMbuf(mb))
+		for ; mb != nil; mb = GetNextMbuf(mb) {
+			sent[i] = append(sent[i], GetRawPacketBytesMbuf(mb)...)
+		}
+		bytes += uint64(len(sent[i]))
+	}
+	p.Lock()
+	if p.peer == nil {
+		p.sent = append(p.sent, sent...)
+	}
+	p.stats.TxPackets += uint64(len(buf))
+	p.stats.TxBytes += bytes
+	p.Unlock()
+	if p.peer != nil {
+		p.peer.Lock()
+		p.peer.input = append(p.peer.input, sent...)
+		p.peer.Unlock()
+	}
+	simFreeMbufs(buf)
+}
+
+// receive moves up to len(buf) injected packets into mbufs.
+func (p *simPort) receive(buf []uintptr) uint {
+	p.Lock()
+	defer p.Unlock()
+	n := uint(len(buf))
+	if uint(len(p.input)) < n {
+		n = uint(len(p.input))
+	}
+	if n == 0 {
+		return 0
+	}
+	if simAllocateMbufs(buf, p.mempool, n) != nil {
+		p.stats.RxNoMbuf++
+		return 0
+	}
+	received := uint(0)
+	for i := uint(0); i < n; i++ {
+		data := p.input[i]
+		p.input[i] = nil
+		// Frames which are longer than MTU allows are dropped as NIC
+		// does it
+		if len(data) > int(p.mtu)+simFrameOverhead {
+			p.stats.RxErrors++
+			continue
+		}
+		buf[received], buf[i] = buf[i], buf[received]
+		mb := (*Mbuf)(unsafe.Pointer(buf[received]))
+		WriteDataToMbuf(mb, data)
+		simSetMbufLen(mb, uint(len(data)))
+		p.stats.RxBytes += uint64(len(data))
+		received++
+	}
+	if received < n {
+		simFreeMbufs(buf[received:n])
+	}
+	p.stats.RxPackets += uint64(received)
+	p.input = p.input[n:]
+	return received
+}
+
+func simCreatePort(port uint16, willReceive bool, inIndex int32, mtu uint16) error {
+	p, err := getSimPort(port)
+	if err != nil {
+		return err
+	}
+	if mtu != 0 {
+		if err := simSetPortMTU(port, mtu); err != nil {
+			return err
+		}
+	}
+	if willReceive && p.mempool == nil {
+		p.mempool = CreateMempoolOnSocket("receive", p.socket)
+	}
+	return nil
+}
+
+func simPendingPackets(port uint16) int64 {
+	p := simPorts[port]
+	p.Lock()
+	defer p.Unlock()
+	return int64(len(p.input))
+}
+
+// Virtual port has only one receive queue, so all packets are received
+// by the first queue of inIndex.
+func simReceive(port uint16, inIndex []int32, OUT Rings, flag *int32) {
+	p := simPorts[port]
+	buf := make([]uintptr, simBurstSize)
+	for atomic.LoadInt32(flag) == simProcess {
+		n := p.receive(buf)
+		if n == 0 {
+			runtime.Gosched()
+			continue
+		}
+		pushed := OUT[inIndex[1]].EnqueueBurst(buf, n)
+		// Free any packets which can't be pushed to the ring. The ring is probably full.
+		if pushed < n {
+			simFreeMbufs(buf[pushed:n])
+			p.Lock()
+			p.stats.RxMissed += uint64(n - pushed)
+			p.Unlock()
+		}
+	}
+	atomic.StoreInt32(flag, simWasStopped)
+}
+
+func simSend(port uint16, IN Rings, inIndexNumber int32, flag *int32) {
+	buf := make([]uintptr, simBurstSize)
+	for atomic.LoadInt32(flag) == simProcess {
+		sent := false
+		for q := int32(0); q < inIndexNumber; q++ {
+			n := IN[q].DequeueBurst(buf, simBurstSize)
+			if n != 0 {
+				simTransmit(port, buf[:n])
+				sent = true
+			}
+		}
+		if !sent {
+			runtime.Gosched()
+		}
+	}
+	atomic.StoreInt32(flag, simWasStopped)
+}
+
+func simStop(IN Rings, flag *int32) {
+	buf := make([]uintptr, simBurstSize)
+	// Flag is used for both scheduler and stop.
+	// stopRequest will stop scheduler and this loop will stop with stopRequest+1
+	for f := atomic.LoadInt32(flag); f == simProcess || f == simStopRequest; f = atomic.LoadInt32(flag) {
+		freed := false
+		for q := range IN {
+			n := IN[q].DequeueBurst(buf, simBurstSize)
+			if n != 0 {
+				simFreeMbufs(buf[:n])
+				freed = true
+			}
+		}
+		if !freed {
+			runtime.Gosched()
+		}
+	}
+	atomic.StoreInt32(flag, simWasStopped)
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !nodpdk
+// +build !nodpdk
+
+package low
+
+// Mbufs, mempools and rings of simulation mode are DPDK structures
+// placed in usual process memory, so the same code works with them in
+// simulation and with DPDK.
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// These functions are implemented in low.h
+void sim_init(uint32_t burstSize);
+void *sim_ring_create(const char *name, unsigned count);
+void *sim_mempool_create(uint32_t num_mbufs, char **mem, uint32_t *elt_size);
+void sim_mempool_free(void *mp, char *mem);
+void sim_mbufs_reset(void **bufs, unsigned count);
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+type simMempool struct {
+	sync.Mutex
+	mempool *Mempool
+	mem     *C.char
+	number  uint
+	free    []uintptr
+	// Released mempool is freed when all its mbufs are free
+	released bool
+}
+
+var simMempools = make(map[*Mempool]*simMempool)
+var simMempoolsLock sync.RWMutex
+
+func simInit(burstSize uint) {
+	C.sim_init(C.uint32_t(burstSize))
+}
+
+// simSetMbufLen sets length of data of one segment mbuf.
+func simSetMbufLen(mb *Mbuf, length uint) {
+	mb.data_len = C.uint16_t(length)
+	mb.pkt_len = C.uint32_t(length)
+}
+
+func simCreateRing(name *C.char, count uint) *Ring {
+	r := (*Ring)(C.sim_ring_create(name, C.unsigned(count)))
+	if r == nil {
+		common.LogFatal(common.Initialization, "Cannot create ring of size", count)
+	}
+	return r
+}
+
+func simCreateMempool() *Mempool {
+	m := new(simMempool)
+	m.number = mbufNumberT
+	var eltSize C.uint32_t
+	m.mempool = (*Mempool)(C.sim_mempool_create(C.uint32_t(m.number), &m.mem, &eltSize))
+	if m.mempool == nil {
+		common.LogFatal(common.Initialization, "Cannot create mbuf pool")
+	}
+	m.free = make([]uintptr, m.number)
+	for i := range m.free {
+		m.free[i] = uintptr(unsafe.Pointer(m.mem)) + uintptr(i)*uintptr(eltSize)
+	}
+	simMempoolsLock.Lock()
+	simMempools[m.mempool] = m
+	simMempoolsLock.Unlock()
+	return m.mempool
+}
+
+// simFreeMempools releases all mempools. Mbufs can still be used by
+// user, so only pools which have all mbufs free are freed now, other
+// pools are freed when their last mbuf is freed.
+func simFreeMempools() {
+	simMempoolsLock.Lock()
+	for k, m := range simMempools {
+		m.Lock()
+		m.released = true
+		if m.freeUnused() {
+			delete(simMempools, k)
+		}
+		m.Unlock()
+	}
+	simMempoolsLock.Unlock()
+	for i := range simPorts {
+		simPorts[i].mempool = nil
+	}
+}
+
+func getSimMempool(mempool *Mempool) *simMempool {
+	simMempoolsLock.RLock()
+	m := simMempools[mempool]
+	simMempoolsLock.RUnlock()
+	return m
+}
+
+// freeUnused frees released mempool if all its mbufs are free. Should
+// be called with lock of mempool.
+func (m *simMempool) freeUnused() bool {
+	if !m.released || uint(len(m.free)) != m.number {
+		return false
+	}
+	C.sim_mempool_free(unsafe.Pointer(m.mempool), m.mem)
+	m.free = nil
+	return true
+}
+
+func (m *simMempool) inUse() uint {
+	m.Lock()
+	defer m.Unlock()
+	return m.number - uint(len(m.free))
+}
+
+func simAllocateMbufs(mb []uintptr, mempool *Mempool, n uint) error {
+	m := getSimMempool(mempool)
+	m.Lock()
+	if m.released || uint(len(m.free)) < n {
+		m.Unlock()
+		return common.WrapWithNFError(nil, "Simulated mempool is empty", common.AllocMbufErr)
+	}
+	copy(mb, m.free[uint(len(m.free))-n:])
+	m.free = m.free[:uint(len(m.free))-n]
+	m.Unlock()
+	C.sim_mbufs_reset((*unsafe.Pointer)(unsafe.Pointer(&mb[0])), C.unsigned(n))
+	return nil
+}
+
+// simFreeMbufs returns all segments of given mbufs to their pools.
+func simFreeMbufs(buf []uintptr) {
+	for i := range buf {
+		for mb := (*Mbuf)(unsafe.Pointer(buf[i])); mb != nil; {
+			next := (*Mbuf)(unsafe.Pointer(mb.next))
+			m := getSimMempool((*Mempool)(unsafe.Pointer(mb.pool)))
+			m.Lock()
+			m.free = append(m.free, uintptr(unsafe.Pointer(mb)))
+			freed := m.freeUnused()
+			m.Unlock()
+			if freed {
+				simMempoolsLock.Lock()
+				delete(simMempools, m.mempool)
+				simMempoolsLock.Unlock()
+			}
+			mb = next
+		}
+	}
+}
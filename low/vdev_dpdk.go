@@ -0,0 +1,19 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !nodpdk && !novdev
+// +build !nodpdk,!novdev
+
+package low
+
+// Drivers of virtual devices which are used by virtual device ports,
+// local ports and TAP interfaces. They are linked entirely like other
+// PMD drivers. Library built with "novdev" tag doesn't need them and
+// libpcap, so it can be used with DPDK which is built without pcap
+// driver, but such virtual devices can't be attached.
+
+/*
+#cgo LDFLAGS: -Wl,--whole-archive -lrte_pmd_pcap -lrte_pmd_tap -lrte_pmd_vhost -Wl,--no-whole-archive -lrte_vhost -lpcap
+*/
+import "C"
@@ -0,0 +1,435 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nat provides network address translation on top of packet
+// headers. Translator keeps a table of mappings between private and
+// public transport endpoints. It supports source NAT (masquerade) with a
+// pool of public IPv4 addresses and a range of ports which are allocated
+// for private endpoints, and destination NAT (port forwarding) rules which
+// are static mappings set by user. Packets are changed in place and all
+// checksums are fixed incrementally.
+//
+// Translator has two handlers, PrivateToPublic and PublicToPrivate, which
+// have SeparateFunction type and should be used with SetHandlerDrop:
+//
+//	flow.CheckFatal(flow.SetHandlerDrop(fromPrivate, translator.PrivateToPublic, nil))
+//	flow.CheckFatal(flow.SetHandlerDrop(fromPublic, translator.PublicToPrivate, nil))
+//
+// Packets which can't be translated are dropped. Packets which are not
+// IPv4 (for example ARP) are passed without any changes.
+//
+// All addresses have the same representation as in IPv4Hdr, so
+// packet.BytesToIPv4 should be used to construct them. Ports are in
+// host byte order.
+package nat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+)
+
+const (
+	protoTCP = iota
+	protoUDP
+	protoICMP
+	protoNumber
+)
+
+// Config is a struct with translator parameters.
+type Config struct {
+	// Public IPv4 addresses which are used for source translation.
+	// Private host is always translated to the same public address.
+	// Pool should have at least one address.
+	PublicAddresses []uint32
+	// Range of public ports (and ICMP identifiers) which are allocated
+	// for private endpoints. Default values are 1024 and 65535.
+	PortMin uint16
+	PortMax uint16
+	// Time after which mapping is removed if no packets were translated
+	// with it. Default values are 2 hours 4 minutes for TCP (RFC 5382),
+	// 5 minutes for UDP (RFC 4787) and 60 seconds for ICMP (RFC 5508).
+	TCPTimeout  time.Duration
+	UDPTimeout  time.Duration
+	ICMPTimeout time.Duration
+	// Granularity of mapping timeouts. Default value is 1 second.
+	CheckInterval time.Duration
+}
+
+// DNATRule is a static mapping which forwards packets for public
+// address and port to private address and port. Replies from private
+// endpoint are translated back. Protocol is common.TCPNumber or
+// common.UDPNumber.
+type DNATRule struct {
+	Protocol       uint8
+	PublicAddress  uint32
+	PublicPort     uint16
+	PrivateAddress uint32
+	PrivatePort    uint16
+}
+
+type endpoint struct {
+	addr uint32
+	port uint16
+}
+
+type mappingKey struct {
+	proto uint8
+	endpoint
+}
+
+type mapping struct {
+	proto    uint8
+	private  endpoint
+	public   endpoint
+	lastSeen int64
+	static   bool
+	// Expiration timer, nil for static mappings
+	timer *flow.TimerEntry
+}
+
+// portSet is a set of free ports of one protocol on public address.
+// Ports are taken and released in constant time.
+type portSet struct {
+	free []uint16
+	// Position of port in free list, -1 if port is used
+	index []int32
+}
+
+func newPortSet(size int) portSet {
+	s := portSet{free: make([]uint16, size), index: make([]int32, size)}
+	// Ports are taken from the end of list, so list is reversed to
+	// allocate lower ports first
+	for i := range s.free {
+		s.free[i] = uint16(size - 1 - i)
+		s.index[size-1-i] = int32(i)
+	}
+	return s
+}
+
+func (s *portSet) used(i uint16) bool {
+	return s.index[i] < 0
+}
+
+// take removes port with given offset from set. Returns false if it is
+// already used.
+func (s *portSet) take(i uint16) bool {
+	pos := s.index[i]
+	if pos < 0 {
+		return false
+	}
+	last := s.free[len(s.free)-1]
+	s.free[pos] = last
+	s.index[last] = pos
+	s.free = s.free[:len(s.free)-1]
+	s.index[i] = -1
+	return true
+}
+
+// pop takes any free port.
+func (s *portSet) pop() (uint16, bool) {
+	if len(s.free) == 0 {
+		return 0, false
+	}
+	i := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	s.index[i] = -1
+	return i, true
+}
+
+func (s *portSet) put(i uint16) {
+	if s.index[i] >= 0 {
+		return
+	}
+	s.index[i] = int32(len(s.free))
+	s.free = append(s.free, i)
+}
+
+// addressPool tracks ports used on one public address for every protocol.
+type addressPool struct {
+	ports [protoNumber]portSet
+}
+
+// Translator is a NAT connection table with handlers which translate
+// packets according to it.
+type Translator struct {
+	sync.RWMutex
+	private   map[mappingKey]*mapping
+	public    map[mappingKey]*mapping
+	addresses []uint32
+	pools     map[uint32]*addressPool
+	portMin   uint16
+	portMax   uint16
+	timeouts  [protoNumber]int64
+	now       int64
+	// Mappings expire on timers of wheel, clock updates now
+	wheel *flow.TimerWheel
+	clock *flow.TimerEntry
+	// Drop reason of packets which can't get public port
+	noPorts common.DropReason
+
+	checkInterval time.Duration
+}
+
+// NewTranslator creates translator with given configuration and starts
+// timers which remove expired mappings. Timers are stopped by Stop.
+func NewTranslator(userConfig *Config) (*Translator, error) {
+	if userConfig == nil || len(userConfig.PublicAddresses) == 0 {
+		return nil, common.WrapWithNFError(nil, "NAT public address pool is empty", common.BadArgument)
+	}
+	config := *userConfig
+	if config.PortMin == 0 {
+		config.PortMin = 1024
+	}
+	if config.PortMax == 0 {
+		config.PortMax = 65535
+	}
+	if config.PortMin > config.PortMax {
+		return nil, common.WrapWithNFError(nil, "NAT port range is empty", common.BadArgument)
+	}
+	if config.TCPTimeout == 0 {
+		config.TCPTimeout = 2*time.Hour + 4*time.Minute
+	}
+	if config.UDPTimeout == 0 {
+		config.UDPTimeout = 5 * time.Minute
+	}
+	if config.ICMPTimeout == 0 {
+		config.ICMPTimeout = 60 * time.Second
+	}
+	if config.CheckInterval == 0 {
+		config.CheckInterval = time.Second
+	}
+
+	tr := new(Translator)
+	tr.private = make(map[mappingKey]*mapping)
+	tr.public = make(map[mappingKey]*mapping)
+	tr.addresses = append([]uint32(nil), config.PublicAddresses...)
+	tr.pools = make(map[uint32]*addressPool)
+	tr.portMin = config.PortMin
+	tr.portMax = config.PortMax
+	for _, addr := range tr.addresses {
+		pool := new(addressPool)
+		for p := range pool.ports {
+			pool.ports[p] = newPortSet(int(tr.portMax) - int(tr.portMin) + 1)
+		}
+		tr.pools[addr] = pool
+	}
+	tr.timeouts[protoTCP] = int64(config.TCPTimeout)
+	tr.timeouts[protoUDP] = int64(config.UDPTimeout)
+	tr.timeouts[protoICMP] = int64(config.ICMPTimeout)
+	tr.checkInterval = config.CheckInterval
+	tr.now = time.Now().UnixNano()
+	reason, err := flow.RegisterDropReason("NAT has no free ports")
+	if err != nil {
+		return nil, err
+	}
+	tr.noPorts = reason
+	tr.wheel = flow.NewTimerWheel(tr.checkInterval)
+	// Clock can tick before Add returns
+	tr.Lock()
+	tr.clock = tr.wheel.Add(tr.checkInterval, tr.tick, nil)
+	tr.Unlock()
+	return tr, nil
+}
+
+// Stop stops timers of translator. Mappings don't expire after it.
+func (tr *Translator) Stop() {
+	tr.wheel.Stop()
+}
+
+func protoIndex(proto uint8) int {
+	switch proto {
+	case common.TCPNumber:
+		return protoTCP
+	case common.UDPNumber:
+		return protoUDP
+	default:
+		return protoICMP
+	}
+}
+
+// tick updates time which is used to mark mappings instead of calling
+// time.Now for every packet.
+func (tr *Translator) tick(ctx flow.UserContext) {
+	atomic.StoreInt64(&tr.now, time.Now().UnixNano())
+	tr.RLock()
+	clock := tr.clock
+	tr.RUnlock()
+	clock.Reset(tr.checkInterval)
+}
+
+// Timer of mapping isn't reset by packets. When it expires, mapping is
+// removed if it wasn't used during timeout, otherwise timer is started
+// again for the rest of timeout.
+func (tr *Translator) expire(m *mapping, now int64) {
+	left := tr.timeouts[protoIndex(m.proto)] - (now - atomic.LoadInt64(&m.lastSeen))
+	if left > 0 {
+		m.timer.Reset(time.Duration(left))
+		return
+	}
+	key := mappingKey{m.proto, m.private}
+	tr.Lock()
+	// Mapping could be removed while its timer was expiring
+	if tr.private[key] == m {
+		tr.removeMapping(key, m)
+	}
+	tr.Unlock()
+}
+
+// AddDNATRule adds static mapping. Dynamic mapping of the same private
+// endpoint is replaced. If public address belongs to the pool, its port
+// isn't used for source translation anymore.
+func (tr *Translator) AddDNATRule(rule DNATRule) error {
+	if rule.Protocol != common.TCPNumber && rule.Protocol != common.UDPNumber {
+		return common.WrapWithNFError(nil, "DNAT rule protocol should be TCP or UDP", common.BadArgument)
+	}
+	m := &mapping{
+		proto:   rule.Protocol,
+		private: endpoint{rule.PrivateAddress, rule.PrivatePort},
+		public:  endpoint{rule.PublicAddress, rule.PublicPort},
+		static:  true,
+	}
+	privateKey := mappingKey{m.proto, m.private}
+	publicKey := mappingKey{m.proto, m.public}
+	tr.Lock()
+	defer tr.Unlock()
+	if old, ok := tr.public[publicKey]; ok {
+		if old.static {
+			return common.WrapWithNFError(nil, "DNAT rule for this public port already exists", common.BadArgument)
+		}
+		tr.removeMapping(mappingKey{old.proto, old.private}, old)
+	}
+	if old, ok := tr.private[privateKey]; ok {
+		if old.static {
+			return common.WrapWithNFError(nil, "DNAT rule for this private port already exists", common.BadArgument)
+		}
+		tr.removeMapping(privateKey, old)
+	}
+	tr.setPortUsed(m.proto, m.public, true)
+	tr.private[privateKey] = m
+	tr.public[publicKey] = m
+	return nil
+}
+
+// RemoveDNATRule removes static mapping for given public endpoint.
+// Returns false if there was no such rule.
+func (tr *Translator) RemoveDNATRule(protocol uint8, publicAddress uint32, publicPort uint16) bool {
+	tr.Lock()
+	defer tr.Unlock()
+	m, ok := tr.public[mappingKey{protocol, endpoint{publicAddress, publicPort}}]
+	if !ok || !m.static {
+		return false
+	}
+	tr.removeMapping(mappingKey{m.proto, m.private}, m)
+	return true
+}
+
+// Mappings returns current number of dynamic and static mappings.
+func (tr *Translator) Mappings() int {
+	tr.RLock()
+	defer tr.RUnlock()
+	return len(tr.private)
+}
+
+// Should be called with write lock.
+func (tr *Translator) removeMapping(privateKey mappingKey, m *mapping) {
+	delete(tr.private, privateKey)
+	delete(tr.public, mappingKey{m.proto, m.public})
+	if m.timer != nil {
+		m.timer.Cancel()
+	}
+	tr.setPortUsed(m.proto, m.public, false)
+}
+
+func (tr *Translator) setPortUsed(proto uint8, e endpoint, used bool) {
+	pool, ok := tr.pools[e.addr]
+	if !ok || e.port < tr.portMin || e.port > tr.portMax {
+		return
+	}
+	ports := &pool.ports[protoIndex(proto)]
+	if used {
+		ports.take(e.port - tr.portMin)
+	} else {
+		ports.put(e.port - tr.portMin)
+	}
+}
+
+// Paired pooling: the same private address always uses the same public one.
+func (tr *Translator) publicAddress(private uint32) uint32 {
+	h := private * 2654435761
+	return tr.addresses[(h>>16)%uint32(len(tr.addresses))]
+}
+
+// Should be called with write lock. Private port is preserved if it is
+// free, otherwise any free port from the range is used.
+func (tr *Translator) allocatePort(proto uint8, addr uint32, preferred uint16) (uint16, bool) {
+	ports := &tr.pools[addr].ports[protoIndex(proto)]
+	if preferred >= tr.portMin && preferred <= tr.portMax && ports.take(preferred-tr.portMin) {
+		return preferred, true
+	}
+	i, ok := ports.pop()
+	return tr.portMin + i, ok
+}
+
+// outbound returns mapping for private endpoint. New mapping is created if
+// there is no such one.
+func (tr *Translator) outbound(proto uint8, addr uint32, port uint16) *mapping {
+	key := mappingKey{proto, endpoint{addr, port}}
+	now := atomic.LoadInt64(&tr.now)
+	tr.RLock()
+	m, ok := tr.private[key]
+	tr.RUnlock()
+	if ok {
+		atomic.StoreInt64(&m.lastSeen, now)
+		return m
+	}
+	tr.Lock()
+	defer tr.Unlock()
+	// Mapping could be created by other handler clone
+	if m, ok = tr.private[key]; ok {
+		return m
+	}
+	public := tr.publicAddress(addr)
+	publicPort, ok := tr.allocatePort(proto, public, port)
+	if !ok {
+		return nil
+	}
+	m = &mapping{
+		proto:    proto,
+		private:  key.endpoint,
+		public:   endpoint{public, publicPort},
+		lastSeen: now,
+	}
+	m.timer = tr.wheel.Add(time.Duration(tr.timeouts[protoIndex(proto)]), func(flow.UserContext) {
+		tr.expire(m, time.Now().UnixNano())
+	}, nil)
+	tr.private[key] = m
+	tr.public[mappingKey{proto, m.public}] = m
+	return m
+}
+
+// lookup returns mapping for private endpoint or nil if there is no such
+// one. New mapping isn't created.
+func (tr *Translator) lookup(proto uint8, addr uint32, port uint16) *mapping {
+	tr.RLock()
+	defer tr.RUnlock()
+	return tr.private[mappingKey{proto, endpoint{addr, port}}]
+}
+
+// inbound returns mapping for public endpoint or nil if there is no such one.
+func (tr *Translator) inbound(proto uint8, addr uint32, port uint16) *mapping {
+	tr.RLock()
+	m, ok := tr.public[mappingKey{proto, endpoint{addr, port}}]
+	tr.RUnlock()
+	if !ok {
+		return nil
+	}
+	if !m.static {
+		atomic.StoreInt64(&m.lastSeen, atomic.LoadInt64(&tr.now))
+	}
+	return m
+}
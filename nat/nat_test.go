@@ -0,0 +1,406 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"log"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/low"
+	"github.com/intel-go/nff-go/packet"
+)
+
+const payloadSize = 64
+
+var (
+	privateHost  = packet.BytesToIPv4(192, 168, 1, 10)
+	remoteHost   = packet.BytesToIPv4(8, 8, 8, 8)
+	publicAddr   = packet.BytesToIPv4(203, 0, 113, 1)
+	privateRoute = packet.BytesToIPv4(192, 168, 1, 1)
+)
+
+func init() {
+	argc, argv := low.InitDPDKArguments([]string{})
+	// burstSize=32, mbufNumber=8191, mbufCacheSize=250
+	if err := low.InitDPDK(argc, argv, 32, 8191, 250, 0); err != nil {
+		log.Fatal(err)
+	}
+	packet.SetNonPerfMempool(low.CreateMempool("Test"))
+}
+
+func getTestTranslator(t *testing.T, config *Config) *Translator {
+	if config == nil {
+		config = &Config{PublicAddresses: []uint32{publicAddr}}
+	}
+	tr, err := NewTranslator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func getPacket(t *testing.T) *packet.Packet {
+	pkt, err := packet.NewPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func fillIPv4(pkt *packet.Packet, src, dst uint32) {
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.SrcAddr = src
+	ipv4.DstAddr = dst
+}
+
+func getUDPPacket(t *testing.T, src uint32, srcPort uint16, dst uint32, dstPort uint16) *packet.Packet {
+	pkt := getPacket(t)
+	packet.InitEmptyIPv4UDPPacket(pkt, payloadSize)
+	fillIPv4(pkt, src, dst)
+	udp := pkt.GetUDPNoCheck()
+	udp.SrcPort = packet.SwapBytesUint16(srcPort)
+	udp.DstPort = packet.SwapBytesUint16(dstPort)
+	setChecksums(pkt)
+	return pkt
+}
+
+func getTCPPacket(t *testing.T, src uint32, srcPort uint16, dst uint32, dstPort uint16) *packet.Packet {
+	pkt := getPacket(t)
+	packet.InitEmptyIPv4TCPPacket(pkt, payloadSize)
+	fillIPv4(pkt, src, dst)
+	tcp := pkt.GetTCPNoCheck()
+	tcp.SrcPort = packet.SwapBytesUint16(srcPort)
+	tcp.DstPort = packet.SwapBytesUint16(dstPort)
+	setChecksums(pkt)
+	return pkt
+}
+
+func getEchoPacket(t *testing.T, src, dst uint32, icmpType uint8, id uint16) *packet.Packet {
+	pkt := getPacket(t)
+	packet.InitEmptyIPv4ICMPPacket(pkt, payloadSize)
+	fillIPv4(pkt, src, dst)
+	icmp := pkt.GetICMPNoCheck()
+	icmp.Type = icmpType
+	icmp.Identifier = packet.SwapBytesUint16(id)
+	icmp.SeqNum = packet.SwapBytesUint16(1)
+	setChecksums(pkt)
+	return pkt
+}
+
+// getICMPErrorPacket creates destination unreachable error which
+// contains first 8 bytes of L4 header of given packet.
+func getICMPErrorPacket(t *testing.T, src, dst uint32, original *packet.Packet) *packet.Packet {
+	pkt := getPacket(t)
+	packet.InitEmptyIPv4ICMPPacket(pkt, common.IPv4MinLen+8)
+	fillIPv4(pkt, src, dst)
+	icmp := pkt.GetICMPNoCheck()
+	icmp.Type = icmpTypeDestinationUnreachable
+	icmp.Code = 3
+	original.ParseL3()
+	raw := original.GetRawPacketBytes()[common.EtherLen : common.EtherLen+common.IPv4MinLen+8]
+	copy((*[common.IPv4MinLen + 8]byte)(pkt.Data)[:], raw)
+	setChecksums(pkt)
+	return pkt
+}
+
+func setChecksums(pkt *packet.Packet) {
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4NoCheck()
+	ipv4.HdrChecksum = packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4))
+	pkt.ParseL4ForIPv4()
+	switch ipv4.NextProtoID {
+	case common.TCPNumber:
+		tcp := pkt.GetTCPNoCheck()
+		tcp.Cksum = packet.SwapBytesUint16(packet.CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data))
+	case common.UDPNumber:
+		udp := pkt.GetUDPNoCheck()
+		udp.DgramCksum = packet.SwapBytesUint16(packet.CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data))
+	case common.ICMPNumber:
+		icmp := pkt.GetICMPNoCheck()
+		icmp.Cksum = packet.SwapBytesUint16(packet.CalculateIPv4ICMPChecksum(ipv4, icmp, pkt.Data))
+	}
+}
+
+// checkChecksums compares incrementally updated checksums with
+// checksums calculated from scratch.
+func checkChecksums(t *testing.T, pkt *packet.Packet) {
+	pkt.ParseL3()
+	ipv4 := pkt.GetIPv4NoCheck()
+	if got, want := ipv4.HdrChecksum, packet.SwapBytesUint16(packet.CalculateIPv4Checksum(ipv4)); got != want {
+		t.Errorf("Incorrect IPv4 checksum: got %x, want %x", got, want)
+	}
+	pkt.ParseL4ForIPv4()
+	switch ipv4.NextProtoID {
+	case common.TCPNumber:
+		tcp := pkt.GetTCPNoCheck()
+		if got, want := tcp.Cksum, packet.SwapBytesUint16(packet.CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data)); got != want {
+			t.Errorf("Incorrect TCP checksum: got %x, want %x", got, want)
+		}
+	case common.UDPNumber:
+		udp := pkt.GetUDPNoCheck()
+		if got, want := udp.DgramCksum, packet.SwapBytesUint16(packet.CalculateIPv4UDPChecksum(ipv4, udp, pkt.Data)); got != want {
+			t.Errorf("Incorrect UDP checksum: got %x, want %x", got, want)
+		}
+	case common.ICMPNumber:
+		icmp := pkt.GetICMPNoCheck()
+		if got, want := icmp.Cksum, packet.SwapBytesUint16(packet.CalculateIPv4ICMPChecksum(ipv4, icmp, pkt.Data)); got != want {
+			t.Errorf("Incorrect ICMP checksum: got %x, want %x", got, want)
+		}
+	}
+}
+
+func checkEndpoint(t *testing.T, name string, gotAddr uint32, gotPort uint16, wantAddr uint32, wantPort uint16) {
+	if gotAddr != wantAddr || packet.SwapBytesUint16(gotPort) != wantPort {
+		t.Errorf("Incorrect %s: got %s:%d, want %s:%d", name,
+			packet.IPv4ToString(gotAddr), packet.SwapBytesUint16(gotPort),
+			packet.IPv4ToString(wantAddr), wantPort)
+	}
+}
+
+func TestSNATUDP(t *testing.T) {
+	tr := getTestTranslator(t, nil)
+
+	out := getUDPPacket(t, privateHost, 5000, remoteHost, 53)
+	if !tr.PrivateToPublic(out, nil) {
+		t.Fatal("Outgoing packet was dropped")
+	}
+	udp := out.GetUDPNoCheck()
+	checkEndpoint(t, "source", out.GetIPv4NoCheck().SrcAddr, udp.SrcPort, publicAddr, 5000)
+	checkChecksums(t, out)
+
+	in := getUDPPacket(t, remoteHost, 53, publicAddr, 5000)
+	if !tr.PublicToPrivate(in, nil) {
+		t.Fatal("Reply packet was dropped")
+	}
+	udp = in.GetUDPNoCheck()
+	checkEndpoint(t, "destination", in.GetIPv4NoCheck().DstAddr, udp.DstPort, privateHost, 5000)
+	checkChecksums(t, in)
+
+	unknown := getUDPPacket(t, remoteHost, 53, publicAddr, 5001)
+	if tr.PublicToPrivate(unknown, nil) {
+		t.Error("Packet without mapping wasn't dropped")
+	}
+}
+
+func TestSNATPortAllocation(t *testing.T) {
+	tr := getTestTranslator(t, &Config{
+		PublicAddresses: []uint32{publicAddr},
+		PortMin:         2000,
+		PortMax:         2001,
+	})
+	// Private port is out of range, so ports are allocated from range
+	for i, want := range []uint16{2000, 2001} {
+		pkt := getTCPPacket(t, privateHost+uint32(i)<<24, 80, remoteHost, 80)
+		if !tr.PrivateToPublic(pkt, nil) {
+			t.Fatal("Outgoing packet was dropped")
+		}
+		tcp := pkt.GetTCPNoCheck()
+		checkEndpoint(t, "source", pkt.GetIPv4NoCheck().SrcAddr, tcp.SrcPort, publicAddr, want)
+		checkChecksums(t, pkt)
+	}
+	pkt := getTCPPacket(t, privateHost+3<<24, 80, remoteHost, 80)
+	if tr.PrivateToPublic(pkt, nil) {
+		t.Error("Packet was translated when port range was exhausted")
+	}
+	if pkt.GetDropReason() != tr.noPorts {
+		t.Error("Packet dropped because of port exhaustion isn't tagged")
+	}
+}
+
+func TestDNAT(t *testing.T) {
+	tr := getTestTranslator(t, nil)
+	if err := tr.AddDNATRule(DNATRule{
+		Protocol:       common.TCPNumber,
+		PublicAddress:  publicAddr,
+		PublicPort:     8080,
+		PrivateAddress: privateHost,
+		PrivatePort:    80,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	in := getTCPPacket(t, remoteHost, 40000, publicAddr, 8080)
+	if !tr.PublicToPrivate(in, nil) {
+		t.Fatal("Forwarded packet was dropped")
+	}
+	checkEndpoint(t, "destination", in.GetIPv4NoCheck().DstAddr, in.GetTCPNoCheck().DstPort, privateHost, 80)
+	checkChecksums(t, in)
+
+	out := getTCPPacket(t, privateHost, 80, remoteHost, 40000)
+	if !tr.PrivateToPublic(out, nil) {
+		t.Fatal("Reply packet was dropped")
+	}
+	checkEndpoint(t, "source", out.GetIPv4NoCheck().SrcAddr, out.GetTCPNoCheck().SrcPort, publicAddr, 8080)
+	checkChecksums(t, out)
+
+	if !tr.RemoveDNATRule(common.TCPNumber, publicAddr, 8080) {
+		t.Error("DNAT rule wasn't removed")
+	}
+	in = getTCPPacket(t, remoteHost, 40000, publicAddr, 8080)
+	if tr.PublicToPrivate(in, nil) {
+		t.Error("Packet was forwarded after rule removal")
+	}
+}
+
+func TestICMPEcho(t *testing.T) {
+	tr := getTestTranslator(t, nil)
+
+	out := getEchoPacket(t, privateHost, remoteHost, common.ICMPTypeEchoRequest, 4000)
+	if !tr.PrivateToPublic(out, nil) {
+		t.Fatal("Echo request was dropped")
+	}
+	icmp := out.GetICMPNoCheck()
+	checkEndpoint(t, "source", out.GetIPv4NoCheck().SrcAddr, icmp.Identifier, publicAddr, 4000)
+	checkChecksums(t, out)
+
+	in := getEchoPacket(t, remoteHost, publicAddr, common.ICMPTypeEchoResponse, 4000)
+	if !tr.PublicToPrivate(in, nil) {
+		t.Fatal("Echo reply was dropped")
+	}
+	icmp = in.GetICMPNoCheck()
+	checkEndpoint(t, "destination", in.GetIPv4NoCheck().DstAddr, icmp.Identifier, privateHost, 4000)
+	checkChecksums(t, in)
+}
+
+func TestICMPError(t *testing.T) {
+	tr := getTestTranslator(t, nil)
+
+	out := getUDPPacket(t, privateHost, 5000, remoteHost, 53)
+	if !tr.PrivateToPublic(out, nil) {
+		t.Fatal("Outgoing packet was dropped")
+	}
+
+	// Remote side answers with error which contains translated packet
+	in := getICMPErrorPacket(t, remoteHost, publicAddr, out)
+	if !tr.PublicToPrivate(in, nil) {
+		t.Fatal("ICMP error was dropped")
+	}
+	if in.GetIPv4NoCheck().DstAddr != privateHost {
+		t.Errorf("Incorrect outer destination: %s", packet.IPv4ToString(in.GetIPv4NoCheck().DstAddr))
+	}
+	inner := (*packet.IPv4Hdr)(in.Data)
+	innerUDP := (*packet.UDPHdr)(unsafe.Pointer(uintptr(in.Data) + common.IPv4MinLen))
+	checkEndpoint(t, "inner source", inner.SrcAddr, innerUDP.SrcPort, privateHost, 5000)
+	if got, want := inner.HdrChecksum, packet.SwapBytesUint16(packet.CalculateIPv4Checksum(inner)); got != want {
+		t.Errorf("Incorrect inner IPv4 checksum: got %x, want %x", got, want)
+	}
+	checkChecksums(t, in)
+
+	// Private router reports error about packet which goes to private host
+	reply := getUDPPacket(t, remoteHost, 53, privateHost, 5000)
+	errOut := getICMPErrorPacket(t, privateRoute, remoteHost, reply)
+	if !tr.PrivateToPublic(errOut, nil) {
+		t.Fatal("ICMP error was dropped")
+	}
+	if errOut.GetIPv4NoCheck().SrcAddr != publicAddr {
+		t.Errorf("Incorrect outer source: %s", packet.IPv4ToString(errOut.GetIPv4NoCheck().SrcAddr))
+	}
+	inner = (*packet.IPv4Hdr)(errOut.Data)
+	innerUDP = (*packet.UDPHdr)(unsafe.Pointer(uintptr(errOut.Data) + common.IPv4MinLen))
+	checkEndpoint(t, "inner destination", inner.DstAddr, innerUDP.DstPort, publicAddr, 5000)
+	checkChecksums(t, errOut)
+}
+
+func TestExpire(t *testing.T) {
+	tr := getTestTranslator(t, &Config{
+		PublicAddresses: []uint32{publicAddr},
+		UDPTimeout:      time.Minute,
+	})
+	if err := tr.AddDNATRule(DNATRule{common.UDPNumber, publicAddr, 53, privateHost, 53}); err != nil {
+		t.Fatal(err)
+	}
+	pkt := getUDPPacket(t, privateHost, 5000, remoteHost, 53)
+	if !tr.PrivateToPublic(pkt, nil) {
+		t.Fatal("Outgoing packet was dropped")
+	}
+	if tr.Mappings() != 2 {
+		t.Fatalf("Incorrect number of mappings: got %d, want 2", tr.Mappings())
+	}
+	m := tr.lookup(common.UDPNumber, privateHost, 5000)
+	start := m.lastSeen
+	tr.expire(m, start+int64(30*time.Second))
+	if tr.Mappings() != 2 {
+		t.Errorf("Mapping expired too early")
+	}
+	if !m.timer.Cancel() {
+		t.Errorf("Timer of used mapping wasn't restarted")
+	}
+	tr.expire(m, start+int64(2*time.Minute))
+	if tr.Mappings() != 1 {
+		t.Errorf("Incorrect number of mappings after expiration: got %d, want 1", tr.Mappings())
+	}
+	if tr.pools[publicAddr].ports[protoUDP].used(5000 - tr.portMin) {
+		t.Errorf("Port of expired mapping wasn't released")
+	}
+}
+
+func TestExpireTimer(t *testing.T) {
+	tr := getTestTranslator(t, &Config{
+		PublicAddresses: []uint32{publicAddr},
+		UDPTimeout:      50 * time.Millisecond,
+		CheckInterval:   10 * time.Millisecond,
+	})
+	defer tr.Stop()
+	pkt := getUDPPacket(t, privateHost, 5000, remoteHost, 53)
+	if !tr.PrivateToPublic(pkt, nil) {
+		t.Fatal("Outgoing packet was dropped")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.Mappings() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tr.Mappings() != 0 {
+		t.Fatal("Mapping didn't expire")
+	}
+	// Released port is allocated again
+	pkt = getUDPPacket(t, privateHost+1<<24, 5000, remoteHost, 53)
+	if !tr.PrivateToPublic(pkt, nil) {
+		t.Fatal("Outgoing packet was dropped")
+	}
+	checkEndpoint(t, "source", pkt.GetIPv4NoCheck().SrcAddr, pkt.GetUDPNoCheck().SrcPort, publicAddr, 5000)
+}
+
+func TestTranslatorGraph(t *testing.T) {
+	data := getUDPPacket(t, privateHost, 5000, remoteHost, 53).GetRawPacketBytes()
+	// Framework replaces mempool of packets which are created by tests
+	defer func() {
+		packet.SetNonPerfMempool(low.CreateMempool("Test"))
+	}()
+	flow.CheckFatal(flow.SystemInit(&flow.Config{Simulation: true, SimulationPorts: 2, DisableScheduler: true,
+		ScaleTime: 10, LogType: common.No}))
+	tr, err := NewTranslator(&Config{PublicAddresses: []uint32{publicAddr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Stop()
+	in, err := flow.SetReceiver(0)
+	flow.CheckFatal(err)
+	flow.CheckFatal(flow.SetHandlerDrop(in, tr.PrivateToPublic, nil))
+	flow.CheckFatal(flow.SetSender(in, 1))
+	go flow.SystemStart()
+
+	flow.CheckFatal(flow.InjectPackets(0, data))
+	sent, err := flow.WaitSentPackets(1, 1, 10*time.Second)
+	stopped := make(chan error)
+	go func() {
+		stopped <- flow.SystemStop()
+	}()
+	select {
+	case stopErr := <-stopped:
+		flow.CheckFatal(stopErr)
+	case <-time.After(10 * time.Second):
+		t.Fatal("SystemStop doesn't return")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Mappings() != 1 || len(sent) != 1 {
+		t.Errorf("Packet isn't translated, %d mappings", tr.Mappings())
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nat
+
+import (
+	"unsafe"
+
+	"github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/flow"
+	"github.com/intel-go/nff-go/packet"
+)
+
+const (
+	icmpTypeDestinationUnreachable uint8 = 3
+	icmpTypeTimeExceeded           uint8 = 11
+	icmpTypeParameterProblem       uint8 = 12
+)
+
+// transport points to fields of L4 header which are changed by translation.
+// ICMP query identifier is used as both source and destination port.
+type transport struct {
+	srcPort *uint16
+	dstPort *uint16
+	cksum   *uint16 // nil if checksum is out of available data
+	pseudo  bool    // checksum covers IPv4 addresses
+	udp     bool    // zero checksum means no checksum
+}
+
+func getTransport(proto uint8, l4 unsafe.Pointer, length uintptr, inner bool) (t transport, ok bool) {
+	switch proto {
+	case common.TCPNumber:
+		// Only 8 bytes of L4 header are guaranteed inside ICMP error
+		if length < 8 || (!inner && length < common.TCPMinLen) {
+			return t, false
+		}
+		tcp := (*packet.TCPHdr)(l4)
+		t.srcPort, t.dstPort = &tcp.SrcPort, &tcp.DstPort
+		if length >= unsafe.Offsetof(tcp.Cksum)+2 {
+			t.cksum = &tcp.Cksum
+		}
+		t.pseudo = true
+	case common.UDPNumber:
+		if length < common.UDPLen {
+			return t, false
+		}
+		udp := (*packet.UDPHdr)(l4)
+		t.srcPort, t.dstPort, t.cksum = &udp.SrcPort, &udp.DstPort, &udp.DgramCksum
+		t.pseudo, t.udp = true, true
+	case common.ICMPNumber:
+		if length < common.ICMPLen {
+			return t, false
+		}
+		icmp := (*packet.ICMPHdr)(l4)
+		if icmp.Type != common.ICMPTypeEchoRequest && icmp.Type != common.ICMPTypeEchoResponse {
+			return t, false
+		}
+		t.srcPort, t.dstPort, t.cksum = &icmp.Identifier, &icmp.Identifier, &icmp.Cksum
+	default:
+		return t, false
+	}
+	return t, true
+}
+
+func isICMPError(icmpType uint8) bool {
+	switch icmpType {
+	case icmpTypeDestinationUnreachable, icmpTypeTimeExceeded, icmpTypeParameterProblem:
+		return true
+	}
+	return false
+}
+
+// Non-first fragments have no L4 header and can't be translated.
+func isFragment(ipv4 *packet.IPv4Hdr) bool {
+	return packet.SwapBytesUint16(ipv4.FragmentOffset)&0x1fff != 0
+}
+
+// l4Length returns number of packet bytes starting from L4 header.
+func l4Length(pkt *packet.Packet) uintptr {
+	offset := uintptr(pkt.L4) - uintptr(unsafe.Pointer(pkt.Ether))
+	length := uintptr(pkt.GetPacketLen())
+	if offset > length {
+		return 0
+	}
+	return length - offset
+}
+
+// rewrite sets new address and port to given fields of packet and fixes
+// IPv4 header and L4 checksums.
+func rewrite(ipv4 *packet.IPv4Hdr, addr *uint32, port *uint16, t *transport, e endpoint) {
+	newAddr := e.addr
+	newPort := packet.SwapBytesUint16(e.port)
+	if t.cksum != nil && !(t.udp && *t.cksum == 0) {
+		if t.pseudo {
+			*t.cksum = packet.UpdateChecksum32(*t.cksum, *addr, newAddr)
+		}
+		*t.cksum = packet.UpdateChecksum16(*t.cksum, *port, newPort)
+		if t.udp && *t.cksum == 0 {
+			*t.cksum = 0xffff
+		}
+	}
+	ipv4.HdrChecksum = packet.UpdateChecksum32(ipv4.HdrChecksum, *addr, newAddr)
+	*addr = newAddr
+	*port = newPort
+}
+
+// PrivateToPublic translates packet which goes from private network.
+// Source endpoint is changed to public one, new mapping is allocated
+// if it is required. Function has SeparateFunction type. Returns false
+// if packet can't be translated and should be dropped.
+func (tr *Translator) PrivateToPublic(pkt *packet.Packet, ctx flow.UserContext) bool {
+	pkt.ParseL3CheckVLAN()
+	ipv4 := pkt.GetIPv4CheckVLAN()
+	if ipv4 == nil {
+		return true
+	}
+	if isFragment(ipv4) {
+		return false
+	}
+	pkt.ParseL4ForIPv4()
+	length := l4Length(pkt)
+	if ipv4.NextProtoID == common.ICMPNumber && length >= common.ICMPLen &&
+		isICMPError(pkt.GetICMPNoCheck().Type) {
+		return tr.translateICMPError(pkt, ipv4, length, true)
+	}
+	t, ok := getTransport(ipv4.NextProtoID, pkt.L4, length, false)
+	if !ok {
+		return false
+	}
+	m := tr.outbound(ipv4.NextProtoID, ipv4.SrcAddr, packet.SwapBytesUint16(*t.srcPort))
+	if m == nil {
+		pkt.SetDropReason(tr.noPorts)
+		return false
+	}
+	rewrite(ipv4, &ipv4.SrcAddr, t.srcPort, &t, m.public)
+	return true
+}
+
+// PublicToPrivate translates packet which goes from public network.
+// Destination endpoint is changed to private one according to existing
+// mapping. Function has SeparateFunction type. Returns false if there is
+// no mapping for packet and it should be dropped.
+func (tr *Translator) PublicToPrivate(pkt *packet.Packet, ctx flow.UserContext) bool {
+	pkt.ParseL3CheckVLAN()
+	ipv4 := pkt.GetIPv4CheckVLAN()
+	if ipv4 == nil {
+		return true
+	}
+	if isFragment(ipv4) {
+		return false
+	}
+	pkt.ParseL4ForIPv4()
+	length := l4Length(pkt)
+	if ipv4.NextProtoID == common.ICMPNumber && length >= common.ICMPLen &&
+		isICMPError(pkt.GetICMPNoCheck().Type) {
+		return tr.translateICMPError(pkt, ipv4, length, false)
+	}
+	t, ok := getTransport(ipv4.NextProtoID, pkt.L4, length, false)
+	if !ok {
+		return false
+	}
+	m := tr.inbound(ipv4.NextProtoID, ipv4.DstAddr, packet.SwapBytesUint16(*t.dstPort))
+	if m == nil {
+		return false
+	}
+	rewrite(ipv4, &ipv4.DstAddr, t.dstPort, &t, m.private)
+	return true
+}
+
+// translateICMPError translates ICMP error and packet inside it, which
+// is a packet of reverse direction. For example error from private host
+// contains packet which was sent to this host, so inner destination is
+// translated together with outer source.
+func (tr *Translator) translateICMPError(pkt *packet.Packet, ipv4 *packet.IPv4Hdr, length uintptr, outbound bool) bool {
+	if length < common.ICMPLen+common.IPv4MinLen {
+		return false
+	}
+	icmp := pkt.GetICMPNoCheck()
+	inner := (*packet.IPv4Hdr)(unsafe.Pointer(uintptr(pkt.L4) + common.ICMPLen))
+	innerLen := uintptr(inner.VersionIhl&0x0f) << 2
+	if innerLen < common.IPv4MinLen || length < common.ICMPLen+innerLen {
+		return false
+	}
+	innerL4 := unsafe.Pointer(uintptr(unsafe.Pointer(inner)) + innerLen)
+	t, ok := getTransport(inner.NextProtoID, innerL4, length-common.ICMPLen-innerLen, true)
+	if !ok {
+		return false
+	}
+
+	var m *mapping
+	var e endpoint
+	var addr, outerAddr *uint32
+	var port *uint16
+	if outbound {
+		m = tr.lookup(inner.NextProtoID, inner.DstAddr, packet.SwapBytesUint16(*t.dstPort))
+		if m == nil {
+			return false
+		}
+		e, addr, port, outerAddr = m.public, &inner.DstAddr, t.dstPort, &ipv4.SrcAddr
+	} else {
+		m = tr.inbound(inner.NextProtoID, inner.SrcAddr, packet.SwapBytesUint16(*t.srcPort))
+		if m == nil {
+			return false
+		}
+		e, addr, port, outerAddr = m.private, &inner.SrcAddr, t.srcPort, &ipv4.DstAddr
+	}
+
+	// ICMP checksum covers the whole inner packet, so it is fixed for
+	// every changed field of inner headers.
+	oldAddr, oldPort, oldHdrChecksum := *addr, *port, inner.HdrChecksum
+	var oldCksum uint16
+	if t.cksum != nil {
+		oldCksum = *t.cksum
+	}
+	rewrite(inner, addr, port, &t, e)
+	cksum := packet.UpdateChecksum32(icmp.Cksum, oldAddr, *addr)
+	cksum = packet.UpdateChecksum16(cksum, oldPort, *port)
+	cksum = packet.UpdateChecksum16(cksum, oldHdrChecksum, inner.HdrChecksum)
+	if t.cksum != nil {
+		cksum = packet.UpdateChecksum16(cksum, oldCksum, *t.cksum)
+	}
+	icmp.Cksum = cksum
+
+	ipv4.HdrChecksum = packet.UpdateChecksum32(ipv4.HdrChecksum, *outerAddr, e.addr)
+	*outerAddr = e.addr
+	return true
+}
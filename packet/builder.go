@@ -0,0 +1,500 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+type builderLayerType int
+
+const (
+	etherLayer builderLayerType = iota
+	vlanLayer
+	mplsLayer
+	ipv4Layer
+	ipv6Layer
+	tcpLayer
+	udpLayer
+	icmpLayer
+	gtpLayer
+	payloadLayer
+)
+
+var builderLayerNames = [...]string{"Ether", "VLAN", "MPLS", "IPv4", "IPv6", "TCP", "UDP", "ICMP", "GTP", "payload"}
+
+type builderLayer struct {
+	kind builderLayerType
+	hdr  []byte
+	// Next protocol field was set explicitly and shouldn't be
+	// calculated from next header
+	nextSet bool
+}
+
+// Builder composes packet from arbitrary stack of headers. Headers are
+// added from the outermost to the innermost one, field setters change
+// the last added header. Next protocol types, lengths and checksums are
+// calculated automatically when packet is built, checksums are always
+// calculated in software. Addresses are in network byte order as in
+// header structures, all other values are in host byte order.
+//
+// Example of GTP-U tunnelled packet:
+//
+//	data, err := packet.NewBuilder().
+//		Ether(srcMAC, dstMAC).VLAN(100).
+//		IPv6(srcIPv6, dstIPv6).UDP(2152, UDPPortGTPU).GTP(teid).
+//		IPv4(srcIPv4, dstIPv4).TTL(32).
+//		TCP(1000, 80).Flags(TCPFlagSyn).
+//		Payload(payload).Bytes()
+type Builder struct {
+	layers []builderLayer
+	err    error
+}
+
+// NewBuilder returns empty packet builder.
+func NewBuilder() *Builder {
+	return new(Builder)
+}
+
+func (b *Builder) add(kind builderLayerType, length int) []byte {
+	b.layers = append(b.layers, builderLayer{kind: kind, hdr: make([]byte, length)})
+	return b.layers[len(b.layers)-1].hdr
+}
+
+// last returns header of the last layer if it has one of given types.
+// Otherwise error is remembered and nil is returned.
+func (b *Builder) last(field string, kinds ...builderLayerType) *builderLayer {
+	if len(b.layers) != 0 {
+		l := &b.layers[len(b.layers)-1]
+		for _, k := range kinds {
+			if l.kind == k {
+				return l
+			}
+		}
+	}
+	if b.err == nil {
+		msg := "Builder: " + field + " can't be set for "
+		if len(b.layers) == 0 {
+			msg += "empty packet"
+		} else {
+			msg += builderLayerNames[b.layers[len(b.layers)-1].kind] + " header"
+		}
+		b.err = WrapWithNFError(nil, msg, BadArgument)
+	}
+	return nil
+}
+
+// Ether adds Ethernet header. EtherType is taken from next header.
+func (b *Builder) Ether(src, dst [EtherAddrLen]uint8) *Builder {
+	hdr := b.add(etherLayer, EtherLen)
+	copy(hdr[0:], dst[:])
+	copy(hdr[EtherAddrLen:], src[:])
+	return b
+}
+
+// EtherType sets EtherType of last Ethernet or VLAN header explicitly.
+func (b *Builder) EtherType(t uint16) *Builder {
+	if l := b.last("EtherType", etherLayer, vlanLayer); l != nil {
+		binary.BigEndian.PutUint16(l.hdr[len(l.hdr)-2:], t)
+		l.nextSet = true
+	}
+	return b
+}
+
+// VLAN adds 802.1Q VLAN tag with given VLAN ID.
+func (b *Builder) VLAN(id uint16) *Builder {
+	hdr := b.add(vlanLayer, VLANLen)
+	binary.BigEndian.PutUint16(hdr, id&0x0fff)
+	return b
+}
+
+// Priority sets PCP field of last VLAN tag.
+func (b *Builder) Priority(pcp uint8) *Builder {
+	if l := b.last("Priority", vlanLayer); l != nil {
+		tci := binary.BigEndian.Uint16(l.hdr)
+		binary.BigEndian.PutUint16(l.hdr, tci&0x1fff|uint16(pcp&0x7)<<13)
+	}
+	return b
+}
+
+// MPLS adds MPLS label. TTL is 64 by default. Bottom of stack bit is
+// set for the last label of the stack.
+func (b *Builder) MPLS(label uint32) *Builder {
+	hdr := b.add(mplsLayer, MPLSLen)
+	binary.BigEndian.PutUint32(hdr, label<<12|64)
+	return b
+}
+
+// IPv4 adds IPv4 header without options. TTL is 64 by default.
+func (b *Builder) IPv4(src, dst uint32) *Builder {
+	hdr := b.add(ipv4Layer, IPv4MinLen)
+	hdr[0] = IPv4VersionIhl
+	hdr[8] = 64
+	hdr[9] = NoNextHeader
+	binary.LittleEndian.PutUint32(hdr[12:], src)
+	binary.LittleEndian.PutUint32(hdr[16:], dst)
+	return b
+}
+
+// IPv6 adds IPv6 header without extension headers. Hop limit is 255 by
+// default.
+func (b *Builder) IPv6(src, dst [IPv6AddrLen]uint8) *Builder {
+	hdr := b.add(ipv6Layer, IPv6Len)
+	hdr[0] = IPv6VtcFlow
+	hdr[6] = NoNextHeader
+	hdr[7] = 255
+	copy(hdr[8:], src[:])
+	copy(hdr[24:], dst[:])
+	return b
+}
+
+// TTL sets TTL of last IPv4 header or MPLS label or hop limit of last
+// IPv6 header.
+func (b *Builder) TTL(ttl uint8) *Builder {
+	if l := b.last("TTL", ipv4Layer, ipv6Layer, mplsLayer); l != nil {
+		switch l.kind {
+		case ipv4Layer:
+			l.hdr[8] = ttl
+		case ipv6Layer:
+			l.hdr[7] = ttl
+		case mplsLayer:
+			l.hdr[3] = ttl
+		}
+	}
+	return b
+}
+
+// TrafficClass sets type of service of last IPv4 header, traffic class
+// of last IPv6 header or 3 bits traffic class of last MPLS label.
+func (b *Builder) TrafficClass(tc uint8) *Builder {
+	if l := b.last("TrafficClass", ipv4Layer, ipv6Layer, mplsLayer); l != nil {
+		switch l.kind {
+		case ipv4Layer:
+			l.hdr[1] = tc
+		case ipv6Layer:
+			vtc := binary.BigEndian.Uint32(l.hdr)
+			binary.BigEndian.PutUint32(l.hdr, vtc&^(0xff<<20)|uint32(tc)<<20)
+		case mplsLayer:
+			l.hdr[2] = l.hdr[2]&^0x0e | (tc&0x7)<<1
+		}
+	}
+	return b
+}
+
+// ID sets packet ID of last IPv4 header.
+func (b *Builder) ID(id uint16) *Builder {
+	if l := b.last("ID", ipv4Layer); l != nil {
+		binary.BigEndian.PutUint16(l.hdr[4:], id)
+	}
+	return b
+}
+
+// DontFragment sets DF flag of last IPv4 header.
+func (b *Builder) DontFragment() *Builder {
+	if l := b.last("DontFragment", ipv4Layer); l != nil {
+		l.hdr[6] |= 0x40
+	}
+	return b
+}
+
+// FlowLabel sets flow label of last IPv6 header.
+func (b *Builder) FlowLabel(label uint32) *Builder {
+	if l := b.last("FlowLabel", ipv6Layer); l != nil {
+		vtc := binary.BigEndian.Uint32(l.hdr)
+		binary.BigEndian.PutUint32(l.hdr, vtc&^0xfffff|label&0xfffff)
+	}
+	return b
+}
+
+// Protocol sets next protocol of last IPv4 or IPv6 header explicitly.
+func (b *Builder) Protocol(proto uint8) *Builder {
+	if l := b.last("Protocol", ipv4Layer, ipv6Layer); l != nil {
+		if l.kind == ipv4Layer {
+			l.hdr[9] = proto
+		} else {
+			l.hdr[6] = proto
+		}
+		l.nextSet = true
+	}
+	return b
+}
+
+// TCP adds TCP header without options.
+func (b *Builder) TCP(src, dst uint16) *Builder {
+	hdr := b.add(tcpLayer, TCPMinLen)
+	binary.BigEndian.PutUint16(hdr[0:], src)
+	binary.BigEndian.PutUint16(hdr[2:], dst)
+	hdr[12] = TCPMinDataOffset
+	return b
+}
+
+// Seq sets sequence number of last TCP header.
+func (b *Builder) Seq(seq uint32) *Builder {
+	if l := b.last("Seq", tcpLayer); l != nil {
+		binary.BigEndian.PutUint32(l.hdr[4:], seq)
+	}
+	return b
+}
+
+// Ack sets acknowledgement number of last TCP header.
+func (b *Builder) Ack(ack uint32) *Builder {
+	if l := b.last("Ack", tcpLayer); l != nil {
+		binary.BigEndian.PutUint32(l.hdr[8:], ack)
+	}
+	return b
+}
+
+// Flags sets flags of last TCP header.
+func (b *Builder) Flags(flags TCPFlags) *Builder {
+	if l := b.last("Flags", tcpLayer); l != nil {
+		l.hdr[13] = uint8(flags)
+	}
+	return b
+}
+
+// Window sets receive window of last TCP header.
+func (b *Builder) Window(window uint16) *Builder {
+	if l := b.last("Window", tcpLayer); l != nil {
+		binary.BigEndian.PutUint16(l.hdr[14:], window)
+	}
+	return b
+}
+
+// UDP adds UDP header.
+func (b *Builder) UDP(src, dst uint16) *Builder {
+	hdr := b.add(udpLayer, UDPLen)
+	binary.BigEndian.PutUint16(hdr[0:], src)
+	binary.BigEndian.PutUint16(hdr[2:], dst)
+	return b
+}
+
+// ICMP adds ICMP header with given type and code. It is ICMPv6 header
+// if it follows IPv6 header.
+func (b *Builder) ICMP(t, code uint8) *Builder {
+	hdr := b.add(icmpLayer, ICMPLen)
+	hdr[0] = t
+	hdr[1] = code
+	return b
+}
+
+// Echo sets identifier and sequence number of last ICMP header.
+func (b *Builder) Echo(id, seq uint16) *Builder {
+	if l := b.last("Echo", icmpLayer); l != nil {
+		binary.BigEndian.PutUint16(l.hdr[4:], id)
+		binary.BigEndian.PutUint16(l.hdr[6:], seq)
+	}
+	return b
+}
+
+// GTP adds GTPv1-U header of G-PDU message without optional fields.
+func (b *Builder) GTP(teid uint32) *Builder {
+	hdr := b.add(gtpLayer, GTPMinLen)
+	// Version 1, protocol type GTP
+	hdr[0] = 0x30
+	hdr[1] = G_PDU
+	binary.BigEndian.PutUint32(hdr[4:], teid)
+	return b
+}
+
+// MessageType sets message type of last GTP header.
+func (b *Builder) MessageType(t uint8) *Builder {
+	if l := b.last("MessageType", gtpLayer); l != nil {
+		l.hdr[1] = t
+	}
+	return b
+}
+
+// Payload adds raw data. Data is copied.
+func (b *Builder) Payload(data []byte) *Builder {
+	copy(b.add(payloadLayer, len(data)), data)
+	return b
+}
+
+// Len returns length of packet which will be built.
+func (b *Builder) Len() int {
+	n := 0
+	for i := range b.layers {
+		n += len(b.layers[i].hdr)
+	}
+	return n
+}
+
+func builderEtherType(next builderLayerType) uint16 {
+	switch next {
+	case vlanLayer:
+		return VLANNumber
+	case mplsLayer:
+		return MPLSNumber
+	case ipv4Layer:
+		return IPV4Number
+	case ipv6Layer:
+		return IPV6Number
+	}
+	return 0
+}
+
+func builderProtocol(next builderLayerType, ipv6 bool) uint8 {
+	switch next {
+	case ipv4Layer:
+		return IPNumber
+	case ipv6Layer:
+		return 41
+	case tcpLayer:
+		return TCPNumber
+	case udpLayer:
+		return UDPNumber
+	case icmpLayer:
+		if ipv6 {
+			return ICMPv6Number
+		}
+		return ICMPNumber
+	}
+	return NoNextHeader
+}
+
+// builderSum adds bytes of b as 16 bit big endian words to sum.
+func builderSum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)&1 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// pseudoHeaderSum returns checksum of IPv4 or IPv6 pseudo header for
+// L4 segment of given length.
+func pseudoHeaderSum(ip []byte, proto uint8, length int) uint32 {
+	var sum uint32
+	if ip[0]>>4 == 4 {
+		sum = builderSum(0, ip[12:20])
+	} else {
+		sum = builderSum(0, ip[8:40])
+	}
+	return sum + uint32(proto) + uint32(length)
+}
+
+// Bytes returns built packet or error if some field setter was used
+// incorrectly.
+func (b *Builder) Bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	data := make([]byte, b.Len())
+	offsets := make([]int, len(b.layers))
+	off := 0
+	for i := range b.layers {
+		offsets[i] = off
+		off += copy(data[off:], b.layers[i].hdr)
+	}
+
+	// Inner headers are finished first because outer checksums
+	// depend on them.
+	for i := len(b.layers) - 1; i >= 0; i-- {
+		l := &b.layers[i]
+		hdr := data[offsets[i]:]
+		next := payloadLayer
+		if i+1 < len(b.layers) {
+			next = b.layers[i+1].kind
+		}
+		// Nearest outer IP header is used for L4 checksums
+		var ip []byte
+		for j := i - 1; j >= 0; j-- {
+			if b.layers[j].kind == ipv4Layer || b.layers[j].kind == ipv6Layer {
+				ip = data[offsets[j]:]
+				break
+			}
+		}
+		switch l.kind {
+		case etherLayer, vlanLayer:
+			if !l.nextSet {
+				binary.BigEndian.PutUint16(hdr[len(l.hdr)-2:], builderEtherType(next))
+			}
+		case mplsLayer:
+			if next != mplsLayer {
+				hdr[2] |= 0x01
+			}
+		case ipv4Layer:
+			if !l.nextSet {
+				hdr[9] = builderProtocol(next, false)
+			}
+			binary.BigEndian.PutUint16(hdr[2:], uint16(len(hdr)))
+			binary.BigEndian.PutUint16(hdr[10:], 0)
+			binary.BigEndian.PutUint16(hdr[10:], ^reduceChecksum(builderSum(0, hdr[:IPv4MinLen])))
+		case ipv6Layer:
+			if !l.nextSet {
+				hdr[6] = builderProtocol(next, true)
+			}
+			binary.BigEndian.PutUint16(hdr[4:], uint16(len(hdr)-IPv6Len))
+		case tcpLayer:
+			binary.BigEndian.PutUint16(hdr[16:], 0)
+			if ip != nil {
+				sum := builderSum(pseudoHeaderSum(ip, TCPNumber, len(hdr)), hdr)
+				binary.BigEndian.PutUint16(hdr[16:], ^reduceChecksum(sum))
+			}
+		case udpLayer:
+			binary.BigEndian.PutUint16(hdr[4:], uint16(len(hdr)))
+			binary.BigEndian.PutUint16(hdr[6:], 0)
+			if ip != nil {
+				cksum := ^reduceChecksum(builderSum(pseudoHeaderSum(ip, UDPNumber, len(hdr)), hdr))
+				// Zero checksum is sent as all ones
+				if cksum == 0 {
+					cksum = 0xffff
+				}
+				binary.BigEndian.PutUint16(hdr[6:], cksum)
+			}
+		case icmpLayer:
+			binary.BigEndian.PutUint16(hdr[2:], 0)
+			var sum uint32
+			if ip != nil && ip[0]>>4 == 6 {
+				sum = pseudoHeaderSum(ip, ICMPv6Number, len(hdr))
+			}
+			binary.BigEndian.PutUint16(hdr[2:], ^reduceChecksum(builderSum(sum, hdr)))
+		case gtpLayer:
+			binary.BigEndian.PutUint16(hdr[2:], uint16(len(hdr)-GTPMinLen))
+		}
+	}
+	return data, nil
+}
+
+// Build writes built packet to empty packet and sets its L3, L4 and
+// Data pointers to the first IP header, header after it and payload.
+func (b *Builder) Build(packet *Packet) error {
+	data, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	if !GeneratePacketFromByte(packet, data) {
+		return WrapWithNFError(nil, "Builder: packet doesn't fit into mbuf", BadArgument)
+	}
+	ptr := uintptr(unsafe.Pointer(packet.Ether))
+	packet.L3 = nil
+	packet.L4 = nil
+	packet.Data = nil
+	l3 := -1
+	for i := range b.layers {
+		switch b.layers[i].kind {
+		case ipv4Layer, ipv6Layer:
+			if l3 == -1 {
+				packet.L3 = unsafe.Pointer(ptr)
+				l3 = i
+			}
+		case tcpLayer, udpLayer, icmpLayer:
+			if l3 != -1 && l3 == i-1 {
+				packet.L4 = unsafe.Pointer(ptr)
+			}
+		case payloadLayer:
+			if packet.Data == nil {
+				packet.Data = unsafe.Pointer(ptr)
+			}
+		}
+		ptr += uintptr(len(b.layers[i].hdr))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+var (
+	builderSrcMAC  = [EtherAddrLen]uint8{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	builderDstMAC  = [EtherAddrLen]uint8{0x01, 0x11, 0x21, 0x31, 0x41, 0x51}
+	builderSrcIPv6 = [IPv6AddrLen]uint8{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	builderDstIPv6 = [IPv6AddrLen]uint8{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+)
+
+func TestBuilderIPv4TCP(t *testing.T) {
+	payload := []byte("hello, world")
+	pkt := getPacket()
+	err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TTL(32).ID(7).
+		TCP(1234, 80).Seq(100).Ack(200).Flags(TCPFlagAck).Window(1000).
+		Payload(payload).Build(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt.GetPacketLen() != EtherLen+IPv4MinLen+TCPMinLen+uint(len(payload)) {
+		t.Errorf("Incorrect packet length %d", pkt.GetPacketLen())
+	}
+	if pkt.Ether.SAddr != builderSrcMAC || pkt.Ether.DAddr != builderDstMAC {
+		t.Errorf("Incorrect MAC addresses: %s", pkt.Ether)
+	}
+	ipv4 := pkt.GetIPv4()
+	if ipv4 == nil {
+		t.Fatal("IPv4 header wasn't found")
+	}
+	if ipv4.NextProtoID != TCPNumber || ipv4.TimeToLive != 32 || SwapBytesUint16(ipv4.PacketID) != 7 ||
+		SwapBytesUint16(ipv4.TotalLength) != IPv4MinLen+TCPMinLen+uint16(len(payload)) {
+		t.Errorf("Incorrect IPv4 header fields: %+v", *ipv4)
+	}
+	if ipv4.HdrChecksum != SwapBytesUint16(CalculateIPv4Checksum(ipv4)) {
+		t.Errorf("Incorrect IPv4 checksum %x", SwapBytesUint16(ipv4.HdrChecksum))
+	}
+	tcp := pkt.GetTCPForIPv4()
+	if tcp == nil || unsafe.Pointer(tcp) != pkt.L4 {
+		t.Fatal("TCP header wasn't found")
+	}
+	if SwapBytesUint16(tcp.DstPort) != 80 || SwapBytesUint32(tcp.SentSeq) != 100 ||
+		SwapBytesUint32(tcp.RecvAck) != 200 || tcp.TCPFlags != TCPFlagAck || SwapBytesUint16(tcp.RxWin) != 1000 {
+		t.Errorf("Incorrect TCP header fields: %+v", *tcp)
+	}
+	if tcp.Cksum != SwapBytesUint16(CalculateIPv4TCPChecksum(ipv4, tcp, pkt.Data)) {
+		t.Errorf("Incorrect TCP checksum %x", SwapBytesUint16(tcp.Cksum))
+	}
+	if data, ok := pkt.GetPacketPayload(); !ok || !bytes.Equal(data, payload) {
+		t.Errorf("Incorrect payload %q", data)
+	}
+}
+
+func TestBuilderIPv6ICMP(t *testing.T) {
+	pkt := getPacket()
+	err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv6(builderSrcIPv6, builderDstIPv6).
+		ICMP(ICMPv6TypeEchoRequest, 0).Echo(1, 2).
+		Payload(make([]byte, 33)).Build(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv6 := pkt.GetIPv6()
+	if ipv6 == nil || ipv6.Proto != ICMPv6Number || SwapBytesUint16(ipv6.PayloadLen) != ICMPLen+33 {
+		t.Fatalf("Incorrect IPv6 header: %v", ipv6)
+	}
+	icmp := pkt.GetICMPForIPv6()
+	if icmp.Cksum != SwapBytesUint16(CalculateIPv6ICMPChecksum(ipv6, icmp, pkt.Data)) {
+		t.Errorf("Incorrect ICMPv6 checksum %x", SwapBytesUint16(icmp.Cksum))
+	}
+}
+
+func TestBuilderTunnel(t *testing.T) {
+	pkt := getPacket()
+	err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).VLAN(100).Priority(5).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).
+		UDP(UDPPortGTPU, UDPPortGTPU).GTP(0x1234).
+		IPv4(BytesToIPv4(192, 168, 0, 1), BytesToIPv4(192, 168, 0, 2)).
+		UDP(5000, 53).Payload(make([]byte, 20)).Build(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vlan := pkt.ParseL3CheckVLAN()
+	if vlan == nil || vlan.GetVLANTagIdentifier() != 100 || SwapBytesUint16(vlan.TCI)>>13 != 5 {
+		t.Fatalf("Incorrect VLAN tag: %v", vlan)
+	}
+	outer := pkt.GetIPv4CheckVLAN()
+	if outer == nil || outer.NextProtoID != UDPNumber ||
+		SwapBytesUint16(outer.TotalLength) != 2*IPv4MinLen+2*UDPLen+GTPMinLen+20 {
+		t.Fatalf("Incorrect outer IPv4 header: %v", outer)
+	}
+	gtp := (*GTPHdr)(unsafe.Pointer(uintptr(pkt.L4) + UDPLen))
+	if SwapBytesUint32(gtp.TEID) != 0x1234 || SwapBytesUint16(gtp.MessageLength) != IPv4MinLen+UDPLen+20 {
+		t.Errorf("Incorrect GTP header: %v", gtp)
+	}
+	l3 := pkt.GetGTPUInnerL3()
+	if l3 == nil {
+		t.Fatal("Inner IPv4 header wasn't found")
+	}
+	inner := (*IPv4Hdr)(l3)
+	if inner.HdrChecksum != SwapBytesUint16(CalculateIPv4Checksum(inner)) {
+		t.Errorf("Incorrect inner IPv4 checksum %x", SwapBytesUint16(inner.HdrChecksum))
+	}
+	udp := (*UDPHdr)(unsafe.Pointer(uintptr(l3) + IPv4MinLen))
+	if udp.DgramCksum != SwapBytesUint16(CalculateIPv4UDPChecksum(inner, udp, unsafe.Pointer(uintptr(l3)+IPv4MinLen+UDPLen))) {
+		t.Errorf("Incorrect inner UDP checksum %x", SwapBytesUint16(udp.DgramCksum))
+	}
+}
+
+func TestBuilderMPLS(t *testing.T) {
+	data, err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		MPLS(100).MPLS(200).TTL(5).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x88, 0x47, 0x00, 0x06, 0x40, 0x40, 0x00, 0x0c, 0x81, 0x05, 0x45}
+	if !bytes.Equal(data[12:23], want) {
+		t.Errorf("Incorrect MPLS stack: got %x, want %x", data[12:23], want)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	if _, err := NewBuilder().TTL(1).Bytes(); err == nil {
+		t.Error("Setter for empty packet should return error")
+	}
+	if _, err := NewBuilder().IPv4(0, 0).UDP(1, 2).Seq(1).Bytes(); err == nil {
+		t.Error("TCP setter for UDP header should return error")
+	}
+}
@@ -132,9 +132,8 @@ func SetHWOffloadingHdrChecksum(p *Packet) {
 		} else if icmp != nil {
 			// calculate full software checksum for icmp, cause there is no
 			// hardware calculation for icmp packets.
-			p.GetICMPForIPv4().Cksum = 0
-			p.GetICMPForIPv4().Cksum = SwapBytesUint16(CalculateIPv4ICMPChecksum(ipv4, icmp,
-				unsafe.Pointer(uintptr(unsafe.Pointer(icmp))+ICMPLen)))
+			cksum, _ := p.CalculateL4Checksum()
+			icmp.Cksum = SwapBytesUint16(cksum)
 		}
 	} else if ipv6 != nil {
 		tcp, udp, icmp := p.ParseAllKnownL4ForIPv6()
@@ -145,9 +144,8 @@ func SetHWOffloadingHdrChecksum(p *Packet) {
 		} else if icmp != nil {
 			// calculate full software checksum for icmp, cause there is no
 			// hardware calculation for icmp packets.
-			p.GetICMPForIPv6().Cksum = 0
-			p.GetICMPForIPv6().Cksum = SwapBytesUint16(CalculateIPv6ICMPChecksum(ipv6, icmp,
-				unsafe.Pointer(uintptr(unsafe.Pointer(icmp))+ICMPLen)))
+			cksum, _ := p.CalculateL4Checksum()
+			icmp.Cksum = SwapBytesUint16(cksum)
 		}
 	}
 }
@@ -342,3 +340,144 @@ func CalculateIPv6ICMPChecksum(hdr *IPv6Hdr, icmp *ICMPHdr, data unsafe.Pointer)
 
 	return ^reduceChecksum(sum)
 }
+
+// Verification of checksums. Verify functions compare checksums which
+// are stored in headers with software calculated ones and take the same
+// arguments as corresponding Calculate functions.
+
+// VerifyIPv4Checksum returns true if checksum of IPv4 header is correct.
+func VerifyIPv4Checksum(hdr *IPv4Hdr) bool {
+	return SwapBytesUint16(hdr.HdrChecksum) == CalculateIPv4Checksum(hdr)
+}
+
+// VerifyIPv4TCPChecksum returns true if TCP checksum is correct for case
+// if L3 protocol is IPv4.
+func VerifyIPv4TCPChecksum(hdr *IPv4Hdr, tcp *TCPHdr, data unsafe.Pointer) bool {
+	return SwapBytesUint16(tcp.Cksum) == CalculateIPv4TCPChecksum(hdr, tcp, data)
+}
+
+// VerifyIPv4UDPChecksum returns true if UDP checksum is correct for case
+// if L3 protocol is IPv4. Zero checksum means that checksum wasn't
+// calculated by sender, so it is always correct.
+func VerifyIPv4UDPChecksum(hdr *IPv4Hdr, udp *UDPHdr, data unsafe.Pointer) bool {
+	return udp.DgramCksum == 0 || SwapBytesUint16(udp.DgramCksum) == CalculateIPv4UDPChecksum(hdr, udp, data)
+}
+
+// VerifyIPv4ICMPChecksum returns true if ICMP checksum is correct for
+// case if L3 protocol is IPv4.
+func VerifyIPv4ICMPChecksum(hdr *IPv4Hdr, icmp *ICMPHdr, data unsafe.Pointer) bool {
+	return SwapBytesUint16(icmp.Cksum) == CalculateIPv4ICMPChecksum(hdr, icmp, data)
+}
+
+// VerifyIPv6TCPChecksum returns true if TCP checksum is correct for case
+// if L3 protocol is IPv6.
+func VerifyIPv6TCPChecksum(hdr *IPv6Hdr, tcp *TCPHdr, data unsafe.Pointer) bool {
+	return SwapBytesUint16(tcp.Cksum) == CalculateIPv6TCPChecksum(hdr, tcp, data)
+}
+
+// VerifyIPv6UDPChecksum returns true if UDP checksum is correct for case
+// if L3 protocol is IPv6. Checksum is mandatory for IPv6, so zero
+// checksum is incorrect.
+func VerifyIPv6UDPChecksum(hdr *IPv6Hdr, udp *UDPHdr, data unsafe.Pointer) bool {
+	return SwapBytesUint16(udp.DgramCksum) == CalculateIPv6UDPChecksum(hdr, udp, data)
+}
+
+// VerifyIPv6ICMPChecksum returns true if ICMPv6 checksum is correct.
+func VerifyIPv6ICMPChecksum(hdr *IPv6Hdr, icmp *ICMPHdr, data unsafe.Pointer) bool {
+	return SwapBytesUint16(icmp.Cksum) == CalculateIPv6ICMPChecksum(hdr, icmp, data)
+}
+
+// VerifyChecksums returns true if checksums of IPv4 header and TCP, UDP
+// or ICMP header of packet are correct. Packet can have VLAN tag. If
+// NIC reported checksum state for received packet, it is used instead
+// of software verification, so function should be called before packet
+// is changed. Checksums of unknown headers are not checked. Payload of
+// multi-segment packets is checked in all segments.
+func (packet *Packet) VerifyChecksums() bool {
+	ipv4, ipv6, _ := packet.ParseAllKnownL3CheckVLAN()
+	l4State := low.GetRXL4ChecksumState(packet.CMbuf)
+	if ipv4 != nil {
+		switch low.GetRXIPChecksumState(packet.CMbuf) {
+		case low.RXChecksumBad:
+			return false
+		case low.RXChecksumUnknown:
+			if !VerifyIPv4Checksum(ipv4) {
+				return false
+			}
+		}
+	}
+	if packet.IsMultiSegment() {
+		return l4State == low.RXChecksumGood || l4State == low.RXChecksumUnknown &&
+			packet.verifyL4Checksum(ipv4 != nil)
+	}
+	if ipv4 != nil {
+		tcp, udp, icmp := packet.ParseAllKnownL4ForIPv4()
+		if tcp != nil {
+			return l4State == low.RXChecksumGood || l4State == low.RXChecksumUnknown &&
+				VerifyIPv4TCPChecksum(ipv4, tcp, unsafe.Pointer(uintptr(packet.L4)+TCPMinLen))
+		} else if udp != nil {
+			return l4State == low.RXChecksumGood || l4State == low.RXChecksumUnknown &&
+				VerifyIPv4UDPChecksum(ipv4, udp, unsafe.Pointer(uintptr(packet.L4)+UDPLen))
+		} else if icmp != nil {
+			return VerifyIPv4ICMPChecksum(ipv4, icmp, unsafe.Pointer(uintptr(packet.L4)+ICMPLen))
+		}
+	} else if ipv6 != nil {
+		tcp, udp, icmp := packet.ParseAllKnownL4ForIPv6()
+		if tcp != nil {
+			return l4State == low.RXChecksumGood || l4State == low.RXChecksumUnknown &&
+				VerifyIPv6TCPChecksum(ipv6, tcp, unsafe.Pointer(uintptr(packet.L4)+TCPMinLen))
+		} else if udp != nil {
+			return l4State == low.RXChecksumGood || l4State == low.RXChecksumUnknown &&
+				VerifyIPv6UDPChecksum(ipv6, udp, unsafe.Pointer(uintptr(packet.L4)+UDPLen))
+		} else if icmp != nil {
+			return VerifyIPv6ICMPChecksum(ipv6, icmp, unsafe.Pointer(uintptr(packet.L4)+ICMPLen))
+		}
+	}
+	return true
+}
+
+// verifyL4Checksum compares checksum of L4 header with checksum
+// calculated over all segments of packet. Zero UDP checksum is accepted
+// if it is optional, which is the case for IPv4.
+func (packet *Packet) verifyL4Checksum(optional bool) bool {
+	cksum, field, udp := packet.calculateL4Checksum()
+	if field == nil {
+		return true
+	}
+	if udp && optional && *field == 0 {
+		return true
+	}
+	return SwapBytesUint16(*field) == cksum
+}
+
+// Incremental update of checksums
+
+// UpdateChecksum16 returns checksum which is incrementally updated
+// after change of 16-bit word from old to new value, as described by
+// RFC 1624, equation 3: HC' = ~(~HC + ~m + m'). One's complement sum
+// doesn't depend on byte order, so raw header fields can be used
+// without swapping if checksum isn't swapped too. Zero UDP checksum
+// means that checksum is absent and shouldn't be updated.
+func UpdateChecksum16(cksum, old, new uint16) uint16 {
+	sum := uint32(^cksum) + uint32(^old) + uint32(new)
+	sum = (sum >> 16) + (sum & 0xffff)
+	sum += sum >> 16
+	return ^uint16(sum)
+}
+
+// UpdateChecksum32 returns checksum which is incrementally updated after
+// change of 32-bit value, for example IPv4 address, from old to new.
+func UpdateChecksum32(cksum uint16, old, new uint32) uint16 {
+	cksum = UpdateChecksum16(cksum, uint16(old), uint16(new))
+	return UpdateChecksum16(cksum, uint16(old>>16), uint16(new>>16))
+}
+
+// UpdateChecksumIPv6Addr returns checksum which is incrementally updated
+// after change of IPv6 address from old to new, for example checksum of
+// TCP, UDP or ICMPv6 header which covers address by pseudo header.
+func UpdateChecksumIPv6Addr(cksum uint16, old, new [IPv6AddrLen]uint8) uint16 {
+	for i := 0; i < IPv6AddrLen; i += 2 {
+		cksum = UpdateChecksum16(cksum, uint16(old[i+1])<<8|uint16(old[i]), uint16(new[i+1])<<8|uint16(new[i]))
+	}
+	return cksum
+}
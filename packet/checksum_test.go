@@ -9,6 +9,7 @@ import (
 	"github.com/intel-go/nff-go/common"
 	"net"
 	"testing"
+	"unsafe"
 )
 
 const N uint = 20
@@ -183,3 +184,115 @@ func initICMP(icmp *ICMPHdr) {
 	icmp.Identifier = SwapBytesUint16(0xbe)
 	icmp.SeqNum = SwapBytesUint16(0xaf)
 }
+
+func buildChecksumPacket(tb testing.TB, b *Builder) *Packet {
+	pkt := getPacket()
+	if err := b.Payload(make([]byte, payloadSizeLocal)).Build(pkt); err != nil {
+		tb.Fatal(err)
+	}
+	return pkt
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	src := BytesToIPv4(10, 0, 0, 1)
+	dst := BytesToIPv4(10, 0, 0, 2)
+	var src6, dst6 [common.IPv6AddrLen]uint8
+	copy(src6[:], net.ParseIP("2001:db8:0:0:1::1"))
+	copy(dst6[:], net.ParseIP("2001:db8:0:0:1::12"))
+	builders := []*Builder{
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv4(src, dst).TCP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).VLAN(10).IPv4(src, dst).UDP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv4(src, dst).ICMP(common.ICMPTypeEchoRequest, 0),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).TCP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).UDP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).ICMP(common.ICMPv6TypeEchoRequest, 0),
+	}
+	for i, b := range builders {
+		pkt := buildChecksumPacket(t, b)
+		if !pkt.VerifyChecksums() {
+			t.Errorf("Packet %d: correct checksums weren't verified:\n%s", i, pkt.Dissect())
+		}
+		pkt.GetRawPacketBytes()[pkt.GetPacketLen()-1]++
+		if pkt.VerifyChecksums() {
+			t.Errorf("Packet %d: changed payload wasn't detected:\n%s", i, pkt.Dissect())
+		}
+	}
+
+	pkt := buildChecksumPacket(t, builders[1])
+	pkt.GetUDPNoCheck().DgramCksum = 0
+	if !pkt.VerifyChecksums() {
+		t.Error("Zero UDP checksum should be correct for IPv4")
+	}
+	pkt.GetIPv4NoCheck().TimeToLive--
+	if pkt.VerifyChecksums() {
+		t.Error("Changed IPv4 header wasn't detected")
+	}
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	pkt := buildChecksumPacket(t, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TCP(1, 2))
+	ipv4 := pkt.GetIPv4NoCheck()
+	tcp := pkt.GetTCPForIPv4()
+	newAddr := BytesToIPv4(192, 168, 14, 3)
+	ipv4.HdrChecksum = UpdateChecksum32(ipv4.HdrChecksum, ipv4.SrcAddr, newAddr)
+	tcp.Cksum = UpdateChecksum32(tcp.Cksum, ipv4.SrcAddr, newAddr)
+	tcp.Cksum = UpdateChecksum16(tcp.Cksum, tcp.SrcPort, SwapBytesUint16(4321))
+	ipv4.SrcAddr = newAddr
+	tcp.SrcPort = SwapBytesUint16(4321)
+	if !pkt.VerifyChecksums() {
+		t.Errorf("Incorrect IPv4 checksums after incremental update:\n%s", pkt.Dissect())
+	}
+
+	pkt = buildChecksumPacket(t, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv6(builderSrcIPv6, builderDstIPv6).UDP(1, 2))
+	ipv6 := pkt.GetIPv6NoCheck()
+	udp := pkt.GetUDPForIPv6()
+	var newAddr6 [common.IPv6AddrLen]uint8
+	copy(newAddr6[:], net.ParseIP("fd00::abcd:1"))
+	udp.DgramCksum = UpdateChecksumIPv6Addr(udp.DgramCksum, ipv6.DstAddr, newAddr6)
+	ipv6.DstAddr = newAddr6
+	if !pkt.VerifyChecksums() {
+		t.Errorf("Incorrect IPv6 checksum after incremental update:\n%s", pkt.Dissect())
+	}
+}
+
+// Verification and update of TCP checksum after address change compared
+// with full recalculation.
+
+func BenchmarkCalculateIPv4TCPChecksum(b *testing.B) {
+	pkt := buildChecksumPacket(b, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TCP(1, 2))
+	ipv4 := pkt.GetIPv4NoCheck()
+	tcp := pkt.GetTCPForIPv4()
+	data := unsafe.Pointer(uintptr(pkt.L4) + common.TCPMinLen)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ipv4.SrcAddr++
+		ipv4.HdrChecksum = SwapBytesUint16(CalculateIPv4Checksum(ipv4))
+		tcp.Cksum = SwapBytesUint16(CalculateIPv4TCPChecksum(ipv4, tcp, data))
+	}
+}
+
+func BenchmarkUpdateIPv4TCPChecksum(b *testing.B) {
+	pkt := buildChecksumPacket(b, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TCP(1, 2))
+	ipv4 := pkt.GetIPv4NoCheck()
+	tcp := pkt.GetTCPForIPv4()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newAddr := ipv4.SrcAddr + 1
+		ipv4.HdrChecksum = UpdateChecksum32(ipv4.HdrChecksum, ipv4.SrcAddr, newAddr)
+		tcp.Cksum = UpdateChecksum32(tcp.Cksum, ipv4.SrcAddr, newAddr)
+		ipv4.SrcAddr = newAddr
+	}
+}
+
+func BenchmarkVerifyChecksums(b *testing.B) {
+	pkt := buildChecksumPacket(b, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TCP(1, 2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkt.VerifyChecksums()
+	}
+}
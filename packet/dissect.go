@@ -0,0 +1,670 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+// Dissect returns human readable description of all known layers of
+// packet. Each layer is described by one line which starts with its
+// offset in packet, options and extension headers are described by
+// additional indented lines. Packet doesn't need to be parsed. Is
+// intended for debugging and logs, not for performance critical paths.
+func (packet *Packet) Dissect() string {
+	return DissectBytes(packet.GetRawPacketBytes())
+}
+
+// Dump returns Dissect description of packet followed by its hexdump.
+// Each hexdump line is annotated by names of layers which start in it.
+func (packet *Packet) Dump() string {
+	return DumpBytes(packet.GetRawPacketBytes())
+}
+
+// DissectBytes returns the same description as Dissect for raw bytes of
+// packet which start from Ethernet header.
+func DissectBytes(data []byte) string {
+	d := dissector{data: data}
+	d.dissect()
+	return d.out.String()
+}
+
+// DumpBytes returns the same description as Dump for raw bytes of
+// packet which start from Ethernet header.
+func DumpBytes(data []byte) string {
+	d := dissector{data: data}
+	d.dissect()
+	d.hexdump()
+	return d.out.String()
+}
+
+// Layers which dissector can recognize
+type dissectLayer uint8
+
+const (
+	dissectEther dissectLayer = iota
+	dissectVLAN
+	dissectMPLS
+	dissectARP
+	dissectIPv4
+	dissectIPv6
+	dissectTCP
+	dissectUDP
+	dissectICMP
+	dissectICMPv6
+	dissectGTP
+	dissectPayload
+	dissectStop
+)
+
+type dissectMark struct {
+	offset int
+	name   string
+}
+
+type dissector struct {
+	data  []byte
+	out   strings.Builder
+	marks []dissectMark
+}
+
+// Constants which are used only for dissecting
+const (
+	qinqNumber      = 0x88a8
+	mplsMultiNumber = 0x8848
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6ESP         = 50
+	ipv6AH          = 51
+	ipv6NoNext      = 59
+	ipv6DstOptions  = 60
+	gtpMinSeqLen    = 12
+
+	icmpv6RouterSolicitation  = 133
+	icmpv6RouterAdvertisement = 134
+	icmpv6Redirect            = 137
+)
+
+var (
+	dissectEtherTypes = map[uint16]string{
+		IPV4Number:      "IPv4",
+		ARPNumber:       "ARP",
+		VLANNumber:      "802.1Q",
+		qinqNumber:      "802.1ad",
+		MPLSNumber:      "MPLS",
+		mplsMultiNumber: "MPLS multicast",
+		IPV6Number:      "IPv6",
+	}
+	dissectProtocols = map[uint8]string{
+		ICMPNumber:     "ICMP",
+		IPNumber:       "IPv4",
+		TCPNumber:      "TCP",
+		UDPNumber:      "UDP",
+		41:             "IPv6",
+		47:             "GRE",
+		ipv6ESP:        "ESP",
+		ipv6AH:         "AH",
+		ICMPv6Number:   "ICMPv6",
+		ipv6NoNext:     "no next header",
+		ipv6HopByHop:   "Hop-by-Hop",
+		ipv6Routing:    "Routing",
+		ipv6Fragment:   "Fragment",
+		ipv6DstOptions: "Destination Options",
+	}
+	dissectICMPTypes = map[uint8]string{
+		ICMPTypeEchoResponse: "echo reply",
+		3:                    "destination unreachable",
+		5:                    "redirect",
+		ICMPTypeEchoRequest:  "echo request",
+		11:                   "time exceeded",
+		12:                   "parameter problem",
+	}
+	dissectICMPv6Types = map[uint8]string{
+		1:                           "destination unreachable",
+		2:                           "packet too big",
+		3:                           "time exceeded",
+		4:                           "parameter problem",
+		ICMPv6TypeEchoRequest:       "echo request",
+		ICMPv6TypeEchoResponse:      "echo reply",
+		icmpv6RouterSolicitation:    "router solicitation",
+		icmpv6RouterAdvertisement:   "router advertisement",
+		ICMPv6NeighborSolicitation:  "neighbor solicitation",
+		ICMPv6NeighborAdvertisement: "neighbor advertisement",
+		icmpv6Redirect:              "redirect",
+	}
+	dissectNDOptions = map[uint8]string{
+		ICMPv6NDSourceLinkLayerAddress: "source link-layer address",
+		ICMPv6NDTargetLinkLayerAddress: "target link-layer address",
+		ICMPv6NDPrefixInformation:      "prefix information",
+		ICMPv6NDRedirectedHeader:       "redirected header",
+		ICMPv6NDMTU:                    "MTU",
+	}
+)
+
+func dissectName(names map[uint8]string, n uint8) string {
+	if name, ok := names[n]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func dissectEtherTypeName(t uint16) string {
+	if name, ok := dissectEtherTypes[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// layer starts description of layer at given offset.
+func (d *dissector) layer(offset int, name string, format string, args ...interface{}) {
+	d.marks = append(d.marks, dissectMark{offset, name})
+	fmt.Fprintf(&d.out, "%04x %s: ", offset, name)
+	fmt.Fprintf(&d.out, format, args...)
+	d.out.WriteByte('\n')
+}
+
+// detail adds indented line to description of current layer.
+func (d *dissector) detail(format string, args ...interface{}) {
+	d.out.WriteString("       ")
+	fmt.Fprintf(&d.out, format, args...)
+	d.out.WriteByte('\n')
+}
+
+// truncated checks that length bytes are present at offset and reports
+// truncated layer otherwise.
+func (d *dissector) truncated(offset, length int, name string) bool {
+	if offset+length <= len(d.data) {
+		return false
+	}
+	d.layer(offset, name, "truncated, %d bytes present, at least %d expected", len(d.data)-offset, length)
+	return true
+}
+
+func (d *dissector) u16(offset int) uint16 {
+	return binary.BigEndian.Uint16(d.data[offset:])
+}
+
+func (d *dissector) u32(offset int) uint32 {
+	return binary.BigEndian.Uint32(d.data[offset:])
+}
+
+func (d *dissector) mac(offset int) string {
+	var mac [EtherAddrLen]uint8
+	copy(mac[:], d.data[offset:])
+	return MACToString(mac)
+}
+
+func (d *dissector) ipv4(offset int) string {
+	return fmt.Sprintf("%d.%d.%d.%d", d.data[offset], d.data[offset+1], d.data[offset+2], d.data[offset+3])
+}
+
+func (d *dissector) ipv6(offset int) string {
+	var addr [IPv6AddrLen]uint8
+	copy(addr[:], d.data[offset:])
+	return IPv6ToString(addr)
+}
+
+func (d *dissector) dissect() {
+	layer, offset := dissectEther, 0
+	for layer != dissectStop {
+		if offset == len(d.data) {
+			return
+		}
+		switch layer {
+		case dissectEther:
+			layer, offset = d.ether(offset)
+		case dissectVLAN:
+			layer, offset = d.vlan(offset)
+		case dissectMPLS:
+			layer, offset = d.mpls(offset)
+		case dissectARP:
+			layer, offset = d.arp(offset)
+		case dissectIPv4:
+			layer, offset = d.ipv4Hdr(offset)
+		case dissectIPv6:
+			layer, offset = d.ipv6Hdr(offset)
+		case dissectTCP:
+			layer, offset = d.tcp(offset)
+		case dissectUDP:
+			layer, offset = d.udp(offset)
+		case dissectICMP:
+			layer, offset = d.icmp(offset)
+		case dissectICMPv6:
+			layer, offset = d.icmpv6(offset)
+		case dissectGTP:
+			layer, offset = d.gtp(offset)
+		case dissectPayload:
+			d.layer(offset, "Payload", "%d bytes", len(d.data)-offset)
+			layer = dissectStop
+		}
+	}
+}
+
+func etherTypeLayer(t uint16) dissectLayer {
+	switch t {
+	case VLANNumber, qinqNumber:
+		return dissectVLAN
+	case MPLSNumber, mplsMultiNumber:
+		return dissectMPLS
+	case ARPNumber:
+		return dissectARP
+	case IPV4Number:
+		return dissectIPv4
+	case IPV6Number:
+		return dissectIPv6
+	}
+	return dissectPayload
+}
+
+func protocolLayer(proto uint8) dissectLayer {
+	switch proto {
+	case TCPNumber:
+		return dissectTCP
+	case UDPNumber:
+		return dissectUDP
+	case ICMPNumber:
+		return dissectICMP
+	case ICMPv6Number:
+		return dissectICMPv6
+	case IPNumber:
+		return dissectIPv4
+	case 41:
+		return dissectIPv6
+	case ipv6NoNext:
+		return dissectStop
+	}
+	return dissectPayload
+}
+
+// ipVersionLayer guesses type of header without explicit type, for
+// example after MPLS or GTP, by IP version field.
+func (d *dissector) ipVersionLayer(offset int) dissectLayer {
+	switch d.data[offset] >> 4 {
+	case 4:
+		return dissectIPv4
+	case 6:
+		return dissectIPv6
+	}
+	return dissectPayload
+}
+
+func (d *dissector) ether(offset int) (dissectLayer, int) {
+	if d.truncated(offset, EtherLen, "Ethernet") {
+		return dissectStop, offset
+	}
+	t := d.u16(offset + 12)
+	d.layer(offset, "Ethernet", "%s > %s, type %s (0x%04x)",
+		d.mac(offset+6), d.mac(offset), dissectEtherTypeName(t), t)
+	return etherTypeLayer(t), offset + EtherLen
+}
+
+func (d *dissector) vlan(offset int) (dissectLayer, int) {
+	if d.truncated(offset, VLANLen, "VLAN") {
+		return dissectStop, offset
+	}
+	tci := d.u16(offset)
+	t := d.u16(offset + 2)
+	d.layer(offset, "VLAN", "id %d, priority %d, drop %d, type %s (0x%04x)",
+		tci&0xfff, tci>>13, (tci>>12)&1, dissectEtherTypeName(t), t)
+	return etherTypeLayer(t), offset + VLANLen
+}
+
+func (d *dissector) mpls(offset int) (dissectLayer, int) {
+	for {
+		if d.truncated(offset, MPLSLen, "MPLS") {
+			return dissectStop, offset
+		}
+		m := d.u32(offset)
+		d.layer(offset, "MPLS", "label %d, tc %d, s %d, ttl %d", m>>12, (m>>9)&7, (m>>8)&1, m&0xff)
+		offset += MPLSLen
+		if m&0x100 != 0 {
+			break
+		}
+	}
+	if offset == len(d.data) {
+		return dissectStop, offset
+	}
+	return d.ipVersionLayer(offset), offset
+}
+
+func (d *dissector) arp(offset int) (dissectLayer, int) {
+	if d.truncated(offset, ARPLen, "ARP") {
+		return dissectStop, offset
+	}
+	op := "unknown operation"
+	switch d.u16(offset + 6) {
+	case ARPRequest:
+		op = "request"
+	case ARPReply:
+		op = "reply"
+	}
+	d.layer(offset, "ARP", "%s, sender %s (%s), target %s (%s)", op,
+		d.ipv4(offset+14), d.mac(offset+8), d.ipv4(offset+24), d.mac(offset+18))
+	return dissectPayload, offset + ARPLen
+}
+
+func (d *dissector) ipv4Hdr(offset int) (dissectLayer, int) {
+	if d.truncated(offset, IPv4MinLen, "IPv4") {
+		return dissectStop, offset
+	}
+	hdrLen := int(d.data[offset]&0x0f) * 4
+	proto := d.data[offset+9]
+	frag := d.u16(offset + 6)
+	flags := ""
+	if frag&0x4000 != 0 {
+		flags += "DF"
+	}
+	if frag&0x2000 != 0 {
+		flags += "MF"
+	}
+	d.layer(offset, "IPv4", "%s > %s, proto %s (%d), tos 0x%x, ttl %d, id %d, flags [%s], offset %d, len %d, cksum 0x%04x",
+		d.ipv4(offset+12), d.ipv4(offset+16), dissectName(dissectProtocols, proto), proto,
+		d.data[offset+1], d.data[offset+8], d.u16(offset+4), flags, (frag&0x1fff)*8,
+		d.u16(offset+2), d.u16(offset+10))
+	if hdrLen < IPv4MinLen || d.truncated(offset, hdrLen, "IPv4 options") {
+		return dissectStop, offset
+	}
+	if hdrLen > IPv4MinLen {
+		d.detail("options: % x", d.data[offset+IPv4MinLen:offset+hdrLen])
+	}
+	// Only the first fragment contains next header.
+	if frag&0x1fff != 0 {
+		return dissectPayload, offset + hdrLen
+	}
+	return protocolLayer(proto), offset + hdrLen
+}
+
+func (d *dissector) ipv6Hdr(offset int) (dissectLayer, int) {
+	if d.truncated(offset, IPv6Len, "IPv6") {
+		return dissectStop, offset
+	}
+	vtc := d.u32(offset)
+	next := d.data[offset+6]
+	d.layer(offset, "IPv6", "%s > %s, next %s (%d), tc 0x%x, flow 0x%05x, hlim %d, payload len %d",
+		d.ipv6(offset+8), d.ipv6(offset+24), dissectName(dissectProtocols, next), next,
+		(vtc>>20)&0xff, vtc&0xfffff, d.data[offset+7], d.u16(offset+4))
+	offset += IPv6Len
+	for {
+		var length int
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DstOptions:
+			if d.truncated(offset, 8, "IPv6 extension") {
+				return dissectStop, offset
+			}
+			length = (int(d.data[offset+1]) + 1) * 8
+		case ipv6Fragment:
+			if d.truncated(offset, 8, "IPv6 fragment") {
+				return dissectStop, offset
+			}
+			length = 8
+		case ipv6AH:
+			if d.truncated(offset, 8, "IPv6 AH") {
+				return dissectStop, offset
+			}
+			length = (int(d.data[offset+1]) + 2) * 4
+		default:
+			return protocolLayer(next), offset
+		}
+		if d.truncated(offset, length, dissectName(dissectProtocols, next)) {
+			return dissectStop, offset
+		}
+		hdr := next
+		next = d.data[offset]
+		if hdr == ipv6Fragment {
+			frag := d.u16(offset + 2)
+			d.layer(offset, "IPv6 Fragment", "next %s (%d), offset %d, more %d, id 0x%08x",
+				dissectName(dissectProtocols, next), next, frag&0xfff8, frag&1, d.u32(offset+4))
+			if frag&0xfff8 != 0 {
+				return dissectPayload, offset + length
+			}
+		} else {
+			d.layer(offset, "IPv6 "+dissectName(dissectProtocols, hdr), "next %s (%d), len %d",
+				dissectName(dissectProtocols, next), next, length)
+		}
+		offset += length
+	}
+}
+
+func (d *dissector) tcp(offset int) (dissectLayer, int) {
+	if d.truncated(offset, TCPMinLen, "TCP") {
+		return dissectStop, offset
+	}
+	hdrLen := int(d.data[offset+12]>>4) * 4
+	flags := d.data[offset+13]
+	flagNames := ""
+	for i, name := range []string{"F", "S", "R", "P", ".", "U", "E", "W"} {
+		if flags&(1<<uint(i)) != 0 {
+			flagNames += name
+		}
+	}
+	d.layer(offset, "TCP", "%d > %d, flags [%s], seq %d, ack %d, win %d, urg %d, cksum 0x%04x",
+		d.u16(offset), d.u16(offset+2), flagNames, d.u32(offset+4), d.u32(offset+8),
+		d.u16(offset+14), d.u16(offset+18), d.u16(offset+16))
+	if hdrLen < TCPMinLen || d.truncated(offset, hdrLen, "TCP options") {
+		return dissectStop, offset
+	}
+	d.tcpOptions(d.data[offset+TCPMinLen : offset+hdrLen])
+	return dissectPayload, offset + hdrLen
+}
+
+func (d *dissector) tcpOptions(opts []byte) {
+	for len(opts) > 0 {
+		kind := opts[0]
+		if kind == 0 {
+			d.detail("option eol")
+			return
+		}
+		if kind == 1 {
+			d.detail("option nop")
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			d.detail("option %d malformed: % x", kind, opts)
+			return
+		}
+		opt := opts[:opts[1]]
+		switch {
+		case kind == 2 && len(opt) == 4:
+			d.detail("option mss %d", binary.BigEndian.Uint16(opt[2:]))
+		case kind == 3 && len(opt) == 3:
+			d.detail("option wscale %d", opt[2])
+		case kind == 4 && len(opt) == 2:
+			d.detail("option sackOK")
+		case kind == 5 && len(opt)%8 == 2:
+			sack := ""
+			for i := 2; i < len(opt); i += 8 {
+				sack += fmt.Sprintf(" %d:%d", binary.BigEndian.Uint32(opt[i:]), binary.BigEndian.Uint32(opt[i+4:]))
+			}
+			d.detail("option sack%s", sack)
+		case kind == 8 && len(opt) == 10:
+			d.detail("option timestamp val %d ecr %d", binary.BigEndian.Uint32(opt[2:]), binary.BigEndian.Uint32(opt[6:]))
+		default:
+			d.detail("option %d: % x", kind, opt[2:])
+		}
+		opts = opts[len(opt):]
+	}
+}
+
+func (d *dissector) udp(offset int) (dissectLayer, int) {
+	if d.truncated(offset, UDPLen, "UDP") {
+		return dissectStop, offset
+	}
+	src, dst := d.u16(offset), d.u16(offset+2)
+	d.layer(offset, "UDP", "%d > %d, len %d, cksum 0x%04x", src, dst, d.u16(offset+4), d.u16(offset+6))
+	if src == UDPPortGTPU || dst == UDPPortGTPU {
+		return dissectGTP, offset + UDPLen
+	}
+	return dissectPayload, offset + UDPLen
+}
+
+func (d *dissector) icmp(offset int) (dissectLayer, int) {
+	if d.truncated(offset, ICMPLen, "ICMP") {
+		return dissectStop, offset
+	}
+	t, code := d.data[offset], d.data[offset+1]
+	if t == ICMPTypeEchoRequest || t == ICMPTypeEchoResponse {
+		d.layer(offset, "ICMP", "%s, id %d, seq %d, cksum 0x%04x", dissectName(dissectICMPTypes, t),
+			d.u16(offset+4), d.u16(offset+6), d.u16(offset+2))
+		return dissectPayload, offset + ICMPLen
+	}
+	d.layer(offset, "ICMP", "%s (%d), code %d, cksum 0x%04x", dissectName(dissectICMPTypes, t), t, code, d.u16(offset+2))
+	return dissectPayload, offset + ICMPLen
+}
+
+func (d *dissector) icmpv6(offset int) (dissectLayer, int) {
+	if d.truncated(offset, ICMPLen, "ICMPv6") {
+		return dissectStop, offset
+	}
+	t, code := d.data[offset], d.data[offset+1]
+	name := dissectName(dissectICMPv6Types, t)
+	cksum := d.u16(offset + 2)
+	// Length of message body before ND options
+	var body int
+	switch t {
+	case ICMPv6TypeEchoRequest, ICMPv6TypeEchoResponse:
+		d.layer(offset, "ICMPv6", "%s, id %d, seq %d, cksum 0x%04x", name, d.u16(offset+4), d.u16(offset+6), cksum)
+		return dissectPayload, offset + ICMPLen
+	case icmpv6RouterSolicitation:
+		d.layer(offset, "ICMPv6", "%s, cksum 0x%04x", name, cksum)
+		body = ICMPLen
+	case icmpv6RouterAdvertisement:
+		if d.truncated(offset, 16, "ICMPv6") {
+			return dissectStop, offset
+		}
+		d.layer(offset, "ICMPv6", "%s, hop limit %d, flags 0x%02x, lifetime %d, reachable %d, retrans %d, cksum 0x%04x",
+			name, d.data[offset+4], d.data[offset+5], d.u16(offset+6), d.u32(offset+8), d.u32(offset+12), cksum)
+		body = 16
+	case ICMPv6NeighborSolicitation, ICMPv6NeighborAdvertisement:
+		if d.truncated(offset, 24, "ICMPv6") {
+			return dissectStop, offset
+		}
+		d.layer(offset, "ICMPv6", "%s, target %s, flags 0x%08x, cksum 0x%04x",
+			name, d.ipv6(offset+8), d.u32(offset+4), cksum)
+		body = 24
+	case icmpv6Redirect:
+		if d.truncated(offset, 40, "ICMPv6") {
+			return dissectStop, offset
+		}
+		d.layer(offset, "ICMPv6", "%s, target %s, destination %s, cksum 0x%04x",
+			name, d.ipv6(offset+8), d.ipv6(offset+24), cksum)
+		body = 40
+	default:
+		d.layer(offset, "ICMPv6", "%s (%d), code %d, cksum 0x%04x", name, t, code, cksum)
+		return dissectPayload, offset + ICMPLen
+	}
+	d.ndOptions(d.data[offset+body:])
+	return dissectStop, len(d.data)
+}
+
+func (d *dissector) ndOptions(opts []byte) {
+	for len(opts) > 0 {
+		if len(opts) < 2 || opts[1] == 0 || int(opts[1])*ICMPv6NDMessageOptionUnitSize > len(opts) {
+			d.detail("option malformed: % x", opts)
+			return
+		}
+		kind := opts[0]
+		opt := opts[:int(opts[1])*ICMPv6NDMessageOptionUnitSize]
+		name := dissectName(dissectNDOptions, kind)
+		switch {
+		case (kind == ICMPv6NDSourceLinkLayerAddress || kind == ICMPv6NDTargetLinkLayerAddress) && len(opt) == 8:
+			var mac [EtherAddrLen]uint8
+			copy(mac[:], opt[2:])
+			d.detail("option %s %s", name, MACToString(mac))
+		case kind == ICMPv6NDPrefixInformation && len(opt) == 32:
+			var prefix [IPv6AddrLen]uint8
+			copy(prefix[:], opt[16:])
+			d.detail("option %s %s/%d, flags 0x%02x, valid %d, preferred %d", name, IPv6ToString(prefix),
+				opt[2], opt[3], binary.BigEndian.Uint32(opt[4:]), binary.BigEndian.Uint32(opt[8:]))
+		case kind == ICMPv6NDMTU && len(opt) == 8:
+			d.detail("option %s %d", name, binary.BigEndian.Uint32(opt[4:]))
+		default:
+			d.detail("option %s (%d), len %d", name, kind, len(opt))
+		}
+		opts = opts[len(opt):]
+	}
+}
+
+func (d *dissector) gtp(offset int) (dissectLayer, int) {
+	if d.truncated(offset, GTPMinLen, "GTP") {
+		return dissectStop, offset
+	}
+	flags := d.data[offset]
+	t := d.data[offset+1]
+	d.layer(offset, "GTP", "version %d, pt %d, flags [E %d, S %d, PN %d], type %d, len %d, teid 0x%08x",
+		flags>>5, (flags>>4)&1, (flags>>2)&1, (flags>>1)&1, flags&1, t, d.u16(offset+2), d.u32(offset+4))
+	if flags>>5 != 1 {
+		return dissectPayload, offset + GTPMinLen
+	}
+	offset += GTPMinLen
+	if flags&0x07 != 0 {
+		if d.truncated(offset, gtpMinSeqLen-GTPMinLen, "GTP optional fields") {
+			return dissectStop, offset
+		}
+		d.detail("seq %d, n-pdu %d, next extension 0x%02x", d.u16(offset), d.data[offset+2], d.data[offset+3])
+		next := d.data[offset+3]
+		offset += gtpMinSeqLen - GTPMinLen
+		for flags&0x04 != 0 && next != NoExtensionHeaders {
+			if d.truncated(offset, 4, "GTP extension") {
+				return dissectStop, offset
+			}
+			length := int(d.data[offset]) * 4
+			if length == 0 || d.truncated(offset, length, "GTP extension") {
+				return dissectStop, offset
+			}
+			d.detail("extension 0x%02x: % x", next, d.data[offset+1:offset+length-1])
+			next = d.data[offset+length-1]
+			offset += length
+		}
+	}
+	if t != G_PDU || offset == len(d.data) {
+		return dissectPayload, offset
+	}
+	return d.ipVersionLayer(offset), offset
+}
+
+// hexdump adds hexdump of packet with 16 bytes per line. Each line is
+// annotated by names of layers which start in it.
+func (d *dissector) hexdump() {
+	mark := 0
+	for line := 0; line < len(d.data); line += 16 {
+		end := line + 16
+		if end > len(d.data) {
+			end = len(d.data)
+		}
+		fmt.Fprintf(&d.out, "%04x", line)
+		for i := line; i < line+16; i++ {
+			if i%8 == 0 {
+				d.out.WriteByte(' ')
+			}
+			if i < end {
+				fmt.Fprintf(&d.out, " %02x", d.data[i])
+			} else {
+				d.out.WriteString("   ")
+			}
+		}
+		d.out.WriteString("  |")
+		for _, b := range d.data[line:end] {
+			if b < 32 || b > 126 {
+				b = '.'
+			}
+			d.out.WriteByte(b)
+		}
+		d.out.WriteString("|" + strings.Repeat(" ", 16-(end-line)))
+		var names []string
+		for ; mark < len(d.marks) && d.marks[mark].offset < end; mark++ {
+			names = append(names, d.marks[mark].name)
+		}
+		if len(names) != 0 {
+			d.out.WriteString(" " + strings.Join(names, ", "))
+		}
+		d.out.WriteByte('\n')
+	}
+}
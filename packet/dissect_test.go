@@ -0,0 +1,115 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+func checkDissect(t *testing.T, got string, want []string) {
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("Dissect result doesn't contain %q:\n%s", w, got)
+		}
+	}
+}
+
+func TestDissectTunnel(t *testing.T) {
+	pkt := getPacket()
+	err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).VLAN(100).Priority(5).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).DontFragment().
+		UDP(UDPPortGTPU, UDPPortGTPU).GTP(0x1234).
+		IPv6(builderSrcIPv6, builderDstIPv6).
+		UDP(5000, 53).Payload(make([]byte, 20)).Build(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDissect(t, pkt.Dissect(), []string{
+		"0000 Ethernet: 00:11:22:33:44:55 > 01:11:21:31:41:51, type 802.1Q (0x8100)\n",
+		"000e VLAN: id 100, priority 5, drop 0, type IPv4 (0x0800)\n",
+		"0012 IPv4: 10.0.0.1 > 10.0.0.2, proto UDP (17), tos 0x0, ttl 64, id 0, flags [DF], offset 0, len 104",
+		"0026 UDP: 2152 > 2152, len 84",
+		"002e GTP: version 1, pt 1, flags [E 0, S 0, PN 0], type 255, len 68, teid 0x00001234\n",
+		"0036 IPv6: [2001:0db8:0000:0000:0000:0000:0000:0001] > [2001:0db8:0000:0000:0000:0000:0000:0002], next UDP (17)",
+		"005e UDP: 5000 > 53, len 28",
+		"0066 Payload: 20 bytes\n",
+	})
+}
+
+func TestDissectTCPOptions(t *testing.T) {
+	data, err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).
+		TCP(1234, 80).Seq(100).Flags(TCPFlagSyn | TCPFlagAck).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// MSS, NOP, window scale and timestamp options
+	options := []byte{2, 4, 0x05, 0xb4, 1, 3, 3, 7, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2}
+	options = append(options, 0, 0)
+	tcpOffset := EtherLen + IPv4MinLen
+	data[tcpOffset+12] = byte((TCPMinLen + len(options)) / 4 << 4)
+	data = append(data[:tcpOffset+TCPMinLen], options...)
+	checkDissect(t, DissectBytes(data), []string{
+		"0022 TCP: 1234 > 80, flags [S.], seq 100, ack 0",
+		"       option mss 1460\n       option nop\n       option wscale 7\n",
+		"       option timestamp val 1 ecr 2\n       option eol\n",
+	})
+}
+
+func TestDissectNeighborSolicitation(t *testing.T) {
+	body := make([]byte, 4+IPv6AddrLen+8)
+	copy(body[4:], builderDstIPv6[:])
+	body[20] = ICMPv6NDSourceLinkLayerAddress
+	body[21] = 1
+	copy(body[22:], builderSrcMAC[:])
+	data, err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv6(builderSrcIPv6, builderDstIPv6).
+		ICMP(ICMPv6NeighborSolicitation, 0).Payload(body[4:]).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDissect(t, DissectBytes(data), []string{
+		"0036 ICMPv6: neighbor solicitation, target [2001:0db8:0000:0000:0000:0000:0000:0002], flags 0x00000000",
+		"       option source link-layer address 00:11:22:33:44:55\n",
+	})
+}
+
+func TestDissectTruncated(t *testing.T) {
+	data, err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).
+		UDP(1, 2).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDissect(t, DissectBytes(data[:EtherLen+IPv4MinLen+4]), []string{
+		"0022 UDP: truncated, 4 bytes present, at least 8 expected\n",
+	})
+}
+
+func TestDump(t *testing.T) {
+	data, err := NewBuilder().
+		Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).
+		UDP(1, 2).Payload([]byte("abcd")).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDissect(t, DumpBytes(data), []string{
+		"0000  01 11 21 31 41 51 00 11  22 33 44 55 08 00 45 00  |..!1AQ..\"3DU..E.| Ethernet, IPv4\n",
+		"0020  00 02 00 01 00 02 00 0c  ",
+		"|........'.abcd|   UDP, Payload\n",
+	})
+}
@@ -2,6 +2,11 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// LPM tables are implemented by DPDK only.
+
+//go:build !nodpdk
+// +build !nodpdk
+
 package packet
 
 import (
@@ -231,6 +231,22 @@ type Packet struct {
 	CMbuf *low.Mbuf // Private pointer to mbuf. Users shouldn't know anything about mbuf
 
 	Next *Packet // non nil if packet consists of several chained mbufs
+
+	// Reason of drop, is cleared by InitMbuf macros inside low.c file
+	dropReason DropReason
+}
+
+// SetDropReason tags packet with reason which is counted if packet is
+// dropped by flow graph, for example by separator or splitter which
+// sends it to stopper.
+func (packet *Packet) SetDropReason(reason DropReason) {
+	packet.dropReason = reason
+}
+
+// GetDropReason returns reason which was set by SetDropReason or
+// UnknownDropReason.
+func (packet *Packet) GetDropReason() DropReason {
+	return packet.dropReason
 }
 
 func (packet *Packet) unparsed() unsafe.Pointer {
@@ -479,13 +495,26 @@ func (p *Packet) ToUintptr() uintptr {
 }
 
 // GeneratePacketFromByte function gets non-initialized packet and slice of bytes of any size.
-// Initializes input packet and fills it with these bytes.
+// Initializes input packet and fills it with these bytes. If bytes don't fit
+// into one mbuf, packet is created from several chained mbufs.
 func GeneratePacketFromByte(packet *Packet, data []byte) bool {
-	if low.AppendMbuf(packet.CMbuf, uint(len(data))) == false {
+	first := uint(len(data))
+	if tailroom := low.GetMbufTailroom(packet.CMbuf); first > tailroom {
+		first = tailroom
+	}
+	if low.AppendMbuf(packet.CMbuf, first) == false {
 		LogWarning(Debug, "GeneratePacketFromByte: Cannot append mbuf")
 		return false
 	}
-	low.WriteDataToMbuf(packet.CMbuf, data)
+	low.WriteDataToMbuf(packet.CMbuf, data[:first])
+	for rest := data[first:]; len(rest) != 0; {
+		seg, n := packet.appendSegment(rest)
+		if seg == nil {
+			LogWarning(Debug, "GeneratePacketFromByte: Cannot append mbuf segment")
+			return false
+		}
+		rest = rest[n:]
+	}
 	return true
 }
 
@@ -717,8 +746,13 @@ func SwapBytesUint32(x uint32) uint32 {
 	return ((x & 0x000000ff) << 24) | ((x & 0x0000ff00) << 8) | ((x & 0x00ff0000) >> 8) | ((x & 0xff000000) >> 24)
 }
 
-// GetRawPacketBytes returns all bytes from this packet. Not zero-copy.
+// GetRawPacketBytes returns all bytes from this packet. Bytes of the first
+// segment are returned without copy, bytes of multi-segment packet are
+// copied to a new slice, so changes of it don't affect packet.
 func (packet *Packet) GetRawPacketBytes() []byte {
+	if packet.IsMultiSegment() {
+		return packet.CopyRawPacketBytes()
+	}
 	return low.GetRawPacketBytesMbuf(packet.CMbuf)
 }
 
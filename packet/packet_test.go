@@ -14,6 +14,7 @@ import (
 	"unsafe"
 
 	. "github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
 )
 
 func init() {
@@ -432,6 +433,33 @@ var (
 	gtLineIPv6UDP = "00000000000000000000000086dd60000000001011ff000000000000000000000000000000000000000000000000000000000000000004d2162e00100000ffdd0000bbaa0000"
 )
 
+// Ether, CMbuf, Next and dropReason fields of packet are initialized by
+// low package at fixed offsets after mbuf
+func TestPacketFieldsInit(t *testing.T) {
+	pkt := getPacket()
+	mbuf := uintptr(unsafe.Pointer(pkt.CMbuf))
+	if ExtractPacket(mbuf) != pkt {
+		t.Fatal("CMbuf field doesn't point to mbuf of packet")
+	}
+	if uintptr(unsafe.Pointer(pkt.Ether)) != low.GetPacketDataStartPointer(pkt.CMbuf) {
+		t.Error("Ether field doesn't point to packet data")
+	}
+	if pkt.Next != nil || pkt.GetDropReason() != UnknownDropReason {
+		t.Error("Next and dropReason fields aren't cleared")
+	}
+	// Fields are cleared again when mbuf is reused
+	pkt.Next = pkt
+	pkt.SetDropReason(1)
+	low.DirectStop(1, []uintptr{mbuf})
+	pkt = getPacket()
+	if uintptr(unsafe.Pointer(pkt.CMbuf)) != mbuf {
+		t.Skip("Mempool returned other mbuf")
+	}
+	if pkt.Next != nil || pkt.GetDropReason() != UnknownDropReason {
+		t.Error("Next and dropReason fields of reused mbuf aren't cleared")
+	}
+}
+
 func TestInitEmptyPacket(t *testing.T) {
 	// Create empty packet, set Ether header fields
 	pkt := getPacket()
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/intel-go/nff-go/common"
-	"github.com/intel-go/nff-go/low"
 )
 
 type nowFuncT func() time.Time
@@ -56,21 +55,26 @@ func WritePcapGlobalHdr(f io.Writer) error {
 
 // WritePcapOnePacket writes one packet with pcap header in file.
 // Assumes global pcap header is already present in file. Packet timestamps have nanosecond resolution.
+// Multi-segment packets are written segment by segment without copying.
 func (pkt *Packet) WritePcapOnePacket(f io.Writer) error {
-	bytes := low.GetRawPacketBytesMbuf(pkt.CMbuf)
-	if err := writePcapRecHdr(f, bytes); err != nil {
+	if err := writePcapRecHdr(f, pkt.GetPacketLen()); err != nil {
 		return err
 	}
-	return writePacketBytes(f, bytes)
+	for it := pkt.Segments(); it.Next(); {
+		if err := writePacketBytes(f, it.Data()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func writePcapRecHdr(f io.Writer, pktBytes []byte) error {
+func writePcapRecHdr(f io.Writer, pktLen uint) error {
 	t := now()
 	hdr := PcapRecHdr{
 		TsSec:   uint32(t.Unix()),
 		TsUsec:  uint32(t.UnixNano() % 1e9),
-		InclLen: uint32(len(pktBytes)),
-		OrigLen: uint32(len(pktBytes)),
+		InclLen: uint32(pktLen),
+		OrigLen: uint32(pktLen),
 	}
 	if err := binary.Write(f, binary.LittleEndian, &hdr); err != nil {
 		return common.WrapWithNFError(err, "write pcap header failed", common.PcapWriteFail)
@@ -0,0 +1,270 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+)
+
+// RSSInputSet is a set of packet types which fields are used for software
+// RSS hash calculation. For types with L4 protocol addresses and ports
+// are used, for IP types only addresses are used. Packets of types which
+// are not in the set have zero hash.
+type RSSInputSet uint
+
+// RSS input set types, similar to ETH_RSS_* flags of DPDK
+const (
+	RSSIPv4 RSSInputSet = 1 << iota
+	RSSIPv4TCP
+	RSSIPv4UDP
+	RSSIPv6
+	RSSIPv6TCP
+	RSSIPv6UDP
+
+	RSSIP  = RSSIPv4 | RSSIPv6
+	RSSTCP = RSSIPv4TCP | RSSIPv6TCP
+	RSSUDP = RSSIPv4UDP | RSSIPv6UDP
+	RSSAll = RSSIP | RSSTCP | RSSUDP
+)
+
+// RSSDefaultKey is a 40 bytes Toeplitz key which is used by default by
+// many NICs and in Microsoft RSS specification.
+var RSSDefaultKey = []byte{
+	0x6d, 0x5a, 0x56, 0xda, 0x25, 0x5b, 0x0e, 0xc2,
+	0x41, 0x67, 0x25, 0x3d, 0x43, 0xa3, 0x8f, 0xb0,
+	0xd0, 0xca, 0x2b, 0xcb, 0xae, 0x7b, 0x30, 0xb4,
+	0x77, 0xcb, 0x2d, 0xa3, 0x80, 0x30, 0xf2, 0x0c,
+	0x6a, 0x42, 0xb7, 0x3b, 0xbe, 0xac, 0x01, 0xfa,
+}
+
+// RSSSymmetricKey is a 40 bytes Toeplitz key which gives the same hash
+// if source and destination addresses and ports are swapped.
+var RSSSymmetricKey = []byte{
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+	0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a, 0x6d, 0x5a,
+}
+
+// Maximum tuple is IPv6 addresses and ports
+const rssMaxTupleLen = 2*IPv6AddrLen/4 + 1
+
+// SoftRSS calculates Toeplitz hash of input tuple in the same way as
+// rte_softrss function of DPDK. Tuple consists of 32 bit words in host
+// byte order. Key should be at least 4 bytes longer than tuple.
+func SoftRSS(tuple []uint32, key []byte) uint32 {
+	var ret uint32
+	for j := range tuple {
+		k0 := binary.BigEndian.Uint32(key[j*4:])
+		k1 := binary.BigEndian.Uint32(key[j*4+4:])
+		for m := tuple[j]; m != 0; m &= m - 1 {
+			// Index of lowest set bit
+			var i uint
+			for m&(1<<i) == 0 {
+				i++
+			}
+			ret ^= k0<<(31-i) | k1>>(i+1)
+		}
+	}
+	return ret
+}
+
+// FindL4 returns transport protocol and header of IPv4 or IPv6 packet
+// which header is pointed by l3 and which has length bytes in buffer
+// starting from it. IPv6 Hop-by-Hop, Routing, Destination Options and
+// Fragment extension headers are skipped. Header is nil if packet is a
+// non-first fragment or is truncated before ports of transport header.
+func FindL4(l3 unsafe.Pointer, length uintptr) (uint8, unsafe.Pointer) {
+	if length == 0 {
+		return 0, nil
+	}
+	var proto uint8
+	var l4 uintptr
+	switch *(*uint8)(l3) >> 4 {
+	case 4:
+		if length < IPv4MinLen {
+			return 0, nil
+		}
+		hdr := (*IPv4Hdr)(l3)
+		proto = hdr.NextProtoID
+		ihl := uintptr(hdr.VersionIhl&0x0f) << 2
+		// Non-first fragments have no L4 header
+		if SwapBytesUint16(hdr.FragmentOffset)&0x1fff != 0 || ihl < IPv4MinLen {
+			return proto, nil
+		}
+		l4 = uintptr(l3) + ihl
+	case 6:
+		if length < IPv6Len {
+			return 0, nil
+		}
+		proto = (*IPv6Hdr)(l3).Proto
+		l4 = uintptr(l3) + IPv6Len
+		for proto == ipv6HopByHop || proto == ipv6Routing || proto == ipv6DstOptions || proto == ipv6Fragment {
+			// All these headers have next header and length in the first
+			// two bytes and are at least 8 bytes long
+			if l4+8 > uintptr(l3)+length {
+				return proto, nil
+			}
+			next := *(*uint8)(unsafe.Pointer(l4))
+			hdrLen := (uintptr(*(*uint8)(unsafe.Pointer(l4 + 1))) + 1) * 8
+			if proto == ipv6Fragment {
+				if SwapBytesUint16(*(*uint16)(unsafe.Pointer(l4 + 2)))&0xfff8 != 0 {
+					return next, nil
+				}
+				hdrLen = 8
+			}
+			proto = next
+			l4 += hdrLen
+		}
+	default:
+		return 0, nil
+	}
+	// Ports have the same offsets in TCP and UDP headers
+	if l4+4 > uintptr(l3)+length {
+		return proto, nil
+	}
+	return proto, unsafe.Pointer(l4)
+}
+
+// CalculateRSSHash calculates Toeplitz hash for IPv4 or IPv6 header
+// pointed by l3 and TCP or UDP header after it. Length is a number of
+// bytes in buffer starting from l3. IP version is taken from the header
+// itself. Packets without ports in buffer are hashed by addresses.
+// Returns zero if packet type is not in input set.
+func CalculateRSSHash(l3 unsafe.Pointer, length uintptr, key []byte, inputSet RSSInputSet) uint32 {
+	var tuple [rssMaxTupleLen]uint32
+	var n int
+	var ipSet, tcpSet, udpSet RSSInputSet
+
+	if length == 0 {
+		return 0
+	}
+	switch *(*uint8)(l3) >> 4 {
+	case 4:
+		if length < IPv4MinLen {
+			return 0
+		}
+		hdr := (*IPv4Hdr)(l3)
+		tuple[0] = SwapBytesUint32(hdr.SrcAddr)
+		tuple[1] = SwapBytesUint32(hdr.DstAddr)
+		n = 2
+		ipSet, tcpSet, udpSet = RSSIPv4, RSSIPv4TCP, RSSIPv4UDP
+	case 6:
+		if length < IPv6Len {
+			return 0
+		}
+		hdr := (*IPv6Hdr)(l3)
+		for i := 0; i < IPv6AddrLen/4; i++ {
+			tuple[i] = binary.BigEndian.Uint32(hdr.SrcAddr[i*4:])
+			tuple[IPv6AddrLen/4+i] = binary.BigEndian.Uint32(hdr.DstAddr[i*4:])
+		}
+		n = 2 * IPv6AddrLen / 4
+		ipSet, tcpSet, udpSet = RSSIPv6, RSSIPv6TCP, RSSIPv6UDP
+	default:
+		return 0
+	}
+
+	proto, l4 := FindL4(l3, length)
+	if l4 != nil && ((proto == TCPNumber && inputSet&tcpSet != 0) ||
+		(proto == UDPNumber && inputSet&udpSet != 0)) {
+		hdr := (*UDPHdr)(l4)
+		tuple[n] = uint32(SwapBytesUint16(hdr.SrcPort))<<16 | uint32(SwapBytesUint16(hdr.DstPort))
+		n++
+	} else if inputSet&ipSet == 0 {
+		return 0
+	}
+	return SoftRSS(tuple[:n], key)
+}
+
+// GetRSSHash calculates Toeplitz hash of packet in the same way as NIC
+// does it for RSS. VLAN tag is taken into account. Returns zero if packet
+// type is not in input set.
+func (packet *Packet) GetRSSHash(key []byte, inputSet RSSInputSet) uint32 {
+	packet.ParseL3CheckVLAN()
+	etherType := packet.GetEtherType()
+	if etherType != IPV4Number && etherType != IPV6Number {
+		return 0
+	}
+	return CalculateRSSHash(packet.L3, packet.l3Length(), key, inputSet)
+}
+
+// l3Length returns number of bytes in the first segment of packet
+// starting from parsed L3 header.
+func (packet *Packet) l3Length() uintptr {
+	end := uintptr(unsafe.Pointer(packet.Ether)) + uintptr(packet.GetPacketSegmentLen())
+	if uintptr(packet.L3) >= end {
+		return 0
+	}
+	return end - uintptr(packet.L3)
+}
+
+// GetGTPUInnerL3 returns pointer to IP header which is encapsulated into
+// GTP-U G-PDU message. Returns nil if packet isn't IPv4/UDP/GTP-U packet
+// with IPv4 or IPv6 payload or if it is truncated.
+func (packet *Packet) GetGTPUInnerL3() unsafe.Pointer {
+	packet.ParseL3CheckVLAN()
+	ipv4 := packet.GetIPv4CheckVLAN()
+	if ipv4 == nil || ipv4.NextProtoID != UDPNumber {
+		return nil
+	}
+	proto, l4 := FindL4(packet.L3, packet.l3Length())
+	if proto != UDPNumber || l4 == nil {
+		return nil
+	}
+	end := uintptr(unsafe.Pointer(packet.Ether)) + uintptr(packet.GetPacketSegmentLen())
+	if uintptr(l4)+UDPLen+GTPMinLen > end || (*UDPHdr)(l4).DstPort != SwapUDPPortGTPU {
+		return nil
+	}
+	gtp := (*GTPHdr)(unsafe.Pointer(uintptr(l4) + UDPLen))
+	if gtp.HeaderType&0xe0 != 0x20 || gtp.MessageType != G_PDU {
+		return nil
+	}
+	inner := uintptr(unsafe.Pointer(gtp)) + GTPMinLen
+	// Optional fields are present if any of E, S or PN flags is set
+	if gtp.HeaderType&0x07 != 0 {
+		inner += 4
+		if inner > end {
+			return nil
+		}
+		next := gtp.NextExtensionHeader
+		for gtp.HeaderType&0x04 != 0 && next != NoExtensionHeaders {
+			if inner >= end {
+				return nil
+			}
+			// Extension header length is in 4 octets units,
+			// next extension header type is its last octet
+			length := uintptr(*(*uint8)(unsafe.Pointer(inner))) * 4
+			if length == 0 || inner+length > end {
+				return nil
+			}
+			next = *(*uint8)(unsafe.Pointer(inner + length - 1))
+			inner += length
+		}
+	}
+	if inner+IPv4MinLen > end {
+		return nil
+	}
+	switch *(*uint8)(unsafe.Pointer(inner)) >> 4 {
+	case 4:
+	case 6:
+		if inner+IPv6Len > end {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return unsafe.Pointer(inner)
+}
+
+// RSSQueue returns number of RX queue which NIC chooses for given hash
+// if its redirection table has retaSize entries filled with queues in
+// round-robin order, which is default for most DPDK drivers.
+func RSSQueue(hash uint32, retaSize uint, queues uint) uint {
+	return (uint(hash) % retaSize) % queues
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"net"
+	"testing"
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+func init() {
+	tInitDPDK()
+}
+
+// Verification suite from Microsoft RSS specification
+var rssIPv4Tests = []struct {
+	src, dst         string
+	srcPort, dstPort uint16
+	wantIP, wantTCP  uint32
+}{
+	{"66.9.149.187", "161.142.100.80", 2794, 1766, 0x323e8fc2, 0x51ccc178},
+	{"199.92.111.2", "65.69.140.83", 14230, 4739, 0xd718262a, 0xc626b0ea},
+	{"24.19.198.95", "12.22.207.184", 12898, 38024, 0xd2d0a5de, 0x5c2b394a},
+	{"38.27.205.30", "209.142.163.6", 48228, 2217, 0x82989176, 0xafc7327f},
+	{"153.39.163.191", "202.188.127.2", 44251, 1303, 0x5d1809c5, 0x10e828a2},
+}
+
+var rssIPv6Tests = []struct {
+	src, dst         string
+	srcPort, dstPort uint16
+	wantIP, wantTCP  uint32
+}{
+	{"3ffe:2501:200:1fff::7", "3ffe:2501:200:3::1", 2794, 1766, 0x2cc18cd5, 0x40207d3d},
+	{"3ffe:501:8::260:97ff:fe40:efab", "ff02::1", 14230, 4739, 0x0f0c461c, 0xdde51bbf},
+}
+
+func parseIPv4(s string) uint32 {
+	ip := net.ParseIP(s).To4()
+	return BytesToIPv4(ip[0], ip[1], ip[2], ip[3])
+}
+
+func TestRSSIPv4(t *testing.T) {
+	for _, tt := range rssIPv4Tests {
+		pkt := getPacket()
+		InitEmptyIPv4TCPPacket(pkt, 0)
+		pkt.GetIPv4().SrcAddr = parseIPv4(tt.src)
+		pkt.GetIPv4().DstAddr = parseIPv4(tt.dst)
+		pkt.GetTCPNoCheck().SrcPort = SwapBytesUint16(tt.srcPort)
+		pkt.GetTCPNoCheck().DstPort = SwapBytesUint16(tt.dstPort)
+
+		if got := pkt.GetRSSHash(RSSDefaultKey, RSSIP); got != tt.wantIP {
+			t.Errorf("Incorrect IPv4 hash for %s -> %s: got %x, want %x", tt.src, tt.dst, got, tt.wantIP)
+		}
+		if got := pkt.GetRSSHash(RSSDefaultKey, RSSAll); got != tt.wantTCP {
+			t.Errorf("Incorrect IPv4 TCP hash for %s -> %s: got %x, want %x", tt.src, tt.dst, got, tt.wantTCP)
+		}
+		if got := pkt.GetRSSHash(RSSDefaultKey, RSSUDP); got != 0 {
+			t.Errorf("Hash of TCP packet with UDP input set should be zero, got %x", got)
+		}
+	}
+}
+
+func TestRSSIPv6(t *testing.T) {
+	for _, tt := range rssIPv6Tests {
+		pkt := getPacket()
+		InitEmptyIPv6TCPPacket(pkt, 0)
+		copy(pkt.GetIPv6().SrcAddr[:], net.ParseIP(tt.src))
+		copy(pkt.GetIPv6().DstAddr[:], net.ParseIP(tt.dst))
+		pkt.GetTCPNoCheck().SrcPort = SwapBytesUint16(tt.srcPort)
+		pkt.GetTCPNoCheck().DstPort = SwapBytesUint16(tt.dstPort)
+
+		if got := pkt.GetRSSHash(RSSDefaultKey, RSSIP); got != tt.wantIP {
+			t.Errorf("Incorrect IPv6 hash for %s -> %s: got %x, want %x", tt.src, tt.dst, got, tt.wantIP)
+		}
+		if got := pkt.GetRSSHash(RSSDefaultKey, RSSAll); got != tt.wantTCP {
+			t.Errorf("Incorrect IPv6 TCP hash for %s -> %s: got %x, want %x", tt.src, tt.dst, got, tt.wantTCP)
+		}
+	}
+}
+
+func TestRSSSymmetricKey(t *testing.T) {
+	pkt := getPacket()
+	InitEmptyIPv4UDPPacket(pkt, 0)
+	initIPv4Addrs(pkt)
+	initPorts(pkt)
+	forward := pkt.GetRSSHash(RSSSymmetricKey, RSSAll)
+
+	ipv4 := pkt.GetIPv4()
+	ipv4.SrcAddr, ipv4.DstAddr = ipv4.DstAddr, ipv4.SrcAddr
+	udp := pkt.GetUDPNoCheck()
+	udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+	if backward := pkt.GetRSSHash(RSSSymmetricKey, RSSAll); forward != backward {
+		t.Errorf("Symmetric key gives different hashes: %x and %x", forward, backward)
+	}
+}
+
+func TestGTPUInnerRSS(t *testing.T) {
+	tt := rssIPv4Tests[0]
+	pkt := getPacket()
+	InitEmptyIPv4TCPPacket(pkt, 0)
+	pkt.GetIPv4().SrcAddr = parseIPv4(tt.src)
+	pkt.GetIPv4().DstAddr = parseIPv4(tt.dst)
+	pkt.GetTCPNoCheck().SrcPort = SwapBytesUint16(tt.srcPort)
+	pkt.GetTCPNoCheck().DstPort = SwapBytesUint16(tt.dstPort)
+
+	if pkt.GetGTPUInnerL3() != nil {
+		t.Error("Inner header was found in not encapsulated packet")
+	}
+	if !pkt.EncapsulateIPv4GTP(1) {
+		t.Fatal("Can't encapsulate packet")
+	}
+	pkt.ParseL3()
+	fillIPv4Default(pkt, uint16(pkt.GetPacketLen()-EtherLen), UDPNumber)
+	pkt.ParseL4ForIPv4()
+	pkt.GetUDPNoCheck().DstPort = SwapBytesUint16(UDPPortGTPU)
+
+	inner := pkt.GetGTPUInnerL3()
+	if inner == nil {
+		t.Fatal("Inner header wasn't found")
+	}
+	if got := CalculateRSSHash(inner, pkt.l3Length()-(uintptr(inner)-uintptr(pkt.L3)), RSSDefaultKey, RSSAll); got != tt.wantTCP {
+		t.Errorf("Incorrect inner hash: got %x, want %x", got, tt.wantTCP)
+	}
+	// Packet which ends inside GTP header has no inner header
+	l4 := uint(uintptr(pkt.L4) - uintptr(unsafe.Pointer(pkt.Ether)))
+	if !low.TrimMbuf(pkt.CMbuf, pkt.GetPacketLen()-l4-UDPLen-4) {
+		t.Fatal("Can't trim packet")
+	}
+	if pkt.GetGTPUInnerL3() != nil {
+		t.Error("Inner header was found in truncated packet")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"unsafe"
+
+	. "github.com/intel-go/nff-go/common"
+	"github.com/intel-go/nff-go/low"
+)
+
+// Support of packets which consist of several chained mbufs (segments).
+// Such packets are received when jumbo frames don't fit into one mbuf
+// or are generated from large slices of bytes. All headers are assumed
+// to be in the first segment, so Ether, L3, L4 and Data pointers are
+// valid as usual, but payload can continue in following segments.
+
+// IsMultiSegment returns true if packet consists of several chained mbufs.
+func (packet *Packet) IsMultiSegment() bool {
+	return low.GetNextMbuf(packet.CMbuf) != nil
+}
+
+// SegmentIterator walks over segments of packet. Zero value is an
+// empty iterator, use Packet.Segments to get iterator of packet.
+type SegmentIterator struct {
+	next    *low.Mbuf
+	current *low.Mbuf
+}
+
+// Segments returns iterator over segments of packet. Next should be
+// called before first access to Data:
+//
+//	for it := pkt.Segments(); it.Next(); {
+//		use(it.Data())
+//	}
+func (packet *Packet) Segments() SegmentIterator {
+	return SegmentIterator{next: packet.CMbuf}
+}
+
+// Next moves iterator to next segment. Returns false if there are no
+// more segments.
+func (it *SegmentIterator) Next() bool {
+	it.current = it.next
+	if it.current == nil {
+		return false
+	}
+	it.next = low.GetNextMbuf(it.current)
+	return true
+}
+
+// Data returns bytes of current segment. Zero-copy.
+func (it *SegmentIterator) Data() []byte {
+	return low.GetRawPacketBytesMbuf(it.current)
+}
+
+// CopyRawPacketBytes returns all bytes of all segments of packet
+// in one slice. Not zero-copy.
+func (packet *Packet) CopyRawPacketBytes() []byte {
+	out := make([]byte, 0, packet.GetPacketLen())
+	for it := packet.Segments(); it.Next(); {
+		out = append(out, it.Data()...)
+	}
+	return out
+}
+
+// forEachRange calls f for parts of segments which are covered by
+// length bytes starting at offset from the beginning of packet.
+// Returns number of bytes passed to f.
+func (packet *Packet) forEachRange(offset, length uint, f func([]byte)) uint {
+	var done uint
+	for it := packet.Segments(); it.Next() && done < length; {
+		data := it.Data()
+		if offset >= uint(len(data)) {
+			offset -= uint(len(data))
+			continue
+		}
+		data = data[offset:]
+		offset = 0
+		if uint(len(data)) > length-done {
+			data = data[:length-done]
+		}
+		f(data)
+		done += uint(len(data))
+	}
+	return done
+}
+
+// ReadBytesAt copies bytes of packet starting at offset to dst, crossing
+// segment boundaries if needed. Returns number of copied bytes, which is
+// less than len(dst) if packet is shorter.
+func (packet *Packet) ReadBytesAt(offset uint, dst []byte) uint {
+	var n uint
+	return packet.forEachRange(offset, uint(len(dst)), func(data []byte) {
+		n += uint(copy(dst[n:], data))
+	})
+}
+
+// WriteBytesAt copies src to packet starting at offset, crossing segment
+// boundaries if needed. Packet length isn't changed, so returned number
+// of written bytes is less than len(src) if packet is shorter.
+func (packet *Packet) WriteBytesAt(offset uint, src []byte) uint {
+	var n uint
+	return packet.forEachRange(offset, uint(len(src)), func(data []byte) {
+		n += uint(copy(data, src[n:]))
+	})
+}
+
+// Linearize moves all data of packet to the first segment and frees
+// other segments, so packet can be accessed with usual pointer
+// arithmetic. Returns false if data doesn't fit into the first mbuf.
+func (packet *Packet) Linearize() bool {
+	if !low.LinearizeMbuf(packet.CMbuf) {
+		return false
+	}
+	packet.Next = nil
+	return true
+}
+
+// appendSegment adds new segment with data to the end of packet.
+func (packet *Packet) appendSegment(data []byte) (*Packet, uint) {
+	mb := low.AppendMbufSegment(packet.CMbuf, data)
+	if mb == nil {
+		return nil, 0
+	}
+	seg := ExtractPacket(uintptr(unsafe.Pointer(mb)))
+	seg.Data = unsafe.Pointer(seg.Ether)
+	last := packet
+	for last.Next != nil {
+		last = last.Next
+	}
+	last.Next = seg
+	return seg, low.GetDataLenMbuf(mb)
+}
+
+// checksumBytes adds bytes to one's complement sum. Slice can start at
+// odd offset of checksummed data, which is told by odd flag. Returns new
+// sum and odd flag for next slice.
+func checksumBytes(sum uint32, odd bool, b []byte) (uint32, bool) {
+	if len(b) == 0 {
+		return sum, odd
+	}
+	if odd {
+		sum += uint32(b[0])
+		b = b[1:]
+	}
+	for len(b) > 1 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+		return sum, true
+	}
+	return sum, false
+}
+
+// CalculateL4Checksum calculates TCP, UDP or ICMP checksum of packet
+// walking over all its segments, so it can be used for multi-segment
+// packets. Packet can have VLAN tag. Value of checksum field in packet
+// is ignored. Returns checksum in host byte order and false if packet
+// doesn't have known L4 header.
+func (packet *Packet) CalculateL4Checksum() (uint16, bool) {
+	cksum, field, _ := packet.calculateL4Checksum()
+	return cksum, field != nil
+}
+
+// calculateL4Checksum returns calculated checksum, pointer to checksum
+// field in L4 header or nil if header is unknown and whether it is UDP.
+func (packet *Packet) calculateL4Checksum() (uint16, *uint16, bool) {
+	ipv4, ipv6, _ := packet.ParseAllKnownL3CheckVLAN()
+	var tcp *TCPHdr
+	var udp *UDPHdr
+	var icmp *ICMPHdr
+	var sum uint32
+	var l4len uintptr
+	if ipv4 != nil {
+		tcp, udp, icmp = packet.ParseAllKnownL4ForIPv4()
+		l4len = uintptr(SwapBytesUint16(ipv4.TotalLength)) - (uintptr(packet.L4) - uintptr(packet.L3))
+		sum = calculateIPv4AddrChecksum(ipv4)
+	} else if ipv6 != nil {
+		tcp, udp, icmp = packet.ParseAllKnownL4ForIPv6()
+		l4len = uintptr(SwapBytesUint16(ipv6.PayloadLen)) - (uintptr(packet.L4) - uintptr(packet.L3) - IPv6Len)
+		sum = calculateIPv6AddrChecksum(ipv6)
+	}
+	var field *uint16
+	var proto uint32
+	switch {
+	case tcp != nil:
+		field, proto = &tcp.Cksum, TCPNumber
+	case udp != nil:
+		field, proto = &udp.DgramCksum, UDPNumber
+	case icmp != nil && ipv6 != nil:
+		field, proto = &icmp.Cksum, ICMPv6Number
+	case icmp != nil:
+		field = &icmp.Cksum
+	default:
+		return 0, nil, false
+	}
+	if proto != 0 {
+		sum += proto + uint32(l4len)
+	} else {
+		// There is no pseudo header for ICMP over IPv4
+		sum = 0
+	}
+
+	offset := uintptr(packet.L4) - uintptr(unsafe.Pointer(packet.Ether))
+	odd := false
+	packet.forEachRange(uint(offset), uint(l4len), func(data []byte) {
+		sum, odd = checksumBytes(sum, odd, data)
+	})
+	// Exclude current value of checksum field
+	sum += uint32(^SwapBytesUint16(*field))
+
+	retSum := ^reduceChecksum(sum)
+	// Zero UDP checksum should be sent as the one's complement (all 1s).
+	if udp != nil && retSum == 0 {
+		retSum = ^retSum
+	}
+	return retSum, field, udp != nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Intel Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package packet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/intel-go/nff-go/common"
+)
+
+// Larger than one mbuf, so packet is created from several segments
+const multiSegmentPayloadSize = 5000
+
+func buildMultiSegmentPacket(tb testing.TB, b *Builder) (*Packet, []byte) {
+	payload := make([]byte, multiSegmentPayloadSize)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	data, err := b.Payload(payload).Bytes()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pkt := getPacket()
+	if err := b.Build(pkt); err != nil {
+		tb.Fatal(err)
+	}
+	return pkt, data
+}
+
+func TestMultiSegmentPacket(t *testing.T) {
+	pkt, data := buildMultiSegmentPacket(t, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).UDP(1, 2))
+	if !pkt.IsMultiSegment() {
+		t.Fatal("Packet should consist of several segments")
+	}
+	if pkt.GetPacketLen() != uint(len(data)) {
+		t.Errorf("Wrong packet length %d, expected %d", pkt.GetPacketLen(), len(data))
+	}
+	segments := 0
+	next := pkt
+	for it := pkt.Segments(); it.Next(); segments++ {
+		if next == nil || uint(len(it.Data())) != next.GetPacketSegmentLen() {
+			t.Fatalf("Segment %d doesn't match Next chain of packets", segments)
+		}
+		next = next.Next
+	}
+	if segments < 3 {
+		t.Errorf("Expected at least 3 segments, got %d", segments)
+	}
+	if !bytes.Equal(pkt.CopyRawPacketBytes(), data) {
+		t.Error("Copied bytes don't match generated bytes")
+	}
+	if !bytes.Equal(pkt.GetRawPacketBytes(), data) {
+		t.Error("Raw bytes of multi-segment packet don't match generated bytes")
+	}
+	payload, ok := pkt.GetPacketPayload()
+	if !ok || !bytes.Equal(payload, data[len(data)-multiSegmentPayloadSize:]) {
+		t.Error("Wrong payload of multi-segment packet")
+	}
+
+	boundary := pkt.GetPacketSegmentLen()
+	buf := make([]byte, 8)
+	if n := pkt.ReadBytesAt(boundary-3, buf); n != 8 || !bytes.Equal(buf, data[boundary-3:boundary+5]) {
+		t.Errorf("Wrong bytes %x read across segment boundary", buf[:n])
+	}
+	if n := pkt.ReadBytesAt(uint(len(data))-2, buf); n != 2 {
+		t.Errorf("Read %d bytes at the end of packet, expected 2", n)
+	}
+	if n := pkt.WriteBytesAt(boundary-4, []byte{1, 2, 3, 4, 5, 6, 7, 8}); n != 8 {
+		t.Errorf("Written %d bytes across segment boundary, expected 8", n)
+	}
+	pkt.ReadBytesAt(boundary-4, buf)
+	if !bytes.Equal(buf, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("Wrong bytes %x after write across segment boundary", buf)
+	}
+
+	if pkt.Linearize() {
+		t.Error("Linearize should fail if packet doesn't fit into one mbuf")
+	}
+}
+
+func TestMultiSegmentChecksums(t *testing.T) {
+	src := BytesToIPv4(10, 0, 0, 1)
+	dst := BytesToIPv4(10, 0, 0, 2)
+	var src6, dst6 [common.IPv6AddrLen]uint8
+	copy(src6[:], net.ParseIP("2001:db8:0:0:1::1"))
+	copy(dst6[:], net.ParseIP("2001:db8:0:0:1::12"))
+	builders := []*Builder{
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv4(src, dst).TCP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).VLAN(10).IPv4(src, dst).UDP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv4(src, dst).ICMP(common.ICMPTypeEchoRequest, 0),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).TCP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).UDP(1, 2),
+		NewBuilder().Ether(builderSrcMAC, builderDstMAC).IPv6(src6, dst6).ICMP(common.ICMPv6TypeEchoRequest, 0),
+	}
+	for i, b := range builders {
+		pkt, data := buildMultiSegmentPacket(t, b)
+		if !pkt.VerifyChecksums() {
+			t.Errorf("Packet %d: correct checksums of multi-segment packet weren't verified", i)
+		}
+		pkt.WriteBytesAt(uint(len(data))-1, []byte{data[len(data)-1] + 1})
+		if pkt.VerifyChecksums() {
+			t.Errorf("Packet %d: changed payload in last segment wasn't detected", i)
+		}
+	}
+}
+
+func TestLinearize(t *testing.T) {
+	pkt := getPacket()
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if !GeneratePacketFromByte(pkt, data[:200]) {
+		t.Fatal("Cannot generate packet")
+	}
+	if seg, _ := pkt.appendSegment(data[200:]); seg == nil {
+		t.Fatal("Cannot append segment")
+	}
+	if !pkt.IsMultiSegment() || pkt.GetPacketLen() != uint(len(data)) {
+		t.Fatal("Appended segment isn't part of packet")
+	}
+	if !pkt.Linearize() {
+		t.Fatal("Linearize failed")
+	}
+	if pkt.IsMultiSegment() || pkt.Next != nil {
+		t.Error("Packet should consist of one segment after Linearize")
+	}
+	if pkt.GetPacketSegmentLen() != uint(len(data)) || !bytes.Equal(pkt.GetRawPacketBytes(), data) {
+		t.Errorf("Wrong bytes after Linearize: %x", pkt.GetRawPacketBytes())
+	}
+}
+
+func TestMultiSegmentPcap(t *testing.T) {
+	pkt, data := buildMultiSegmentPacket(t, NewBuilder().Ether(builderSrcMAC, builderDstMAC).
+		IPv4(BytesToIPv4(10, 0, 0, 1), BytesToIPv4(10, 0, 0, 2)).TCP(1, 2))
+	var buf bytes.Buffer
+	if err := pkt.WritePcapOnePacket(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read := getPacket()
+	if _, err := read.ReadPcapOnePacket(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.CopyRawPacketBytes(), data) {
+		t.Error("Packet read from pcap doesn't match written multi-segment packet")
+	}
+}